@@ -4,18 +4,38 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"diogogmt.com/hbd/pkg/command"
 	"diogogmt.com/hbd/pkg/hbclient"
 	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/pkg/errors"
 )
 
+// exitInterrupted follows the shell convention of 128 + SIGINT
+const exitInterrupted = 130
+
 func main() {
 	rootCmd := command.NewRootCmd()
 	downloadCmd := command.NewDownloadCmd(rootCmd.Conf)
+	keysCmd := command.NewKeysCmd(rootCmd.Conf)
+	serveCmd := command.NewServeCmd(rootCmd.Conf)
+	daemonCmd := command.NewDaemonCmd(rootCmd.Conf)
+	opdsCmd := command.NewOPDSCmd(rootCmd.Conf)
+	catalogCmd := command.NewCatalogCmd(rootCmd.Conf)
+	searchCmd := command.NewSearchCmd(rootCmd.Conf)
+	dedupeCmd := command.NewDedupeCmd(rootCmd.Conf)
 
 	rootCmd.Subcommands = []*ffcli.Command{
 		downloadCmd.Command,
+		keysCmd.Command,
+		serveCmd.Command,
+		daemonCmd.Command,
+		opdsCmd.Command,
+		catalogCmd.Command,
+		searchCmd.Command,
+		dedupeCmd.Command,
 	}
 
 	if err := rootCmd.Parse(os.Args[1:]); err != nil {
@@ -27,8 +47,24 @@ func main() {
 
 	command.WithHBClient(hbClient)(rootCmd.Conf)
 
-	if err := rootCmd.Run(context.Background()); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		fmt.Fprintln(os.Stderr, "interrupted, stopping downloads...")
+		cancel()
+		// a second signal skips the cleanup
+		<-sigCh
+		os.Exit(exitInterrupted)
+	}()
+
+	if err := rootCmd.Run(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if errors.Is(err, command.ErrInterrupted) {
+			os.Exit(exitInterrupted)
+		}
 		os.Exit(1)
 	}
 }
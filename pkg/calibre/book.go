@@ -0,0 +1,80 @@
+package calibre
+
+import (
+	"path/filepath"
+	"strings"
+
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/hbclient"
+)
+
+// NewBook describes a product from the order, the metadata of an EPUB among
+// the files, or of a PDF when there's none, replaces the marketing name of
+// the product with the book title and names its authors
+func NewBook(order *hbclient.Order, product *hbclient.Product, files []string) *Book {
+	b := Book{
+		Key:         Key(product),
+		Title:       strings.TrimSpace(product.HumanName),
+		Identifiers: map[string]string{},
+		Tags:        []string{"Humble Bundle"},
+		Files:       files,
+	}
+	switch {
+	case len(product.Publishers) > 0:
+		b.Publisher = product.Publishers[0].Name
+	case product.Payee != nil:
+		b.Publisher = product.Payee.HumanName
+	}
+	if order.Product != nil && order.Product.HumanName != "" {
+		b.Tags = append(b.Tags, order.Product.HumanName)
+	}
+	for _, ext := range []string{".epub", ".pdf"} {
+		if meta := readMeta(files, ext); meta != nil {
+			b.apply(meta)
+			break
+		}
+	}
+	return &b
+}
+
+// Key identifies a product in the library, its machine name or its lowercase name
+func Key(product *hbclient.Product) string {
+	if product.MachineName != "" {
+		return product.MachineName
+	}
+	return strings.ToLower(product.HumanName)
+}
+
+// apply takes the title, authors and identifiers the ebook states
+func (b *Book) apply(meta *ebookmeta.Metadata) {
+	if meta.Title != "" {
+		b.Title = meta.Title
+	}
+	if len(meta.Creators) > 0 {
+		b.Authors = meta.Creators
+	}
+	if meta.Publisher != "" {
+		b.Publisher = meta.Publisher
+	}
+	b.Language = meta.Language
+	for _, id := range meta.Identifiers {
+		// calibre writes its own uuid and id
+		if id.Scheme != "" && id.Scheme != "uuid" && id.Scheme != "calibre" {
+			b.Identifiers[id.Scheme] = id.Value
+		}
+	}
+}
+
+// readMeta reads the metadata of the first file with the extension that has a title
+func readMeta(files []string, ext string) *ebookmeta.Metadata {
+	for _, f := range files {
+		if strings.ToLower(filepath.Ext(f)) != ext {
+			continue
+		}
+		// PDF titles are often left empty
+		if meta, err := ebookmeta.Read(f); err == nil && meta.Title != "" {
+			return meta
+		}
+	}
+	return nil
+}
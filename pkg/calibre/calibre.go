@@ -0,0 +1,275 @@
+// Package calibre arranges ebooks the way a calibre library stores them,
+// Author/Title (id)/Title - Author.ext with a metadata.opf next to the formats,
+// so calibre can import the directory as is.
+package calibre
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/pkg/errors"
+)
+
+// IndexFile is kept at the root of the library and maps books to their ids and directories
+const IndexFile = ".hbd-calibre.json"
+
+// OPFFile is the metadata file calibre reads in every book directory
+const OPFFile = "metadata.opf"
+
+// UnknownAuthor is used when neither the order nor the ebook name an author
+const UnknownAuthor = "Unknown"
+
+// Book is a product of an order with its ebook formats
+type Book struct {
+	// Key identifies the book across orders and runs, eg; the product machine name
+	Key         string
+	Title       string
+	Authors     []string
+	Publisher   string
+	Language    string
+	Identifiers map[string]string
+	Tags        []string
+	// Files are the format files to move into the book directory
+	Files []string
+}
+
+// author is the author as calibre writes it in paths, authors joined by &
+func (b *Book) author() string {
+	if len(b.Authors) == 0 {
+		return UnknownAuthor
+	}
+	return strings.Join(b.Authors, " & ")
+}
+
+// entry is a book of the index
+type entry struct {
+	ID  int    `json:"id"`
+	Dir string `json:"dir"`
+	// Base names the format files, Title - Author
+	Base string `json:"base,omitempty"`
+}
+
+// Library is a directory of books laid out for calibre
+type Library struct {
+	dir string
+
+	mu    sync.Mutex
+	books map[string]*entry
+	next  int
+}
+
+// Open reads the index of the library in dir, a new library starts empty
+func Open(dir string) (*Library, error) {
+	l := Library{dir: dir, books: map[string]*entry{}, next: 1}
+	by, err := ioutil.ReadFile(filepath.Join(dir, IndexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "ioutil.ReadFile")
+	}
+	if err == nil {
+		if err := json.Unmarshal(by, &l.books); err != nil {
+			return nil, errors.Wrapf(err, "json.Unmarshal %s", filepath.Join(dir, IndexFile))
+		}
+	}
+	for _, e := range l.books {
+		if e.ID >= l.next {
+			l.next = e.ID + 1
+		}
+	}
+	return &l, nil
+}
+
+// Save writes the index of the library
+func (l *Library) Save() error {
+	l.mu.Lock()
+	by, err := json.MarshalIndent(l.books, "", "  ")
+	l.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "json.MarshalIndent")
+	}
+	return state.WriteFileAtomic(filepath.Join(l.dir, IndexFile), by)
+}
+
+// Path is the path relative to the library of the ext format of a book added
+// before, empty for a new book
+func (l *Library) Path(key, ext string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.books[key]
+	if !ok {
+		return ""
+	}
+	if e.Base == "" {
+		return ""
+	}
+	return path.Join(e.Dir, e.Base+strings.ToLower(ext))
+}
+
+// Add moves the book files into Author/Title (id) and writes its metadata.opf,
+// a book added before keeps its id and is moved when its author or title changed,
+// it returns the book directory relative to the library
+func (l *Library) Add(b *Book) (string, error) {
+	l.mu.Lock()
+	e, ok := l.books[b.Key]
+	if !ok {
+		e = &entry{ID: l.next}
+		l.next++
+		l.books[b.Key] = e
+	}
+	title := sanitize(b.Title)
+	dir := filepath.Join(sanitize(b.author()), fmt.Sprintf("%s (%d)", title, e.ID))
+	base := sanitize(b.Title + " - " + b.author())
+	previous := e.Dir
+	e.Dir = filepath.ToSlash(dir)
+	e.Base = base
+	l.mu.Unlock()
+
+	files := append([]string{}, b.Files...)
+	bookDir := filepath.Join(l.dir, dir)
+	if previous != "" && previous != e.Dir {
+		if _, err := os.Stat(bookDir); os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(bookDir), 0755); err != nil {
+				return "", errors.Wrap(err, "os.MkdirAll")
+			}
+			old := filepath.Join(l.dir, filepath.FromSlash(previous))
+			if err := os.Rename(old, bookDir); err != nil && !os.IsNotExist(err) {
+				return "", errors.Wrapf(err, "moving %s", previous)
+			}
+			// files downloaded into the book directory moved along
+			for i, f := range files {
+				if strings.HasPrefix(f, old+string(filepath.Separator)) {
+					files[i] = filepath.Join(bookDir, strings.TrimPrefix(f, old))
+				}
+			}
+			// calibre leaves no empty author directories behind
+			os.Remove(filepath.Dir(old))
+		}
+	}
+	if err := os.MkdirAll(bookDir, 0755); err != nil {
+		return "", errors.Wrap(err, "os.MkdirAll")
+	}
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f))
+		target := filepath.Join(bookDir, base+ext)
+		if err := os.Rename(f, target); err != nil {
+			return "", errors.Wrapf(err, "moving %s", f)
+		}
+		// a book has one file per format, drop the one named after an older title or author
+		others, _ := ioutil.ReadDir(bookDir)
+		for _, other := range others {
+			if name := other.Name(); strings.ToLower(filepath.Ext(name)) == ext && name != base+ext {
+				os.Remove(filepath.Join(bookDir, name))
+			}
+		}
+	}
+	opf := OPF(b, e.ID)
+	if err := state.WriteFileAtomic(filepath.Join(bookDir, OPFFile), opf); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// OPF renders the metadata.opf of a book the way calibre writes it
+func OPF(b *Book, id int) []byte {
+	var buf bytes.Buffer
+	w := func(format string, args ...interface{}) {
+		fmt.Fprintf(&buf, format, args...)
+	}
+	esc := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	w("<?xml version='1.0' encoding='utf-8'?>\n")
+	w("<package xmlns=\"http://www.idpf.org/2007/opf\" unique-identifier=\"uuid_id\" version=\"2.0\">\n")
+	w("  <metadata xmlns:dc=\"http://purl.org/dc/elements/1.1/\" xmlns:opf=\"http://www.idpf.org/2007/opf\">\n")
+	w("    <dc:identifier opf:scheme=\"calibre\" id=\"calibre_id\">%d</dc:identifier>\n", id)
+	w("    <dc:identifier opf:scheme=\"uuid\" id=\"uuid_id\">%s</dc:identifier>\n", uuid(b.Key))
+	w("    <dc:title>%s</dc:title>\n", esc(b.Title))
+	authors := b.Authors
+	if len(authors) == 0 {
+		authors = []string{UnknownAuthor}
+	}
+	for _, a := range authors {
+		w("    <dc:creator opf:file-as=\"%s\" opf:role=\"aut\">%s</dc:creator>\n", esc(authorSort(a)), esc(a))
+	}
+	if b.Publisher != "" {
+		w("    <dc:publisher>%s</dc:publisher>\n", esc(b.Publisher))
+	}
+	if b.Language != "" {
+		w("    <dc:language>%s</dc:language>\n", esc(b.Language))
+	}
+	schemes := make([]string, 0, len(b.Identifiers))
+	for scheme := range b.Identifiers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	for _, scheme := range schemes {
+		w("    <dc:identifier opf:scheme=\"%s\">%s</dc:identifier>\n", esc(strings.ToUpper(scheme)), esc(b.Identifiers[scheme]))
+	}
+	for _, tag := range b.Tags {
+		w("    <dc:subject>%s</dc:subject>\n", esc(tag))
+	}
+	w("    <meta name=\"calibre:title_sort\" content=\"%s\"/>\n", esc(titleSort(b.Title)))
+	w("  </metadata>\n")
+	w("  <guide/>\n")
+	w("</package>\n")
+	return buf.Bytes()
+}
+
+// uuid derives a stable name based uuid from the book key
+func uuid(key string) string {
+	sum := md5.Sum([]byte("hbd:" + key))
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// authorSort is the author as calibre sorts it, eg; Sikorski, Michael
+func authorSort(author string) string {
+	fields := strings.Fields(author)
+	if len(fields) < 2 || author == UnknownAuthor {
+		return author
+	}
+	return fields[len(fields)-1] + ", " + strings.Join(fields[:len(fields)-1], " ")
+}
+
+// titleSort moves the leading article to the end, eg; Art of Exploitation, The
+func titleSort(title string) string {
+	for _, article := range []string{"The ", "A ", "An "} {
+		if strings.HasPrefix(title, article) && len(title) > len(article) {
+			return title[len(article):] + ", " + strings.TrimSpace(article)
+		}
+	}
+	return title
+}
+
+// sanitize replaces the characters calibre doesn't allow in paths and
+// shortens long names, calibre keeps path components under 100 characters
+func sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune(`/\?<>:*|"`, r), r < 32:
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if r := []rune(s); len(r) > 96 {
+		s = strings.TrimSpace(string(r[:96]))
+	}
+	s = strings.TrimRight(s, ". ")
+	if s == "" {
+		return "_"
+	}
+	return s
+}
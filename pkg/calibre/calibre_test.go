@@ -0,0 +1,145 @@
+package calibre
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/ebookmeta/epubtest"
+	"diogogmt.com/hbd/pkg/hbclient"
+)
+
+// writeEPUB writes an EPUB 2 with a title, an author and an isbn
+func writeEPUB(t *testing.T, path, title, creator string) {
+	opf := `<package xmlns="http://www.idpf.org/2007/opf" version="2.0"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+		<dc:title>` + title + `</dc:title><dc:creator opf:role="aut">` + creator + `</dc:creator><dc:language>en</dc:language>
+		<dc:identifier opf:scheme="ISBN">9781118029718</dc:identifier><dc:identifier opf:scheme="uuid">abc</dc:identifier>
+	</metadata></package>`
+	if err := epubtest.Write(path, opf); err != nil {
+		t.Fatalf("epubtest.Write: %v", err)
+	}
+}
+
+// files lists the files under dir relative to it
+func files(t *testing.T, dir string) []string {
+	found := []string{}
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			found = append(found, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(found)
+	return found
+}
+
+func TestLibrary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-calibre.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	order := &hbclient.Order{Product: &hbclient.Product{HumanName: "Humble Book Bundle: Cybersecurity"}}
+	product := &hbclient.Product{
+		HumanName:   "Security/Social Engineering: The Art of Human Hacking",
+		MachineName: "socialengineering",
+		Publishers:  []hbclient.Publisher{{Name: "Wiley"}},
+	}
+	add := func(title string, names ...string) string {
+		paths := []string{}
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if strings.HasSuffix(name, ".epub") {
+				writeEPUB(t, path, title, "Christopher Hadnagy")
+			} else {
+				ioutil.WriteFile(path, []byte(name), 0644)
+			}
+			paths = append(paths, path)
+		}
+		lib, err := Open(dir)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		bookDir, err := lib.Add(NewBook(order, product, paths))
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if err := lib.Save(); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		return bookDir
+	}
+
+	// without an epub the order names the book
+	bookDir := add("", "Security_Social Engineering.pdf")
+	if filepath.ToSlash(bookDir) != "Unknown/Security_Social Engineering_ The Art of Human Hacking (1)" {
+		t.Errorf("unexpected book directory %s", bookDir)
+	}
+
+	// a book added before has its formats in the library, new ones included
+	lib, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	pdf := lib.Path("socialengineering", ".PDF")
+	if pdf != bookDir+"/Security_Social Engineering_ The Art of Human Hacking - Unknown.pdf" {
+		t.Errorf("unexpected pdf path %s", pdf)
+	}
+	if epub := lib.Path("socialengineering", ".epub"); epub != strings.TrimSuffix(pdf, ".pdf")+".epub" {
+		t.Errorf("unexpected epub path %s", epub)
+	}
+	if path := lib.Path("blackhatpython", ".pdf"); path != "" {
+		t.Errorf("expected no path for a new book but got %s", path)
+	}
+
+	// the epub metadata names the author and the book moves, keeping its id,
+	// along with the pdf downloaded in place again
+	bookDir = add("Social Engineering: The Art of Human Hacking", pdf, "Security_Social Engineering.epub")
+	expected := []string{
+		".hbd-calibre.json",
+		"Christopher Hadnagy/Social Engineering_ The Art of Human Hacking (1)/Social Engineering_ The Art of Human Hacking - Christopher Hadnagy.epub",
+		"Christopher Hadnagy/Social Engineering_ The Art of Human Hacking (1)/Social Engineering_ The Art of Human Hacking - Christopher Hadnagy.pdf",
+		"Christopher Hadnagy/Social Engineering_ The Art of Human Hacking (1)/metadata.opf",
+	}
+	if got := files(t, dir); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected files\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	opf, err := ioutil.ReadFile(filepath.Join(dir, bookDir, OPFFile))
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile: %v", err)
+	}
+	for _, line := range []string{
+		`<dc:identifier opf:scheme="calibre" id="calibre_id">1</dc:identifier>`,
+		`<dc:title>Social Engineering: The Art of Human Hacking</dc:title>`,
+		`<dc:creator opf:file-as="Hadnagy, Christopher" opf:role="aut">Christopher Hadnagy</dc:creator>`,
+		`<dc:publisher>Wiley</dc:publisher>`,
+		`<dc:language>en</dc:language>`,
+		`<dc:identifier opf:scheme="ISBN">9781118029718</dc:identifier>`,
+		`<dc:subject>Humble Book Bundle: Cybersecurity</dc:subject>`,
+	} {
+		if !strings.Contains(string(opf), line) {
+			t.Errorf("expected metadata.opf to contain %s\n%s", line, opf)
+		}
+	}
+	if strings.Contains(string(opf), ">abc<") {
+		t.Errorf("expected the epub uuid to be replaced\n%s", opf)
+	}
+}
+
+func TestSort(t *testing.T) {
+	if s := authorSort("Michael Sikorski"); s != "Sikorski, Michael" {
+		t.Errorf("unexpected author sort %s", s)
+	}
+	if s := titleSort("The Art of Exploitation"); s != "Art of Exploitation, The" {
+		t.Errorf("unexpected title sort %s", s)
+	}
+	if s := sanitize(` What? "Quotes" / slashes. `); s != `What_ _Quotes_ _ slashes` {
+		t.Errorf("unexpected sanitized name %q", s)
+	}
+}
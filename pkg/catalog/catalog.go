@@ -0,0 +1,265 @@
+// Package catalog builds an inventory of the orders of an account, their
+// products and whether their files were downloaded, and renders it as CSV,
+// JSON, Markdown or a static HTML page.
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"diogogmt.com/hbd/pkg/downloader"
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/manifest"
+)
+
+// local download statuses of a file
+const (
+	StatusDownloaded = "downloaded"
+	StatusChanged    = "changed"
+	StatusMissing    = "missing"
+)
+
+// product statuses summarizing their files
+const (
+	StatusComplete = "complete"
+	StatusPartial  = "partial"
+	StatusNone     = "none"
+)
+
+// Catalog is the inventory of a set of orders
+type Catalog struct {
+	Generated time.Time `json:"generated"`
+	// Dest is the directory the download statuses were checked against
+	Dest   string   `json:"dest,omitempty"`
+	Orders []*Order `json:"orders"`
+	Totals Totals   `json:"totals"`
+}
+
+// Totals sums up the whole catalog, AmountSpent adds the currencies up as they are
+type Totals struct {
+	Orders      int     `json:"orders"`
+	Products    int     `json:"products"`
+	Files       int     `json:"files"`
+	Downloaded  int     `json:"downloaded"`
+	Size        int64   `json:"size"`
+	AmountSpent float64 `json:"amount_spent"`
+}
+
+// Order is an order of the catalog
+type Order struct {
+	Key         string     `json:"key"`
+	Name        string     `json:"name"`
+	Created     time.Time  `json:"created"`
+	AmountSpent float64    `json:"amount_spent"`
+	Currency    string     `json:"currency,omitempty"`
+	Size        int64      `json:"size"`
+	Products    []*Product `json:"products"`
+}
+
+// Product is a product of an order with its files
+type Product struct {
+	Name        string   `json:"name"`
+	MachineName string   `json:"machine_name"`
+	Platforms   []string `json:"platforms"`
+	Formats     []string `json:"formats"`
+	Size        int64    `json:"size"`
+	// Title, Authors and ISBN are what a downloaded EPUB or PDF of the product says about itself
+	Title   string   `json:"title,omitempty"`
+	Authors []string `json:"authors,omitempty"`
+	ISBN    string   `json:"isbn,omitempty"`
+	// Status is complete when every file was downloaded, partial or none, it's
+	// empty for products without files, eg; game keys, and when no destination is checked
+	Status string  `json:"status,omitempty"`
+	Files  []*File `json:"files"`
+}
+
+// File is a downloadable file of a product
+type File struct {
+	Platform string `json:"platform"`
+	Format   string `json:"format"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Status   string `json:"status,omitempty"`
+}
+
+// Local tells which assets were downloaded into a destination directory
+type Local struct {
+	dest     string
+	manifest *manifest.Manifest
+}
+
+// NewLocal reads the manifest of dest, the daemon keeps one, assets missing
+// from it are looked for where hbd download and serve write them
+func NewLocal(dest string) (*Local, error) {
+	m, err := manifest.Load(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{dest: dest, manifest: m}, nil
+}
+
+// Status is downloaded, changed when the file on disk doesn't match the
+// asset anymore, or missing, it's empty when no destination is checked
+func (l *Local) Status(asset hbclient.Asset) string {
+	if l == nil {
+		return ""
+	}
+	if l.manifest.Get(asset) != nil {
+		switch l.manifest.Status(asset) {
+		case manifest.StatusCurrent:
+			return StatusDownloaded
+		case manifest.StatusChanged:
+			return StatusChanged
+		default:
+			return StatusMissing
+		}
+	}
+	filename := downloader.Filename(asset)
+	// serve downloads into a directory per bundle, download straight into -dest
+	for _, path := range []string{
+		filepath.Join(l.dest, downloader.BundleDir(asset.Order), filename),
+		filepath.Join(l.dest, filename),
+	} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if asset.Size() > 0 && fi.Size() != asset.Size() {
+			return StatusChanged
+		}
+		return StatusDownloaded
+	}
+	return StatusMissing
+}
+
+// Meta is the metadata the manifest recorded for an asset, nil when there's none
+func (l *Local) Meta(asset hbclient.Asset) *ebookmeta.Metadata {
+	if l == nil {
+		return nil
+	}
+	if e := l.manifest.Get(asset); e != nil {
+		return e.Meta
+	}
+	return nil
+}
+
+// ProductStatus is complete when every asset of a product was downloaded,
+// partial or none, it's empty for products without assets, eg; game keys, and
+// when no destination is checked
+func (l *Local) ProductStatus(assets hbclient.Assets) string {
+	if l == nil {
+		return ""
+	}
+	downloaded := 0
+	for _, asset := range assets {
+		if l.Status(asset) == StatusDownloaded {
+			downloaded++
+		}
+	}
+	return productStatus(downloaded, len(assets))
+}
+
+func productStatus(downloaded, total int) string {
+	switch {
+	case total == 0:
+		return ""
+	case downloaded == total:
+		return StatusComplete
+	case downloaded > 0:
+		return StatusPartial
+	default:
+		return StatusNone
+	}
+}
+
+// Build describes the orders, local is nil when no destination is checked
+func Build(orders []*hbclient.Order, local *Local) *Catalog {
+	c := Catalog{Generated: time.Now().UTC(), Orders: []*Order{}}
+	if local != nil {
+		c.Dest = local.dest
+	}
+	for _, o := range orders {
+		order := Order{
+			Key:         o.GameKey,
+			Name:        downloader.BundleDir(o),
+			Created:     o.Created.Time,
+			AmountSpent: o.AmountSpent,
+			Currency:    o.Currency,
+			Products:    []*Product{},
+		}
+		if o.Product != nil && o.Product.HumanName != "" {
+			order.Name = o.Product.HumanName
+		}
+		assets := o.Assets()
+		for _, p := range o.Products {
+			if p == nil {
+				continue
+			}
+			order.Products = append(order.Products, newProduct(p, assets, local, &c.Totals))
+		}
+		for _, p := range order.Products {
+			order.Size += p.Size
+		}
+		c.Totals.Orders++
+		c.Totals.Products += len(order.Products)
+		c.Totals.Size += order.Size
+		c.Totals.AmountSpent += order.AmountSpent
+		c.Orders = append(c.Orders, &order)
+	}
+	// the newest orders first, like the library page of the site
+	sort.SliceStable(c.Orders, func(i, j int) bool {
+		return c.Orders[i].Created.After(c.Orders[j].Created)
+	})
+	return &c
+}
+
+// newProduct describes a product from its assets among the ones of its order
+func newProduct(p *hbclient.Product, assets hbclient.Assets, local *Local, totals *Totals) *Product {
+	product := Product{
+		Name:        p.HumanName,
+		MachineName: p.MachineName,
+		Platforms:   []string{},
+		Formats:     []string{},
+		Files:       []*File{},
+	}
+	platforms, formats := map[string]bool{}, map[string]bool{}
+	downloaded := 0
+	for _, asset := range assets {
+		if asset.Product != p {
+			continue
+		}
+		f := File{
+			Platform: asset.Platform(),
+			Format:   strings.ToLower(strings.TrimPrefix(asset.Type.Name, ".")),
+			Filename: downloader.Filename(asset),
+			Size:     asset.Size(),
+			Status:   local.Status(asset),
+		}
+		if !platforms[f.Platform] {
+			platforms[f.Platform] = true
+			product.Platforms = append(product.Platforms, f.Platform)
+		}
+		if !formats[f.Format] {
+			formats[f.Format] = true
+			product.Formats = append(product.Formats, f.Format)
+		}
+		if f.Status == StatusDownloaded {
+			downloaded++
+		}
+		if meta := local.Meta(asset); meta != nil && product.Title == "" {
+			product.Title, product.Authors, product.ISBN = meta.Title, meta.Creators, meta.ISBN()
+		}
+		product.Size += f.Size
+		product.Files = append(product.Files, &f)
+	}
+	if local != nil {
+		product.Status = productStatus(downloaded, len(product.Files))
+	}
+	totals.Files += len(product.Files)
+	totals.Downloaded += downloaded
+	return &product
+}
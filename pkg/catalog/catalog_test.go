@@ -0,0 +1,171 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/manifest"
+)
+
+func testOrders() []*hbclient.Order {
+	return []*hbclient.Order{
+		{
+			GameKey:     "order1",
+			Created:     hbclient.NewTime(time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)),
+			AmountSpent: 15,
+			Currency:    "usd",
+			Product:     &hbclient.Product{HumanName: "Humble Book Bundle: Hacking"},
+			Products: []*hbclient.Product{
+				{HumanName: "Practical Malware Analysis", MachineName: "practicalmalwareanalysis", Downloads: []*hbclient.Download{
+					{Platform: "ebook", Types: []*hbclient.DownloadType{{Name: "PDF", FileSize: 3}, {Name: "EPUB", FileSize: 4}}},
+				}},
+				{HumanName: "Black Hat Python", MachineName: "blackhatpython", Downloads: []*hbclient.Download{
+					{Platform: "ebook", Types: []*hbclient.DownloadType{{Name: "PDF", FileSize: 5, MD5: "aaa"}}},
+				}},
+				{HumanName: "Steam Key | Game", MachineName: "game_steam"},
+			},
+		},
+		{
+			GameKey:     "order2",
+			Created:     hbclient.NewTime(time.Date(2020, 5, 6, 0, 0, 0, 0, time.UTC)),
+			AmountSpent: 1,
+			Currency:    "usd",
+			Product:     &hbclient.Product{HumanName: "Soundtrack Bundle"},
+			Products: []*hbclient.Product{
+				{HumanName: "Soundtrack", MachineName: "soundtrack", Downloads: []*hbclient.Download{
+					{Platform: "audio", Types: []*hbclient.DownloadType{{Name: "MP3", FileSize: 10}, {Name: "FLAC", FileSize: 20}}},
+				}},
+			},
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dest, err := ioutil.TempDir("", "hbd-catalog.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dest)
+	orders := testOrders()
+
+	// the pdf is on disk, the epub has the wrong size, the daemon recorded an older black hat python
+	bundle := filepath.Join(dest, "Humble Book Bundle: Hacking")
+	os.MkdirAll(bundle, 0755)
+	ioutil.WriteFile(filepath.Join(bundle, "Practical Malware Analysis.pdf"), []byte("pdf"), 0644)
+	ioutil.WriteFile(filepath.Join(bundle, "Practical Malware Analysis.epub"), []byte("epub, newer"), 0644)
+	ioutil.WriteFile(filepath.Join(dest, "Soundtrack.mp3"), []byte("0123456789"), 0644)
+	m, _ := manifest.Load(dest)
+	bhp := orders[0].Assets()[2]
+	bhp.Type = &hbclient.DownloadType{Name: "PDF", FileSize: 5, MD5: "old"}
+	m.Put(bhp, "Humble Book Bundle: Hacking/Black Hat Python.pdf")
+	m.SetMeta(bhp, &ebookmeta.Metadata{Title: "Black Hat Python, 2nd Edition", Creators: []string{"Justin Seitz", "Tim Arnold"}, Identifiers: []ebookmeta.Identifier{{Scheme: "isbn", Value: "978-1-7185-0112-6"}}})
+	ioutil.WriteFile(filepath.Join(bundle, "Black Hat Python.pdf"), []byte("older"), 0644)
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	local, err := NewLocal(dest)
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	c := Build(orders, local)
+	if len(c.Orders) != 2 || c.Orders[0].Key != "order2" {
+		t.Fatalf("expected the newest order first but got %+v", c.Orders)
+	}
+	expected := Totals{Orders: 2, Products: 4, Files: 5, Downloaded: 2, Size: 42, AmountSpent: 16}
+	if c.Totals != expected {
+		t.Errorf("expected totals %+v but got %+v", expected, c.Totals)
+	}
+	statuses := []string{}
+	for _, o := range c.Orders {
+		for _, p := range o.Products {
+			statuses = append(statuses, p.Name+"="+p.Status)
+			for _, f := range p.Files {
+				statuses = append(statuses, f.Format+"="+f.Status)
+			}
+		}
+	}
+	if s := strings.Join(statuses, ","); s != "Soundtrack=partial,mp3=downloaded,flac=missing,Practical Malware Analysis=partial,pdf=downloaded,epub=changed,Black Hat Python=none,pdf=changed,Steam Key | Game=" {
+		t.Errorf("unexpected statuses %s", s)
+	}
+	if p := c.Orders[0].Products[0]; strings.Join(p.Platforms, ",") != "audio" || strings.Join(p.Formats, ",") != "mp3,flac" || p.Size != 30 {
+		t.Errorf("expected the soundtrack platforms, formats and size but got %+v", p)
+	}
+
+	if p := c.Orders[1].Products[1]; p.Title != "Black Hat Python, 2nd Edition" || strings.Join(p.Authors, ",") != "Justin Seitz,Tim Arnold" || p.ISBN != "9781718501126" {
+		t.Errorf("expected the recorded metadata but got %+v", p)
+	}
+
+	// without a destination nothing is reported as missing
+	if c := Build(orders, nil); c.Orders[0].Products[0].Status != "" || c.Orders[0].Products[0].Files[0].Status != "" || c.Dest != "" {
+		t.Errorf("expected no statuses without a destination but got %+v", c.Orders[0].Products[0])
+	}
+}
+
+func TestWrite(t *testing.T) {
+	c := Build(testOrders(), nil)
+	c.Generated = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatCSV, c); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 7 {
+		t.Fatalf("expected a header and 6 rows but got %v %v", rows, err)
+	}
+	if s := strings.Join(rows[3], ","); s != "order1,Humble Book Bundle: Hacking,2019-03-01,15.00,usd,Practical Malware Analysis,practicalmalwareanalysis,,,,ebook,pdf,Practical Malware Analysis.pdf,3," {
+		t.Errorf("unexpected csv row %s", s)
+	}
+	if s := strings.Join(rows[6], ","); s != "order1,Humble Book Bundle: Hacking,2019-03-01,15.00,usd,Steam Key | Game,game_steam,,,,,,,," {
+		t.Errorf("expected a row for the product without files but got %s", s)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatJSON, c); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	decoded := Catalog{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Orders) != 2 || decoded.Orders[1].Products[0].Files[1].Format != "epub" {
+		t.Errorf("expected the catalog to round trip but got %+v %v", decoded, err)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatMarkdown, c); err != nil {
+		t.Fatalf("WriteMarkdown: %v", err)
+	}
+	md := buf.String()
+	for _, s := range []string{
+		"4 products, 5 files, 42 B, generated 2020-06-01 12:00 UTC.",
+		"## Humble Book Bundle: Hacking\n\n`order1` · 2019-03-01 · 15.00 USD · 12 B\n",
+		"| Practical Malware Analysis | ebook | pdf, epub | 7 B |  |\n",
+		"| Steam Key \\| Game |  |  |  |  |\n",
+	} {
+		if !strings.Contains(md, s) {
+			t.Errorf("expected markdown to contain %q but got\n%s", s, md)
+		}
+	}
+
+	buf.Reset()
+	c.Orders[0].Products[0].Name = "<script>alert(1)</script>"
+	if err := Write(&buf, FormatHTML, c); err != nil {
+		t.Fatalf("WriteHTML: %v", err)
+	}
+	page := buf.String()
+	if !strings.Contains(page, `data-platforms="audio" data-status="" data-order="order2"`) || !strings.Contains(page, "&lt;script&gt;alert(1)&lt;/script&gt;") || !strings.Contains(page, "<code>order1</code>") {
+		t.Errorf("expected escaped product rows and orders in the page but got\n%s", page)
+	}
+
+	if err := Write(&buf, "pdf", c); err == nil {
+		t.Errorf("expected an invalid format to fail")
+	}
+}
@@ -0,0 +1,113 @@
+package catalog
+
+// catalogHTML renders every product as a table row, the script only hides the
+// rows not matching the filters so the page reads fine without it
+const catalogHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Humble Bundle catalog</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+td, th { text-align: left; padding: .2em .4em; border-bottom: 1px solid #eee; vertical-align: top; }
+th { position: sticky; top: 0; background: #fff; }
+td.num, th.num { text-align: right; white-space: nowrap; }
+.muted { color: #888; font-size: .9em; }
+.complete { color: #070; }
+.partial { color: #a60; }
+.none { color: #b00; }
+#filters { position: sticky; top: 0; background: #fff; padding: .5em 0; }
+#filters input { width: 20em; }
+</style>
+</head>
+<body>
+<h1>Humble Bundle catalog</h1>
+<p class="muted">{{summary .}}</p>
+<p id="filters">
+  <input id="q" type="search" placeholder="filter, eg; malware or pdf" autofocus>
+  <select id="platform"><option value="">all platforms</option></select>
+  <select id="status">
+    <option value="">any status</option>
+    <option value="complete">complete</option>
+    <option value="partial">partial</option>
+    <option value="none">none</option>
+  </select>
+  <span id="count" class="muted"></span>
+</p>
+<h2>Products</h2>
+<table>
+<thead><tr><th>Product</th><th>Bundle</th><th>Platforms</th><th>Formats</th><th class="num">Size</th><th>Status</th></tr></thead>
+<tbody id="products">
+{{- range $o := .Orders}}{{range .Products}}
+<tr data-platforms="{{join .Platforms " "}}" data-status="{{.Status}}" data-order="{{$o.Key}}">
+  <td>{{.Name}}<br><span class="muted">{{.MachineName}}</span></td>
+  <td>{{$o.Name}}<br><span class="muted">{{date $o}}</span></td>
+  <td>{{join .Platforms ", "}}</td>
+  <td>{{join .Formats ", "}}</td>
+  <td class="num">{{size .Size}}</td>
+  <td class="{{.Status}}">{{.Status}}</td>
+</tr>
+{{- end}}{{end}}
+</tbody>
+</table>
+<h2>Orders</h2>
+<table>
+<thead><tr><th>Bundle</th><th>Key</th><th>Date</th><th class="num">Amount</th><th class="num">Products</th><th class="num">Size</th></tr></thead>
+<tbody id="orders">
+{{- range .Orders}}
+<tr data-order="{{.Key}}">
+  <td>{{.Name}}</td>
+  <td><code>{{.Key}}</code></td>
+  <td>{{date .}}</td>
+  <td class="num">{{amount .}}</td>
+  <td class="num">{{len .Products}}</td>
+  <td class="num">{{size .Size}}</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+<script>
+(function() {
+  var q = document.getElementById('q');
+  var platform = document.getElementById('platform');
+  var status = document.getElementById('status');
+  var products = Array.prototype.slice.call(document.querySelectorAll('#products tr'));
+  var orders = Array.prototype.slice.call(document.querySelectorAll('#orders tr'));
+  var platforms = {};
+  products.forEach(function(tr) {
+    tr.dataset.platforms.split(' ').forEach(function(p) { if (p) platforms[p] = true; });
+  });
+  Object.keys(platforms).sort().forEach(function(p) {
+    var o = document.createElement('option');
+    o.value = o.textContent = p;
+    platform.appendChild(o);
+  });
+  function filter() {
+    var words = q.value.toLowerCase().split(/\s+/).filter(Boolean);
+    var visible = 0, visibleOrders = {};
+    products.forEach(function(tr) {
+      var text = tr.textContent.toLowerCase();
+      var show = words.every(function(w) { return text.indexOf(w) >= 0; }) &&
+        (!platform.value || (' ' + tr.dataset.platforms + ' ').indexOf(' ' + platform.value + ' ') >= 0) &&
+        (!status.value || tr.dataset.status === status.value);
+      tr.hidden = !show;
+      if (show) {
+        visible++;
+        visibleOrders[tr.dataset.order] = true;
+      }
+    });
+    orders.forEach(function(tr) {
+      var text = tr.textContent.toLowerCase();
+      tr.hidden = !visibleOrders[tr.dataset.order] && !(words.length && words.every(function(w) { return text.indexOf(w) >= 0; }));
+    });
+    document.getElementById('count').textContent = visible + ' of ' + products.length + ' products';
+  }
+  [q, platform, status].forEach(function(el) { el.addEventListener('input', filter); });
+  filter();
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,162 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+
+	"diogogmt.com/hbd/pkg/units"
+	"github.com/pkg/errors"
+)
+
+// output formats
+const (
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+)
+
+// Formats lists the output formats in the order they're documented
+var Formats = []string{FormatCSV, FormatJSON, FormatMarkdown, FormatHTML}
+
+// ValidFormat checks an output format
+func ValidFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return errors.Errorf("invalid format %q, expected %s", format, strings.Join(Formats, ", "))
+}
+
+// Write renders the catalog in format
+func Write(w io.Writer, format string, c *Catalog) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, c)
+	case FormatJSON:
+		return WriteJSON(w, c)
+	case FormatMarkdown:
+		return WriteMarkdown(w, c)
+	case FormatHTML:
+		return WriteHTML(w, c)
+	}
+	return ValidFormat(format)
+}
+
+// WriteCSV writes a row per file, products without files and orders without
+// products get a row with the missing columns empty
+func WriteCSV(w io.Writer, c *Catalog) error {
+	cw := csv.NewWriter(w)
+	headers := []string{"order_key", "order_name", "order_date", "amount_spent", "currency", "product", "machine_name", "title", "authors", "isbn", "platform", "format", "filename", "size", "status"}
+	if err := cw.Write(headers); err != nil {
+		return errors.Wrap(err, "csv.Write")
+	}
+	for _, o := range c.Orders {
+		order := []string{o.Key, o.Name, date(o), strconv.FormatFloat(o.AmountSpent, 'f', 2, 64), o.Currency}
+		if len(o.Products) == 0 {
+			cw.Write(append(order, "", "", "", "", "", "", "", "", "", ""))
+		}
+		for _, p := range o.Products {
+			product := append(append([]string{}, order...), p.Name, p.MachineName, p.Title, strings.Join(p.Authors, " & "), p.ISBN)
+			if len(p.Files) == 0 {
+				cw.Write(append(product, "", "", "", "", ""))
+				continue
+			}
+			for _, f := range p.Files {
+				cw.Write(append(append([]string{}, product...), f.Platform, f.Format, f.Filename, strconv.FormatInt(f.Size, 10), f.Status))
+			}
+		}
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "csv.Write")
+}
+
+// WriteJSON writes the catalog as an indented JSON document
+func WriteJSON(w io.Writer, c *Catalog) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(c), "json.Encode")
+}
+
+// WriteMarkdown writes a section per order with a table of its products
+func WriteMarkdown(w io.Writer, c *Catalog) error {
+	ew := errWriter{w: w}
+	ew.printf("# Humble Bundle catalog\n\n")
+	ew.printf("%s\n", summary(c))
+	for _, o := range c.Orders {
+		ew.printf("\n## %s\n\n", mdEscape(o.Name))
+		ew.printf("`%s` · %s · %s · %s\n\n", o.Key, date(o), amount(o), units.FormatSize(o.Size))
+		if len(o.Products) == 0 {
+			ew.printf("No products.\n")
+			continue
+		}
+		ew.printf("| Product | Platforms | Formats | Size | Status |\n")
+		ew.printf("| --- | --- | --- | ---: | --- |\n")
+		for _, p := range o.Products {
+			ew.printf("| %s | %s | %s | %s | %s |\n", mdEscape(p.Name), strings.Join(p.Platforms, ", "), strings.Join(p.Formats, ", "), size(p.Size), p.Status)
+		}
+	}
+	return ew.err
+}
+
+// WriteHTML writes a single static page, the filters run in the browser
+func WriteHTML(w io.Writer, c *Catalog) error {
+	return errors.Wrap(htmlTemplate.Execute(w, c), "template.Execute")
+}
+
+func summary(c *Catalog) string {
+	s := fmt.Sprintf("%d orders, %d products, %d files, %s", c.Totals.Orders, c.Totals.Products, c.Totals.Files, units.FormatSize(c.Totals.Size))
+	if c.Dest != "" {
+		s += fmt.Sprintf(", %d downloaded in %s", c.Totals.Downloaded, c.Dest)
+	}
+	return s + fmt.Sprintf(", generated %s.", c.Generated.Format("2006-01-02 15:04 MST"))
+}
+
+func date(o *Order) string {
+	if o.Created.IsZero() {
+		return ""
+	}
+	return o.Created.Format("2006-01-02")
+}
+
+func amount(o *Order) string {
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", o.AmountSpent, strings.ToUpper(o.Currency)))
+}
+
+func size(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return units.FormatSize(n)
+}
+
+// mdEscape keeps product names from breaking the tables
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`", "\n", " ").Replace(s)
+}
+
+// errWriter keeps the first write error so rendering code doesn't check every line
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
+
+var htmlTemplate = template.Must(template.New("catalog").Funcs(template.FuncMap{
+	"date":    date,
+	"amount":  amount,
+	"size":    size,
+	"summary": summary,
+	"join":    strings.Join,
+}).Parse(catalogHTML))
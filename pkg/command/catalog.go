@@ -0,0 +1,132 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"diogogmt.com/hbd/pkg/catalog"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/library"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/pkg/errors"
+)
+
+// CatalogCmd wraps the catalog config and a ffcli.Command
+type CatalogCmd struct {
+	Conf *CatalogConfig
+
+	*ffcli.Command
+}
+
+// CatalogConfig has the config for the catalog command and a reference to the root command config
+type CatalogConfig struct {
+	RootConf *RootConfig
+
+	Key    string
+	All    bool
+	Cached bool
+	Dest   string
+	Format string
+	Output string
+}
+
+// NewCatalogCmd creates a new CatalogCmd
+func NewCatalogCmd(rootConf *RootConfig) *CatalogCmd {
+	conf := CatalogConfig{
+		RootConf: rootConf,
+	}
+	cmd := CatalogCmd{
+		Conf: &conf,
+	}
+	fs := flag.NewFlagSet("hbd catalog", flag.ExitOnError)
+	cmd.RegisterFlags(fs)
+
+	cmd.Command = &ffcli.Command{
+		Name:       "catalog",
+		ShortUsage: "hbd catalog [-key X | -all] [-cached] [-dest D] [-format csv|json|md|html] [-o FILE]",
+		ShortHelp:  "Export an inventory of the orders, products and their download status",
+		FlagSet:    fs,
+		Exec:       cmd.Exec,
+	}
+	return &cmd
+}
+
+// RegisterFlags registers a set of flags for the catalog command
+func (c *CatalogCmd) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Conf.Key, "key", "", "purchase key")
+	fs.BoolVar(&c.Conf.All, "all", false, "list every order of the account")
+	fs.BoolVar(&c.Conf.Cached, "cached", false, "read the orders cached in the state directory instead of fetching them")
+	fs.StringVar(&c.Conf.Dest, "dest", ".", "directory the download status is checked against, empty to skip it")
+	fs.StringVar(&c.Conf.Format, "format", catalog.FormatMarkdown, "output format, "+strings.Join(catalog.Formats, ", "))
+	fs.StringVar(&c.Conf.Output, "o", "", "file to write the catalog to, stdout by default")
+}
+
+// Exec executes the catalog command
+func (c *CatalogCmd) Exec(ctx context.Context, args []string) error {
+	if err := catalog.ValidFormat(c.Conf.Format); err != nil {
+		return err
+	}
+	if c.Conf.Key == "" && !c.Conf.All {
+		return errors.New("missing key, use -key or -all")
+	}
+	orders, err := c.orders(ctx)
+	if err != nil {
+		return err
+	}
+	var local *catalog.Local
+	if c.Conf.Dest != "" {
+		if local, err = catalog.NewLocal(c.Conf.Dest); err != nil {
+			return err
+		}
+	}
+	cat := catalog.Build(orders, local)
+
+	var out io.Writer = c.Conf.RootConf.Out
+	if c.Conf.Output != "" {
+		f, err := os.Create(c.Conf.Output)
+		if err != nil {
+			return errors.Wrap(err, "os.Create")
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := catalog.Write(out, c.Conf.Format, cat); err != nil {
+		return err
+	}
+	if c.Conf.Output != "" {
+		fmt.Fprintf(c.Conf.RootConf.Out, "wrote %d orders and %d products to %s\n", cat.Totals.Orders, cat.Totals.Products, c.Conf.Output)
+	}
+	return nil
+}
+
+// orders reads the orders from the library cache, or fetches them and refreshes the cache
+func (c *CatalogCmd) orders(ctx context.Context) ([]*hbclient.Order, error) {
+	lib := library.New(state.Dir(c.Conf.RootConf.StateDir), c.Conf.RootConf.HBClient)
+	if c.Conf.Cached {
+		if c.Conf.All {
+			return lib.Orders()
+		}
+		order, err := lib.Order(c.Conf.Key)
+		if err != nil {
+			return nil, err
+		}
+		return []*hbclient.Order{order}, nil
+	}
+	keys := []string{}
+	if !c.Conf.All {
+		keys = append(keys, c.Conf.Key)
+	}
+	orders, err := lib.Sync(ctx, keys...)
+	if ctx.Err() != nil {
+		return nil, ErrInterrupted
+	}
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+func TestCatalog(t *testing.T) {
+	srv := newKeysServer(t)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "hbd-catalog.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := filepath.Join(dir, "state")
+
+	dd := []struct {
+		name      string
+		args      []string
+		contains  []string
+		expectErr bool
+	}{
+		{name: "missing-key", args: []string{}, expectErr: true},
+		{name: "invalid-format", args: []string{"-all", "-format", "xml"}, expectErr: true},
+		{name: "not-cached", args: []string{"-cached", "-key", "order1"}, expectErr: true},
+		{
+			name:     "csv-all",
+			args:     []string{"-all", "-format", "csv", "-dest", ""},
+			contains: []string{"order_key,order_name,order_date", "order1,Humble Indie Bundle,", "order2,Humble Book Bundle,"},
+		},
+		{
+			// the previous run cached the orders
+			name:     "md-cached",
+			args:     []string{"-cached", "-key", "order2"},
+			contains: []string{"# Humble Bundle catalog", "## Humble Book Bundle\n\n`order2`"},
+		},
+		{
+			name:     "html-file",
+			args:     []string{"-cached", "-all", "-format", "html", "-o", filepath.Join(dir, "catalog.html")},
+			contains: []string{"wrote 2 orders and 0 products to " + filepath.Join(dir, "catalog.html")},
+		},
+	}
+	for _, d := range dd {
+		var out strings.Builder
+		rootCmd := NewRootCmd(WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))), WithOutput(&out), WithStateDir(stateDir))
+		catalogCmd := NewCatalogCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			catalogCmd.Command,
+		}
+		if err := rootCmd.Parse(append([]string{"catalog"}, d.args...)); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		err := rootCmd.Run(context.Background())
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", d.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: rootCmd.Run: %v", d.name, err)
+		}
+		for _, s := range d.contains {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("%s: expected output to contain %q but got\n%s", d.name, s, out.String())
+			}
+		}
+	}
+	if page, err := ioutil.ReadFile(filepath.Join(dir, "catalog.html")); err != nil || !strings.Contains(string(page), "<code>order1</code>") {
+		t.Errorf("expected the html catalog to be written but got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"diogogmt.com/hbd/pkg/daemon"
+	"diogogmt.com/hbd/pkg/dedupe"
+	"diogogmt.com/hbd/pkg/downloader"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/library"
+	"diogogmt.com/hbd/pkg/notify"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/peterbourgon/ff/v2"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/pkg/errors"
+)
+
+// daemonLockFile is held while a daemon runs so only one syncs the same state directory
+const daemonLockFile = "daemon.lock"
+
+// notifyTimeout bounds how long each notifier has to deliver a notification
+const notifyTimeout = 30 * time.Second
+
+// DaemonCmd wraps the daemon config and a ffcli.Command
+type DaemonCmd struct {
+	Conf *DaemonConfig
+
+	*ffcli.Command
+}
+
+// DaemonConfig has the config for the daemon command and a reference to the root command config
+type DaemonConfig struct {
+	RootConf *RootConfig
+
+	Dest       string
+	Keys       string
+	TypesFlag  string
+	Schedule   string
+	HealthAddr string
+	Once       bool
+	Via        string
+	Keep       int
+	Notify     notify.Specs
+
+	LimitRate     string
+	LimitRateFile string
+	LimitSchedule string
+
+	Hooks           downloader.Hooks
+	HookConcurrency int
+	HookTimeout     time.Duration
+
+	Extract       bool
+	ExtractDir    string
+	ExtractDelete bool
+
+	Store string
+	Link  string
+}
+
+// NewDaemonCmd creates a new DaemonCmd
+func NewDaemonCmd(rootConf *RootConfig) *DaemonCmd {
+	conf := DaemonConfig{
+		RootConf: rootConf,
+	}
+	cmd := DaemonCmd{
+		Conf: &conf,
+	}
+	fs := flag.NewFlagSet("hbd daemon", flag.ExitOnError)
+	cmd.RegisterFlags(fs)
+
+	cmd.Command = &ffcli.Command{
+		Name:       "daemon",
+		ShortUsage: "hbd daemon -dest D [-keys X,Y] [-schedule 6h | -schedule '30 3 * * *'] [-once]",
+		ShortHelp:  "Sync the library on a schedule and download new or changed assets",
+		FlagSet:    fs,
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+		},
+		Exec: cmd.Exec,
+	}
+	return &cmd
+}
+
+// RegisterFlags registers a set of flags for the daemon command
+func (c *DaemonCmd) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Conf.Dest, "dest", ".", "directory where each bundle is downloaded into its own subdirectory")
+	fs.StringVar(&c.Conf.Keys, "keys", "", "comma separated purchase keys to sync, every order of the account when empty")
+	fs.StringVar(&c.Conf.TypesFlag, "types", "all", "comma separated list of file types, eg; pdf,epub,mobi")
+	fs.StringVar(&c.Conf.Schedule, "schedule", "24h", "interval, eg; 6h, or cron expression, eg; 30 3 * * *")
+	fs.StringVar(&c.Conf.HealthAddr, "health-addr", "127.0.0.1:8081", "address serving /healthz and /status, empty to disable")
+	fs.BoolVar(&c.Conf.Once, "once", false, "sync once and exit, for cron jobs")
+	fs.IntVar(&c.Conf.Keep, "keep-versions", 3, "previous versions of a changed file kept in the .old directory next to it, 0 to keep none")
+	fs.Var(&c.Conf.Notify, "notify", "repeatable EVENTS:KIND:TARGET notifier, KIND is exec, webhook or smtp, eg; new_assets,auth_expired:webhook:https://example.com/hook")
+	fs.StringVar(&c.Conf.Via, "via", downloader.ViaHTTP, "download backend, http or torrent")
+	fs.StringVar(&c.Conf.LimitRate, "limit-rate", "0", "max aggregate download rate, eg; 500K, 5M, 0 for unlimited")
+	fs.StringVar(&c.Conf.LimitRateFile, "limit-rate-file", "0", "max download rate of each file, 0 for unlimited")
+	fs.StringVar(&c.Conf.LimitSchedule, "limit-schedule", "", "daily windows overriding -limit-rate, eg; 01:00-07:00=0,09:00-18:00=1M")
+	fs.BoolVar(&c.Conf.Extract, "extract", false, "unpack downloaded zip, tar, tar.gz and tar.bz2 archives")
+	fs.StringVar(&c.Conf.ExtractDir, "extract-dir", "{name}", "where archives are unpacked, relative to the archive, with {name}, {product}, {platform}, {type}, {bundle}, {order}, {title}, {author} and {isbn} placeholders")
+	fs.BoolVar(&c.Conf.ExtractDelete, "extract-delete", false, "remove archives once unpacked")
+	fs.StringVar(&c.Conf.Store, "store", "", "content addressed store linking identical files instead of downloading them again, eg; D/"+dedupe.DefaultDir)
+	fs.StringVar(&c.Conf.Link, "link", dedupe.LinkHard, "how files are linked to the -store, hard or symlink")
+	fs.Var(&c.Conf.Hooks, "exec", "repeatable SELECTOR:COMMAND hook run on downloaded files, eg; platform=windows:clamscan \"$HBD_PATH\"")
+	fs.IntVar(&c.Conf.HookConcurrency, "exec-concurrency", 2, "max hooks running at once")
+	fs.DurationVar(&c.Conf.HookTimeout, "exec-timeout", 10*time.Minute, "kill hooks running longer than this, 0 for no limit")
+	fs.String("config", "", "config file with one flag per line, eg; schedule 30 3 * * *")
+}
+
+// Exec executes the daemon command
+func (c *DaemonCmd) Exec(ctx context.Context, args []string) error {
+	d, err := c.newDaemon()
+	if err != nil {
+		return err
+	}
+	lock, err := state.Dir(c.Conf.RootConf.StateDir).Lock(daemonLockFile)
+	if err != nil {
+		return errors.Wrap(err, "another daemon is running")
+	}
+	defer lock.Unlock()
+
+	if c.Conf.Once {
+		_, err := d.Sync(ctx)
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+		return err
+	}
+
+	if c.Conf.HealthAddr != "" {
+		srv := http.Server{Addr: c.Conf.HealthAddr, Handler: d.Handler()}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(c.Conf.RootConf.Out, "health endpoint: %v\n", err)
+			}
+		}()
+		defer srv.Close()
+		fmt.Fprintf(c.Conf.RootConf.Out, "health endpoint on http://%s/healthz\n", c.Conf.HealthAddr)
+	}
+	if err := d.Run(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ErrInterrupted
+}
+
+// newDaemon validates the flags and builds the daemon
+func (c *DaemonCmd) newDaemon() (*daemon.Daemon, error) {
+	if !downloader.ValidVia(c.Conf.Via) {
+		return nil, errors.Errorf("invalid -via %q, expected %s or %s", c.Conf.Via, downloader.ViaHTTP, downloader.ViaTorrent)
+	}
+	if c.Conf.Keep < 0 {
+		return nil, errors.Errorf("invalid -keep-versions %d", c.Conf.Keep)
+	}
+	schedule, err := daemon.ParseSchedule(c.Conf.Schedule)
+	if err != nil {
+		return nil, errors.Wrap(err, "-schedule")
+	}
+	dlOpts, err := limitOptions(c.Conf.LimitRate, c.Conf.LimitRateFile, c.Conf.LimitSchedule)
+	if err != nil {
+		return nil, err
+	}
+	dlOpts = append(dlOpts, downloader.WithVia(c.Conf.Via), downloader.WithHooks(c.Conf.Hooks, c.Conf.HookConcurrency, c.Conf.HookTimeout))
+	if c.Conf.Extract {
+		dlOpts = append(dlOpts, downloader.WithExtract(c.Conf.ExtractDir, c.Conf.ExtractDelete))
+	}
+	if c.Conf.Store != "" {
+		store, err := dedupe.Open(c.Conf.Store, c.Conf.Link)
+		if err != nil {
+			return nil, errors.Wrap(err, "-store")
+		}
+		dlOpts = append(dlOpts, downloader.WithStore(store))
+	}
+
+	stateDir := state.Dir(c.Conf.RootConf.StateDir)
+	opts := []daemon.Option{
+		daemon.WithSchedule(schedule),
+		daemon.WithFilters(hbclient.ByType(strings.Split(c.Conf.TypesFlag, ",")...)),
+		daemon.WithDownloaderOptions(dlOpts...),
+		daemon.WithKeepVersions(c.Conf.Keep),
+		daemon.WithLog(c.Conf.RootConf.Out),
+	}
+	if len(c.Conf.Notify) != 0 {
+		opts = append(opts, daemon.WithNotifier(c.Conf.Notify.Dispatcher(notify.NewDispatcher(notifyTimeout))))
+	}
+	if c.Conf.Keys != "" {
+		opts = append(opts, daemon.WithKeys(strings.Split(c.Conf.Keys, ",")...))
+	}
+	lib := library.New(stateDir, c.Conf.RootConf.HBClient)
+	return daemon.New(lib, stateDir, c.Conf.Dest, opts...), nil
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+func TestDaemon(t *testing.T) {
+	srv := newKeysServer(t)
+	defer srv.Close()
+
+	dd := []struct {
+		name      string
+		args      []string
+		locked    bool
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "once",
+			args:     []string{"-once", "-keys", "order1,order2"},
+			expected: "sync ok, 2 orders, 0/0 assets downloaded",
+		},
+		{
+			name:      "locked",
+			args:      []string{"-once"},
+			locked:    true,
+			expectErr: true,
+		},
+		{
+			name:      "invalid-schedule",
+			args:      []string{"-schedule", "sometimes"},
+			expectErr: true,
+		},
+	}
+	for _, d := range dd {
+		stateDir, err := ioutil.TempDir("", "hbd-state")
+		if err != nil {
+			t.Fatalf("ioutil.TempDir: %v", err)
+		}
+		defer os.RemoveAll(stateDir)
+		if d.locked {
+			lock, err := state.Dir(stateDir).Lock(daemonLockFile)
+			if err != nil {
+				t.Fatalf("%s: Lock: %v", d.name, err)
+			}
+			defer lock.Unlock()
+		}
+
+		var out strings.Builder
+		rootCmd := NewRootCmd(
+			WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))),
+			WithOutput(&out),
+			WithStateDir(stateDir),
+		)
+		daemonCmd := NewDaemonCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			daemonCmd.Command,
+		}
+		args := append([]string{"daemon", "-dest", stateDir + "/dest"}, d.args...)
+		if err := rootCmd.Parse(args); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		err = rootCmd.Run(context.Background())
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", d.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: rootCmd.Run: %v", d.name, err)
+		}
+		if !strings.Contains(out.String(), d.expected) {
+			t.Errorf("%s: expected output to contain %q but got\n%s", d.name, d.expected, out.String())
+		}
+	}
+}
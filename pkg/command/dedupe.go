@@ -0,0 +1,91 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"diogogmt.com/hbd/pkg/dedupe"
+	"diogogmt.com/hbd/pkg/units"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+// DedupeCmd wraps the dedupe config and a ffcli.Command
+type DedupeCmd struct {
+	Conf *DedupeConfig
+
+	*ffcli.Command
+}
+
+// DedupeConfig has the config for the dedupe command and a reference to the root command config
+type DedupeConfig struct {
+	RootConf *RootConfig
+
+	Dest   string
+	Store  string
+	Link   string
+	DryRun bool
+}
+
+// NewDedupeCmd creates a new DedupeCmd
+func NewDedupeCmd(rootConf *RootConfig) *DedupeCmd {
+	conf := DedupeConfig{
+		RootConf: rootConf,
+	}
+	cmd := DedupeCmd{
+		Conf: &conf,
+	}
+	fs := flag.NewFlagSet("hbd dedupe", flag.ExitOnError)
+	cmd.RegisterFlags(fs)
+
+	cmd.Command = &ffcli.Command{
+		Name:       "dedupe",
+		ShortUsage: "hbd dedupe -dest D [-store DIR] [-link hard|symlink] [-dry-run]",
+		ShortHelp:  "Replace identical files with links to a content addressed store",
+		FlagSet:    fs,
+		Exec:       cmd.Exec,
+	}
+	return &cmd
+}
+
+// RegisterFlags registers a set of flags for the dedupe command
+func (c *DedupeCmd) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Conf.Dest, "dest", ".", "directory holding the downloaded bundles")
+	fs.StringVar(&c.Conf.Store, "store", "", "content addressed store, "+dedupe.DefaultDir+" in -dest by default")
+	fs.StringVar(&c.Conf.Link, "link", dedupe.LinkHard, "how files are linked to the store, hard or symlink")
+	fs.BoolVar(&c.Conf.DryRun, "dry-run", false, "report the duplicates without changing any file")
+}
+
+// Exec executes the dedupe command
+func (c *DedupeCmd) Exec(ctx context.Context, args []string) error {
+	storeDir := c.Conf.Store
+	if storeDir == "" {
+		storeDir = filepath.Join(c.Conf.Dest, dedupe.DefaultDir)
+	}
+	store, err := dedupe.Open(storeDir, c.Conf.Link)
+	if err != nil {
+		return err
+	}
+	report, err := store.Dedupe(ctx, c.Conf.Dest, c.Conf.DryRun)
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	if err != nil {
+		return err
+	}
+
+	out := c.Conf.RootConf.Out
+	if c.Conf.RootConf.Verbose || c.Conf.DryRun {
+		for _, d := range report.Duplicates {
+			fmt.Fprintf(out, "  %s (%s) -> %s\n", d.Path, units.FormatSize(d.Size), d.Object)
+		}
+	}
+	reclaimed := "reclaimed"
+	if c.Conf.DryRun {
+		reclaimed = "would reclaim"
+	}
+	fmt.Fprintf(out, "%d files, %d duplicates, %s %s, %d stored, %d already linked in %s\n",
+		report.Files, len(report.Duplicates), reclaimed, units.FormatSize(report.Reclaimed), report.Stored, report.Linked, store.Dir())
+	return nil
+}
@@ -0,0 +1,72 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/dedupe"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+func TestDedupe(t *testing.T) {
+	dest, err := ioutil.TempDir("", "hbd-dedupe.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dest)
+	for _, bundle := range []string{"Book Bundle", "Other Bundle"} {
+		if err := os.MkdirAll(filepath.Join(dest, bundle), 0755); err != nil {
+			t.Fatalf("os.MkdirAll: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dest, bundle, "Book.pdf"), []byte(strings.Repeat("pdf", 1024)), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+	}
+	store := filepath.Join(dest, dedupe.DefaultDir)
+
+	dd := []struct {
+		name      string
+		args      []string
+		contains  []string
+		expectErr bool
+	}{
+		{name: "invalid-link", args: []string{"-dest", dest, "-link", "copy"}, expectErr: true},
+		{
+			name:     "dry-run",
+			args:     []string{"-dest", dest, "-dry-run"},
+			contains: []string{filepath.Join(dest, "Other Bundle", "Book.pdf") + " (3.0 KiB)", "2 files, 1 duplicates, would reclaim 3.0 KiB, 1 stored, 0 already linked in " + store},
+		},
+		{name: "dedupe", args: []string{"-dest", dest}, contains: []string{"2 files, 1 duplicates, reclaimed 3.0 KiB, 1 stored"}},
+		{name: "linked", args: []string{"-dest", dest}, contains: []string{"2 files, 0 duplicates, reclaimed 0 B, 0 stored, 2 already linked"}},
+	}
+	for _, d := range dd {
+		var out strings.Builder
+		rootCmd := NewRootCmd(WithOutput(&out))
+		dedupeCmd := NewDedupeCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			dedupeCmd.Command,
+		}
+		if err := rootCmd.Parse(append([]string{"dedupe"}, d.args...)); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		err := rootCmd.Run(context.Background())
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", d.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: rootCmd.Run: %v", d.name, err)
+		}
+		for _, s := range d.contains {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("%s: expected output to contain %q but got\n%s", d.name, s, out.String())
+			}
+		}
+	}
+}
@@ -1,22 +1,35 @@
 package command
 
 import (
+	"bytes"
 	"context"
-	"crypto/md5"
-	"crypto/sha1"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
-	"sync"
+	"time"
 
+	"diogogmt.com/hbd/pkg/calibre"
+	"diogogmt.com/hbd/pkg/dedupe"
+	"diogogmt.com/hbd/pkg/downloader"
 	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/manifest"
+	"diogogmt.com/hbd/pkg/ratelimit"
+	"diogogmt.com/hbd/pkg/storage"
+	"diogogmt.com/hbd/pkg/tui"
+	"github.com/peterbourgon/ff/v2"
 	"github.com/peterbourgon/ff/v2/ffcli"
 	"github.com/pkg/errors"
 )
 
+// file layouts of the download destination
+const (
+	layoutFlat    = "flat"
+	layoutCalibre = "calibre"
+)
+
 // DownloadCmd wraps the download config and a ffcli.Command
 type DownloadCmd struct {
 	Conf *DownloadConfig
@@ -32,6 +45,30 @@ type DownloadConfig struct {
 	Dest      string
 	Types     map[string]struct{}
 	TypesFlag string
+	Via       string
+
+	Layout        string
+	Interactive   bool
+	Selection     string
+	SaveSelection string
+
+	LimitRate     string
+	LimitRateFile string
+	LimitSchedule string
+
+	Hooks           downloader.Hooks
+	HookConcurrency int
+	HookTimeout     time.Duration
+
+	Extract       bool
+	ExtractDir    string
+	ExtractDelete bool
+
+	Store string
+	Link  string
+
+	IgnoreSpace  bool
+	SkipExisting bool
 }
 
 // NewDownloadCmd creates a new DownloadCmd
@@ -48,10 +85,14 @@ func NewDownloadCmd(rootConf *RootConfig) *DownloadCmd {
 
 	cmd.Command = &ffcli.Command{
 		Name:       "download",
-		ShortUsage: "hbd download",
+		ShortUsage: "hbd download -key X [-dest D | -dest s3://bucket/prefix] [-types pdf,epub] [-i | -select FILE] [-save-selection FILE] [-layout calibre]",
 		ShortHelp:  "Download assets from bundle",
 		FlagSet:    fs,
-		Exec:       cmd.Exec,
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+		},
+		Exec: cmd.Exec,
 	}
 	return &cmd
 }
@@ -59,8 +100,27 @@ func NewDownloadCmd(rootConf *RootConfig) *DownloadCmd {
 // RegisterFlags registers a set of flags for the download command
 func (c *DownloadCmd) RegisterFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.Conf.Key, "key", "", "purchase key")
-	fs.StringVar(&c.Conf.Dest, "dest", "", "directory to download all bundle assets")
+	fs.StringVar(&c.Conf.Dest, "dest", "", "directory to download all bundle assets, or s3://bucket/prefix to upload them")
 	fs.StringVar(&c.Conf.TypesFlag, "types", "all", "comma separated list of file types, eg; pdf,epub,mobi")
+	fs.StringVar(&c.Conf.Via, "via", downloader.ViaHTTP, "download backend, http or torrent")
+	fs.StringVar(&c.Conf.Layout, "layout", layoutFlat, "file layout, flat or calibre to arrange ebooks as Author/Title (id)/Title - Author.ext with a metadata.opf")
+	fs.BoolVar(&c.Conf.Interactive, "i", false, "pick the assets to download in a terminal UI")
+	fs.StringVar(&c.Conf.Selection, "select", "", "selection file listing the assets to download, one product/platform/type per line")
+	fs.StringVar(&c.Conf.SaveSelection, "save-selection", "", "save the assets selected for download as a selection file")
+	fs.StringVar(&c.Conf.LimitRate, "limit-rate", "0", "max aggregate download rate, eg; 500K, 5M, 0 for unlimited")
+	fs.StringVar(&c.Conf.LimitRateFile, "limit-rate-file", "0", "max download rate of each file, 0 for unlimited")
+	fs.StringVar(&c.Conf.LimitSchedule, "limit-schedule", "", "daily windows overriding -limit-rate, eg; 01:00-07:00=0,09:00-18:00=1M")
+	fs.BoolVar(&c.Conf.Extract, "extract", false, "unpack downloaded zip, tar, tar.gz and tar.bz2 archives")
+	fs.StringVar(&c.Conf.ExtractDir, "extract-dir", "{name}", "where archives are unpacked, relative to the archive, with {name}, {product}, {platform}, {type}, {bundle}, {order}, {title}, {author} and {isbn} placeholders")
+	fs.BoolVar(&c.Conf.ExtractDelete, "extract-delete", false, "remove archives once unpacked")
+	fs.BoolVar(&c.Conf.IgnoreSpace, "ignore-space", false, "download even when the assets don't fit in the free space of -dest, files already there count unless skipped as they're fetched again next to the old copy")
+	fs.BoolVar(&c.Conf.SkipExisting, "skip-existing", false, "skip the assets already in -dest with the size and checksum reported by the API")
+	fs.StringVar(&c.Conf.Store, "store", "", "content addressed store linking identical files instead of downloading them again, see hbd dedupe")
+	fs.StringVar(&c.Conf.Link, "link", dedupe.LinkHard, "how files are linked to the -store, hard or symlink")
+	fs.Var(&c.Conf.Hooks, "exec", "repeatable SELECTOR:COMMAND hook run on downloaded files, eg; ext=zip:unzip -o \"$HBD_PATH\"")
+	fs.IntVar(&c.Conf.HookConcurrency, "exec-concurrency", 2, "max hooks running at once")
+	fs.DurationVar(&c.Conf.HookTimeout, "exec-timeout", 10*time.Minute, "kill hooks running longer than this, 0 for no limit")
+	fs.String("config", "", "config file with one flag per line, eg; limit-rate 5M")
 }
 
 // Exec executes the download command
@@ -68,131 +128,345 @@ func (c *DownloadCmd) Exec(ctx context.Context, args []string) error {
 	if c.Conf.Key == "" {
 		return errors.Errorf("missing key")
 	}
+	if !downloader.ValidVia(c.Conf.Via) {
+		return errors.Errorf("invalid -via %q, expected %s or %s", c.Conf.Via, downloader.ViaHTTP, downloader.ViaTorrent)
+	}
+	if c.Conf.Layout != layoutFlat && c.Conf.Layout != layoutCalibre {
+		return errors.Errorf("invalid -layout %q, expected %s or %s", c.Conf.Layout, layoutFlat, layoutCalibre)
+	}
+	if storage.IsS3(c.Conf.Dest) {
+		if err := c.checkS3Flags(); err != nil {
+			return err
+		}
+	}
+	opts, err := c.downloaderOptions()
+	if err != nil {
+		return err
+	}
 	for _, t := range strings.Split(c.Conf.TypesFlag, ",") {
 		c.Conf.Types[strings.ToLower(t)] = struct{}{}
 	}
 
-	order, err := c.Conf.RootConf.HBClient.GetOrder(c.Conf.Key)
+	order, err := c.Conf.RootConf.HBClient.GetOrder(ctx, c.Conf.Key)
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
 	if err != nil {
 		return errors.Wrap(err, "HBClient.GetOrder")
 	}
 	if c.Conf.Dest == "" {
-		c.Conf.Dest = fmt.Sprintf("./%s", strings.ReplaceAll(order.Product.HumanName, "/", "_"))
+		c.Conf.Dest = fmt.Sprintf("./%s", downloader.BundleDir(order))
 	}
-	if err := c.downloadBundle(ctx, order); err != nil {
+	if err := c.downloadBundle(ctx, order, opts); err != nil {
 		return errors.Wrap(err, "download bundle")
 	}
 	return nil
 }
 
-// downloadBundle fetches a bundle order and download all its assets
-func (c *DownloadCmd) downloadBundle(ctx context.Context, order *hbclient.Order) error {
-	_ = os.MkdirAll(c.Conf.Dest, 0777)
-	downloadTypes := []*hbclient.DownloadType{}
-	for i := 0; i < len(order.Products); i++ {
-		prod := order.Products[i]
-		for j := 0; j < len(prod.Downloads); j++ {
-			download := prod.Downloads[j]
-			for x := 0; x < len(download.Types); x++ {
-				dt := download.Types[x]
-				_, all := c.Conf.Types["all"]
-				_, includeType := c.Conf.Types[strings.ToLower(dt.Name)]
-				if !all && !includeType {
-					continue
-				}
-				dt.HumanName = prod.HumanName
-				downloadTypes = append(downloadTypes, dt)
-			}
+// checkS3Flags rejects the flags that need the downloaded files on disk
+func (c *DownloadCmd) checkS3Flags() error {
+	local := map[string]bool{
+		"-via torrent":    c.Conf.Via == downloader.ViaTorrent,
+		"-layout calibre": c.Conf.Layout == layoutCalibre,
+		"-extract":        c.Conf.Extract,
+		"-exec":           len(c.Conf.Hooks) != 0,
+		"-store":          c.Conf.Store != "",
+	}
+	for _, flag := range []string{"-via torrent", "-layout calibre", "-extract", "-exec", "-store"} {
+		if local[flag] {
+			return errors.Errorf("%s needs a local -dest, not %s", flag, c.Conf.Dest)
 		}
 	}
+	return nil
+}
 
-	var errs []string
-	errCh := make(chan string)
+// downloaderOptions translates the download flags into downloader options
+func (c *DownloadCmd) downloaderOptions() ([]downloader.Option, error) {
+	opts, err := limitOptions(c.Conf.LimitRate, c.Conf.LimitRateFile, c.Conf.LimitSchedule)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, downloader.WithVia(c.Conf.Via), downloader.WithHooks(c.Conf.Hooks, c.Conf.HookConcurrency, c.Conf.HookTimeout))
+	if c.Conf.Extract {
+		opts = append(opts, downloader.WithExtract(c.Conf.ExtractDir, c.Conf.ExtractDelete))
+	}
+	if c.Conf.Store != "" {
+		store, err := dedupe.Open(c.Conf.Store, c.Conf.Link)
+		if err != nil {
+			return nil, errors.Wrap(err, "-store")
+		}
+		opts = append(opts, downloader.WithStore(store))
+	}
+	if storage.IsS3(c.Conf.Dest) {
+		s, err := storage.Open(c.Conf.Dest)
+		if err != nil {
+			return nil, errors.Wrap(err, "-dest")
+		}
+		opts = append(opts, downloader.WithStorage(s))
+	}
+	opts = append(opts, downloader.WithSkipExisting(c.Conf.SkipExisting))
+	if c.Conf.RootConf.Verbose {
+		opts = append(opts, downloader.WithEventHandler(c.logEvent))
+	}
+	return opts, nil
+}
 
-	var group sync.WaitGroup
-	for x := 0; x < len(downloadTypes); x++ {
-		downloadType := downloadTypes[x]
-		group.Add(1)
-		go func() {
-			defer group.Done()
-			if err := c.downloadAsset(ctx, downloadType); err != nil {
-				errCh <- errors.Wrapf(err, "downloadAsset %s.%s", downloadType.HumanName, downloadType.Name).Error()
-			}
-		}()
+// limitOptions parses the -limit-rate, -limit-rate-file and -limit-schedule flags
+func limitOptions(limitRate, limitRateFile, limitSchedule string) ([]downloader.Option, error) {
+	rate, err := ratelimit.ParseRate(limitRate)
+	if err != nil {
+		return nil, errors.Wrap(err, "-limit-rate")
+	}
+	schedule, err := ratelimit.ParseSchedule(limitSchedule)
+	if err != nil {
+		return nil, errors.Wrap(err, "-limit-schedule")
 	}
-	go func() {
-		group.Wait()
-		close(errCh)
-	}()
+	fileRate, err := ratelimit.ParseRate(limitRateFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "-limit-rate-file")
+	}
+	return []downloader.Option{
+		downloader.WithRateLimit(rate, schedule),
+		downloader.WithFileRateLimit(fileRate),
+	}, nil
+}
 
-	for {
-		err, ok := <-errCh
-		if !ok {
-			break
+// logEvent prints the start and end of every download
+func (c *DownloadCmd) logEvent(e downloader.Event) {
+	out := c.Conf.RootConf.Out
+	switch {
+	case out == nil:
+	case e.Type == downloader.EventStarted, e.Type == downloader.EventDone, e.Type == downloader.EventSkipped:
+		fmt.Fprintf(out, "%s %s\n", e.Type, e.Item.Filename)
+	case e.Type == downloader.EventExtracted && e.Err == nil:
+		fmt.Fprintf(out, "%s %s into %s\n", e.Type, e.Item.Filename, e.Extracted.Dir)
+	case e.Type == downloader.EventFailed, e.Type == downloader.EventHookFailed, e.Type == downloader.EventExtracted:
+		fmt.Fprintf(out, "%s %s: %v\n", e.Type, e.Item.Filename, e.Err)
+	}
+}
+
+// downloadBundle fetches a bundle order and download all its assets
+func (c *DownloadCmd) downloadBundle(ctx context.Context, order *hbclient.Order, opts []downloader.Option) error {
+	filter, err := c.assetFilter(ctx, order)
+	if err != nil {
+		return err
+	}
+	plan := downloader.NewPlan(order, filter)
+	if c.Conf.SaveSelection != "" {
+		if err := saveSelection(c.Conf.SaveSelection, plan); err != nil {
+			return err
 		}
-		errs = append(errs, err)
 	}
 
-	if len(errs) != 0 {
-		return errors.Errorf(strings.Join(errs, " - "))
+	var lib *calibre.Library
+	if c.Conf.Layout == layoutCalibre {
+		if lib, err = calibre.Open(c.Conf.Dest); err != nil {
+			return errors.Wrap(err, "-layout calibre")
+		}
+		calibrePaths(lib, plan)
 	}
 
-	return nil
+	var m *manifest.Manifest
+	if !storage.IsS3(c.Conf.Dest) {
+		if m, err = manifest.Load(c.Conf.Dest); err != nil {
+			return err
+		}
+		opts = append(opts[:len(opts):len(opts)], downloader.WithEventHandler(c.record(m)))
+	}
+
+	d := downloader.New(c.Conf.Dest, opts...)
+	if !c.Conf.IgnoreSpace {
+		if err := d.CheckSpace(plan); err != nil {
+			return errors.Wrap(err, "use -ignore-space to download anyway")
+		}
+	}
+	result, err := d.Download(ctx, plan)
+	if result != nil {
+		c.printSummary(ctx, result, len(plan.Items))
+	}
+	var arrangeErr error
+	if ctx.Err() == nil && result != nil && lib != nil {
+		arrangeErr = c.arrangeCalibre(lib, m, order, result)
+	}
+	// keep what finished even if the download stopped midway
+	if m != nil && result != nil && len(result.Finished) != 0 {
+		if saveErr := m.Save(); saveErr != nil {
+			return saveErr
+		}
+	}
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	if arrangeErr != nil {
+		return errors.Wrap(arrangeErr, "-layout calibre")
+	}
+	return err
 }
 
-// downloadAsset downlads the assets of a bundle
-func (c *DownloadCmd) downloadAsset(ctx context.Context, asset *hbclient.DownloadType) error {
-	filename := fmt.Sprintf("%s.%s", asset.HumanName, strings.ToLower(strings.TrimPrefix(asset.Name, ".")))
-	downloadURL := asset.URL.Web
-	filename = strings.ReplaceAll(filename, "/", "_")
-	resp, err := http.Get(downloadURL)
-	if err != nil {
-		return errors.Wrapf(err, "http.Get book %s", downloadURL)
+// record keeps the downloaded files and their metadata in the manifest of the
+// destination, and prints the events with -v
+func (c *DownloadCmd) record(m *manifest.Manifest) downloader.EventHandler {
+	return func(e downloader.Event) {
+		switch {
+		case e.Type == downloader.EventDone:
+			m.Put(e.Item.Asset, e.Item.Filename)
+			m.SetMeta(e.Item.Asset, e.Meta)
+		case e.Type == downloader.EventExtracted && e.Err == nil:
+			if dir, err := filepath.Rel(m.Dir(), e.Extracted.Dir); err == nil {
+				m.SetExtracted(e.Item.Asset, dir)
+			}
+		}
+		if c.Conf.RootConf.Verbose {
+			c.logEvent(e)
+		}
 	}
-	defer resp.Body.Close()
+}
 
-	bookLastmodTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
-	if err != nil {
-		return errors.Wrapf(err, "http.ParseTime last-modified header %s", resp.Header.Get("Last-Modified"))
+// calibrePaths points the ebooks of books already in the library at their
+// formats there, so existing files are found where a previous run moved them
+func calibrePaths(lib *calibre.Library, plan *downloader.Plan) {
+	for _, item := range plan.Items {
+		if item.Platform() != "ebook" {
+			continue
+		}
+		if path := lib.Path(calibre.Key(item.Product), filepath.Ext(item.Filename)); path != "" {
+			item.Filename = filepath.FromSlash(path)
+		}
 	}
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return errors.Errorf("invalid response status code %d", resp.StatusCode)
+// arrangeCalibre moves the downloaded ebooks into the calibre library rooted at
+// the destination, the formats of a product share a book directory
+func (c *DownloadCmd) arrangeCalibre(lib *calibre.Library, m *manifest.Manifest, order *hbclient.Order, result *downloader.Result) error {
+	products := []*hbclient.Product{}
+	files := map[*hbclient.Product][]string{}
+	finished := map[*hbclient.Product]bool{}
+	for i, item := range append(append([]*downloader.Item{}, result.Finished...), result.Skipped...) {
+		path := filepath.Join(c.Conf.Dest, item.Filename)
+		// a hook or -extract-delete may have moved the file already
+		if _, err := os.Stat(path); item.Platform() != "ebook" || err != nil {
+			continue
+		}
+		if _, ok := files[item.Product]; !ok {
+			products = append(products, item.Product)
+		}
+		files[item.Product] = append(files[item.Product], path)
+		if i < len(result.Finished) {
+			finished[item.Product] = true
+		}
 	}
+	for _, p := range products {
+		// skipped formats are in place already, they only describe the book along new ones
+		if !finished[p] {
+			continue
+		}
+		dir, err := lib.Add(calibre.NewBook(order, p, files[p]))
+		if err != nil {
+			return err
+		}
+		for _, item := range result.Finished {
+			if item.Product != p || item.Platform() != "ebook" {
+				continue
+			}
+			if path := lib.Path(calibre.Key(p), filepath.Ext(item.Filename)); path != "" {
+				m.SetPath(item.Asset, path)
+			}
+		}
+		if out := c.Conf.RootConf.Out; out != nil {
+			fmt.Fprintf(out, "  %s -> %s\n", p.HumanName, dir)
+		}
+	}
+	return lib.Save()
+}
 
-	s, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return errors.Wrap(err, "ioutil.ReadAll response body")
+// assetFilter combines -types and -select, with -i the user picks the assets
+// starting from the ones matching them
+func (c *DownloadCmd) assetFilter(ctx context.Context, order *hbclient.Order) (hbclient.AssetFilter, error) {
+	types := make([]string, 0, len(c.Conf.Types))
+	for t := range c.Conf.Types {
+		types = append(types, t)
+	}
+	filters := []hbclient.AssetFilter{hbclient.ByType(types...)}
+	if c.Conf.Selection != "" {
+		f, err := os.Open(c.Conf.Selection)
+		if err != nil {
+			return nil, errors.Wrap(err, "-select")
+		}
+		defer f.Close()
+		ids, err := hbclient.ReadSelection(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "-select %s", c.Conf.Selection)
+		}
+		filters = append(filters, hbclient.ByID(ids...))
+	}
+	if !c.Conf.Interactive {
+		return hbclient.And(filters...), nil
 	}
 
-	bookFile, err := os.Create(fmt.Sprintf("%s/%s", c.Conf.Dest, filename))
+	// nothing starts checked unless the user narrowed down the assets
+	var preselect hbclient.AssetFilter
+	if _, all := c.Conf.Types["all"]; !all || c.Conf.Selection != "" {
+		preselect = hbclient.And(filters...)
+	}
+	selected, err := tui.Pick(ctx, c.Conf.RootConf.In, c.Conf.RootConf.Out, order.Assets(), preselect)
+	if err == tui.ErrInterrupted {
+		return nil, ErrInterrupted
+	}
 	if err != nil {
-		return errors.Wrapf(err, "os.Create %s/%s", c.Conf.Dest, filename)
+		return nil, errors.Wrap(err, "-i")
+	}
+	ids := make([]string, 0, len(selected))
+	for _, a := range selected {
+		ids = append(ids, a.ID())
 	}
-	defer bookFile.Close()
+	return hbclient.ByID(ids...), nil
+}
 
-	if _, err = bookFile.Write(s); err != nil {
-		return errors.Wrap(err, "writting book file")
+// saveSelection writes the plan assets as a selection file reusable with -select
+func saveSelection(path string, plan *downloader.Plan) error {
+	assets := make(hbclient.Assets, 0, len(plan.Items))
+	for _, item := range plan.Items {
+		assets = append(assets, item.Asset)
+	}
+	var buf bytes.Buffer
+	if err := hbclient.WriteSelection(&buf, assets); err != nil {
+		return err
 	}
-	if err := os.Chtimes(fmt.Sprintf("%s/%s", c.Conf.Dest, filename), bookLastmodTime, bookLastmodTime); err != nil {
-		return errors.Wrap(err, "os.Chtimes")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "-save-selection")
 	}
+	return nil
+}
 
-	if asset.SHA1 != "" {
-		hash := sha1.New()
-		hash.Write([]byte(s))
-		bs := hash.Sum(nil)
-		if asset.SHA1 != fmt.Sprintf("%x", bs) {
-			return errors.Errorf("SHA1 checksum failed for %s -- expected %s but got %x", filename, asset.SHA1, bs)
-		}
+// printSummary prints which assets were downloaded
+func (c *DownloadCmd) printSummary(ctx context.Context, result *downloader.Result, total int) {
+	out := c.Conf.RootConf.Out
+	if out == nil {
+		return
+	}
+	status := "downloaded"
+	if ctx.Err() != nil {
+		status = "interrupted, downloaded"
 	}
-	if asset.MD5 != "" {
-		hash := md5.New()
-		hash.Write([]byte(s))
-		md5Checksum := fmt.Sprintf("%x", hash.Sum(nil))
-		if asset.MD5 != md5Checksum {
-			return errors.Errorf("MD5 checksum failed for %s -- expected %s but got %s", filename, asset.MD5, md5Checksum)
+	fmt.Fprintf(out, "%s %d/%d assets to %s\n", status, len(result.Finished), total, c.Conf.Dest)
+	for _, item := range result.Finished {
+		fmt.Fprintf(out, "  %s\n", item.Filename)
+	}
+	if len(result.Skipped) != 0 {
+		fmt.Fprintf(out, "skipped %d assets already in %s\n", len(result.Skipped), c.Conf.Dest)
+	}
+	for _, x := range result.Extracted {
+		if x.Error != "" {
+			fmt.Fprintf(out, "extracting %s failed: %s\n", x.Archive, x.Error)
+			continue
+		}
+		removed := ""
+		if x.Deleted {
+			removed = ", archive removed"
 		}
+		fmt.Fprintf(out, "extracted %s into %s, %d files%s\n", x.Archive, x.Dir, x.Files, removed)
+	}
+	for _, hookErr := range result.HookErrors {
+		fmt.Fprintf(out, "%v\n", hookErr)
 	}
-	return nil
 }
@@ -1,6 +1,7 @@
 package command
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"crypto/sha1"
@@ -9,11 +10,18 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"diogogmt.com/hbd/pkg/downloader"
 	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/manifest"
+	"diogogmt.com/hbd/pkg/storage/s3test"
+	"diogogmt.com/hbd/pkg/torrent/torrenttest"
 	"github.com/peterbourgon/ff/v2/ffcli"
 	"github.com/pkg/errors"
 )
@@ -67,28 +75,25 @@ func TestDownloadAsset(t *testing.T) {
 							Platform: "ebook",
 							Types: []*hbclient.DownloadType{
 								&hbclient.DownloadType{
-									Name:      "PDF",
-									HumanName: "Security/Social Engineering: The Art of Human Hacking",
-									MD5:       md5Checksum,
-									SHA1:      sha1Checksum,
+									Name: "PDF",
+									MD5:  md5Checksum,
+									SHA1: sha1Checksum,
 									URL: hbclient.DownloadTypeURL{
 										Web: fmt.Sprintf("%s/%s", apiURL, uid),
 									},
 								},
 								&hbclient.DownloadType{
-									Name:      "EPUB",
-									HumanName: "Security/Social Engineering: The Art of Human Hacking",
-									MD5:       md5Checksum,
-									SHA1:      sha1Checksum,
+									Name: "EPUB",
+									MD5:  md5Checksum,
+									SHA1: sha1Checksum,
 									URL: hbclient.DownloadTypeURL{
 										Web: fmt.Sprintf("%s/%s", apiURL, uid),
 									},
 								},
 								&hbclient.DownloadType{
-									Name:      "PRC",
-									HumanName: "Security/Social Engineering: The Art of Human Hacking",
-									MD5:       md5Checksum,
-									SHA1:      sha1Checksum,
+									Name: "PRC",
+									MD5:  md5Checksum,
+									SHA1: sha1Checksum,
 									URL: hbclient.DownloadTypeURL{
 										Web: fmt.Sprintf("%s/%s", apiURL, uid),
 									},
@@ -118,9 +123,8 @@ func TestDownloadAsset(t *testing.T) {
 							Platform: "ebook",
 							Types: []*hbclient.DownloadType{
 								&hbclient.DownloadType{
-									Name:      "PDF",
-									HumanName: "Social Engineering: The Art of Human Hacking",
-									MD5:       "INVALID",
+									Name: "PDF",
+									MD5:  "INVALID",
 									URL: hbclient.DownloadTypeURL{
 										Web: fmt.Sprintf("%s/%s", apiURL, uid),
 									},
@@ -150,9 +154,8 @@ func TestDownloadAsset(t *testing.T) {
 							Platform: "ebook",
 							Types: []*hbclient.DownloadType{
 								&hbclient.DownloadType{
-									Name:      "PDF",
-									HumanName: "Social Engineering: The Art of Human Hacking",
-									SHA1:      "INVALID",
+									Name: "PDF",
+									SHA1: "INVALID",
 									URL: hbclient.DownloadTypeURL{
 										Web: fmt.Sprintf("%s/%s", apiURL, uid),
 									},
@@ -173,6 +176,7 @@ func TestDownloadAsset(t *testing.T) {
 		name      string
 		order     hbclient.Order
 		types     []string
+		existing  string
 		expectErr bool
 	}{
 		{
@@ -184,6 +188,7 @@ func TestDownloadAsset(t *testing.T) {
 			name:      "invalid-md5",
 			order:     invalidMD5Order,
 			types:     []string{"all"},
+			existing:  "previous good copy",
 			expectErr: true,
 		},
 		{
@@ -210,6 +215,13 @@ func TestDownloadAsset(t *testing.T) {
 			t.Fatalf("ioutil.TempDir: %s", err)
 		}
 
+		existingPath := fmt.Sprintf("%s/%s", tempDir, "Social Engineering: The Art of Human Hacking.pdf")
+		if d.existing != "" {
+			if err := ioutil.WriteFile(existingPath, []byte(d.existing), 0644); err != nil {
+				t.Fatalf("ioutil.WriteFile: %s", err)
+			}
+		}
+
 		rootCmd := NewRootCmd()
 		rootCmd.Conf.HBClient = hbClient
 		downloadCmd := NewDownloadCmd(rootCmd.Conf)
@@ -227,6 +239,26 @@ func TestDownloadAsset(t *testing.T) {
 			t.Errorf("%s: downloadCmd.Exec: expected error but got nil", d.name)
 		}
 
+		// failed downloads must not leave temp files or replace good files
+		files, err := ioutil.ReadDir(tempDir)
+		if err != nil {
+			t.Fatalf("ioutil.ReadDir: %v", err)
+		}
+		for _, f := range files {
+			if strings.HasSuffix(f.Name(), ".part") {
+				t.Errorf("%s: unexpected temp file %s", d.name, f.Name())
+			}
+		}
+		if d.existing != "" {
+			by, err := ioutil.ReadFile(existingPath)
+			if err != nil {
+				t.Errorf("%s: ioutil.ReadFile: %v", d.name, err)
+			}
+			if string(by) != d.existing {
+				t.Errorf("%s: expected existing file to be kept but got %q", d.name, string(by))
+			}
+		}
+
 		if d.expectErr {
 			continue
 		}
@@ -246,7 +278,7 @@ func TestDownloadAsset(t *testing.T) {
 					if _, ok := typesMap[strings.ToLower(asset.Name)]; !ok {
 						continue
 					}
-					filename := fmt.Sprintf("%s.%s", asset.HumanName, strings.ToLower(strings.TrimPrefix(asset.Name, ".")))
+					filename := fmt.Sprintf("%s.%s", prod.HumanName, strings.ToLower(strings.TrimPrefix(asset.Name, ".")))
 					filename = strings.ReplaceAll(filename, "/", "_")
 					by, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", tempDir, filename))
 					if err != nil {
@@ -283,3 +315,544 @@ func setupHandlers(t *testing.T, order hbclient.Order) {
 	})
 
 }
+
+func TestDownloadInterrupted(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		panic(fmt.Errorf("net.Listen: %s", err))
+	}
+
+	addrParts := strings.Split(listener.Addr().String(), ":")
+	apiURL := fmt.Sprintf("http://localhost:%s", addrParts[len(addrParts)-1])
+	hbClient := hbclient.NewClient(hbclient.WithAPIURL(apiURL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	uid := "INTERRUPTED01"
+	order := hbclient.Order{
+		UID:     uid,
+		Product: &hbclient.Product{HumanName: "Interrupted Bundle"},
+		Products: []*hbclient.Product{
+			&hbclient.Product{
+				HumanName: "Slow Book",
+				Downloads: []*hbclient.Download{
+					&hbclient.Download{
+						Platform: "ebook",
+						Types: []*hbclient.DownloadType{
+							&hbclient.DownloadType{
+								Name: "PDF",
+								URL:  hbclient.DownloadTypeURL{Web: fmt.Sprintf("%s/slow/%s", apiURL, uid)},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	setupHandlers(t, order)
+	http.HandleFunc(fmt.Sprintf("/slow/%s", uid), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Add("Content-Length", "1024")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		// the client goes away mid transfer
+		cancel()
+		<-r.Context().Done()
+	})
+
+	srv := http.Server{}
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			panic(fmt.Errorf("srv.ListenAndServe: %s", err))
+		}
+	}()
+
+	tempDir, err := ioutil.TempDir("/tmp", "hbd.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	var out strings.Builder
+	rootCmd := NewRootCmd(WithHBClient(hbClient), WithOutput(&out))
+	downloadCmd := NewDownloadCmd(rootCmd.Conf)
+	rootCmd.Subcommands = []*ffcli.Command{
+		downloadCmd.Command,
+	}
+	if err := rootCmd.Parse([]string{"download", "-key", uid, "-dest", tempDir}); err != nil {
+		t.Fatalf("rootCmd.Parse: %v", err)
+	}
+
+	err = downloadCmd.Exec(ctx, []string{})
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("expected ErrInterrupted but got %v", err)
+	}
+	files, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("ioutil.ReadDir: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected partial files to be removed but found %d", len(files))
+	}
+	if !strings.Contains(out.String(), "interrupted, downloaded 0/1 assets") {
+		t.Errorf("expected summary to report the interrupt but got %q", out.String())
+	}
+}
+
+func TestDownloadViaTorrent(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		panic(fmt.Errorf("net.Listen: %s", err))
+	}
+
+	addrParts := strings.Split(listener.Addr().String(), ":")
+	apiURL := fmt.Sprintf("http://localhost:%s", addrParts[len(addrParts)-1])
+	hbClient := hbclient.NewClient(hbclient.WithAPIURL(apiURL))
+
+	content := []byte(strings.Repeat("humble torrent content ", 4096))
+	seeder := torrenttest.NewSeeder("torrent-book.pdf", content, 16*1024)
+	defer seeder.Close()
+
+	uid := "TORRENT0RDER1"
+	order := hbclient.Order{
+		UID:     uid,
+		Product: &hbclient.Product{HumanName: "Torrent Bundle"},
+		Products: []*hbclient.Product{
+			&hbclient.Product{
+				HumanName: "Torrent Book",
+				Downloads: []*hbclient.Download{
+					&hbclient.Download{
+						Platform: "ebook",
+						Types: []*hbclient.DownloadType{
+							&hbclient.DownloadType{
+								Name: "PDF",
+								MD5:  fmt.Sprintf("%x", md5.Sum(content)),
+								SHA1: fmt.Sprintf("%x", sha1.Sum(content)),
+								URL: hbclient.DownloadTypeURL{
+									// the web URL must not be used
+									Web:        fmt.Sprintf("%s/missing/%s", apiURL, uid),
+									BitTorrent: seeder.TorrentURL,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	setupHandlers(t, order)
+
+	srv := http.Server{}
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			panic(fmt.Errorf("srv.ListenAndServe: %s", err))
+		}
+	}()
+
+	tempDir, err := ioutil.TempDir("/tmp", "hbd.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	rootCmd := NewRootCmd(WithHBClient(hbClient), WithOutput(ioutil.Discard))
+	downloadCmd := NewDownloadCmd(rootCmd.Conf)
+	rootCmd.Subcommands = []*ffcli.Command{
+		downloadCmd.Command,
+	}
+	if err := rootCmd.Parse([]string{"download", "-key", uid, "-dest", tempDir, "-via", "torrent"}); err != nil {
+		t.Fatalf("rootCmd.Parse: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := downloadCmd.Exec(ctx, []string{}); err != nil {
+		t.Fatalf("downloadCmd.Exec: %v", err)
+	}
+	by, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", tempDir, "Torrent Book.pdf"))
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile: %v", err)
+	}
+	if string(by) != string(content) {
+		t.Errorf("expected torrent content to be downloaded")
+	}
+}
+
+func TestDownloadLimitsConfig(t *testing.T) {
+	config, err := ioutil.TempFile("", "hbd-config.")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile: %v", err)
+	}
+	defer os.Remove(config.Name())
+	fmt.Fprintln(config, "limit-rate 5M")
+	fmt.Fprintln(config, "limit-schedule 01:00-07:00=0")
+	config.Close()
+
+	rootCmd := NewRootCmd()
+	downloadCmd := NewDownloadCmd(rootCmd.Conf)
+	rootCmd.Subcommands = []*ffcli.Command{
+		downloadCmd.Command,
+	}
+	if err := rootCmd.Parse([]string{"download", "-config", config.Name(), "-limit-rate-file", "1M"}); err != nil {
+		t.Fatalf("rootCmd.Parse: %v", err)
+	}
+	if downloadCmd.Conf.LimitRate != "5M" || downloadCmd.Conf.LimitSchedule != "01:00-07:00=0" {
+		t.Errorf("expected limits to be read from the config file but got %q %q", downloadCmd.Conf.LimitRate, downloadCmd.Conf.LimitSchedule)
+	}
+	if downloadCmd.Conf.LimitRateFile != "1M" {
+		t.Errorf("expected per file rate of 1M but got %q", downloadCmd.Conf.LimitRateFile)
+	}
+	if _, err := downloadCmd.downloaderOptions(); err != nil {
+		t.Errorf("downloaderOptions: %v", err)
+	}
+
+	downloadCmd.Conf.LimitRate = "fast"
+	if _, err := downloadCmd.downloaderOptions(); err == nil {
+		t.Errorf("expected invalid -limit-rate to fail")
+	}
+}
+
+func TestDownloadSelection(t *testing.T) {
+	order := &hbclient.Order{
+		Products: []*hbclient.Product{
+			&hbclient.Product{
+				HumanName:   "Black Hat Python",
+				MachineName: "blackhatpython",
+				Downloads: []*hbclient.Download{
+					&hbclient.Download{
+						Platform: "ebook",
+						Types: []*hbclient.DownloadType{
+							&hbclient.DownloadType{Name: "PDF"},
+							&hbclient.DownloadType{Name: "EPUB"},
+							&hbclient.DownloadType{Name: "MOBI"},
+						},
+					},
+				},
+			},
+		},
+	}
+	tempDir, err := ioutil.TempDir("", "hbd-selection.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	selection := fmt.Sprintf("%s/selection.txt", tempDir)
+
+	// save the assets picked with -types and reuse them with -select
+	rootCmd := NewRootCmd()
+	downloadCmd := NewDownloadCmd(rootCmd.Conf)
+	downloadCmd.Conf.Types = map[string]struct{}{"pdf": {}, "mobi": {}}
+	filter, err := downloadCmd.assetFilter(context.Background(), order)
+	if err != nil {
+		t.Fatalf("assetFilter: %v", err)
+	}
+	if err := saveSelection(selection, downloader.NewPlan(order, filter)); err != nil {
+		t.Fatalf("saveSelection: %v", err)
+	}
+
+	downloadCmd = NewDownloadCmd(rootCmd.Conf)
+	downloadCmd.Conf.Types = map[string]struct{}{"all": {}}
+	downloadCmd.Conf.Selection = selection
+	filter, err = downloadCmd.assetFilter(context.Background(), order)
+	if err != nil {
+		t.Fatalf("assetFilter: %v", err)
+	}
+	names := []string{}
+	for _, a := range order.Assets().Filter(filter) {
+		names = append(names, a.Type.Name)
+	}
+	if strings.Join(names, ",") != "PDF,MOBI" {
+		t.Errorf("expected the selection to keep PDF,MOBI but got %v", names)
+	}
+
+	// the picker can't run without a terminal
+	downloadCmd.Conf.Interactive = true
+	downloadCmd.Conf.RootConf.In = strings.NewReader("\r")
+	if _, err := downloadCmd.assetFilter(context.Background(), order); err == nil {
+		t.Errorf("expected -i to fail without a terminal")
+	}
+}
+
+func TestDownloadHooksConfig(t *testing.T) {
+	config, err := ioutil.TempFile("", "hbd-config.")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile: %v", err)
+	}
+	defer os.Remove(config.Name())
+	fmt.Fprintln(config, `exec ext=zip:unzip -o "$HBD_PATH" -d "$HBD_DIR"`)
+	fmt.Fprintln(config, `exec platform=audio:mv "$HBD_PATH" ~/Music/`)
+	config.Close()
+
+	dd := []struct {
+		args     []string
+		expected []string
+	}{
+		{
+			args:     []string{"-exec-timeout", "1m"},
+			expected: []string{`unzip -o "$HBD_PATH" -d "$HBD_DIR"`, `mv "$HBD_PATH" ~/Music/`},
+		},
+		{
+			// hooks given on the command line replace the config file ones
+			args:     []string{"-exec", "platform=windows:clamscan $HBD_PATH", "-exec-timeout", "1m"},
+			expected: []string{"clamscan $HBD_PATH"},
+		},
+	}
+	for _, d := range dd {
+		rootCmd := NewRootCmd()
+		downloadCmd := NewDownloadCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			downloadCmd.Command,
+		}
+		if err := rootCmd.Parse(append([]string{"download", "-config", config.Name()}, d.args...)); err != nil {
+			t.Fatalf("rootCmd.Parse: %v", err)
+		}
+		commands := []string{}
+		for _, hook := range downloadCmd.Conf.Hooks {
+			commands = append(commands, hook.Command)
+		}
+		if strings.Join(commands, "\n") != strings.Join(d.expected, "\n") {
+			t.Errorf("%v: expected hooks %q but got %q", d.args, d.expected, commands)
+		}
+		if downloadCmd.Conf.HookTimeout != time.Minute || downloadCmd.Conf.HookConcurrency != 2 {
+			t.Errorf("%v: unexpected hook limits %s %d", d.args, downloadCmd.Conf.HookTimeout, downloadCmd.Conf.HookConcurrency)
+		}
+	}
+}
+
+func TestDownloadSpace(t *testing.T) {
+	order := hbclient.Order{
+		GameKey: "huge",
+		Product: &hbclient.Product{HumanName: "Huge Bundle"},
+		Products: []*hbclient.Product{
+			&hbclient.Product{
+				HumanName: "Huge Game",
+				Downloads: []*hbclient.Download{
+					&hbclient.Download{
+						Platform: "linux",
+						Types: []*hbclient.DownloadType{
+							&hbclient.DownloadType{Name: "Download", FileSize: 1 << 60},
+						},
+					},
+				},
+			},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/order/huge", func(w http.ResponseWriter, r *http.Request) {
+		by, _ := json.Marshal(&order)
+		w.Write(by)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	tempDir, err := ioutil.TempDir("", "hbd-space.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rootCmd := NewRootCmd(WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))))
+	downloadCmd := NewDownloadCmd(rootCmd.Conf)
+	rootCmd.Subcommands = []*ffcli.Command{
+		downloadCmd.Command,
+	}
+	if err := rootCmd.Parse([]string{"download", "-key", "huge", "-dest", tempDir + "/huge"}); err != nil {
+		t.Fatalf("rootCmd.Parse: %v", err)
+	}
+	err = rootCmd.Run(context.Background())
+	var spaceErr *downloader.SpaceError
+	if !errors.As(err, &spaceErr) || spaceErr.Dest != tempDir+"/huge" || !strings.Contains(err.Error(), "use -ignore-space") {
+		t.Errorf("expected the download to be refused but got %v", err)
+	}
+	if _, err := os.Stat(tempDir + "/huge"); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written but got %v", err)
+	}
+}
+
+// testPDF is a minimal PDF whose info dictionary names the title and author
+func testPDF(title, author string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := []int{}
+	for _, obj := range []string{"<< /Type /Catalog >>", fmt.Sprintf("<< /Title (%s) /Author (%s) >>", title, author)} {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 3\n0000000000 65535 f \n")
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size 3 /Root 1 0 R /Info 2 0 R >>\nstartxref\n%d\n%%%%EOF\n", xref)
+	return b.Bytes()
+}
+
+func TestDownloadCalibre(t *testing.T) {
+	content := testPDF("Black Hat Python", "Justin Seitz")
+	order := hbclient.Order{
+		GameKey: "calibre",
+		Products: []*hbclient.Product{
+			&hbclient.Product{
+				HumanName:   "Black Hat Python",
+				MachineName: "blackhatpython",
+				Downloads: []*hbclient.Download{
+					&hbclient.Download{
+						Platform: "ebook",
+						Types: []*hbclient.DownloadType{
+							&hbclient.DownloadType{Name: "PDF", FileSize: int64(len(content)), MD5: fmt.Sprintf("%x", md5.Sum(content))},
+						},
+					},
+				},
+			},
+		},
+	}
+	fetches := 0
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	order.Products[0].Downloads[0].Types[0].URL.Web = srv.URL + "/pdf"
+	mux.HandleFunc("/order/calibre", func(w http.ResponseWriter, r *http.Request) {
+		by, _ := json.Marshal(&order)
+		w.Write(by)
+	})
+	mux.HandleFunc("/pdf", func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(content)
+	})
+	tempDir, err := ioutil.TempDir("", "hbd-calibre.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// later runs find the book where the first one arranged it
+	dd := []struct {
+		args     []string
+		contains []string
+		fetches  int
+	}{
+		{contains: []string{"downloaded 1/1 assets", "Black Hat Python -> Justin Seitz/Black Hat Python (1)"}, fetches: 1},
+		{args: []string{"-skip-existing"}, contains: []string{"downloaded 0/1 assets", "skipped 1 assets"}, fetches: 1},
+		{contains: []string{"downloaded 1/1 assets", "Justin Seitz/Black Hat Python (1)/Black Hat Python - Justin Seitz.pdf"}, fetches: 2},
+	}
+	for _, d := range dd {
+		var out strings.Builder
+		rootCmd := NewRootCmd(WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))), WithOutput(&out))
+		downloadCmd := NewDownloadCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			downloadCmd.Command,
+		}
+		args := append([]string{"download", "-key", "calibre", "-dest", tempDir, "-layout", "calibre"}, d.args...)
+		if err := rootCmd.Parse(args); err != nil {
+			t.Fatalf("%v: rootCmd.Parse: %v", d.args, err)
+		}
+		if err := rootCmd.Run(context.Background()); err != nil {
+			t.Errorf("%v: rootCmd.Run: %v", d.args, err)
+		}
+		for _, s := range d.contains {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("%v: expected output to contain %q but got\n%s", d.args, s, out.String())
+			}
+		}
+		if fetches != d.fetches {
+			t.Errorf("%v: expected %d fetches but got %d", d.args, d.fetches, fetches)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "Black Hat Python.pdf")); !os.IsNotExist(err) {
+			t.Errorf("%v: expected no file outside the library but got %v", d.args, err)
+		}
+		by, err := ioutil.ReadFile(filepath.Join(tempDir, "Justin Seitz", "Black Hat Python (1)", "Black Hat Python - Justin Seitz.pdf"))
+		if err != nil || string(by) != string(content) {
+			t.Errorf("%v: expected the pdf in the library but got %q %v", d.args, by, err)
+		}
+		// the manifest has the file where it was arranged and what it says about itself
+		m, err := manifest.Load(tempDir)
+		if err != nil {
+			t.Fatalf("manifest.Load: %v", err)
+		}
+		e := m.Get(order.Assets()[0])
+		if e == nil || e.Path != "Justin Seitz/Black Hat Python (1)/Black Hat Python - Justin Seitz.pdf" || e.Meta == nil || e.Meta.Title != "Black Hat Python" {
+			t.Errorf("%v: expected the arranged pdf in the manifest but got %+v", d.args, e)
+		}
+	}
+}
+
+func TestDownloadS3(t *testing.T) {
+	content := []byte("ebook content")
+	order := hbclient.Order{
+		GameKey: "s3",
+		Product: &hbclient.Product{HumanName: "S3 Bundle"},
+		Products: []*hbclient.Product{
+			&hbclient.Product{
+				HumanName: "Cloud Book",
+				Downloads: []*hbclient.Download{
+					&hbclient.Download{
+						Platform: "ebook",
+						Types: []*hbclient.DownloadType{
+							&hbclient.DownloadType{Name: "EPUB", MD5: fmt.Sprintf("%x", md5.Sum(content)), FileSize: int64(len(content))},
+						},
+					},
+				},
+			},
+		},
+	}
+	fetches := 0
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	order.Products[0].Downloads[0].Types[0].URL.Web = srv.URL + "/epub"
+	mux.HandleFunc("/order/s3", func(w http.ResponseWriter, r *http.Request) {
+		by, _ := json.Marshal(&order)
+		w.Write(by)
+	})
+	mux.HandleFunc("/epub", func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(content)
+	})
+	s3 := s3test.NewServer("bucket")
+	defer s3.Close()
+	for k, v := range map[string]string{"AWS_ENDPOINT_URL_S3": s3.URL, "AWS_ACCESS_KEY_ID": "id", "AWS_SECRET_ACCESS_KEY": "secret"} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	dd := []struct {
+		name      string
+		args      []string
+		contains  []string
+		expectErr string
+	}{
+		{name: "torrent", args: []string{"-via", "torrent"}, expectErr: "-via torrent needs a local -dest"},
+		{name: "calibre", args: []string{"-layout", "calibre"}, expectErr: "-layout calibre needs a local -dest"},
+		{name: "download", contains: []string{"downloaded 1/1 assets to s3://bucket/library", "Cloud Book.epub"}},
+		{name: "skip-existing", args: []string{"-skip-existing"}, contains: []string{"downloaded 0/1 assets", "skipped 1 assets already in s3://bucket/library"}},
+	}
+	for _, d := range dd {
+		var out strings.Builder
+		rootCmd := NewRootCmd(WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))), WithOutput(&out))
+		downloadCmd := NewDownloadCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			downloadCmd.Command,
+		}
+		args := append([]string{"download", "-key", "s3", "-dest", "s3://bucket/library"}, d.args...)
+		if err := rootCmd.Parse(args); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		err := rootCmd.Run(context.Background())
+		if d.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), d.expectErr) {
+				t.Errorf("%s: expected error %q but got %v", d.name, d.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: rootCmd.Run: %v", d.name, err)
+		}
+		for _, s := range d.contains {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("%s: expected output to contain %q but got\n%s", d.name, s, out.String())
+			}
+		}
+	}
+	if object := s3.Object("bucket", "library/Cloud Book.epub"); object == nil || string(object.Data) != string(content) {
+		t.Errorf("expected the epub to be uploaded but got %+v", object)
+	}
+	if fetches != 1 {
+		t.Errorf("expected a single fetch but got %d", fetches)
+	}
+}
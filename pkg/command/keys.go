@@ -0,0 +1,111 @@
+package command
+
+import (
+	"context"
+	"flag"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+// key states reported by the keys command
+const (
+	KeyUnrevealed = "unrevealed"
+	KeyRevealed   = "revealed"
+	KeyExpired    = "expired"
+	KeySoldOut    = "sold out"
+)
+
+// KeysCmd wraps the keys config and a ffcli.Command
+type KeysCmd struct {
+	Conf *KeysConfig
+
+	*ffcli.Command
+}
+
+// KeysConfig has the config for the keys command and a reference to the root command config
+type KeysConfig struct {
+	RootConf *RootConfig
+
+	Key    string
+	All    bool
+	Format string
+}
+
+// NewKeysCmd creates a new KeysCmd
+func NewKeysCmd(rootConf *RootConfig) *KeysCmd {
+	conf := KeysConfig{
+		RootConf: rootConf,
+	}
+	cmd := KeysCmd{
+		Conf: &conf,
+	}
+	fs := flag.NewFlagSet("hbd keys", flag.ExitOnError)
+	cmd.RegisterFlags(fs)
+
+	cmd.Command = &ffcli.Command{
+		Name:       "keys",
+		ShortUsage: "hbd keys [-key X | -all] [-format table|csv|json] [<subcommand>]",
+		ShortHelp:  "List third party game keys, eg; steam or gog",
+		FlagSet:    fs,
+		Subcommands: []*ffcli.Command{
+			NewKeysRevealCmd(rootConf).Command,
+		},
+		Exec: cmd.Exec,
+	}
+	return &cmd
+}
+
+// RegisterFlags registers a set of flags for the keys command
+func (c *KeysCmd) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Conf.Key, "key", "", "purchase key")
+	fs.BoolVar(&c.Conf.All, "all", false, "list the keys of every order of the account")
+	fs.StringVar(&c.Conf.Format, "format", FormatTable, "output format, table, csv or json")
+}
+
+// Exec executes the keys command
+func (c *KeysCmd) Exec(ctx context.Context, args []string) error {
+	if err := validFormat(c.Conf.Format); err != nil {
+		return err
+	}
+	orders, err := fetchOrders(ctx, c.Conf.RootConf.HBClient, c.Conf.Key, c.Conf.All)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, order := range orders {
+		for _, tpk := range order.TPKs() {
+			rows = append(rows, []string{
+				order.GameKey,
+				tpk.HumanName,
+				keyPlatform(tpk),
+				keyStatus(tpk),
+				tpk.RedeemedKeyVal,
+			})
+		}
+	}
+	return writeRecords(c.Conf.RootConf.Out, c.Conf.Format, []string{"order", "name", "platform", "status", "key"}, rows)
+}
+
+// keyPlatform is the store a key redeems on
+func keyPlatform(tpk *hbclient.TPK) string {
+	if tpk.KeyTypeHumanName != "" {
+		return tpk.KeyTypeHumanName
+	}
+	return tpk.KeyType
+}
+
+// keyStatus summarizes whether a key can still be revealed
+func keyStatus(tpk *hbclient.TPK) string {
+	switch {
+	case tpk.Revealed():
+		return KeyRevealed
+	case tpk.IsExpired:
+		return KeyExpired
+	case tpk.SoldOut:
+		return KeySoldOut
+	default:
+		return KeyUnrevealed
+	}
+}
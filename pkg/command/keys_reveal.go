@@ -0,0 +1,240 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/pkg/errors"
+)
+
+// keysStateFile is the state file recording revealed keys
+const keysStateFile = "keys.json"
+
+// KeyRecord is a reveal attempt recorded in the state directory
+type KeyRecord struct {
+	GameKey     string    `json:"gamekey"`
+	KeyIndex    int       `json:"keyindex"`
+	MachineName string    `json:"machine_name"`
+	HumanName   string    `json:"human_name"`
+	Platform    string    `json:"platform"`
+	Key         string    `json:"key,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	RevealedAt  time.Time `json:"revealed_at"`
+}
+
+// KeysRevealCmd wraps the keys reveal config and a ffcli.Command
+type KeysRevealCmd struct {
+	Conf *KeysRevealConfig
+
+	*ffcli.Command
+}
+
+// KeysRevealConfig has the config for the keys reveal command and a reference to the root command config
+type KeysRevealConfig struct {
+	RootConf *RootConfig
+
+	Key    string
+	All    bool
+	Name   string
+	DryRun bool
+	Yes    bool
+}
+
+// NewKeysRevealCmd creates a new KeysRevealCmd
+func NewKeysRevealCmd(rootConf *RootConfig) *KeysRevealCmd {
+	conf := KeysRevealConfig{
+		RootConf: rootConf,
+	}
+	cmd := KeysRevealCmd{
+		Conf: &conf,
+	}
+	fs := flag.NewFlagSet("hbd keys reveal", flag.ExitOnError)
+	cmd.RegisterFlags(fs)
+
+	cmd.Command = &ffcli.Command{
+		Name:       "reveal",
+		ShortUsage: "hbd keys reveal [-key X | -all] [-name X] [-dry-run] [-yes]",
+		ShortHelp:  "Reveal unrevealed third party keys, revealed keys can't be gifted anymore",
+		FlagSet:    fs,
+		Exec:       cmd.Exec,
+	}
+	return &cmd
+}
+
+// RegisterFlags registers a set of flags for the keys reveal command
+func (c *KeysRevealCmd) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Conf.Key, "key", "", "purchase key")
+	fs.BoolVar(&c.Conf.All, "all", false, "look for keys in every order of the account")
+	fs.StringVar(&c.Conf.Name, "name", "", "reveal the keys whose name contains this text, prompts for a choice when empty")
+	fs.BoolVar(&c.Conf.DryRun, "dry-run", false, "list the keys that would be revealed without revealing them")
+	fs.BoolVar(&c.Conf.Yes, "yes", false, "don't ask for confirmation")
+}
+
+// Exec executes the keys reveal command
+func (c *KeysRevealCmd) Exec(ctx context.Context, args []string) error {
+	out := c.Conf.RootConf.Out
+	in := bufio.NewReader(c.Conf.RootConf.In)
+
+	orders, err := fetchOrders(ctx, c.Conf.RootConf.HBClient, c.Conf.Key, c.Conf.All)
+	if err != nil {
+		return err
+	}
+	candidates := []*hbclient.TPK{}
+	for _, order := range orders {
+		for _, tpk := range order.TPKs() {
+			if keyStatus(tpk) == KeyUnrevealed {
+				candidates = append(candidates, tpk)
+			}
+		}
+	}
+
+	var selected []*hbclient.TPK
+	if c.Conf.Name != "" {
+		selected = matchKeys(candidates, c.Conf.Name)
+	} else if len(candidates) > 0 {
+		for i, tpk := range candidates {
+			fmt.Fprintf(out, "%d) %s (%s)\n", i+1, tpk.HumanName, keyPlatform(tpk))
+		}
+		answer, err := prompt(out, in, "keys to reveal, eg; 1,3-4 or all: ")
+		if err != nil {
+			return err
+		}
+		if selected, err = chooseKeys(candidates, answer); err != nil {
+			return err
+		}
+	}
+	if len(selected) == 0 {
+		fmt.Fprintln(out, "no unrevealed keys selected")
+		return nil
+	}
+
+	if c.Conf.DryRun {
+		fmt.Fprintf(out, "dry run, would reveal %d keys:\n", len(selected))
+		for _, tpk := range selected {
+			fmt.Fprintf(out, "%s\t%s\n", tpk.HumanName, keyPlatform(tpk))
+		}
+		return nil
+	}
+	if !c.Conf.Yes {
+		answer, err := prompt(out, in, fmt.Sprintf("reveal %d keys? revealed keys can't be gifted [y/N]: ", len(selected)))
+		if err != nil {
+			return err
+		}
+		if answer != "y" && answer != "yes" {
+			fmt.Fprintln(out, "aborted")
+			return nil
+		}
+	}
+
+	dir := state.Dir(c.Conf.RootConf.StateDir)
+	records := []*KeyRecord{}
+	if err := dir.Load(keysStateFile, &records); err != nil {
+		return err
+	}
+	failed := 0
+	for _, tpk := range selected {
+		key, err := c.Conf.RootConf.HBClient.RedeemKey(ctx, tpk)
+		if err != nil && ctx.Err() != nil {
+			break
+		}
+		record := KeyRecord{
+			GameKey:     tpk.GameKey,
+			KeyIndex:    tpk.KeyIndex,
+			MachineName: tpk.MachineName,
+			HumanName:   tpk.HumanName,
+			Platform:    keyPlatform(tpk),
+			Key:         key,
+			RevealedAt:  time.Now().UTC(),
+		}
+		if err != nil {
+			failed++
+			record.Error = err.Error()
+			fmt.Fprintf(out, "%s\tfailed: %v\n", tpk.HumanName, err)
+		} else {
+			fmt.Fprintf(out, "%s\t%s\n", tpk.HumanName, key)
+		}
+		records = append(records, &record)
+		// a key revealed as the user interrupted is claimed already, it's
+		// recorded before stopping
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err := dir.Save(keysStateFile, records); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	if failed > 0 {
+		return errors.Errorf("failed to reveal %d of %d keys", failed, len(selected))
+	}
+	return nil
+}
+
+// prompt prints a question and reads a trimmed, lower cased answer line
+func prompt(out io.Writer, in *bufio.Reader, question string) (string, error) {
+	fmt.Fprint(out, question)
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.Wrap(err, "reading answer")
+	}
+	return strings.ToLower(strings.TrimSpace(line)), nil
+}
+
+// matchKeys selects the keys whose human or machine name contains name, ignoring case
+func matchKeys(tpks []*hbclient.TPK, name string) []*hbclient.TPK {
+	name = strings.ToLower(name)
+	matched := []*hbclient.TPK{}
+	for _, tpk := range tpks {
+		if strings.Contains(strings.ToLower(tpk.HumanName), name) || strings.Contains(strings.ToLower(tpk.MachineName), name) {
+			matched = append(matched, tpk)
+		}
+	}
+	return matched
+}
+
+// chooseKeys selects keys from a 1-based list of numbers and ranges, eg; 1,3-4, or all
+func chooseKeys(tpks []*hbclient.TPK, answer string) ([]*hbclient.TPK, error) {
+	if answer == "all" {
+		return tpks, nil
+	}
+	chosen := []*hbclient.TPK{}
+	seen := map[int]bool{}
+	for _, part := range strings.Split(answer, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, errors.Errorf("invalid choice %q", part)
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+				return nil, errors.Errorf("invalid choice %q", part)
+			}
+		}
+		if from < 1 || to > len(tpks) || from > to {
+			return nil, errors.Errorf("choice %q out of range 1-%d", part, len(tpks))
+		}
+		for i := from; i <= to; i++ {
+			if !seen[i] {
+				seen[i] = true
+				chosen = append(chosen, tpks[i-1])
+			}
+		}
+	}
+	return chosen, nil
+}
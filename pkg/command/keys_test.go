@@ -0,0 +1,307 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+// newKeysServer serves two orders with third party keys
+func newKeysServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	orders := map[string]hbclient.Order{
+		"order1": {
+			GameKey: "order1",
+			Product: &hbclient.Product{HumanName: "Humble Indie Bundle"},
+			TPKDict: &hbclient.TPKDict{AllTPKs: []*hbclient.TPK{
+				{HumanName: "FTL: Faster Than Light", MachineName: "ftl_steam", GameKey: "order1", KeyType: "steam", KeyTypeHumanName: "Steam", RedeemedKeyVal: "AAAAA-BBBBB-CCCCC"},
+				{HumanName: "Into the Breach", MachineName: "itb_steam", GameKey: "order1", KeyIndex: 1, KeyType: "steam", KeyTypeHumanName: "Steam"},
+			}},
+		},
+		"order2": {
+			GameKey: "order2",
+			Product: &hbclient.Product{HumanName: "Humble Book Bundle"},
+			TPKDict: &hbclient.TPKDict{AllTPKs: []*hbclient.TPK{
+				{HumanName: "Old Game", MachineName: "old_gog", GameKey: "order2", KeyType: "gog", IsExpired: true},
+				{HumanName: "Broken Game", MachineName: "broken_steam", GameKey: "order2", KeyIndex: 1, KeyType: "steam", KeyTypeHumanName: "Steam"},
+			}},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/order", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"gamekey": "order1"}, {"gamekey": "order2"}]`))
+	})
+	mux.HandleFunc("/humbler/redeemkey", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("CSRF-Prevention-Token") == "" {
+			http.Error(w, `{"success": false, "error_msg": "missing csrf token"}`, http.StatusForbidden)
+			return
+		}
+		switch r.FormValue("keytype") {
+		case "itb_steam":
+			w.Write([]byte(`{"success": true, "key": "DDDDD-EEEEE-FFFFF"}`))
+		default:
+			w.Write([]byte(`{"success": false, "error_msg": "out of keys"}`))
+		}
+	})
+	for key, order := range orders {
+		order := order
+		mux.HandleFunc(fmt.Sprintf("/order/%s", key), func(w http.ResponseWriter, r *http.Request) {
+			by, err := json.Marshal(&order)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(by)
+		})
+	}
+	return httptest.NewServer(mux)
+}
+
+func TestKeys(t *testing.T) {
+	srv := newKeysServer(t)
+	defer srv.Close()
+
+	dd := []struct {
+		name      string
+		args      []string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name: "csv-all",
+			args: []string{"-all", "-format", "csv"},
+			expected: "order,name,platform,status,key\n" +
+				"order1,FTL: Faster Than Light,Steam,revealed,AAAAA-BBBBB-CCCCC\n" +
+				"order1,Into the Breach,Steam,unrevealed,\n" +
+				"order2,Old Game,gog,expired,\n" +
+				"order2,Broken Game,Steam,unrevealed,\n",
+		},
+		{
+			name: "table-key",
+			args: []string{"-key", "order2"},
+			expected: "ORDER   NAME         PLATFORM  STATUS      KEY\n" +
+				"order2  Old Game     gog       expired     \n" +
+				"order2  Broken Game  Steam     unrevealed  \n",
+		},
+		{
+			name:      "missing-key",
+			args:      []string{},
+			expectErr: true,
+		},
+		{
+			name:      "invalid-format",
+			args:      []string{"-all", "-format", "xml"},
+			expectErr: true,
+		},
+	}
+	for _, d := range dd {
+		var out strings.Builder
+		rootCmd := NewRootCmd(WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))), WithOutput(&out))
+		keysCmd := NewKeysCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			keysCmd.Command,
+		}
+		if err := rootCmd.Parse(append([]string{"keys"}, d.args...)); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		err := rootCmd.Run(context.Background())
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", d.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: rootCmd.Run: %v", d.name, err)
+		}
+		if out.String() != d.expected {
+			t.Errorf("%s: expected\n%s\nbut got\n%s", d.name, d.expected, out.String())
+		}
+	}
+}
+
+func TestKeysReveal(t *testing.T) {
+	srv := newKeysServer(t)
+	defer srv.Close()
+
+	dd := []struct {
+		name      string
+		args      []string
+		input     string
+		expected  string
+		records   []string
+		expectErr bool
+	}{
+		{
+			name:     "dry-run",
+			args:     []string{"-all", "-name", "into", "-dry-run"},
+			expected: "dry run, would reveal 1 keys:\nInto the Breach\tSteam\n",
+		},
+		{
+			name:     "name-yes",
+			args:     []string{"-key", "order1", "-name", "breach", "-yes"},
+			expected: "Into the Breach\tDDDDD-EEEEE-FFFFF\n",
+			records:  []string{"DDDDD-EEEEE-FFFFF"},
+		},
+		{
+			name:  "interactive",
+			args:  []string{"-all"},
+			input: "1\ny\n",
+			expected: "1) Into the Breach (Steam)\n2) Broken Game (Steam)\n" +
+				"keys to reveal, eg; 1,3-4 or all: " +
+				"reveal 1 keys? revealed keys can't be gifted [y/N]: " +
+				"Into the Breach\tDDDDD-EEEEE-FFFFF\n",
+			records: []string{"DDDDD-EEEEE-FFFFF"},
+		},
+		{
+			name:  "not-confirmed",
+			args:  []string{"-all", "-name", "game"},
+			input: "n\n",
+			expected: "reveal 1 keys? revealed keys can't be gifted [y/N]: " +
+				"aborted\n",
+		},
+		{
+			name:      "redeem-error",
+			args:      []string{"-all", "-yes"},
+			input:     "all\n",
+			expected:  "1) Into the Breach (Steam)\n2) Broken Game (Steam)\nkeys to reveal, eg; 1,3-4 or all: Into the Breach\tDDDDD-EEEEE-FFFFF\nBroken Game\tfailed: redeem key: out of keys\n",
+			records:   []string{"DDDDD-EEEEE-FFFFF", ""},
+			expectErr: true,
+		},
+		{
+			name:      "invalid-choice",
+			args:      []string{"-all"},
+			input:     "3\n",
+			expected:  "1) Into the Breach (Steam)\n2) Broken Game (Steam)\nkeys to reveal, eg; 1,3-4 or all: ",
+			expectErr: true,
+		},
+	}
+	for _, d := range dd {
+		stateDir, err := ioutil.TempDir("", "hbd-state")
+		if err != nil {
+			t.Fatalf("ioutil.TempDir: %v", err)
+		}
+		defer os.RemoveAll(stateDir)
+
+		var out strings.Builder
+		rootCmd := NewRootCmd(
+			WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))),
+			WithOutput(&out),
+			WithInput(strings.NewReader(d.input)),
+			WithStateDir(stateDir),
+		)
+		keysCmd := NewKeysCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			keysCmd.Command,
+		}
+		if err := rootCmd.Parse(append([]string{"keys", "reveal"}, d.args...)); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		err = rootCmd.Run(context.Background())
+		if d.expectErr && err == nil {
+			t.Errorf("%s: expected error", d.name)
+		}
+		if !d.expectErr && err != nil {
+			t.Errorf("%s: rootCmd.Run: %v", d.name, err)
+		}
+		if out.String() != d.expected {
+			t.Errorf("%s: expected\n%q\nbut got\n%q", d.name, d.expected, out.String())
+		}
+
+		records := []*KeyRecord{}
+		if err := state.Dir(stateDir).Load(keysStateFile, &records); err != nil {
+			t.Fatalf("%s: Load records: %v", d.name, err)
+		}
+		if len(records) != len(d.records) {
+			t.Errorf("%s: expected %d records but got %d", d.name, len(d.records), len(records))
+			continue
+		}
+		for i, record := range records {
+			if record.Key != d.records[i] {
+				t.Errorf("%s: expected record %d key %q but got %q", d.name, i, d.records[i], record.Key)
+			}
+			if record.Key == "" && record.Error == "" {
+				t.Errorf("%s: expected record %d to have an error", d.name, i)
+			}
+		}
+	}
+}
+
+// cancelTransport cancels a context once a response to path is read
+type cancelTransport struct {
+	http.RoundTripper
+	path   string
+	cancel context.CancelFunc
+}
+
+func (t *cancelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || req.URL.Path != t.path {
+		return resp, err
+	}
+	defer resp.Body.Close()
+	by, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(by))
+	t.cancel()
+	return resp, nil
+}
+
+func TestKeysRevealInterrupted(t *testing.T) {
+	srv := newKeysServer(t)
+	defer srv.Close()
+	stateDir, err := ioutil.TempDir("", "hbd-state")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	// the interrupt lands right after the key is revealed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = &cancelTransport{RoundTripper: defaultTransport, path: "/humbler/redeemkey", cancel: cancel}
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	var out strings.Builder
+	rootCmd := NewRootCmd(
+		WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))),
+		WithOutput(&out),
+		WithStateDir(stateDir),
+	)
+	keysCmd := NewKeysCmd(rootCmd.Conf)
+	rootCmd.Subcommands = []*ffcli.Command{
+		keysCmd.Command,
+	}
+	if err := rootCmd.Parse([]string{"keys", "reveal", "-all", "-yes", "-name", "breach"}); err != nil {
+		t.Fatalf("rootCmd.Parse: %v", err)
+	}
+	if err := rootCmd.Run(ctx); err != ErrInterrupted {
+		t.Errorf("expected ErrInterrupted but got %v", err)
+	}
+	if expected := "Into the Breach\tDDDDD-EEEEE-FFFFF\n"; out.String() != expected {
+		t.Errorf("expected the revealed key to be printed but got %q", out.String())
+	}
+	records := []*KeyRecord{}
+	if err := state.Dir(stateDir).Load(keysStateFile, &records); err != nil {
+		t.Fatalf("Load records: %v", err)
+	}
+	if len(records) != 1 || records[0].Key != "DDDDD-EEEEE-FFFFF" {
+		t.Errorf("expected the revealed key to be recorded but got %+v", records)
+	}
+}
@@ -0,0 +1,104 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"diogogmt.com/hbd/pkg/opds"
+	"github.com/peterbourgon/ff/v2"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/pkg/errors"
+)
+
+// OPDSCmd wraps the opds config and a ffcli.Command
+type OPDSCmd struct {
+	Conf *OPDSConfig
+
+	*ffcli.Command
+}
+
+// OPDSConfig has the config for the opds command and a reference to the root command config
+type OPDSConfig struct {
+	RootConf *RootConfig
+
+	Addr     string
+	Dest     string
+	Title    string
+	User     string
+	Password string
+}
+
+// NewOPDSCmd creates a new OPDSCmd
+func NewOPDSCmd(rootConf *RootConfig) *OPDSCmd {
+	conf := OPDSConfig{
+		RootConf: rootConf,
+	}
+	cmd := OPDSCmd{
+		Conf: &conf,
+	}
+	fs := flag.NewFlagSet("hbd opds", flag.ExitOnError)
+	cmd.RegisterFlags(fs)
+
+	cmd.Command = &ffcli.Command{
+		Name:       "opds",
+		ShortUsage: "hbd opds [-addr 127.0.0.1:8082] [-dest D] [-user U -password P]",
+		ShortHelp:  "Serve the downloaded ebooks as an OPDS catalog for e-readers",
+		FlagSet:    fs,
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("HBD"),
+		},
+		Exec: cmd.Exec,
+	}
+	return &cmd
+}
+
+// RegisterFlags registers a set of flags for the opds command
+func (c *OPDSCmd) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Conf.Addr, "addr", "127.0.0.1:8082", "address to listen on, eg; :8082 for e-readers on the LAN")
+	fs.StringVar(&c.Conf.Dest, "dest", ".", "directory the ebooks were downloaded into")
+	fs.StringVar(&c.Conf.Title, "title", "Humble Bundle", "catalog title shown by e-readers")
+	fs.StringVar(&c.Conf.User, "user", "", "basic auth user, also read from HBD_USER")
+	fs.StringVar(&c.Conf.Password, "password", "", "basic auth password, also read from HBD_PASSWORD")
+	fs.String("config", "", "config file with one flag per line, eg; addr :8082")
+}
+
+// Exec executes the opds command
+func (c *OPDSCmd) Exec(ctx context.Context, args []string) error {
+	if (c.Conf.User == "") != (c.Conf.Password == "") {
+		return errors.New("-user and -password must be set together")
+	}
+	host, _, err := net.SplitHostPort(c.Conf.Addr)
+	if err != nil {
+		return errors.Wrap(err, "-addr")
+	}
+	if fi, err := os.Stat(c.Conf.Dest); err != nil || !fi.IsDir() {
+		return errors.Errorf("-dest %s isn't a directory", c.Conf.Dest)
+	}
+	out := c.Conf.RootConf.Out
+	if ip := net.ParseIP(host); c.Conf.User == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		fmt.Fprintf(out, "warning: serving on %s without -user and -password\n", c.Conf.Addr)
+	}
+
+	opts := []opds.Option{opds.WithTitle(c.Conf.Title)}
+	if c.Conf.User != "" {
+		opts = append(opts, opds.WithBasicAuth(c.Conf.User, c.Conf.Password))
+	}
+	srv := opds.New(c.Conf.Dest, opts...)
+	catalog, err := srv.Catalog()
+	if err != nil {
+		return errors.Wrap(err, "reading the catalog")
+	}
+	fmt.Fprintf(out, "serving %d books of %d bundles on http://%s/opds\n", len(catalog.Books), len(catalog.Bundles), c.Conf.Addr)
+	if err := srv.Serve(ctx, c.Conf.Addr); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+func TestOPDSFlags(t *testing.T) {
+	dd := []struct {
+		name string
+		args []string
+	}{
+		{name: "user-without-password", args: []string{"-user", "reader"}},
+		{name: "invalid-addr", args: []string{"-addr", "8082"}},
+		{name: "missing-dest", args: []string{"-dest", "/nonexistent/hbd/books"}},
+	}
+	for _, d := range dd {
+		rootCmd := NewRootCmd(WithOutput(ioutil.Discard))
+		opdsCmd := NewOPDSCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			opdsCmd.Command,
+		}
+		if err := rootCmd.Parse(append([]string{"opds"}, d.args...)); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		if err := rootCmd.Run(context.Background()); err == nil {
+			t.Errorf("%s: expected error", d.name)
+		}
+	}
+}
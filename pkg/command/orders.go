@@ -0,0 +1,35 @@
+package command
+
+import (
+	"context"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"github.com/pkg/errors"
+)
+
+// fetchOrders fetches the order matching key, or every order of the account when all is set
+func fetchOrders(ctx context.Context, client *hbclient.HBDClient, key string, all bool) ([]*hbclient.Order, error) {
+	keys := []string{key}
+	if all {
+		var err error
+		keys, err = client.GetOrderKeys(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "HBClient.GetOrderKeys")
+		}
+	} else if key == "" {
+		return nil, errors.Errorf("missing key, use -key or -all")
+	}
+
+	orders := make([]*hbclient.Order, 0, len(keys))
+	for _, k := range keys {
+		order, err := client.GetOrder(ctx, k)
+		if ctx.Err() != nil {
+			return nil, ErrInterrupted
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "HBClient.GetOrder %s", k)
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
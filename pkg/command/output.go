@@ -0,0 +1,67 @@
+package command
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// output formats shared by the listing commands
+const (
+	FormatTable = "table"
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+)
+
+// validFormat checks the -format flag before doing any work
+func validFormat(format string) error {
+	switch format {
+	case FormatTable, FormatCSV, FormatJSON:
+		return nil
+	default:
+		return errors.Errorf("invalid format %q, expected %s, %s or %s", format, FormatTable, FormatCSV, FormatJSON)
+	}
+}
+
+// writeRecords prints records as an aligned table, CSV or a JSON array of objects keyed by header
+func writeRecords(out io.Writer, format string, headers []string, rows [][]string) error {
+	switch format {
+	case FormatTable:
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(headers, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	case FormatCSV:
+		w := csv.NewWriter(out)
+		if err := w.Write(headers); err != nil {
+			return errors.Wrap(err, "csv.Write")
+		}
+		if err := w.WriteAll(rows); err != nil {
+			return errors.Wrap(err, "csv.WriteAll")
+		}
+		return nil
+	case FormatJSON:
+		objects := make([]map[string]string, 0, len(rows))
+		for _, row := range rows {
+			obj := map[string]string{}
+			for i, h := range headers {
+				if i < len(row) {
+					obj[h] = row[i]
+				}
+			}
+			objects = append(objects, obj)
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(objects), "json.Encode")
+	default:
+		return validFormat(format)
+	}
+}
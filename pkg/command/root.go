@@ -3,11 +3,18 @@ package command
 import (
 	"context"
 	"flag"
+	"io"
+	"os"
 
 	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/state"
 	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/pkg/errors"
 )
 
+// ErrInterrupted is returned by commands whose context was cancelled before they finished
+var ErrInterrupted = errors.New("interrupted")
+
 // RootCmd wraps the  config and a ffcli.Command
 type RootCmd struct {
 	Conf *RootConfig
@@ -19,7 +26,10 @@ type RootCmd struct {
 type RootConfig struct {
 	JWTCookie string
 	Verbose   bool
+	StateDir  string
 	HBClient  *hbclient.HBDClient
+	Out       io.Writer
+	In        io.Reader
 }
 
 // RootConfigOption defines the signature for functional options to be applied to the root command
@@ -29,7 +39,11 @@ type RootConfigOption = func(c *RootConfig)
 func NewRootCmd(opts ...RootConfigOption) *RootCmd {
 	fs := flag.NewFlagSet("hbd", flag.ExitOnError)
 
-	conf := RootConfig{}
+	conf := RootConfig{
+		StateDir: string(state.DefaultDir()),
+		Out:      os.Stdout,
+		In:       os.Stdin,
+	}
 	for _, opt := range opts {
 		opt(&conf)
 	}
@@ -53,6 +67,7 @@ func NewRootCmd(opts ...RootConfigOption) *RootCmd {
 func (c *RootCmd) RegisterFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.Conf.JWTCookie, "jwt", "", "humblebundle dashboard JWT _simpleauth_sess cookie")
 	fs.BoolVar(&c.Conf.Verbose, "v", false, "log verbose output")
+	fs.StringVar(&c.Conf.StateDir, "state-dir", c.Conf.StateDir, "directory where hbd keeps its local records")
 }
 
 // Exec executes the root command
@@ -67,3 +82,24 @@ func WithHBClient(hbClient *hbclient.HBDClient) RootConfigOption {
 		c.HBClient = hbClient
 	}
 }
+
+// WithOutput sets the writer commands print their results to
+func WithOutput(out io.Writer) RootConfigOption {
+	return func(c *RootConfig) {
+		c.Out = out
+	}
+}
+
+// WithInput sets the reader commands read answers to prompts from
+func WithInput(in io.Reader) RootConfigOption {
+	return func(c *RootConfig) {
+		c.In = in
+	}
+}
+
+// WithStateDir sets the directory where commands keep their local records
+func WithStateDir(dir string) RootConfigOption {
+	return func(c *RootConfig) {
+		c.StateDir = dir
+	}
+}
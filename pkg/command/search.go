@@ -0,0 +1,199 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"diogogmt.com/hbd/pkg/catalog"
+	"diogogmt.com/hbd/pkg/downloader"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/library"
+	"diogogmt.com/hbd/pkg/search"
+	"diogogmt.com/hbd/pkg/state"
+	"diogogmt.com/hbd/pkg/units"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/pkg/errors"
+)
+
+// SearchCmd wraps the search config and a ffcli.Command
+type SearchCmd struct {
+	Conf *SearchConfig
+
+	*ffcli.Command
+}
+
+// SearchConfig has the config for the search command and a reference to the root command config
+type SearchConfig struct {
+	RootConf *RootConfig
+
+	Limit    int
+	Sync     bool
+	Dest     string
+	Format   string
+	Download bool
+	Types    string
+	Via      string
+}
+
+// NewSearchCmd creates a new SearchCmd
+func NewSearchCmd(rootConf *RootConfig) *SearchCmd {
+	conf := SearchConfig{
+		RootConf: rootConf,
+	}
+	cmd := SearchCmd{
+		Conf: &conf,
+	}
+	fs := flag.NewFlagSet("hbd search", flag.ExitOnError)
+	cmd.RegisterFlags(fs)
+
+	cmd.Command = &ffcli.Command{
+		Name:       "search",
+		ShortUsage: "hbd search [-limit N] [-sync] [-dest D] [-format table|csv|json] [-download [-types pdf,epub]] <query>",
+		ShortHelp:  "Search the products of the cached orders",
+		FlagSet:    fs,
+		Exec:       cmd.Exec,
+	}
+	return &cmd
+}
+
+// RegisterFlags registers a set of flags for the search command
+func (c *SearchCmd) RegisterFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.Conf.Limit, "limit", 10, "max products listed, 0 for all")
+	fs.BoolVar(&c.Conf.Sync, "sync", false, "fetch every order of the account into the cache before searching")
+	fs.StringVar(&c.Conf.Dest, "dest", ".", "directory the download status is checked against and -download writes to, empty to skip the status")
+	fs.StringVar(&c.Conf.Format, "format", FormatTable, "output format, table, csv or json")
+	fs.BoolVar(&c.Conf.Download, "download", false, "download the listed products into a directory per bundle of -dest")
+	fs.StringVar(&c.Conf.Types, "types", "all", "comma separated list of file types downloaded, eg; pdf,epub,mobi")
+	fs.StringVar(&c.Conf.Via, "via", downloader.ViaHTTP, "download backend, http or torrent")
+}
+
+// Exec executes the search command
+func (c *SearchCmd) Exec(ctx context.Context, args []string) error {
+	query := strings.Join(args, " ")
+	if strings.TrimSpace(query) == "" {
+		return errors.New("missing query")
+	}
+	if err := validFormat(c.Conf.Format); err != nil {
+		return err
+	}
+	if c.Conf.Download && c.Conf.Dest == "" {
+		return errors.New("-download needs a -dest")
+	}
+	if !downloader.ValidVia(c.Conf.Via) {
+		return errors.Errorf("invalid -via %q, expected %s or %s", c.Conf.Via, downloader.ViaHTTP, downloader.ViaTorrent)
+	}
+
+	lib := library.New(state.Dir(c.Conf.RootConf.StateDir), c.Conf.RootConf.HBClient)
+	if c.Conf.Sync {
+		_, err := lib.Sync(ctx)
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+		if err != nil {
+			return err
+		}
+	}
+	orders, err := lib.Orders()
+	if err != nil {
+		return err
+	}
+	if len(orders) == 0 {
+		return errors.New("no cached orders, search with -sync or run hbd catalog -all first")
+	}
+
+	hits := search.Search(orders, query)
+	if c.Conf.Limit > 0 && len(hits) > c.Conf.Limit {
+		hits = hits[:c.Conf.Limit]
+	}
+	var local *catalog.Local
+	if c.Conf.Dest != "" {
+		if local, err = catalog.NewLocal(c.Conf.Dest); err != nil {
+			return err
+		}
+	}
+	if len(hits) == 0 && c.Conf.Format == FormatTable {
+		fmt.Fprintf(c.Conf.RootConf.Out, "no products matching %q in %d orders\n", query, len(orders))
+		return nil
+	}
+
+	rows := [][]string{}
+	for _, hit := range hits {
+		size := ""
+		if n := hit.Assets.TotalSize(); n > 0 {
+			size = units.FormatSize(n)
+		}
+		rows = append(rows, []string{
+			hit.Order.GameKey,
+			hit.Bundle(),
+			hit.Product.HumanName,
+			strings.Join(hit.Platforms(), ","),
+			strings.Join(hit.Types(), ","),
+			size,
+			local.ProductStatus(hit.Assets),
+		})
+	}
+	if err := writeRecords(c.Conf.RootConf.Out, c.Conf.Format, []string{"order", "bundle", "product", "platforms", "types", "size", "status"}, rows); err != nil {
+		return err
+	}
+	if c.Conf.Download {
+		return c.download(ctx, lib, hits, local)
+	}
+	return nil
+}
+
+// download fetches the listed products, the cached download links expire so
+// their orders are fetched again, files already downloaded are skipped
+func (c *SearchCmd) download(ctx context.Context, lib *library.Library, hits []*search.Hit, local *catalog.Local) error {
+	keys := []string{}
+	ids := map[string][]string{}
+	types := strings.Split(c.Conf.Types, ",")
+	for _, hit := range hits {
+		for _, asset := range hit.Assets.Filter(hbclient.ByType(types...)) {
+			if local.Status(asset) == catalog.StatusDownloaded {
+				continue
+			}
+			key := hit.Order.GameKey
+			if _, ok := ids[key]; !ok {
+				keys = append(keys, key)
+			}
+			ids[key] = append(ids[key], asset.ID())
+		}
+	}
+	out := c.Conf.RootConf.Out
+	if len(keys) == 0 {
+		fmt.Fprintln(out, "nothing to download")
+		return nil
+	}
+
+	for _, key := range keys {
+		order, err := c.Conf.RootConf.HBClient.GetOrder(ctx, key)
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+		if err != nil {
+			return errors.Wrapf(err, "HBClient.GetOrder %s", key)
+		}
+		if err := lib.Save(order); err != nil {
+			return err
+		}
+		dest := filepath.Join(c.Conf.Dest, downloader.BundleDir(order))
+		plan := downloader.NewPlan(order, hbclient.ByID(ids[key]...))
+		result, err := downloader.New(dest, downloader.WithVia(c.Conf.Via)).Download(ctx, plan)
+		if result != nil {
+			fmt.Fprintf(out, "downloaded %d/%d assets to %s\n", len(result.Finished), len(plan.Items), dest)
+			for _, item := range result.Finished {
+				fmt.Fprintf(out, "  %s\n", item.Filename)
+			}
+		}
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+		if err != nil {
+			return errors.Wrap(err, "download")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+func TestSearch(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	order := hbclient.Order{
+		GameKey: "books",
+		Product: &hbclient.Product{HumanName: "Humble Book Bundle: Cybersecurity"},
+		Products: []*hbclient.Product{
+			{HumanName: "Practical Malware Analysis", MachineName: "practicalmalwareanalysis", Downloads: []*hbclient.Download{{
+				Platform: "ebook",
+				Types: []*hbclient.DownloadType{
+					{Name: "PDF", FileSize: 3, URL: hbclient.DownloadTypeURL{Web: srv.URL + "/files/pma.pdf"}},
+					{Name: "EPUB", FileSize: 4, URL: hbclient.DownloadTypeURL{Web: srv.URL + "/files/pma.epub"}},
+				},
+			}}},
+			{HumanName: "Black Hat Go", MachineName: "blackhatgo"},
+		},
+	}
+	mux.HandleFunc("/user/order", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"gamekey": "books"}]`))
+	})
+	mux.HandleFunc("/order/books", func(w http.ResponseWriter, r *http.Request) {
+		by, _ := json.Marshal(&order)
+		w.Write(by)
+	})
+	mux.HandleFunc("/files/pma.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pdf"))
+	})
+
+	dir, err := ioutil.TempDir("", "hbd-search.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir, dest := filepath.Join(dir, "state"), filepath.Join(dir, "dest")
+
+	dd := []struct {
+		name      string
+		args      []string
+		contains  []string
+		expectErr bool
+	}{
+		{name: "missing-query", args: []string{"-sync"}, expectErr: true},
+		{name: "not-cached", args: []string{"malware"}, expectErr: true},
+		{
+			name:     "sync",
+			args:     []string{"-sync", "-dest", dest, "malwre", "analysis"},
+			contains: []string{"ORDER", "books  Humble Book Bundle: Cybersecurity  Practical Malware Analysis  ebook      pdf,epub  7 B   none"},
+		},
+		{name: "no-hits", args: []string{"rust"}, contains: []string{`no products matching "rust" in 1 orders`}},
+		{
+			name:     "csv-bundle",
+			args:     []string{"-format", "csv", "-dest", "", "cybersecurity"},
+			contains: []string{"order,bundle,product", "books,Humble Book Bundle: Cybersecurity,Practical Malware Analysis,ebook,\"pdf,epub\",7 B,\n", "books,Humble Book Bundle: Cybersecurity,Black Hat Go,,,,\n"},
+		},
+		{name: "download-without-dest", args: []string{"-download", "-dest", "", "malware"}, expectErr: true},
+		{
+			name:     "download",
+			args:     []string{"-download", "-types", "pdf", "-dest", dest, "malware"},
+			contains: []string{"downloaded 1/1 assets to " + filepath.Join(dest, "Humble Book Bundle: Cybersecurity"), "Practical Malware Analysis.pdf"},
+		},
+		{
+			// the pdf is skipped now and reported in the status
+			name:     "downloaded",
+			args:     []string{"-download", "-types", "pdf", "-dest", dest, "malware"},
+			contains: []string{"partial", "nothing to download"},
+		},
+	}
+	for _, d := range dd {
+		var out strings.Builder
+		rootCmd := NewRootCmd(WithHBClient(hbclient.NewClient(hbclient.WithAPIURL(srv.URL))), WithOutput(&out), WithStateDir(stateDir))
+		searchCmd := NewSearchCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			searchCmd.Command,
+		}
+		if err := rootCmd.Parse(append([]string{"search"}, d.args...)); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		err := rootCmd.Run(context.Background())
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", d.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: rootCmd.Run: %v", d.name, err)
+		}
+		for _, s := range d.contains {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("%s: expected output to contain %q but got\n%s", d.name, s, out.String())
+			}
+		}
+	}
+	if by, err := ioutil.ReadFile(filepath.Join(dest, "Humble Book Bundle: Cybersecurity", "Practical Malware Analysis.pdf")); err != nil || string(by) != "pdf" {
+		t.Errorf("expected the pdf to be downloaded but got %q %v", by, err)
+	}
+}
@@ -0,0 +1,126 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+
+	"diogogmt.com/hbd/pkg/downloader"
+	"diogogmt.com/hbd/pkg/library"
+	"diogogmt.com/hbd/pkg/ratelimit"
+	"diogogmt.com/hbd/pkg/state"
+	"diogogmt.com/hbd/pkg/webui"
+	"github.com/peterbourgon/ff/v2"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/pkg/errors"
+)
+
+// ServeCmd wraps the serve config and a ffcli.Command
+type ServeCmd struct {
+	Conf *ServeConfig
+
+	*ffcli.Command
+}
+
+// ServeConfig has the config for the serve command and a reference to the root command config
+type ServeConfig struct {
+	RootConf *RootConfig
+
+	Addr      string
+	Dest      string
+	User      string
+	Password  string
+	Via       string
+	LimitRate string
+	Sync      bool
+}
+
+// NewServeCmd creates a new ServeCmd
+func NewServeCmd(rootConf *RootConfig) *ServeCmd {
+	conf := ServeConfig{
+		RootConf: rootConf,
+	}
+	cmd := ServeCmd{
+		Conf: &conf,
+	}
+	fs := flag.NewFlagSet("hbd serve", flag.ExitOnError)
+	cmd.RegisterFlags(fs)
+
+	cmd.Command = &ffcli.Command{
+		Name:       "serve",
+		ShortUsage: "hbd serve [-addr 127.0.0.1:8080] [-dest D] [-user U -password P]",
+		ShortHelp:  "Serve a web UI to browse the library and queue downloads",
+		FlagSet:    fs,
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("HBD"),
+		},
+		Exec: cmd.Exec,
+	}
+	return &cmd
+}
+
+// RegisterFlags registers a set of flags for the serve command
+func (c *ServeCmd) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Conf.Addr, "addr", "127.0.0.1:8080", "address to listen on, only localhost by default")
+	fs.StringVar(&c.Conf.Dest, "dest", ".", "directory where each bundle is downloaded into its own subdirectory")
+	fs.StringVar(&c.Conf.User, "user", "", "basic auth user, also read from HBD_USER")
+	fs.StringVar(&c.Conf.Password, "password", "", "basic auth password, also read from HBD_PASSWORD")
+	fs.StringVar(&c.Conf.Via, "via", downloader.ViaHTTP, "download backend, http or torrent")
+	fs.StringVar(&c.Conf.LimitRate, "limit-rate", "0", "max aggregate download rate, eg; 500K, 5M, 0 for unlimited")
+	fs.BoolVar(&c.Conf.Sync, "sync", false, "sync the library before serving")
+	fs.String("config", "", "config file with one flag per line, eg; addr :8080")
+}
+
+// Exec executes the serve command
+func (c *ServeCmd) Exec(ctx context.Context, args []string) error {
+	if !downloader.ValidVia(c.Conf.Via) {
+		return errors.Errorf("invalid -via %q, expected %s or %s", c.Conf.Via, downloader.ViaHTTP, downloader.ViaTorrent)
+	}
+	rate, err := ratelimit.ParseRate(c.Conf.LimitRate)
+	if err != nil {
+		return errors.Wrap(err, "-limit-rate")
+	}
+	if (c.Conf.User == "") != (c.Conf.Password == "") {
+		return errors.New("-user and -password must be set together")
+	}
+	host, _, err := net.SplitHostPort(c.Conf.Addr)
+	if err != nil {
+		return errors.Wrap(err, "-addr")
+	}
+	out := c.Conf.RootConf.Out
+	if ip := net.ParseIP(host); c.Conf.User == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		fmt.Fprintf(out, "warning: serving on %s without -user and -password\n", c.Conf.Addr)
+	}
+
+	lib := library.New(state.Dir(c.Conf.RootConf.StateDir), c.Conf.RootConf.HBClient)
+	if c.Conf.Sync {
+		orders, err := lib.Sync(ctx)
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+		if err != nil {
+			return errors.Wrap(err, "library.Sync")
+		}
+		fmt.Fprintf(out, "synced %d orders\n", len(orders))
+	}
+
+	queue := webui.NewQueue(c.Conf.Dest,
+		downloader.WithVia(c.Conf.Via),
+		downloader.WithRateLimit(rate, nil),
+	)
+	opts := []webui.Option{}
+	if c.Conf.User != "" {
+		opts = append(opts, webui.WithBasicAuth(c.Conf.User, c.Conf.Password))
+	}
+	fmt.Fprintf(out, "serving on http://%s\n", c.Conf.Addr)
+	if err := webui.New(lib, queue, opts...).Serve(ctx, c.Conf.Addr); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+func TestServeFlags(t *testing.T) {
+	dd := []struct {
+		name string
+		args []string
+	}{
+		{name: "user-without-password", args: []string{"-user", "team"}},
+		{name: "invalid-via", args: []string{"-via", "ftp"}},
+		{name: "invalid-rate", args: []string{"-limit-rate", "fast"}},
+		{name: "invalid-addr", args: []string{"-addr", "8080"}},
+	}
+	for _, d := range dd {
+		rootCmd := NewRootCmd(WithOutput(ioutil.Discard))
+		serveCmd := NewServeCmd(rootCmd.Conf)
+		rootCmd.Subcommands = []*ffcli.Command{
+			serveCmd.Command,
+		}
+		if err := rootCmd.Parse(append([]string{"serve"}, d.args...)); err != nil {
+			t.Fatalf("%s: rootCmd.Parse: %v", d.name, err)
+		}
+		if err := rootCmd.Run(context.Background()); err == nil {
+			t.Errorf("%s: expected error", d.name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"diogogmt.com/hbd/pkg/extract"
+	"diogogmt.com/hbd/pkg/state"
+)
+
+// ChangelogDir is the directory of the destination where a changelog is written for every run downloading something
+const ChangelogDir = ".hbd-changelog"
+
+// writeChangelog writes a markdown list of the run changes and returns its path relative to dest
+func writeChangelog(dest string, run *RunStatus) (string, error) {
+	changes := append([]Change{}, run.Changes...)
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Status != changes[j].Status {
+			return changes[i].Status < changes[j].Status
+		}
+		return changes[i].Path < changes[j].Path
+	})
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# hbd sync %s\n\n", run.Started.Format("2006-01-02 15:04:05 MST"))
+	for _, c := range changes {
+		fmt.Fprintf(&b, "- %s `%s`", c.Status, c.Path)
+		if c.OldSize != 0 || c.OldMD5 != "" || c.OldSHA1 != "" {
+			fmt.Fprintf(&b, ", %s", describeChange(c))
+		}
+		if c.OldPath != "" {
+			fmt.Fprintf(&b, ", previous version kept as `%s`", c.OldPath)
+		}
+		b.WriteString("\n")
+	}
+	if len(run.Extracted) != 0 {
+		extracted := append([]extract.Result{}, run.Extracted...)
+		sort.Slice(extracted, func(i, j int) bool {
+			return extracted[i].Archive < extracted[j].Archive
+		})
+		fmt.Fprintf(&b, "\n%d archives extracted:\n\n", len(extracted))
+		for _, x := range extracted {
+			if x.Error != "" {
+				fmt.Fprintf(&b, "- `%s` failed, %s\n", x.Archive, x.Error)
+				continue
+			}
+			fmt.Fprintf(&b, "- `%s` into `%s`, %d files", x.Archive, x.Dir, x.Files)
+			if x.Deleted {
+				b.WriteString(", archive removed")
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(run.Failed) != 0 {
+		fmt.Fprintf(&b, "\n%d downloads failed:\n\n", len(run.Failed))
+		for _, p := range run.Failed {
+			fmt.Fprintf(&b, "- `%s`\n", p)
+		}
+	}
+
+	name := path.Join(ChangelogDir, run.Started.UTC().Format("20060102T150405Z")+".md")
+	if err := state.WriteFileAtomic(filepath.Join(dest, filepath.FromSlash(name)), b.Bytes()); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// describeChange lists which of size and checksums changed
+func describeChange(c Change) string {
+	switch {
+	case c.OldSHA1 != c.SHA1:
+		return fmt.Sprintf("sha1 %s -> %s", c.OldSHA1, c.SHA1)
+	case c.OldMD5 != c.MD5:
+		return fmt.Sprintf("md5 %s -> %s", c.OldMD5, c.MD5)
+	default:
+		return fmt.Sprintf("size %d -> %d bytes", c.OldSize, c.Size)
+	}
+}
@@ -0,0 +1,473 @@
+// Package daemon syncs the library on a schedule and downloads the assets
+// that are new or changed since the previous run.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"diogogmt.com/hbd/pkg/downloader"
+	"diogogmt.com/hbd/pkg/extract"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/library"
+	"diogogmt.com/hbd/pkg/manifest"
+	"diogogmt.com/hbd/pkg/notify"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/pkg/errors"
+)
+
+// statusFile is the state file keeping the last run status between restarts
+const statusFile = "daemon.json"
+
+// run statuses
+const (
+	RunRunning     = "running"
+	RunOK          = "ok"
+	RunFailed      = "failed"
+	RunInterrupted = "interrupted"
+)
+
+// RunStatus is the outcome of a sync run
+type RunStatus struct {
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Orders     int       `json:"orders"`
+	Outdated   int       `json:"outdated"`
+	Downloaded []string  `json:"downloaded"`
+	Failed     []string  `json:"failed,omitempty"`
+	// ChecksumFailed lists the failed downloads not matching the API checksums
+	ChecksumFailed []string `json:"checksum_failed,omitempty"`
+	// Extracted lists the downloaded archives unpacked, with paths relative to the destination
+	Extracted []extract.Result `json:"extracted,omitempty"`
+	// HookErrors lists the hooks that failed on downloaded files
+	HookErrors []string `json:"hook_errors,omitempty"`
+	// AuthExpired is set when the API rejected the credentials
+	AuthExpired bool     `json:"auth_expired,omitempty"`
+	Changes     []Change `json:"changes,omitempty"`
+	// Changelog is the path of the changelog written for the run, relative to the destination
+	Changelog string `json:"changelog,omitempty"`
+}
+
+// Change is an asset downloaded because it was new, changed upstream or missing on disk
+type Change struct {
+	Status  string `json:"status"`
+	Order   string `json:"order"`
+	Product string `json:"product"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	MD5     string `json:"md5,omitempty"`
+	SHA1    string `json:"sha1,omitempty"`
+	OldSize int64  `json:"old_size,omitempty"`
+	OldMD5  string `json:"old_md5,omitempty"`
+	OldSHA1 string `json:"old_sha1,omitempty"`
+	// OldPath is where the replaced file was kept, empty when it wasn't
+	OldPath string `json:"old_path,omitempty"`
+}
+
+// Status is reported by the health endpoint
+type Status struct {
+	Running bool       `json:"running"`
+	LastRun *RunStatus `json:"last_run,omitempty"`
+	NextRun time.Time  `json:"next_run,omitempty"`
+}
+
+// Daemon syncs the orders and downloads outdated assets into dest
+type Daemon struct {
+	lib      *library.Library
+	stateDir state.Dir
+	dest     string
+	keys     []string
+	filters  []hbclient.AssetFilter
+	dlOpts   []downloader.Option
+	schedule Schedule
+	notifier notify.Notifier
+	log      io.Writer
+	// keepVersions is how many previous versions of a changed file are kept
+	keepVersions int
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Option defines the signature for functional options to be applied to the daemon
+type Option = func(d *Daemon)
+
+// New creates a daemon syncing lib and downloading into dest, the last run
+// status is kept in stateDir
+func New(lib *library.Library, stateDir state.Dir, dest string, opts ...Option) *Daemon {
+	d := Daemon{
+		lib:          lib,
+		stateDir:     stateDir,
+		dest:         dest,
+		schedule:     Every(24 * time.Hour),
+		log:          ioutil.Discard,
+		keepVersions: 3,
+	}
+	for _, opt := range opts {
+		opt(&d)
+	}
+	last := RunStatus{}
+	if err := stateDir.Load(statusFile, &last); err == nil && !last.Started.IsZero() {
+		d.status.LastRun = &last
+	}
+	return &d
+}
+
+// WithKeys limits the sync to some orders instead of the whole account
+func WithKeys(keys ...string) Option {
+	return func(d *Daemon) {
+		d.keys = keys
+	}
+}
+
+// WithFilters limits the assets downloaded
+func WithFilters(filters ...hbclient.AssetFilter) Option {
+	return func(d *Daemon) {
+		d.filters = filters
+	}
+}
+
+// WithDownloaderOptions sets the options of the downloads, eg; rate limits
+func WithDownloaderOptions(opts ...downloader.Option) Option {
+	return func(d *Daemon) {
+		d.dlOpts = opts
+	}
+}
+
+// WithSchedule sets when the syncs run, it defaults to once a day
+func WithSchedule(s Schedule) Option {
+	return func(d *Daemon) {
+		d.schedule = s
+	}
+}
+
+// WithKeepVersions sets how many previous versions of a changed file are kept
+// in the .old directory next to it, 0 replaces files without keeping a copy
+func WithKeepVersions(n int) Option {
+	return func(d *Daemon) {
+		d.keepVersions = n
+	}
+}
+
+// WithNotifier sets the notifier told about new assets, failures and finished runs
+func WithNotifier(n notify.Notifier) Option {
+	return func(d *Daemon) {
+		d.notifier = n
+	}
+}
+
+// WithLog sets the writer receiving a line per run and per downloaded file
+func WithLog(w io.Writer) Option {
+	return func(d *Daemon) {
+		d.log = w
+	}
+}
+
+// Status returns the current status
+func (d *Daemon) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := d.status
+	if status.LastRun != nil {
+		last := *status.LastRun
+		status.LastRun = &last
+	}
+	return status
+}
+
+// Run syncs right away and then on every scheduled time until ctx is cancelled
+func (d *Daemon) Run(ctx context.Context) error {
+	for {
+		if _, err := d.Sync(ctx); err != nil {
+			fmt.Fprintf(d.log, "sync failed: %v\n", err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		now := time.Now()
+		next := d.schedule.Next(now)
+		if next.IsZero() {
+			return errors.New("the schedule never runs again")
+		}
+		d.mu.Lock()
+		d.status.NextRun = next
+		d.mu.Unlock()
+		fmt.Fprintf(d.log, "next sync at %s\n", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Sync fetches the orders and downloads their new, changed or missing assets
+func (d *Daemon) Sync(ctx context.Context) (*RunStatus, error) {
+	run := RunStatus{
+		Started:    time.Now().UTC(),
+		Status:     RunRunning,
+		Downloaded: []string{},
+	}
+	d.mu.Lock()
+	d.status.Running = true
+	d.mu.Unlock()
+	fmt.Fprintln(d.log, "sync started")
+
+	err := d.sync(ctx, &run)
+
+	run.Finished = time.Now().UTC()
+	switch {
+	case ctx.Err() != nil:
+		run.Status = RunInterrupted
+	case err != nil:
+		run.Status = RunFailed
+		run.Error = err.Error()
+		run.AuthExpired = hbclient.IsAuthError(err)
+	default:
+		run.Status = RunOK
+	}
+	d.mu.Lock()
+	d.status.Running = false
+	d.status.LastRun = &run
+	d.mu.Unlock()
+	fmt.Fprintf(d.log, "sync %s, %d orders, %d/%d assets downloaded\n", run.Status, run.Orders, len(run.Downloaded), run.Outdated)
+	if saveErr := d.stateDir.Save(statusFile, &run); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	d.notify(&run)
+	return &run, err
+}
+
+// notify sends the notifications of a run, interrupted runs aren't reported
+// and failed notifications are only logged
+func (d *Daemon) notify(run *RunStatus) {
+	if d.notifier == nil || run.Status == RunInterrupted {
+		return
+	}
+	for _, n := range notifications(run) {
+		if err := d.notifier.Notify(context.Background(), n); err != nil {
+			fmt.Fprintf(d.log, "notify: %v\n", err)
+		}
+	}
+}
+
+// notifications describes a run as the events notifiers subscribe to
+func notifications(run *RunStatus) []*notify.Notification {
+	ns := []*notify.Notification{}
+	if len(run.Changes) != 0 {
+		lines := []string{}
+		for _, c := range run.Changes {
+			lines = append(lines, fmt.Sprintf("%s %s", c.Status, c.Path))
+		}
+		ns = append(ns, &notify.Notification{
+			Event:   notify.EventNewAssets,
+			Subject: fmt.Sprintf("hbd: %d assets downloaded", len(run.Changes)),
+			Message: strings.Join(lines, "\n"),
+		})
+	}
+	if len(run.ChecksumFailed) != 0 {
+		ns = append(ns, &notify.Notification{
+			Event:   notify.EventChecksumFailed,
+			Subject: fmt.Sprintf("hbd: checksum failed for %d assets", len(run.ChecksumFailed)),
+			Message: strings.Join(run.ChecksumFailed, "\n"),
+		})
+	}
+	if run.AuthExpired {
+		ns = append(ns, &notify.Notification{
+			Event:   notify.EventAuthExpired,
+			Subject: "hbd: humblebundle session expired",
+			Message: "update the -jwt cookie, " + run.Error,
+		})
+	}
+	if run.Status == RunFailed {
+		ns = append(ns, &notify.Notification{
+			Event:   notify.EventRunFailed,
+			Subject: "hbd: sync failed",
+			Message: run.Error,
+		})
+	}
+	summary := fmt.Sprintf("sync %s, %d orders, %d/%d assets downloaded", run.Status, run.Orders, len(run.Downloaded), run.Outdated)
+	ns = append(ns, &notify.Notification{
+		Event:   notify.EventRunFinished,
+		Subject: "hbd: " + summary,
+		Message: summary,
+	})
+	for _, n := range ns {
+		n.Time = run.Finished
+		n.Data = run
+	}
+	return ns
+}
+
+func (d *Daemon) sync(ctx context.Context, run *RunStatus) error {
+	orders, err := d.lib.Sync(ctx, d.keys...)
+	if err != nil {
+		return errors.Wrap(err, "library.Sync")
+	}
+	run.Orders = len(orders)
+	m, err := manifest.Load(d.dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if len(run.Changes) == 0 {
+			return
+		}
+		if path, err := writeChangelog(d.dest, run); err != nil {
+			fmt.Fprintf(d.log, "changelog: %v\n", err)
+		} else {
+			run.Changelog = path
+		}
+	}()
+
+	var (
+		mu   sync.Mutex
+		errs []string
+	)
+	for _, order := range orders {
+		plan := downloader.NewPlan(order, append(append([]hbclient.AssetFilter{}, d.filters...), m.Outdated())...)
+		if len(plan.Items) == 0 {
+			continue
+		}
+		run.Outdated += len(plan.Items)
+		dir := downloader.BundleDir(order)
+		changes := map[*downloader.Item]*Change{}
+		for _, item := range plan.Items {
+			change, err := d.prepare(m, item, filepath.Join(dir, item.Filename))
+			if err != nil {
+				return err
+			}
+			changes[item] = change
+		}
+
+		opts := append(append([]downloader.Option{}, d.dlOpts...), downloader.WithEventHandler(func(e downloader.Event) {
+			change := changes[e.Item]
+			switch e.Type {
+			case downloader.EventDone:
+				m.Put(e.Item.Asset, change.Path)
+				m.SetMeta(e.Item.Asset, e.Meta)
+				mu.Lock()
+				run.Downloaded = append(run.Downloaded, change.Path)
+				run.Changes = append(run.Changes, *change)
+				mu.Unlock()
+				fmt.Fprintf(d.log, "downloaded %s, %s\n", change.Path, change.Status)
+			case downloader.EventFailed:
+				// the file wasn't replaced so its copy isn't needed
+				if change.OldPath != "" {
+					os.Remove(filepath.Join(d.dest, filepath.FromSlash(change.OldPath)))
+				}
+				var checksumErr *downloader.ChecksumError
+				mu.Lock()
+				run.Failed = append(run.Failed, change.Path)
+				if errors.As(e.Err, &checksumErr) {
+					run.ChecksumFailed = append(run.ChecksumFailed, change.Path)
+				}
+				mu.Unlock()
+				fmt.Fprintf(d.log, "failed %s: %v\n", change.Path, e.Err)
+			case downloader.EventExtracted:
+				extracted := *e.Extracted
+				extracted.Archive = change.Path
+				extracted.Dir = d.relPath(extracted.Dir)
+				if e.Err == nil {
+					m.SetExtracted(e.Item.Asset, extracted.Dir)
+					fmt.Fprintf(d.log, "extracted %s into %s, %d files\n", change.Path, extracted.Dir, extracted.Files)
+				} else {
+					fmt.Fprintf(d.log, "extracting %s failed: %v\n", change.Path, e.Err)
+				}
+				mu.Lock()
+				run.Extracted = append(run.Extracted, extracted)
+				mu.Unlock()
+			case downloader.EventHookFailed:
+				mu.Lock()
+				run.HookErrors = append(run.HookErrors, e.Err.Error())
+				mu.Unlock()
+				fmt.Fprintf(d.log, "%v\n", e.Err)
+			}
+		}))
+		_, err := downloader.New(filepath.Join(d.dest, dir), opts...).Download(ctx, plan)
+		// keep what finished even if the run stops here
+		if saveErr := m.Save(); saveErr != nil {
+			return saveErr
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "order %s", order.GameKey).Error())
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, " - "))
+	}
+	return nil
+}
+
+// relPath makes a path under the destination relative to it, with forward slashes
+func (d *Daemon) relPath(path string) string {
+	if rel, err := filepath.Rel(d.dest, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+// prepare describes how an outdated item differs from the manifest and keeps
+// a copy of the file it's about to replace
+func (d *Daemon) prepare(m *manifest.Manifest, item *downloader.Item, path string) (*Change, error) {
+	change := Change{
+		Status:  m.Status(item.Asset).String(),
+		Order:   item.Order.GameKey,
+		Product: item.Product.HumanName,
+		Path:    filepath.ToSlash(path),
+		Size:    item.Size(),
+		MD5:     item.Type.MD5,
+		SHA1:    item.Type.SHA1,
+	}
+	old := m.Get(item.Asset)
+	if old == nil || change.Status != manifest.StatusChanged.String() {
+		return &change, nil
+	}
+	change.OldSize, change.OldMD5, change.OldSHA1 = old.Size, old.MD5, old.SHA1
+	if _, err := os.Stat(filepath.Join(d.dest, filepath.FromSlash(old.Path))); err != nil {
+		return &change, nil
+	}
+	version, err := m.KeepVersion(old, d.keepVersions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "keeping previous version of %s", old.Path)
+	}
+	change.OldPath = version
+	return &change, nil
+}
+
+// Handler serves /healthz, failing with 503 after a failed run, and /status with the run details
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := d.Status()
+		if status.LastRun != nil && status.LastRun.Status == RunFailed {
+			http.Error(w, "last sync failed: "+status.LastRun.Error, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(d.Status())
+	})
+	return mux
+}
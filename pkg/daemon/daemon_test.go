@@ -0,0 +1,320 @@
+package daemon
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/downloader"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/library"
+	"diogogmt.com/hbd/pkg/notify"
+	"diogogmt.com/hbd/pkg/state"
+)
+
+func TestDaemonSync(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		content = "first edition"
+		apiURL  string
+		fetched int
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/order", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"gamekey": "order1"}]`))
+	})
+	mux.HandleFunc("/order/order1", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, `{"gamekey": "order1", "product": {"human_name": "Book Bundle"}, "subproducts": [
+			{"human_name": "Book", "machine_name": "book", "downloads": [{"platform": "ebook", "download_struct": [
+				{"name": "PDF", "md5": "%x", "file_size": %d, "url": {"web": "%s/dl/book.pdf"}}
+			]}]}
+		]}`, md5.Sum([]byte(content)), len(content), apiURL)
+	})
+	mux.HandleFunc("/dl/book.pdf", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetched++
+		w.Write([]byte(content))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	apiURL = srv.URL
+
+	dir, err := ioutil.TempDir("", "hbd-daemon.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := state.Dir(filepath.Join(dir, "state"))
+	dest := filepath.Join(dir, "dest")
+	lib := library.New(stateDir, hbclient.NewClient(hbclient.WithAPIURL(srv.URL)))
+	d := New(lib, stateDir, dest)
+
+	runs := []struct {
+		name       string
+		edit       func()
+		downloaded int
+		fetched    int
+	}{
+		{name: "new", downloaded: 1, fetched: 1},
+		{name: "current", downloaded: 0, fetched: 1},
+		{name: "changed", edit: func() { content = "second edition" }, downloaded: 1, fetched: 2},
+		{name: "missing", edit: func() { os.Remove(filepath.Join(dest, "Book Bundle", "Book.pdf")) }, downloaded: 1, fetched: 3},
+	}
+	oldDir := filepath.Join(dest, "Book Bundle", ".old")
+	for _, r := range runs {
+		if r.edit != nil {
+			mu.Lock()
+			r.edit()
+			mu.Unlock()
+		}
+		run, err := d.Sync(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Sync: %v", r.name, err)
+		}
+		if run.Status != RunOK || run.Orders != 1 || len(run.Downloaded) != r.downloaded {
+			t.Errorf("%s: expected ok run with %d downloads but got %+v", r.name, r.downloaded, run)
+		}
+		if fetched != r.fetched {
+			t.Errorf("%s: expected %d fetches but got %d", r.name, r.fetched, fetched)
+		}
+		if r.downloaded == 0 {
+			if run.Changelog != "" {
+				t.Errorf("%s: expected no changelog but got %s", r.name, run.Changelog)
+			}
+			continue
+		}
+		if len(run.Changes) != 1 || run.Changes[0].Status != r.name {
+			t.Errorf("%s: expected a %s change but got %+v", r.name, r.name, run.Changes)
+		}
+		changelog, err := ioutil.ReadFile(filepath.Join(dest, run.Changelog))
+		if err != nil || !strings.Contains(string(changelog), "- "+r.name+" `Book Bundle/Book.pdf`") {
+			t.Errorf("%s: expected changelog to list the change but got %q %v", r.name, changelog, err)
+		}
+	}
+	versions, err := ioutil.ReadDir(oldDir)
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("expected 1 previous version but got %v %v", versions, err)
+	}
+	by, err := ioutil.ReadFile(filepath.Join(oldDir, versions[0].Name()))
+	if err != nil || string(by) != "first edition" {
+		t.Errorf("expected the first edition to be kept but got %q %v", by, err)
+	}
+	by, err = ioutil.ReadFile(filepath.Join(dest, "Book Bundle", "Book.pdf"))
+	if err != nil || string(by) != "second edition" {
+		t.Errorf("expected the changed book to be downloaded again: %q %v", by, err)
+	}
+
+	// a restarted daemon reports the previous run
+	restarted := New(lib, stateDir, dest)
+	if last := restarted.Status().LastRun; last == nil || last.Status != RunOK {
+		t.Errorf("expected the last run to be loaded but got %+v", last)
+	}
+
+	health := httptest.NewServer(d.Handler())
+	defer health.Close()
+	resp, err := http.Get(health.URL + "/healthz")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected healthy daemon but got %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	// a failed run turns the health check red
+	mu.Lock()
+	apiURL = "http://127.0.0.1:1"
+	content = "third edition"
+	mu.Unlock()
+	if run, err := d.Sync(context.Background()); err == nil || run.Status != RunFailed || len(run.Failed) != 1 {
+		t.Errorf("expected failed run but got %+v, %v", run, err)
+	}
+	resp, err = http.Get(health.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), "last sync failed") {
+		t.Errorf("expected unhealthy daemon but got %d %s", resp.StatusCode, body)
+	}
+	resp, err = http.Get(health.URL + "/status")
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"status": "failed"`) {
+		t.Errorf("expected status to report the failed run but got %s", body)
+	}
+}
+
+type recordNotifier struct {
+	mu            sync.Mutex
+	notifications []*notify.Notification
+}
+
+func (r *recordNotifier) Notify(ctx context.Context, n *notify.Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+func (r *recordNotifier) events() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := []string{}
+	for _, n := range r.notifications {
+		events = append(events, n.Event)
+	}
+	r.notifications = nil
+	return strings.Join(events, ",")
+}
+
+func TestDaemonNotify(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		md5Sum  = fmt.Sprintf("%x", md5.Sum([]byte("book")))
+		expired bool
+		apiURL  string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/order", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if expired {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "login required", "status": "error"}`))
+			return
+		}
+		w.Write([]byte(`[{"gamekey": "order1"}]`))
+	})
+	mux.HandleFunc("/order/order1", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, `{"gamekey": "order1", "product": {"human_name": "Book Bundle"}, "subproducts": [
+			{"human_name": "Book", "machine_name": "book", "downloads": [{"platform": "ebook", "download_struct": [
+				{"name": "PDF", "md5": "%s", "file_size": 4, "url": {"web": "%s/dl/book.pdf"}}
+			]}]}
+		]}`, md5Sum, apiURL)
+	})
+	mux.HandleFunc("/dl/book.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("book"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	apiURL = srv.URL
+
+	dir, err := ioutil.TempDir("", "hbd-daemon.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := state.Dir(filepath.Join(dir, "state"))
+	notifier := &recordNotifier{}
+	lib := library.New(stateDir, hbclient.NewClient(hbclient.WithAPIURL(srv.URL)))
+	d := New(lib, stateDir, filepath.Join(dir, "dest"), WithNotifier(notifier))
+
+	runs := []struct {
+		name     string
+		edit     func()
+		expected string
+	}{
+		{name: "new", expected: "new_assets,run_finished"},
+		{name: "current", expected: "run_finished"},
+		{name: "checksum", edit: func() { md5Sum = "0123" }, expected: "checksum_failed,run_failed,run_finished"},
+		{name: "expired", edit: func() { expired = true }, expected: "auth_expired,run_failed,run_finished"},
+	}
+	for _, r := range runs {
+		if r.edit != nil {
+			mu.Lock()
+			r.edit()
+			mu.Unlock()
+		}
+		d.Sync(context.Background())
+		if got := notifier.events(); got != r.expected {
+			t.Errorf("%s: expected events %s but got %s", r.name, r.expected, got)
+		}
+	}
+}
+
+func TestDaemonExtract(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("01 - Theme.mp3")
+	w.Write([]byte("theme"))
+	zw.Close()
+
+	var (
+		apiURL  string
+		fetched int
+		mu      sync.Mutex
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/order", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"gamekey": "order1"}]`))
+	})
+	mux.HandleFunc("/order/order1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"gamekey": "order1", "product": {"human_name": "Game Bundle"}, "subproducts": [
+			{"human_name": "Game", "machine_name": "game", "downloads": [{"platform": "audio", "download_struct": [
+				{"name": "MP3", "md5": "%x", "file_size": %d, "url": {"web": "%s/dl/soundtrack.zip"}}
+			]}]}
+		]}`, md5.Sum(archive.Bytes()), archive.Len(), apiURL)
+	})
+	mux.HandleFunc("/dl/soundtrack.zip", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched++
+		mu.Unlock()
+		w.Write(archive.Bytes())
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	apiURL = srv.URL
+
+	dir, err := ioutil.TempDir("", "hbd-daemon.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := state.Dir(filepath.Join(dir, "state"))
+	dest := filepath.Join(dir, "dest")
+	lib := library.New(stateDir, hbclient.NewClient(hbclient.WithAPIURL(srv.URL)))
+	d := New(lib, stateDir, dest, WithDownloaderOptions(downloader.WithExtract("{name}", true)))
+
+	for i := 0; i < 2; i++ {
+		run, err := d.Sync(context.Background())
+		if err != nil {
+			t.Fatalf("%d: Sync: %v", i, err)
+		}
+		if i == 1 {
+			if len(run.Downloaded) != 0 {
+				t.Errorf("expected the extracted archive to stay current but got %+v", run)
+			}
+			break
+		}
+		if len(run.Extracted) != 1 || run.Extracted[0].Dir != "Game Bundle/Game" || !run.Extracted[0].Deleted {
+			t.Fatalf("expected the archive to be extracted and removed but got %+v", run.Extracted)
+		}
+		changelog, err := ioutil.ReadFile(filepath.Join(dest, run.Changelog))
+		if err != nil || !strings.Contains(string(changelog), "- `Game Bundle/Game.mp3` into `Game Bundle/Game`, 1 files, archive removed") {
+			t.Errorf("expected changelog to list the extraction but got %q %v", changelog, err)
+		}
+	}
+	if fetched != 1 {
+		t.Errorf("expected a single download but got %d", fetched)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "Game Bundle", "Game", "01 - Theme.mp3")); err != nil {
+		t.Errorf("expected the soundtrack to be unpacked: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	from := time.Date(2020, time.May, 6, 10, 30, 15, 0, time.UTC) // a wednesday
+	dd := []struct {
+		schedule  string
+		expected  time.Time
+		expectErr bool
+	}{
+		{schedule: "6h", expected: from.Add(6 * time.Hour)},
+		{schedule: "@every 90m", expected: from.Add(90 * time.Minute)},
+		{schedule: "30 3 * * *", expected: time.Date(2020, time.May, 7, 3, 30, 0, 0, time.UTC)},
+		{schedule: "*/15 * * * *", expected: time.Date(2020, time.May, 6, 10, 45, 0, 0, time.UTC)},
+		{schedule: "0 9-17/4 * * 1-5", expected: time.Date(2020, time.May, 6, 13, 0, 0, 0, time.UTC)},
+		{schedule: "0 0 * * 7", expected: time.Date(2020, time.May, 10, 0, 0, 0, 0, time.UTC)},
+		{schedule: "0 0 1 * 3", expected: time.Date(2020, time.May, 13, 0, 0, 0, 0, time.UTC)},
+		{schedule: "@monthly", expected: time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{schedule: "0 12 29 2 *", expected: time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{schedule: "10s", expectErr: true},
+		{schedule: "61 * * * *", expectErr: true},
+		{schedule: "* * *", expectErr: true},
+		{schedule: "a * * * *", expectErr: true},
+	}
+	for _, d := range dd {
+		s, err := ParseSchedule(d.schedule)
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", d.schedule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseSchedule: %v", d.schedule, err)
+			continue
+		}
+		if next := s.Next(from); !next.Equal(d.expected) {
+			t.Errorf("%s: expected next run at %s but got %s", d.schedule, d.expected, next)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package dedupe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Report sums up a dedupe run
+type Report struct {
+	// Files is how many files were looked at
+	Files int
+	// Stored is how many files were added to the store
+	Stored int
+	// Linked is how many files were linked to the store already
+	Linked int
+	// Duplicates lists the files replaced with a link to the store
+	Duplicates []Duplicate
+	// Reclaimed is the space the duplicates took
+	Reclaimed int64
+}
+
+// Duplicate is a file with the same content as a store object
+type Duplicate struct {
+	Path   string
+	Object string
+	Size   int64
+}
+
+// Dedupe stores every file under dir and replaces the ones stored already
+// with links, dot files and directories are skipped, eg; the store itself or
+// the old versions kept by the daemon. A dry run only reports what it would do.
+func (s *Store) Dedupe(ctx context.Context, dir string, dryRun bool) (*Report, error) {
+	report := Report{}
+	// the dry run has to remember the content it would have stored
+	seen := map[string]string{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if path != dir && strings.HasPrefix(fi.Name(), ".") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			if abs, err := filepath.Abs(path); err == nil && abs == s.dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// symlinks are either links to the store or not ours to follow
+		if !fi.Mode().IsRegular() || fi.Size() == 0 {
+			return nil
+		}
+		report.Files++
+		sums, err := Hash(path)
+		if err != nil {
+			return err
+		}
+		object, result := "", resultStored
+		if dryRun {
+			object, result = s.plan(path, sums, seen)
+		} else if object, result, err = s.add(path, sums); err != nil {
+			return err
+		}
+		switch result {
+		case resultStored:
+			report.Stored++
+		case resultLinked:
+			report.Linked++
+		case resultDuplicate:
+			report.Duplicates = append(report.Duplicates, Duplicate{Path: path, Object: object, Size: fi.Size()})
+			report.Reclaimed += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return &report, errors.Wrapf(err, "dedupe %s", dir)
+	}
+	return &report, nil
+}
+
+// plan tells what add would do without touching the files
+func (s *Store) plan(path string, sums, seen map[string]string) (string, int) {
+	object := s.objectPath(SHA1, sums[SHA1])
+	if fi, err := os.Stat(object); err == nil {
+		if same, _ := sameFile(path, fi); same {
+			return object, resultLinked
+		}
+		return object, resultDuplicate
+	}
+	if first, ok := seen[sums[SHA1]]; ok {
+		return first, resultDuplicate
+	}
+	seen[sums[SHA1]] = path
+	return object, resultStored
+}
@@ -0,0 +1,158 @@
+package dedupe
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("os.MkdirAll: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	for _, link := range []string{LinkHard, LinkSymlink} {
+		dir, err := ioutil.TempDir("", "hbd-dedupe.")
+		if err != nil {
+			t.Fatalf("ioutil.TempDir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		s, err := Open(filepath.Join(dir, DefaultDir), link)
+		if err != nil {
+			t.Fatalf("%s: Open: %v", link, err)
+		}
+
+		first, second := filepath.Join(dir, "Book Bundle", "Book.pdf"), filepath.Join(dir, "Other Bundle", "Book.pdf")
+		writeFile(t, first, "pdf content")
+		writeFile(t, second, "pdf content")
+		object, dup, err := s.Add(first)
+		if err != nil || dup {
+			t.Fatalf("%s: expected the first file to be stored but got %v %v", link, dup, err)
+		}
+		if _, dup, err := s.Add(second); err != nil || !dup {
+			t.Fatalf("%s: expected the second file to be a duplicate but got %v %v", link, dup, err)
+		}
+		if _, dup, err := s.Add(second); err != nil || dup {
+			t.Errorf("%s: expected the linked file to be left alone but got %v %v", link, dup, err)
+		}
+
+		sha1Sum, md5Sum := fmt.Sprintf("%x", sha1.Sum([]byte("pdf content"))), fmt.Sprintf("%x", md5.Sum([]byte("pdf content")))
+		if s.Lookup(SHA1, sha1Sum) != object || s.Lookup(MD5, md5Sum) == "" || s.Lookup(SHA1, "0123") != "" || s.Lookup(SHA1, "../../etc") != "" {
+			t.Errorf("%s: expected the object under its sha1 and md5", link)
+		}
+		objectInfo, _ := os.Stat(object)
+		for _, path := range []string{first, second} {
+			fi, err := os.Stat(path)
+			if by, _ := ioutil.ReadFile(path); err != nil || !os.SameFile(fi, objectInfo) || string(by) != "pdf content" {
+				t.Errorf("%s: expected %s to link the object but got %q %v", link, path, by, err)
+			}
+			lfi, _ := os.Lstat(path)
+			if isSymlink := lfi.Mode()&os.ModeSymlink != 0; isSymlink != (link == LinkSymlink) {
+				t.Errorf("%s: expected %s to be a %s link but got %v", link, path, link, lfi.Mode())
+			}
+		}
+	}
+
+	// a hardlinked file edited in place modifies the object, the next file
+	// with its content replaces it
+	for _, link := range []string{LinkHard, LinkSymlink} {
+		dir, err := ioutil.TempDir("", "hbd-dedupe.")
+		if err != nil {
+			t.Fatalf("ioutil.TempDir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		s, err := Open(filepath.Join(dir, DefaultDir), link)
+		if err != nil {
+			t.Fatalf("%s: Open: %v", link, err)
+		}
+		first, second := filepath.Join(dir, "Book Bundle", "Book.pdf"), filepath.Join(dir, "Other Bundle", "Book.pdf")
+		writeFile(t, first, "pdf content")
+		object, _, err := s.Add(first)
+		if err != nil || !s.Verify(object) {
+			t.Fatalf("%s: expected a verified object but got %v", link, err)
+		}
+		if err := ioutil.WriteFile(object, []byte("pdf CONTENT"), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+		if s.Verify(object) {
+			t.Errorf("%s: expected the modified object to fail verification", link)
+		}
+		writeFile(t, second, "pdf content")
+		if _, dup, err := s.Add(second); err != nil || dup {
+			t.Errorf("%s: expected the file to replace the object but got %v %v", link, dup, err)
+		}
+		md5Object := s.Lookup(MD5, fmt.Sprintf("%x", md5.Sum([]byte("pdf content"))))
+		for _, path := range []string{object, md5Object, second} {
+			if by, err := ioutil.ReadFile(path); err != nil || string(by) != "pdf content" {
+				t.Errorf("%s: expected %s to hold the original content but got %q %v", link, path, by, err)
+			}
+		}
+	}
+
+	if _, err := Open(".", "copy"); err == nil {
+		t.Errorf("expected an invalid link error")
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-dedupe.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "Book Bundle", "Book.pdf"), "pdf content")
+	writeFile(t, filepath.Join(dir, "Book Bundle", "Book.epub"), "epub content")
+	writeFile(t, filepath.Join(dir, "Other Bundle", "Book.pdf"), "pdf content")
+	writeFile(t, filepath.Join(dir, "Third Bundle", "Renamed.pdf"), "pdf content")
+	writeFile(t, filepath.Join(dir, "Third Bundle", "Empty.txt"), "")
+	writeFile(t, filepath.Join(dir, "Third Bundle", ".old", "Book.20200506T103015Z.pdf"), "pdf content")
+	s, err := Open(filepath.Join(dir, DefaultDir), LinkHard)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	dd := []struct {
+		name                 string
+		dryRun               bool
+		stored, linked, dups int
+		reclaimed            int64
+	}{
+		{name: "dry-run", dryRun: true, stored: 2, dups: 2, reclaimed: 2 * int64(len("pdf content"))},
+		{name: "dedupe", stored: 2, dups: 2, reclaimed: 2 * int64(len("pdf content"))},
+		{name: "again", linked: 4},
+	}
+	for _, d := range dd {
+		report, err := s.Dedupe(context.Background(), dir, d.dryRun)
+		if err != nil {
+			t.Fatalf("%s: Dedupe: %v", d.name, err)
+		}
+		if report.Files != 4 || report.Stored != d.stored || report.Linked != d.linked || len(report.Duplicates) != d.dups || report.Reclaimed != d.reclaimed {
+			t.Errorf("%s: expected %d stored, %d linked and %d duplicates but got %+v", d.name, d.stored, d.linked, d.dups, report)
+		}
+		if _, err := os.Stat(s.Dir()); d.dryRun && !os.IsNotExist(err) {
+			t.Errorf("%s: expected the store to be left alone but got %v", d.name, err)
+		}
+	}
+	old, _ := os.Stat(filepath.Join(dir, "Third Bundle", ".old", "Book.20200506T103015Z.pdf"))
+	renamed, _ := os.Stat(filepath.Join(dir, "Third Bundle", "Renamed.pdf"))
+	if os.SameFile(old, renamed) {
+		t.Errorf("expected the old versions to be skipped")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Dedupe(ctx, dir, false); err == nil {
+		t.Errorf("expected a cancelled dedupe to fail")
+	}
+}
@@ -0,0 +1,258 @@
+// Package dedupe keeps a single copy of identical files in a content
+// addressed store and links them back into the directories they belong to.
+package dedupe
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDir is the store directory kept at the root of a destination directory
+const DefaultDir = ".hbd-store"
+
+// how files are linked to the store objects
+const (
+	LinkHard    = "hard"
+	LinkSymlink = "symlink"
+)
+
+// checksum algorithms naming the store objects, the same as the API ones
+const (
+	SHA1 = "sha1"
+	MD5  = "md5"
+)
+
+// Store holds a file per content, named after its SHA1 and MD5 so either
+// checksum reported by the API finds it, both names link the same file
+type Store struct {
+	dir  string
+	link string
+}
+
+// Open opens the store directory dir, it's created with the first object.
+// Files are linked to it with link, hardlinks need the files on the same
+// filesystem as the store
+func Open(dir, link string) (*Store, error) {
+	if link != LinkHard && link != LinkSymlink {
+		return nil, errors.Errorf("invalid link %q, expected %s or %s", link, LinkHard, LinkSymlink)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "filepath.Abs")
+	}
+	return &Store{dir: dir, link: link}, nil
+}
+
+// Dir is the store directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Lookup returns the object with the checksum sum, empty when there is none,
+// see Verify before trusting its content
+func (s *Store) Lookup(algorithm, sum string) string {
+	path := s.objectPath(algorithm, sum)
+	if path == "" {
+		return ""
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return ""
+	}
+	return path
+}
+
+// Verify reports whether the object still has the checksum it's named
+// after, a hardlinked file edited in place changes the object too
+func (s *Store) Verify(object string) bool {
+	algorithm := filepath.Base(filepath.Dir(filepath.Dir(object)))
+	sums, err := Hash(object)
+	return err == nil && sums[algorithm] == filepath.Base(object)
+}
+
+// Add stores the file at path, a file already stored is replaced with a link
+// to the object, dup reports whether it was
+func (s *Store) Add(path string) (object string, dup bool, err error) {
+	sums, err := Hash(path)
+	if err != nil {
+		return "", false, err
+	}
+	object, result, err := s.add(path, sums)
+	return object, result == resultDuplicate, err
+}
+
+// outcomes of adding a file to the store
+const (
+	resultStored = iota
+	resultLinked
+	resultDuplicate
+)
+
+// add stores the file at path with checksums sums, resultLinked means it already was the object
+func (s *Store) add(path string, sums map[string]string) (string, int, error) {
+	object := s.objectPath(SHA1, sums[SHA1])
+	if fi, err := os.Stat(object); err == nil {
+		if same, _ := sameFile(path, fi); same {
+			return object, resultLinked, nil
+		}
+		if s.Verify(object) {
+			return object, resultDuplicate, s.Link(object, path)
+		}
+		// the object was modified through one of its links, the file replaces it
+		return object, resultStored, s.replace(path, object, sums[MD5])
+	}
+	if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+		return "", 0, errors.Wrap(err, "os.MkdirAll")
+	}
+	var err error
+	if s.link == LinkHard {
+		err = os.Link(path, object)
+	} else {
+		err = s.move(path, object)
+	}
+	if os.IsExist(err) {
+		// a concurrent download stored the same content first
+		return object, resultDuplicate, s.Link(object, path)
+	}
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "storing %s", path)
+	}
+	if err := s.linkMD5(object, sums[MD5]); err != nil {
+		return "", 0, err
+	}
+	return object, resultStored, nil
+}
+
+// Link replaces the file at path with a link to the object, the file is
+// never missing while it's replaced
+func (s *Store) Link(object, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d.link", filepath.Base(path), os.Getpid()))
+	_ = os.Remove(tmp)
+	var err error
+	if s.link == LinkHard {
+		err = os.Link(object, tmp)
+	} else {
+		err = s.symlink(object, tmp)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "linking %s", path)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "os.Rename %s", path)
+	}
+	return nil
+}
+
+// replace puts the file at path in place of a modified object, symlinks to
+// the object see the file from then on
+func (s *Store) replace(path, object, md5Sum string) error {
+	tmp := object + ".tmp"
+	_ = os.Remove(tmp)
+	var err error
+	if s.link == LinkHard {
+		err = os.Link(path, tmp)
+	} else {
+		err = os.Rename(path, tmp)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "storing %s", path)
+	}
+	if err := os.Rename(tmp, object); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "os.Rename %s", object)
+	}
+	if s.link == LinkSymlink {
+		if err := s.symlink(object, path); err != nil {
+			return errors.Wrapf(err, "linking %s", path)
+		}
+	}
+	if md5Path := s.objectPath(MD5, md5Sum); md5Path != "" {
+		_ = os.Remove(md5Path)
+	}
+	return s.linkMD5(object, md5Sum)
+}
+
+// move puts the file into the store and leaves a symlink in its place
+func (s *Store) move(path, object string) error {
+	if _, err := os.Lstat(object); err == nil {
+		return os.ErrExist
+	}
+	if err := os.Rename(path, object); err != nil {
+		return err
+	}
+	if err := s.symlink(object, path); err != nil {
+		// put the file back rather than lose it
+		_ = os.Rename(object, path)
+		return err
+	}
+	return nil
+}
+
+// symlink links path to the object with a relative target so the store and
+// the destination can move together
+func (s *Store) symlink(object, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Rel(filepath.Dir(abs), object)
+	if err != nil {
+		target = object
+	}
+	return os.Symlink(target, path)
+}
+
+// linkMD5 names the object after its MD5 too
+func (s *Store) linkMD5(object, sum string) error {
+	path := s.objectPath(MD5, sum)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "os.MkdirAll")
+	}
+	if err := os.Link(object, path); err != nil && !os.IsExist(err) {
+		return errors.Wrapf(err, "linking %s", path)
+	}
+	return nil
+}
+
+// objectPath spreads the objects over directories named after the first
+// byte of their checksum, eg; sha1/ab/abcdef...
+func (s *Store) objectPath(algorithm, sum string) string {
+	sum = strings.ToLower(sum)
+	if (algorithm != SHA1 && algorithm != MD5) || len(sum) < 3 || strings.Trim(sum, "0123456789abcdef") != "" {
+		return ""
+	}
+	return filepath.Join(s.dir, algorithm, sum[:2], sum)
+}
+
+// Hash returns the SHA1 and MD5 checksums of the file at path
+func Hash(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.Open")
+	}
+	defer f.Close()
+	sha1Hash, md5Hash := sha1.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha1Hash, md5Hash), f); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+	return map[string]string{
+		SHA1: fmt.Sprintf("%x", sha1Hash.Sum(nil)),
+		MD5:  fmt.Sprintf("%x", md5Hash.Sum(nil)),
+	}, nil
+}
+
+// sameFile reports whether path already is the object, through a hardlink or a symlink
+func sameFile(path string, object os.FileInfo) (bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fi, object), nil
+}
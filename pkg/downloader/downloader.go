@@ -0,0 +1,567 @@
+// Package downloader downloads and verifies the assets of humble bundle orders.
+package downloader
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"diogogmt.com/hbd/pkg/dedupe"
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/extract"
+	"diogogmt.com/hbd/pkg/ratelimit"
+	"diogogmt.com/hbd/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// Downloader downloads the items of a plan into a storage, a local directory by default
+type Downloader struct {
+	storage        storage.Storage
+	skipExisting   bool
+	via            string
+	httpFetcher    Fetcher
+	torrentFetcher Fetcher
+	limiter        *ratelimit.Limiter
+	fileRate       int64
+	onEvent        EventHandler
+	hooks          *hookRunner
+	extractDir     string
+	extractDelete  bool
+	store          *dedupe.Store
+}
+
+// Option defines the signature for functional options to be applied to the downloader
+type Option = func(d *Downloader)
+
+// New creates a Downloader writing into dest
+func New(dest string, opts ...Option) *Downloader {
+	d := Downloader{
+		storage:     storage.NewLocal(dest),
+		via:         ViaHTTP,
+		httpFetcher: &HTTPFetcher{},
+	}
+	for _, opt := range opts {
+		opt(&d)
+	}
+	if d.via == ViaTorrent && d.torrentFetcher == nil {
+		d.torrentFetcher = NewTorrentFetcher()
+	}
+	return &d
+}
+
+// WithVia selects the download backend, assets without a torrent always use http
+func WithVia(via string) Option {
+	return func(d *Downloader) {
+		d.via = via
+	}
+}
+
+// WithHTTPClient sets the http client used to download assets
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Downloader) {
+		d.httpFetcher = &HTTPFetcher{Client: client}
+	}
+}
+
+// WithFetchers overrides the http and torrent backends
+func WithFetchers(httpFetcher, torrentFetcher Fetcher) Option {
+	return func(d *Downloader) {
+		d.httpFetcher = httpFetcher
+		d.torrentFetcher = torrentFetcher
+	}
+}
+
+// WithRateLimit caps the aggregate throughput of all downloads, the schedule overrides the rate during its windows
+func WithRateLimit(bytesPerSec int64, schedule ratelimit.Schedule) Option {
+	return func(d *Downloader) {
+		if bytesPerSec <= 0 && len(schedule) == 0 {
+			d.limiter = nil
+			return
+		}
+		d.limiter = ratelimit.NewLimiter(bytesPerSec)
+		d.limiter.SetSchedule(schedule)
+	}
+}
+
+// WithFileRateLimit caps the throughput of each download
+func WithFileRateLimit(bytesPerSec int64) Option {
+	return func(d *Downloader) {
+		d.fileRate = bytesPerSec
+	}
+}
+
+// WithEventHandler registers a callback receiving the download events
+func WithEventHandler(h EventHandler) Option {
+	return func(d *Downloader) {
+		d.onEvent = h
+	}
+}
+
+// WithHooks runs the matching hooks on every downloaded file, at most
+// concurrency hooks run at once and each is killed after timeout, 0 for none
+func WithHooks(hooks Hooks, concurrency int, timeout time.Duration) Option {
+	return func(d *Downloader) {
+		if len(hooks) == 0 {
+			d.hooks = nil
+			return
+		}
+		d.hooks = newHookRunner(hooks, concurrency, timeout)
+	}
+}
+
+// WithExtract unpacks the downloaded zip and tar archives into dirTemplate,
+// see extract.Dir, and removes the archives when deleteArchive is set, an
+// empty template disables the extraction
+func WithExtract(dirTemplate string, deleteArchive bool) Option {
+	return func(d *Downloader) {
+		d.extractDir = dirTemplate
+		d.extractDelete = deleteArchive
+	}
+}
+
+// WithStore links the items found in the content addressed store instead of
+// fetching them and stores the downloaded ones, nil disables it
+func WithStore(store *dedupe.Store) Option {
+	return func(d *Downloader) {
+		d.store = store
+	}
+}
+
+// WithStorage writes the files into s instead of the dest directory, the
+// store and the archive extraction only apply to local storages
+func WithStorage(s storage.Storage) Option {
+	return func(d *Downloader) {
+		d.storage = s
+	}
+}
+
+// WithSkipExisting skips the items already in the storage with the size and
+// checksum reported by the API, see Downloader.Existing
+func WithSkipExisting(skip bool) Option {
+	return func(d *Downloader) {
+		d.skipExisting = skip
+	}
+}
+
+// ValidVia reports whether via names a known download backend
+func ValidVia(via string) bool {
+	return via == ViaHTTP || via == ViaTorrent
+}
+
+// Result lists the outcome of a plan download
+type Result struct {
+	Finished []*Item
+	Failed   []*Item
+	// Skipped lists the items already in the storage, see WithSkipExisting
+	Skipped []*Item
+	// Extracted lists the finished items that were archives
+	Extracted []*extract.Result
+	// HookErrors lists the hooks that failed on finished items
+	HookErrors []*HookError
+}
+
+// Download fetches all the plan items concurrently, the result lists what
+// finished even when the context is cancelled midway
+func (d *Downloader) Download(ctx context.Context, plan *Plan) (*Result, error) {
+	if !ValidVia(d.via) {
+		return nil, errors.Errorf("invalid download backend %q, expected %s or %s", d.via, ViaHTTP, ViaTorrent)
+	}
+	if local := d.local(); local != nil {
+		_ = os.MkdirAll(local.Root(), 0777)
+	}
+
+	fallbackTime := plan.Order.Created.Time
+	if fallbackTime.IsZero() {
+		fallbackTime = time.Now()
+	}
+
+	var (
+		errs   []string
+		result Result
+		mu     sync.Mutex
+		group  sync.WaitGroup
+	)
+	for _, item := range plan.Items {
+		item := item
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			if d.skipExisting && d.Existing(ctx, item) {
+				mu.Lock()
+				result.Skipped = append(result.Skipped, item)
+				mu.Unlock()
+				d.emit(Event{Type: EventSkipped, Item: item, Path: d.path(item)})
+				return
+			}
+			d.emit(Event{Type: EventStarted, Item: item})
+			path, err := d.downloadItem(ctx, item, fallbackTime)
+			if err != nil {
+				mu.Lock()
+				result.Failed = append(result.Failed, item)
+				errs = append(errs, errors.Wrapf(err, "downloadAsset %s", item.Name()).Error())
+				mu.Unlock()
+				d.emit(Event{Type: EventFailed, Item: item, Err: err})
+				return
+			}
+			mu.Lock()
+			result.Finished = append(result.Finished, item)
+			mu.Unlock()
+			meta := d.meta(path)
+			d.emit(Event{Type: EventDone, Item: item, Path: path, Meta: meta})
+			extracted := d.extract(item, path, meta)
+			if extracted != nil {
+				mu.Lock()
+				result.Extracted = append(result.Extracted, extracted)
+				mu.Unlock()
+				e := Event{Type: EventExtracted, Item: item, Path: path, Extracted: extracted}
+				if extracted.Error != "" {
+					e.Err = errors.New(extracted.Error)
+				}
+				d.emit(e)
+			}
+			if d.hooks == nil {
+				return
+			}
+			for _, hookErr := range d.hooks.run(ctx, item, path, extracted, meta) {
+				mu.Lock()
+				result.HookErrors = append(result.HookErrors, hookErr)
+				mu.Unlock()
+				d.emit(Event{Type: EventHookFailed, Item: item, Path: path, Err: hookErr})
+			}
+		}()
+	}
+	group.Wait()
+
+	sort.Slice(result.Finished, func(i, j int) bool {
+		return result.Finished[i].Filename < result.Finished[j].Filename
+	})
+	sort.Slice(result.Skipped, func(i, j int) bool {
+		return result.Skipped[i].Filename < result.Skipped[j].Filename
+	})
+	if ctx.Err() != nil {
+		return &result, ctx.Err()
+	}
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return &result, errors.Errorf(strings.Join(errs, " - "))
+	}
+	return &result, nil
+}
+
+// meta reads the metadata of a downloaded EPUB or PDF, nil for other files,
+// unreadable ebooks and files outside a local storage
+func (d *Downloader) meta(path string) *ebookmeta.Metadata {
+	if d.local() == nil {
+		return nil
+	}
+	meta, _ := ebookmeta.Read(path)
+	return meta
+}
+
+// extract unpacks a downloaded archive, it returns nil when extraction is
+// disabled, the storage isn't local or the file isn't an archive, a failed
+// extraction leaves the archive in place and is reported in the result
+func (d *Downloader) extract(item *Item, path string, meta *ebookmeta.Metadata) *extract.Result {
+	if d.extractDir == "" || d.local() == nil {
+		return nil
+	}
+	format, err := extract.Detect(path)
+	if err != nil {
+		return &extract.Result{Archive: path, Error: err.Error()}
+	}
+	if format == "" {
+		return nil
+	}
+	vars := map[string]string{
+		"order":    item.Order.GameKey,
+		"bundle":   BundleDir(item.Order),
+		"product":  item.Product.HumanName,
+		"platform": item.Platform(),
+		"type":     strings.ToLower(strings.TrimPrefix(item.Type.Name, ".")),
+		"title":    item.Product.HumanName,
+		"author":   "Unknown",
+		"isbn":     "",
+	}
+	if meta != nil {
+		if meta.Title != "" {
+			vars["title"] = meta.Title
+		}
+		if len(meta.Creators) != 0 {
+			vars["author"] = strings.Join(meta.Creators, " & ")
+		}
+		vars["isbn"] = meta.ISBN()
+	}
+	dir := extract.Dir(d.extractDir, path, vars)
+	result, err := extract.Extract(path, dir)
+	if result == nil {
+		result = &extract.Result{Archive: path, Format: format, Dir: dir}
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if d.extractDelete {
+		if err := os.Remove(path); err != nil {
+			result.Error = errors.Wrap(err, "removing archive").Error()
+		} else {
+			result.Deleted = true
+		}
+	}
+	return result
+}
+
+// local is the storage when it's a local directory, nil otherwise
+func (d *Downloader) local() *storage.Local {
+	local, _ := d.storage.(*storage.Local)
+	return local
+}
+
+// name is the storage name of an item
+func (d *Downloader) name(item *Item) string {
+	return filepath.ToSlash(item.Filename)
+}
+
+// path is the file path of an item for events and hooks, the s3:// URL of
+// objects
+func (d *Downloader) path(item *Item) string {
+	if local := d.local(); local != nil {
+		return local.Path(d.name(item))
+	}
+	if s, ok := d.storage.(fmt.Stringer); ok {
+		return s.String() + "/" + d.name(item)
+	}
+	return d.name(item)
+}
+
+// Existing reports whether the storage already has the item, its size must
+// match the API one and so must the checksums kept in the file metadata, local
+// files have none and are hashed instead
+func (d *Downloader) Existing(ctx context.Context, item *Item) bool {
+	fi, err := d.storage.Stat(ctx, d.name(item))
+	if err != nil || (item.Size() > 0 && fi.Size != item.Size()) {
+		return false
+	}
+	for key, expected := range map[string]string{storage.MetaSHA1: item.Type.SHA1, storage.MetaMD5: item.Type.MD5} {
+		if got, ok := fi.Metadata[key]; ok && expected != "" && got != expected {
+			return false
+		}
+	}
+	local := d.local()
+	if local == nil || len(fi.Metadata) != 0 {
+		return true
+	}
+	f, err := os.Open(local.Path(d.name(item)))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return verifyChecksums(f, item) == nil
+}
+
+func (d *Downloader) emit(e Event) {
+	if d.onEvent != nil {
+		d.onEvent(e)
+	}
+}
+
+// fetcherFor picks the download backend for an item, items without a torrent always use http
+func (d *Downloader) fetcherFor(item *Item) Fetcher {
+	if d.via == ViaTorrent && item.Type.URL.BitTorrent != "" && d.torrentFetcher != nil {
+		return d.torrentFetcher
+	}
+	return d.httpFetcher
+}
+
+// downloadItem downloads and verifies a single item and returns the path of the written file,
+// fallbackTime is used as the file mtime when the backend doesn't know when it was last modified
+func (d *Downloader) downloadItem(ctx context.Context, item *Item, fallbackTime time.Time) (string, error) {
+	filePath := d.path(item)
+	if linked, err := d.linkStored(item, filePath); linked || err != nil {
+		return filePath, err
+	}
+
+	// the storage only replaces a previously good file once committed, so a
+	// failed or interrupted download never leaves a partial one behind
+	metadata := map[string]string{}
+	if item.Type.MD5 != "" {
+		metadata[storage.MetaMD5] = item.Type.MD5
+	}
+	if item.Type.SHA1 != "" {
+		metadata[storage.MetaSHA1] = item.Type.SHA1
+	}
+	f, err := d.storage.Create(ctx, d.name(item), storage.CreateOptions{Size: item.Size(), Metadata: metadata})
+	if err != nil {
+		return "", err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = f.Abort()
+		}
+	}()
+
+	var sink Sink = f
+	// files that can't be read back, eg; S3 uploads, are hashed as they're written
+	readBack, seekable := f.(io.ReadSeeker)
+	sums := newChecksums()
+	if !seekable {
+		sink = &hashingSink{Sink: sink, hash: sums}
+	}
+	if d.limiter != nil || d.fileRate > 0 {
+		var fileLimiter *ratelimit.Limiter
+		if d.fileRate > 0 {
+			fileLimiter = ratelimit.NewLimiter(d.fileRate)
+		}
+		sink = ratelimit.NewWriter(ctx, sink, d.limiter, fileLimiter)
+	}
+	if d.onEvent != nil {
+		sink = &progressWriter{Sink: sink, item: item, emit: d.emit}
+	}
+
+	lastModified, err := d.fetcherFor(item).Fetch(ctx, item.Type, sink)
+	if err != nil {
+		return "", err
+	}
+	if lastModified.IsZero() {
+		lastModified = fallbackTime
+	}
+	if seekable {
+		err = verifyChecksums(readBack, item)
+	} else {
+		err = sums.verify(item)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := f.Commit(lastModified); err != nil {
+		return "", err
+	}
+	committed = true
+	if d.store != nil && d.local() != nil {
+		if _, _, err := d.store.Add(filePath); err != nil {
+			return "", err
+		}
+	}
+	return filePath, nil
+}
+
+// linkStored links the store object matching the item checksum, items
+// without a checksum or with a different size or content are fetched
+func (d *Downloader) linkStored(item *Item, filePath string) (bool, error) {
+	object := d.storedObject(item)
+	if object == "" || !d.store.Verify(object) {
+		// a modified object is replaced by the fetched file
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0777); err != nil {
+		return false, errors.Wrap(err, "os.MkdirAll")
+	}
+	if err := d.store.Link(object, filePath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// storedObject is the store object linked instead of fetching the item,
+// empty when there's none
+func (d *Downloader) storedObject(item *Item) string {
+	if d.store == nil || d.local() == nil {
+		return ""
+	}
+	object := d.store.Lookup(item.ChecksumAlgorithm(), item.Checksum())
+	if object == "" {
+		return ""
+	}
+	if fi, err := os.Stat(object); err != nil || (item.Size() > 0 && fi.Size() != item.Size()) {
+		return ""
+	}
+	return object
+}
+
+// ChecksumError is returned when a downloaded file doesn't match the checksum reported by the API
+type ChecksumError struct {
+	Filename  string
+	Algorithm string
+	Expected  string
+	Got       string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum failed for %s -- expected %s but got %s", e.Algorithm, e.Filename, e.Expected, e.Got)
+}
+
+// verifyChecksums hashes the downloaded content and compares it to the item checksums
+func verifyChecksums(r io.ReadSeeker, item *Item) error {
+	asset := item.Type
+	if asset.SHA1 == "" && asset.MD5 == "" {
+		return nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "f.Seek")
+	}
+	sums := newChecksums()
+	if _, err := io.Copy(sums, r); err != nil {
+		return errors.Wrapf(err, "reading %s", item.Filename)
+	}
+	return sums.verify(item)
+}
+
+// checksums hashes content with every algorithm the API reports
+type checksums struct {
+	io.Writer
+	md5  hash.Hash
+	sha1 hash.Hash
+}
+
+func newChecksums() *checksums {
+	c := checksums{md5: md5.New(), sha1: sha1.New()}
+	c.Writer = io.MultiWriter(c.md5, c.sha1)
+	return &c
+}
+
+// verify compares the hashed content to the item checksums
+func (c *checksums) verify(item *Item) error {
+	asset := item.Type
+	if asset.SHA1 != "" {
+		sha1Checksum := fmt.Sprintf("%x", c.sha1.Sum(nil))
+		if asset.SHA1 != sha1Checksum {
+			return &ChecksumError{Filename: item.Filename, Algorithm: "SHA1", Expected: asset.SHA1, Got: sha1Checksum}
+		}
+	}
+	if asset.MD5 != "" {
+		md5Checksum := fmt.Sprintf("%x", c.md5.Sum(nil))
+		if asset.MD5 != md5Checksum {
+			return &ChecksumError{Filename: item.Filename, Algorithm: "MD5", Expected: asset.MD5, Got: md5Checksum}
+		}
+	}
+	return nil
+}
+
+// hashingSink hashes what's written to a sequential sink
+type hashingSink struct {
+	Sink
+	hash io.Writer
+}
+
+func (s *hashingSink) Write(p []byte) (int, error) {
+	n, err := s.Sink.Write(p)
+	_, _ = s.hash.Write(p[:n])
+	return n, err
+}
+
+// WriteAt hashes the content in order, sinks that can't be read back only accept sequential writes
+func (s *hashingSink) WriteAt(p []byte, off int64) (int, error) {
+	n, err := s.Sink.WriteAt(p, off)
+	_, _ = s.hash.Write(p[:n])
+	return n, err
+}
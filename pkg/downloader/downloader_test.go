@@ -0,0 +1,658 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"diogogmt.com/hbd/pkg/dedupe"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/storage"
+	"diogogmt.com/hbd/pkg/storage/s3test"
+	"github.com/pkg/errors"
+)
+
+var testOrder = hbclient.Order{
+	UID:     "XTWV64DX7R8TQ",
+	Created: hbclient.NewTime(time.Date(2019, 6, 4, 17, 43, 12, 0, time.UTC)),
+	Product: &hbclient.Product{HumanName: "Humble Book Bundle: Cybersecurity presented by Wiley"},
+	Products: []*hbclient.Product{
+		&hbclient.Product{
+			HumanName: "Security/Social Engineering: The Art of Human Hacking",
+			Downloads: []*hbclient.Download{
+				&hbclient.Download{
+					Platform: "ebook",
+					Types: []*hbclient.DownloadType{
+						&hbclient.DownloadType{Name: "PDF", MD5: fmt.Sprintf("%x", md5.Sum([]byte("pdf content")))},
+						&hbclient.DownloadType{Name: "EPUB"},
+					},
+				},
+			},
+		},
+		&hbclient.Product{
+			HumanName: "Practical Malware Analysis",
+			Downloads: []*hbclient.Download{
+				&hbclient.Download{
+					Platform: "ebook",
+					Types: []*hbclient.DownloadType{
+						&hbclient.DownloadType{Name: "MOBI"},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestNewPlan(t *testing.T) {
+	dd := []struct {
+		types     []string
+		filenames []string
+	}{
+		{
+			types: []string{"all"},
+			filenames: []string{
+				"Security_Social Engineering: The Art of Human Hacking.pdf",
+				"Security_Social Engineering: The Art of Human Hacking.epub",
+				"Practical Malware Analysis.mobi",
+			},
+		},
+		{
+			types:     []string{"PDF", "mobi"},
+			filenames: []string{"Security_Social Engineering: The Art of Human Hacking.pdf", "Practical Malware Analysis.mobi"},
+		},
+		{
+			types: []string{"cbz"},
+		},
+	}
+	for _, d := range dd {
+		plan := NewPlan(&testOrder, hbclient.ByType(d.types...))
+		filenames := []string{}
+		for _, item := range plan.Items {
+			filenames = append(filenames, item.Filename)
+		}
+		if strings.Join(filenames, ",") != strings.Join(d.filenames, ",") {
+			t.Errorf("%v: expected %v but got %v", d.types, d.filenames, filenames)
+		}
+	}
+}
+
+func TestDownloadEvents(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pdf content"))
+	})
+
+	order := testOrder
+	plan := NewPlan(&order, hbclient.ByType("pdf", "epub"))
+	for _, item := range plan.Items {
+		// copy the types so the shared fixture keeps its URLs
+		dt := *item.Type
+		dt.URL.Web = fmt.Sprintf("%s/%s", srv.URL, strings.ToLower(dt.Name))
+		item.Type = &dt
+	}
+
+	tempDir, err := ioutil.TempDir("", "hbd.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		mu     sync.Mutex
+		events = map[EventType][]string{}
+		bytes  int64
+	)
+	d := New(tempDir, WithEventHandler(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == EventProgress {
+			bytes = e.Written
+			return
+		}
+		events[e.Type] = append(events[e.Type], e.Item.Type.Name)
+	}))
+	result, err := d.Download(context.Background(), plan)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected epub download to fail with a 404 but got %v", err)
+	}
+
+	for _, names := range events {
+		sort.Strings(names)
+	}
+	if strings.Join(events[EventStarted], ",") != "EPUB,PDF" {
+		t.Errorf("expected started events for all items but got %v", events[EventStarted])
+	}
+	if strings.Join(events[EventDone], ",") != "PDF" || strings.Join(events[EventFailed], ",") != "EPUB" {
+		t.Errorf("expected pdf to succeed and epub to fail but got %v", events)
+	}
+	if bytes != int64(len("pdf content")) {
+		t.Errorf("expected progress to report %d bytes but got %d", len("pdf content"), bytes)
+	}
+	if len(result.Finished) != 1 || len(result.Failed) != 1 {
+		t.Errorf("expected one finished and one failed item but got %+v", result)
+	}
+}
+
+func TestDownloadLastModified(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	lastModified := time.Date(2020, 4, 17, 16, 20, 45, 0, time.UTC)
+	created := time.Date(2019, 6, 4, 17, 43, 12, 0, time.UTC)
+
+	dd := []struct {
+		name         string
+		lastModified string
+		status       int
+		expectStatus int
+		expectMtime  time.Time
+	}{
+		{
+			name:         "valid-header",
+			lastModified: lastModified.Format(http.TimeFormat),
+			status:       http.StatusOK,
+			expectMtime:  lastModified,
+		},
+		{
+			name:        "missing-header",
+			status:      http.StatusOK,
+			expectMtime: created,
+		},
+		{
+			name:         "malformed-header",
+			lastModified: "yesterday-ish",
+			status:       http.StatusOK,
+			expectMtime:  created,
+		},
+		{
+			name:         "not-found-with-header",
+			lastModified: lastModified.Format(http.TimeFormat),
+			status:       http.StatusNotFound,
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "not-found-without-header",
+			status:       http.StatusNotFound,
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "server-error-malformed-header",
+			lastModified: "yesterday-ish",
+			status:       http.StatusInternalServerError,
+			expectStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, d := range dd {
+		d := d
+		mux.HandleFunc(fmt.Sprintf("/lastmod/%s", d.name), func(w http.ResponseWriter, r *http.Request) {
+			if d.lastModified != "" {
+				w.Header().Add("Last-Modified", d.lastModified)
+			}
+			w.WriteHeader(d.status)
+			w.Write([]byte(d.name))
+		})
+	}
+
+	for _, d := range dd {
+		tempDir, err := ioutil.TempDir("/tmp", "hbd.")
+		if err != nil {
+			t.Fatalf("ioutil.TempDir: %s", err)
+		}
+		item := &Item{
+			Asset: hbclient.Asset{
+				Product: &hbclient.Product{HumanName: d.name},
+				Type:    &hbclient.DownloadType{Name: "PDF", URL: hbclient.DownloadTypeURL{Web: fmt.Sprintf("%s/lastmod/%s", srv.URL, d.name)}},
+			},
+			Filename: d.name + ".pdf",
+		}
+		path, err := New(tempDir).downloadItem(context.Background(), item, created)
+		if d.expectStatus != 0 {
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) {
+				t.Errorf("%s: expected HTTPError but got %v", d.name, err)
+			} else if httpErr.StatusCode != d.expectStatus {
+				t.Errorf("%s: expected status %d but got %d", d.name, d.expectStatus, httpErr.StatusCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: downloadItem: %v", d.name, err)
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("%s: os.Stat: %v", d.name, err)
+			continue
+		}
+		if !fi.ModTime().Equal(d.expectMtime) {
+			t.Errorf("%s: expected mtime %s but got %s", d.name, d.expectMtime, fi.ModTime())
+		}
+	}
+}
+
+func TestParseHook(t *testing.T) {
+	order := hbclient.Order{
+		Products: []*hbclient.Product{
+			{HumanName: "Book", Downloads: []*hbclient.Download{
+				{Platform: "ebook", Types: []*hbclient.DownloadType{{Name: "PDF"}, {Name: "Supplement", URL: hbclient.DownloadTypeURL{Web: "https://dl.example.com/book.zip?t=1"}}}},
+			}},
+			{HumanName: "Game", Downloads: []*hbclient.Download{
+				{Platform: "windows", Types: []*hbclient.DownloadType{{Name: "Installer"}}},
+				{Platform: "mac", Types: []*hbclient.DownloadType{{Name: "Installer"}}},
+			}},
+		},
+	}
+	dd := []struct {
+		spec      string
+		matches   []string
+		expectErr bool
+	}{
+		{spec: "all:true", matches: []string{"ebook PDF", "ebook Supplement", "windows Installer", "mac Installer"}},
+		{spec: "ext=zip:unzip -o \"$HBD_PATH\"", matches: []string{"ebook Supplement"}},
+		{spec: "platform=windows,platform=mac:clamscan \"$HBD_PATH\"", matches: []string{"windows Installer", "mac Installer"}},
+		{spec: "type=installer,platform=mac:echo", matches: []string{"mac Installer"}},
+		{spec: "type=pdf", expectErr: true},
+		{spec: "type=pdf: ", expectErr: true},
+		{spec: "size=10:echo", expectErr: true},
+		{spec: "pdf:echo", expectErr: true},
+	}
+	for _, d := range dd {
+		hook, err := ParseHook(d.spec)
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", d.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseHook: %v", d.spec, err)
+			continue
+		}
+		matches := []string{}
+		for _, a := range order.Assets().Filter(hook.Filter) {
+			matches = append(matches, a.Platform()+" "+a.Type.Name)
+		}
+		if strings.Join(matches, ",") != strings.Join(d.matches, ",") {
+			t.Errorf("%s: expected %v but got %v", d.spec, d.matches, matches)
+		}
+	}
+}
+
+func TestDownloadHooks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	order := hbclient.Order{GameKey: "order1", Product: &hbclient.Product{HumanName: "Bundle"}}
+	for _, name := range []string{"One", "Two", "Three"} {
+		order.Products = append(order.Products, &hbclient.Product{HumanName: name, Downloads: []*hbclient.Download{
+			{Platform: "ebook", Types: []*hbclient.DownloadType{{Name: "PDF", URL: hbclient.DownloadTypeURL{Web: srv.URL + "/" + name}}}},
+			{Platform: "audio", Types: []*hbclient.DownloadType{{Name: "MP3", URL: hbclient.DownloadTypeURL{Web: srv.URL + "/" + name + ".zip"}}}},
+		}})
+	}
+	plan := NewPlan(&order)
+
+	tempDir, err := ioutil.TempDir("", "hbd.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	log := tempDir + "/hooks.log"
+
+	var hooks Hooks
+	for _, spec := range []string{
+		// the payload reaches the hook as env vars and JSON on stdin
+		`type=pdf:echo "$HBD_FILENAME $HBD_PLATFORM $HBD_SIZE $(cat)" >> ` + log,
+		// at most 2 hooks run at once
+		`platform=audio:mkdir ` + tempDir + `/running.$HBD_PRODUCT && test $(ls -d ` + tempDir + `/running.* | wc -l) -le 2; s=$?; sleep 0.05; rmdir ` + tempDir + `/running.$HBD_PRODUCT; exit $s`,
+		`platform=audio,type=mp3:echo broken; exit 4`,
+		`type=pdf,platform=ebook:sleep 5`,
+	} {
+		if err := hooks.Set(spec); err != nil {
+			t.Fatalf("hooks.Set %s: %v", spec, err)
+		}
+	}
+
+	var (
+		mu         sync.Mutex
+		hookFailed []string
+		doneBefore = map[string]bool{}
+		outOfOrder bool
+	)
+	d := New(tempDir, WithHooks(hooks, 2, 200*time.Millisecond), WithEventHandler(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch e.Type {
+		case EventDone:
+			doneBefore[e.Item.Filename] = true
+		case EventHookFailed:
+			outOfOrder = outOfOrder || !doneBefore[e.Item.Filename]
+			hookFailed = append(hookFailed, e.Item.Filename)
+		}
+	}))
+	result, err := d.Download(context.Background(), plan)
+	if err != nil {
+		t.Fatalf("expected failed hooks to leave the download successful but got %v", err)
+	}
+	if len(result.Finished) != 6 || len(result.Failed) != 0 {
+		t.Errorf("expected all items to finish but got %+v", result)
+	}
+	if outOfOrder {
+		t.Errorf("expected hook failures after the done event")
+	}
+
+	// every mp3 fails the echo broken hook and every pdf times out
+	failures := []string{}
+	for _, hookErr := range result.HookErrors {
+		failures = append(failures, hookErr.Item.Filename+" "+hookErr.Err.Error()+" "+hookErr.Output)
+	}
+	sort.Strings(failures)
+	expected := []string{
+		"One.mp3 exit status 4 broken",
+		"One.pdf timed out after 200ms ",
+		"Three.mp3 exit status 4 broken",
+		"Three.pdf timed out after 200ms ",
+		"Two.mp3 exit status 4 broken",
+		"Two.pdf timed out after 200ms ",
+	}
+	if strings.Join(failures, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected hook failures\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(failures, "\n"))
+	}
+	if len(hookFailed) != 6 {
+		t.Errorf("expected 6 hook failed events but got %v", hookFailed)
+	}
+
+	by, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(by)), "\n")
+	sort.Strings(lines)
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], `One.pdf ebook 0 {"path":"`+tempDir+`/One.pdf","order":"order1","bundle":"Bundle","product":"One","id":"one/ebook/pdf"`) {
+		t.Errorf("unexpected hook log\n%s", by)
+	}
+}
+
+func TestDownloadExtract(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("mp3/01 - Theme.mp3")
+	w.Write([]byte("theme"))
+	zw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/soundtrack" {
+			w.Write(archive.Bytes())
+			return
+		}
+		w.Write([]byte("not an archive"))
+	}))
+	defer srv.Close()
+
+	order := hbclient.Order{GameKey: "order1", Products: []*hbclient.Product{
+		{HumanName: "Game", Downloads: []*hbclient.Download{
+			{Platform: "audio", Types: []*hbclient.DownloadType{{Name: "MP3", URL: hbclient.DownloadTypeURL{Web: srv.URL + "/soundtrack"}}}},
+			{Platform: "linux", Types: []*hbclient.DownloadType{{Name: "Binary", URL: hbclient.DownloadTypeURL{Web: srv.URL + "/binary"}}}},
+		}},
+	}}
+	tempDir, err := ioutil.TempDir("", "hbd.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var hooks Hooks
+	hooks.Set("all:echo \"$HBD_FILENAME=$HBD_EXTRACT_DIR\" >> " + tempDir + "/hooks.log")
+	var extracted []string
+	d := New(tempDir, WithExtract("{title} {platform}{isbn}", true), WithHooks(hooks, 1, time.Minute), WithEventHandler(func(e Event) {
+		if e.Type == EventExtracted {
+			extracted = append(extracted, e.Item.Filename)
+		}
+	}))
+	result, err := d.Download(context.Background(), NewPlan(&order))
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if strings.Join(extracted, ",") != "Game.mp3" || len(result.Extracted) != 1 {
+		t.Fatalf("expected only the soundtrack to be extracted but got %v", extracted)
+	}
+	x := result.Extracted[0]
+	if x.Error != "" || x.Files != 1 || !x.Deleted || x.Dir != filepath.Join(tempDir, "Game audio") {
+		t.Errorf("unexpected extraction %+v", x)
+	}
+	if by, err := ioutil.ReadFile(filepath.Join(tempDir, "Game audio", "mp3", "01 - Theme.mp3")); err != nil || string(by) != "theme" {
+		t.Errorf("expected the soundtrack to be unpacked but got %q %v", by, err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "Game.mp3")); !os.IsNotExist(err) {
+		t.Errorf("expected the archive to be removed but got %v", err)
+	}
+	by, _ := ioutil.ReadFile(filepath.Join(tempDir, "hooks.log"))
+	lines := strings.Split(strings.TrimSpace(string(by)), "\n")
+	sort.Strings(lines)
+	if strings.Join(lines, "\n") != "Game.binary=\nGame.mp3="+filepath.Join(tempDir, "Game audio") {
+		t.Errorf("expected hooks to get the extraction directory but got\n%s", by)
+	}
+}
+
+func TestDownloadStore(t *testing.T) {
+	fetches := 0
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/pdf", func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte("pdf content"))
+	})
+
+	order := testOrder
+	plan := NewPlan(&order, hbclient.ByType("pdf"))
+	dt := *plan.Items[0].Type
+	dt.URL.Web = srv.URL + "/pdf"
+	plan.Items[0].Type = &dt
+
+	tempDir, err := ioutil.TempDir("", "hbd.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	store, err := dedupe.Open(filepath.Join(tempDir, dedupe.DefaultDir), dedupe.LinkHard)
+	if err != nil {
+		t.Fatalf("dedupe.Open: %v", err)
+	}
+
+	// the second bundle links the file stored by the first
+	for _, dir := range []string{"first", "second"} {
+		result, err := New(filepath.Join(tempDir, dir), WithStore(store)).Download(context.Background(), plan)
+		if err != nil || len(result.Finished) != 1 {
+			t.Fatalf("%s: expected the pdf to be downloaded but got %+v %v", dir, result, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected a single fetch but got %d", fetches)
+	}
+	first, err1 := os.Stat(filepath.Join(tempDir, "first", plan.Items[0].Filename))
+	second, err2 := os.Stat(filepath.Join(tempDir, "second", plan.Items[0].Filename))
+	if err1 != nil || err2 != nil || !os.SameFile(first, second) {
+		t.Errorf("expected both bundles to link the same file but got %v %v", err1, err2)
+	}
+	if store.Lookup(dedupe.MD5, dt.MD5) == "" {
+		t.Errorf("expected the pdf to be stored under its md5")
+	}
+
+	// editing a hardlinked file in place modifies the object, it's fetched again
+	if err := ioutil.WriteFile(filepath.Join(tempDir, "second", plan.Items[0].Filename), []byte("pdf CONTENT"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+	if _, err := New(filepath.Join(tempDir, "third"), WithStore(store)).Download(context.Background(), plan); err != nil {
+		t.Fatalf("third: Download: %v", err)
+	}
+	if by, err := ioutil.ReadFile(filepath.Join(tempDir, "third", plan.Items[0].Filename)); err != nil || string(by) != "pdf content" || fetches != 2 {
+		t.Errorf("expected the pdf to be fetched again but got %q %v after %d fetches", by, err, fetches)
+	}
+	if !store.Verify(store.Lookup(dedupe.MD5, dt.MD5)) {
+		t.Errorf("expected the fetched pdf to replace the modified object")
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pdf content"))
+	})
+	tempDir, err := ioutil.TempDir("", "hbd.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	order := testOrder
+	plan := NewPlan(&order, hbclient.ByType("pdf", "mobi"))
+	for _, item := range plan.Items {
+		dt := *item.Type
+		dt.URL.Web = srv.URL + "/pdf"
+		dt.FileSize = 1 << 20
+		item.Type = &dt
+	}
+	d := New(filepath.Join(tempDir, "missing", "dest"))
+	if needed := d.Needed(plan); needed != 2<<20 {
+		t.Errorf("expected both items to be needed but got %d", needed)
+	}
+	if err := d.CheckSpace(plan); err != nil {
+		t.Errorf("expected a couple of MiB to fit but got %v", err)
+	}
+
+	// the reported size is only preallocated, the file keeps the size of its content
+	pdf := &Plan{Order: plan.Order, Items: plan.Items[:1]}
+	if _, err := New(tempDir).Download(context.Background(), pdf); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(tempDir, pdf.Items[0].Filename)); err != nil || fi.Size() != int64(len("pdf content")) {
+		t.Errorf("expected the pdf to keep the size of its content but got %v %v", fi, err)
+	}
+	// a file of the reported size is fetched again next to the previous one
+	// unless it's skipped
+	if err := ioutil.WriteFile(filepath.Join(tempDir, pdf.Items[0].Filename), make([]byte, 1<<20), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+	if needed := New(tempDir).Needed(plan); needed != 2<<20 {
+		t.Errorf("expected both items to be needed without -skip-existing but got %d", needed)
+	}
+	// the estimate only compares sizes, the download hashes the file
+	if needed := New(tempDir, WithSkipExisting(true)).Needed(plan); needed != 1<<20 {
+		t.Errorf("expected only the mobi to be needed but got %d", needed)
+	}
+	plan.Items[0].Type.FileSize = 2 << 20
+	if needed := New(tempDir, WithSkipExisting(true)).Needed(plan); needed != 3<<20 {
+		t.Errorf("expected a file of another size to be needed but got %d", needed)
+	}
+	plan.Items[0].Type.FileSize = 1 << 20
+
+	plan.Items[1].Type.FileSize = 1 << 60
+	var spaceErr *SpaceError
+	if err := New(tempDir, WithSkipExisting(true)).CheckSpace(plan); !errors.As(err, &spaceErr) || spaceErr.Needed != 1<<60 || spaceErr.Free <= 0 {
+		t.Errorf("expected a space error but got %v", err)
+	}
+}
+
+func TestDownloadStorage(t *testing.T) {
+	fetches := 0
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/pdf", func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte("pdf content"))
+	})
+	mux.HandleFunc("/corrupt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("corrupt content"))
+	})
+	s3 := s3test.NewServer("bucket")
+	defer s3.Close()
+	s, err := storage.NewS3("bucket", "books", storage.WithS3Endpoint(s3.URL), storage.WithS3Credentials(storage.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}))
+	if err != nil {
+		t.Fatalf("storage.NewS3: %v", err)
+	}
+
+	order := testOrder
+	plan := NewPlan(&order, hbclient.ByType("pdf"))
+	dt := *plan.Items[0].Type
+	dt.URL.Web = srv.URL + "/pdf"
+	plan.Items[0].Type = &dt
+	key := "books/" + plan.Items[0].Filename
+
+	var paths []string
+	onEvent := func(e Event) {
+		if e.Type == EventDone || e.Type == EventSkipped {
+			paths = append(paths, e.Type.String()+" "+e.Path)
+		}
+	}
+	if _, err := New("", WithStorage(s), WithEventHandler(onEvent)).Download(context.Background(), plan); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	object := s3.Object("bucket", key)
+	if object == nil || string(object.Data) != "pdf content" || object.Metadata[storage.MetaMD5] != dt.MD5 {
+		t.Fatalf("expected the pdf to be uploaded with its md5 but got %+v", object)
+	}
+
+	// the object metadata matches, nothing is fetched again
+	result, err := New("", WithStorage(s), WithSkipExisting(true), WithEventHandler(onEvent)).Download(context.Background(), plan)
+	if err != nil || len(result.Skipped) != 1 || len(result.Finished) != 0 || fetches != 1 {
+		t.Errorf("expected the pdf to be skipped but got %+v %v after %d fetches", result, err, fetches)
+	}
+	expected := []string{"done s3://bucket/" + key, "skipped s3://bucket/" + key}
+	if strings.Join(paths, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected events\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(paths, "\n"))
+	}
+
+	// a checksum mismatch never replaces the object
+	dt.URL.Web = srv.URL + "/corrupt"
+	if _, err := New("", WithStorage(s)).Download(context.Background(), plan); err == nil || !strings.Contains(err.Error(), "checksum failed") {
+		t.Errorf("expected a checksum error but got %v", err)
+	}
+	if object := s3.Object("bucket", key); object == nil || string(object.Data) != "pdf content" {
+		t.Errorf("expected the previous object to be kept but got %+v", object)
+	}
+	if s3.Uploads() != 0 {
+		t.Errorf("expected no upload left over")
+	}
+
+	// local files have no metadata, they're hashed
+	tempDir, err := ioutil.TempDir("", "hbd.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	d := New(tempDir, WithSkipExisting(true))
+	for _, d := range []struct {
+		content string
+		expect  bool
+	}{{content: "pdf content", expect: true}, {content: "pdf CONTENT", expect: false}} {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, plan.Items[0].Filename), []byte(d.content), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+		if got := New(tempDir).Existing(context.Background(), plan.Items[0]); got != d.expect {
+			t.Errorf("%s: expected existing %v but got %v", d.content, d.expect, got)
+		}
+	}
+	dt.URL.Web = srv.URL + "/pdf"
+	if result, err := d.Download(context.Background(), plan); err != nil || len(result.Finished) != 1 {
+		t.Errorf("expected the corrupt file to be downloaded again but got %+v %v", result, err)
+	}
+}
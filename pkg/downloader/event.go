@@ -0,0 +1,92 @@
+package downloader
+
+import (
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/extract"
+)
+
+// EventType identifies the stage of an item download
+type EventType int
+
+// download events in the order they are emitted for an item
+const (
+	EventStarted EventType = iota
+	EventProgress
+	EventDone
+	EventFailed
+	// EventExtracted follows EventDone when the downloaded file is an archive
+	// and extraction is enabled, the extraction failed when Err is set
+	EventExtracted
+	// EventHookFailed follows EventDone when a hook fails on the downloaded file
+	EventHookFailed
+	// EventSkipped replaces EventStarted for the items already in the storage
+	EventSkipped
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStarted:
+		return "started"
+	case EventProgress:
+		return "progress"
+	case EventDone:
+		return "done"
+	case EventFailed:
+		return "failed"
+	case EventExtracted:
+		return "extracted"
+	case EventHookFailed:
+		return "hook failed"
+	case EventSkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports the progress of an item download
+type Event struct {
+	Type EventType
+	Item *Item
+	// Written is the number of bytes written so far
+	Written int64
+	// Path is the final file path, set on EventDone and EventSkipped
+	Path string
+	// Err is set on EventFailed and to a *HookError on EventHookFailed
+	Err error
+	// Extracted is set on EventExtracted
+	Extracted *extract.Result
+	// Meta is set on EventDone for the EPUBs and PDFs of a local storage
+	Meta *ebookmeta.Metadata
+}
+
+// EventHandler receives download events, it's called concurrently from all the download workers
+type EventHandler func(e Event)
+
+// progressWriter emits progress events for every write to the sink
+type progressWriter struct {
+	Sink
+	item    *Item
+	emit    func(Event)
+	written int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Sink.Write(p)
+	w.report(n)
+	return n, err
+}
+
+func (w *progressWriter) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.Sink.WriteAt(p, off)
+	w.report(n)
+	return n, err
+}
+
+func (w *progressWriter) report(n int) {
+	if n <= 0 {
+		return
+	}
+	w.written += int64(n)
+	w.emit(Event{Type: EventProgress, Item: w.item, Written: w.written})
+}
@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/torrent"
+	"github.com/pkg/errors"
+)
+
+// download backends selectable with the -via flag
+const (
+	ViaHTTP    = "http"
+	ViaTorrent = "torrent"
+)
+
+// HTTPError is returned when an asset download responds with a non 2xx status code
+type HTTPError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("GET %s: unexpected response status %s", e.URL, e.Status)
+}
+
+// Sink is the destination fetchers write the asset content to, sequential
+// backends use Write while piece based ones use WriteAt
+type Sink interface {
+	io.Writer
+	io.WriterAt
+}
+
+// Fetcher retrieves the content of an asset
+type Fetcher interface {
+	// Fetch writes the asset content to w and returns its last modified time, zero when unknown
+	Fetch(ctx context.Context, asset *hbclient.DownloadType, w Sink) (time.Time, error)
+}
+
+// HTTPFetcher downloads assets from their web URL
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+// Fetch implements Fetcher
+func (f *HTTPFetcher) Fetch(ctx context.Context, asset *hbclient.DownloadType, w Sink) (time.Time, error) {
+	resp, err := f.get(ctx, asset.URL.Web)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return time.Time{}, errors.Wrap(err, "writting book file")
+	}
+	// the CDN doesn't always send a usable Last-Modified header
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return lastModified, nil
+}
+
+func (f *HTTPFetcher) get(ctx context.Context, downloadURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "http.NewRequestWithContext %s", downloadURL)
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "http.Get %s", downloadURL)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &HTTPError{URL: downloadURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return resp, nil
+}
+
+// TorrentFetcher downloads assets through their .torrent file
+type TorrentFetcher struct {
+	// HTTP fetches the .torrent files
+	HTTP    HTTPFetcher
+	Torrent *torrent.Client
+}
+
+// NewTorrentFetcher creates a TorrentFetcher with a fresh torrent client
+func NewTorrentFetcher() *TorrentFetcher {
+	return &TorrentFetcher{
+		Torrent: torrent.NewClient(),
+	}
+}
+
+// Fetch implements Fetcher
+func (f *TorrentFetcher) Fetch(ctx context.Context, asset *hbclient.DownloadType, w Sink) (time.Time, error) {
+	resp, err := f.HTTP.get(ctx, asset.URL.BitTorrent)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	mi, err := torrent.ReadMetaInfo(resp.Body)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "torrent.ReadMetaInfo %s", asset.URL.BitTorrent)
+	}
+	if len(mi.Info.Files) != 0 {
+		return time.Time{}, errors.Errorf("torrent %s has multiple files", mi.Info.Name)
+	}
+	if err := f.Torrent.Download(ctx, mi, w); err != nil {
+		return time.Time{}, errors.Wrapf(err, "torrent download %s", mi.Info.Name)
+	}
+	return time.Time{}, nil
+}
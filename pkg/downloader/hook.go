@@ -0,0 +1,250 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/extract"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"github.com/pkg/errors"
+)
+
+// Hook is a shell command run on every downloaded file matching its filter
+type Hook struct {
+	// Selector is the filter as written in the spec, eg; type=zip,platform=audio
+	Selector string
+	Command  string
+	Filter   hbclient.AssetFilter
+}
+
+// ParseHook parses SELECTOR:COMMAND, the selector is all or comma separated
+// type=X, platform=X and ext=X terms, terms of the same kind match any of
+// their values and different kinds must all match, eg; platform=windows,platform=mac:clamscan "$HBD_PATH"
+func ParseHook(s string) (*Hook, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		return nil, errors.Errorf("invalid hook %q, expected SELECTOR:COMMAND, eg; type=zip:unzip \"$HBD_PATH\"", s)
+	}
+	hook := Hook{Selector: parts[0], Command: parts[1], Filter: hbclient.All}
+	if parts[0] == "all" {
+		return &hook, nil
+	}
+	values := map[string][]string{}
+	for _, term := range strings.Split(parts[0], ",") {
+		kv := strings.SplitN(strings.TrimSpace(term), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, errors.Errorf("invalid hook selector %q, expected all or type=X, platform=X and ext=X terms", term)
+		}
+		values[kv[0]] = append(values[kv[0]], kv[1])
+	}
+	filters := []hbclient.AssetFilter{}
+	for kind, vs := range values {
+		switch kind {
+		case "type":
+			filters = append(filters, hbclient.ByType(vs...))
+		case "platform":
+			filters = append(filters, hbclient.ByPlatform(vs...))
+		case "ext":
+			filters = append(filters, hbclient.ByExtension(vs...))
+		default:
+			return nil, errors.Errorf("unknown hook selector %q, expected type, platform or ext", kind)
+		}
+	}
+	hook.Filter = hbclient.And(filters...)
+	return &hook, nil
+}
+
+// Hooks is a repeatable flag of hook specs
+type Hooks []*Hook
+
+func (h *Hooks) String() string {
+	if h == nil {
+		return ""
+	}
+	specs := []string{}
+	for _, hook := range *h {
+		specs = append(specs, hook.Selector+":"+hook.Command)
+	}
+	return strings.Join(specs, "; ")
+}
+
+// Set parses and appends a hook spec
+func (h *Hooks) Set(v string) error {
+	hook, err := ParseHook(v)
+	if err != nil {
+		return err
+	}
+	*h = append(*h, hook)
+	return nil
+}
+
+// HookError is a hook failing on a downloaded file, the download itself succeeded
+type HookError struct {
+	Item    *Item
+	Command string
+	Output  string
+	Err     error
+}
+
+func (e *HookError) Error() string {
+	msg := "hook " + e.Command + " failed for " + e.Item.Filename + ": " + e.Err.Error()
+	if e.Output != "" {
+		msg += ": " + e.Output
+	}
+	return msg
+}
+
+// hookPayload describes the downloaded file to a hook, it's passed as JSON on
+// stdin and flattened into HBD_* env vars
+type hookPayload struct {
+	Path     string `json:"path"`
+	Order    string `json:"order"`
+	Bundle   string `json:"bundle"`
+	Product  string `json:"product"`
+	ID       string `json:"id"`
+	Platform string `json:"platform"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size"`
+	MD5      string `json:"md5,omitempty"`
+	SHA1     string `json:"sha1,omitempty"`
+	// ExtractDir is where the file was unpacked, empty when it wasn't
+	ExtractDir string `json:"extract_dir,omitempty"`
+	// Meta is what an EPUB or PDF says about itself
+	Meta *ebookmeta.Metadata `json:"meta,omitempty"`
+}
+
+func (p *hookPayload) env() []string {
+	env := []string{
+		"HBD_PATH=" + p.Path,
+		"HBD_DIR=" + filepath.Dir(p.Path),
+		"HBD_FILENAME=" + filepath.Base(p.Path),
+		"HBD_ORDER=" + p.Order,
+		"HBD_BUNDLE=" + p.Bundle,
+		"HBD_PRODUCT=" + p.Product,
+		"HBD_ID=" + p.ID,
+		"HBD_PLATFORM=" + p.Platform,
+		"HBD_TYPE=" + p.Type,
+		"HBD_SIZE=" + strconv.FormatInt(p.Size, 10),
+		"HBD_MD5=" + p.MD5,
+		"HBD_SHA1=" + p.SHA1,
+		"HBD_EXTRACT_DIR=" + p.ExtractDir,
+	}
+	if p.Meta != nil {
+		env = append(env,
+			"HBD_TITLE="+p.Meta.Title,
+			"HBD_AUTHORS="+strings.Join(p.Meta.Creators, " & "),
+			"HBD_ISBN="+p.Meta.ISBN(),
+		)
+	}
+	return env
+}
+
+// hookRunner runs the hooks matching a downloaded file, at most concurrency
+// commands run at once across all the files and each is killed after timeout
+type hookRunner struct {
+	hooks   Hooks
+	sem     chan struct{}
+	timeout time.Duration
+}
+
+func newHookRunner(hooks Hooks, concurrency int, timeout time.Duration) *hookRunner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &hookRunner{hooks: hooks, sem: make(chan struct{}, concurrency), timeout: timeout}
+}
+
+// run runs the matching hooks one after the other so a hook can rely on the
+// previous ones, eg; scan and then move the file, extracted is the extraction
+// of the file when it was an archive and meta what the file says about itself
+func (r *hookRunner) run(ctx context.Context, item *Item, path string, extracted *extract.Result, meta *ebookmeta.Metadata) []*HookError {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	payload := hookPayload{
+		Path:     abs,
+		Order:    item.Order.GameKey,
+		Bundle:   BundleDir(item.Order),
+		Product:  item.Product.HumanName,
+		ID:       item.ID(),
+		Platform: item.Platform(),
+		Type:     item.Type.Name,
+		Size:     item.Size(),
+		MD5:      item.Type.MD5,
+		SHA1:     item.Type.SHA1,
+		Meta:     meta,
+	}
+	if extracted != nil && extracted.Error == "" {
+		payload.ExtractDir, _ = filepath.Abs(extracted.Dir)
+	}
+	errs := []*HookError{}
+	for _, hook := range r.hooks {
+		if !hook.Filter(item.Asset) {
+			continue
+		}
+		select {
+		case r.sem <- struct{}{}:
+		case <-ctx.Done():
+			return append(errs, &HookError{Item: item, Command: hook.Command, Err: ctx.Err()})
+		}
+		output, err := r.exec(ctx, hook, &payload)
+		<-r.sem
+		if err != nil {
+			errs = append(errs, &HookError{Item: item, Command: hook.Command, Output: output, Err: err})
+		}
+	}
+	return errs
+}
+
+func (r *hookRunner) exec(ctx context.Context, hook *Hook, payload *hookPayload) (string, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	stdin, err := json.Marshal(payload)
+	if err != nil {
+		return "", errors.Wrap(err, "json.Marshal hook payload")
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", hook.Command)
+	}
+	setProcessGroup(cmd)
+	cmd.Dir = filepath.Dir(payload.Path)
+	cmd.Env = append(os.Environ(), payload.env()...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return "", errors.Wrap(err, "starting hook")
+	}
+	// the output pipes stay open until every process of the group exits
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.Errorf("timed out after %s", r.timeout)
+	}
+	return strings.TrimSpace(output.String()), err
+}
@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+)
+
+// Item is a single file to download
+type Item struct {
+	hbclient.Asset
+	// Filename is the name of the file relative to the destination directory
+	Filename string
+}
+
+// Name identifies the item in logs and errors
+func (i *Item) Name() string {
+	return fmt.Sprintf("%s.%s", i.Product.HumanName, i.Type.Name)
+}
+
+// Plan lists the items of an order selected for download
+type Plan struct {
+	Order *hbclient.Order
+	Items []*Item
+}
+
+// NewPlan selects the assets of an order matching all the filters
+func NewPlan(order *hbclient.Order, filters ...hbclient.AssetFilter) *Plan {
+	plan := Plan{Order: order}
+	for _, asset := range order.Assets().Filter(filters...) {
+		plan.Items = append(plan.Items, &Item{
+			Asset:    asset,
+			Filename: Filename(asset),
+		})
+	}
+	return &plan
+}
+
+// Filename is the default name of an asset file, eg; "Product Name.pdf"
+func Filename(asset hbclient.Asset) string {
+	filename := fmt.Sprintf("%s.%s", asset.Product.HumanName, strings.ToLower(strings.TrimPrefix(asset.Type.Name, ".")))
+	return strings.ReplaceAll(filename, "/", "_")
+}
+
+// BundleDir is the default directory name of an order, named after its bundle
+func BundleDir(order *hbclient.Order) string {
+	name := order.GameKey
+	if order.Product != nil && order.Product.HumanName != "" {
+		name = order.Product.HumanName
+	}
+	return strings.ReplaceAll(name, "/", "_")
+}
+
+// TotalSize sums the file sizes reported by the API for the plan items
+func (p *Plan) TotalSize() int64 {
+	var total int64
+	for _, item := range p.Items {
+		total += item.Size()
+	}
+	return total
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"diogogmt.com/hbd/pkg/units"
+	"github.com/pkg/errors"
+)
+
+var errFreeSpaceUnsupported = errors.New("free space unknown on this platform")
+
+// SpaceError is returned when a plan needs more space than the destination has free
+type SpaceError struct {
+	Dest   string
+	Needed int64
+	Free   int64
+}
+
+func (e *SpaceError) Error() string {
+	return fmt.Sprintf("not enough space in %s, the download needs %s but only %s is free", e.Dest, units.FormatSize(e.Needed), units.FormatSize(e.Free))
+}
+
+// Needed sums the sizes of the plan items still to fetch, the items found in
+// the store and, with WithSkipExisting, the files of the reported size already
+// in the storage don't count. Files aren't hashed for the estimate. A file
+// fetched again is written next to the previous one until it's verified, so
+// both take space at once.
+func (d *Downloader) Needed(plan *Plan) int64 {
+	var needed int64
+	for _, item := range plan.Items {
+		if d.skipExisting && d.present(item) {
+			continue
+		}
+		if d.storedObject(item) != "" {
+			continue
+		}
+		needed += item.Size()
+	}
+	return needed
+}
+
+// present reports whether the storage has a file of the item size
+func (d *Downloader) present(item *Item) bool {
+	fi, err := d.storage.Stat(context.Background(), d.name(item))
+	return err == nil && (item.Size() <= 0 || fi.Size == item.Size())
+}
+
+// CheckSpace fails with a SpaceError when the plan doesn't fit in the free
+// space of the destination filesystem, platforms without statfs and storages
+// other than local directories pass
+func (d *Downloader) CheckSpace(plan *Plan) error {
+	local := d.local()
+	if local == nil {
+		return nil
+	}
+	needed := d.Needed(plan)
+	if needed == 0 {
+		return nil
+	}
+	// the destination may not exist yet, its closest parent is on the same filesystem
+	dir := local.Root()
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	free, err := freeSpace(dir)
+	if err == errFreeSpaceUnsupported {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if needed > free {
+		return &SpaceError{Dest: local.Root(), Needed: needed, Free: free}
+	}
+	return nil
+}
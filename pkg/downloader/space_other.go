@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!dragonfly
+
+package downloader
+
+// freeSpace isn't known on this platform, the space check is skipped
+func freeSpace(dir string) (int64, error) {
+	return 0, errFreeSpaceUnsupported
+}
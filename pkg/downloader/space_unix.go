@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package downloader
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// freeSpace is the space available to unprivileged users on the filesystem of dir
+func freeSpace(dir string) (int64, error) {
+	st := syscall.Statfs_t{}
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, errors.Wrapf(err, "statfs %s", dir)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,325 @@
+package ebookmeta
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/ebookmeta/epubtest"
+)
+
+func TestReadEPUB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-ebookmeta.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dd := []struct {
+		name     string
+		opf      string
+		expected Metadata
+	}{
+		{
+			name: "epub2",
+			opf: `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Practical Malware
+      Analysis</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Sikorski, Michael">Michael Sikorski</dc:creator>
+    <dc:creator opf:role="aut">Andrew Honig</dc:creator>
+    <dc:creator opf:role="edt">Some Editor</dc:creator>
+    <dc:publisher>No Starch Press</dc:publisher>
+    <dc:language>en</dc:language>
+    <dc:identifier id="id" opf:scheme="ISBN">978-1-59327-290-6</dc:identifier>
+    <meta name="cover" content="cover-img"/>
+  </metadata>
+  <manifest>
+    <item id="cover-page" href="cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="cover-img" href="images/cover.jpg" media-type="image/jpeg"/>
+  </manifest>
+</package>`,
+			expected: Metadata{
+				Title:       "Practical Malware Analysis",
+				Creators:    []string{"Michael Sikorski", "Andrew Honig"},
+				Publisher:   "No Starch Press",
+				Language:    "en",
+				Identifiers: []Identifier{{Scheme: "isbn", Value: "978-1-59327-290-6"}},
+				Cover:       "OEBPS/images/cover.jpg",
+			},
+		},
+		{
+			name: "epub3",
+			opf: `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uid">urn:isbn:9781593275099</dc:identifier>
+    <dc:title>Black Hat Python</dc:title>
+    <dc:creator id="c1">Justin Seitz</dc:creator>
+    <meta refines="#c1" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="c2">Cover Artist</dc:creator>
+    <meta refines="#c2" property="role" scheme="marc:relators">cov</meta>
+  </metadata>
+  <manifest>
+    <item id="c" href="../cover%20art.png" media-type="image/png" properties="cover-image"/>
+  </manifest>
+</package>`,
+			expected: Metadata{
+				Title:       "Black Hat Python",
+				Creators:    []string{"Justin Seitz"},
+				Identifiers: []Identifier{{Scheme: "isbn", Value: "9781593275099"}},
+				Cover:       "cover art.png",
+			},
+		},
+	}
+	for _, d := range dd {
+		path := filepath.Join(dir, d.name+".epub")
+		if err := epubtest.Write(path, d.opf); err != nil {
+			t.Fatalf("epubtest.Write: %v", err)
+		}
+		meta, err := ReadEPUB(path)
+		if err != nil {
+			t.Errorf("%s: ReadEPUB: %v", d.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*meta, d.expected) {
+			t.Errorf("%s: expected %+v but got %+v", d.name, d.expected, *meta)
+		}
+	}
+
+	if _, err := ReadEPUB(filepath.Join(dir, "missing.epub")); err == nil {
+		t.Errorf("expected missing file to fail")
+	}
+
+	path := filepath.Join(dir, "cover.epub")
+	if err := epubtest.Write(path, dd[0].opf, "OEBPS/images/cover.jpg", "\xff\xd8\xffjpeg"); err != nil {
+		t.Fatalf("epubtest.Write: %v", err)
+	}
+	cover, mediaType, err := ReadEPUBCover(path)
+	if err != nil {
+		t.Fatalf("ReadEPUBCover: %v", err)
+	}
+	if string(cover) != "\xff\xd8\xffjpeg" || mediaType != "image/jpeg" {
+		t.Errorf("expected the jpeg cover but got %q %s", cover, mediaType)
+	}
+	if _, _, err := ReadEPUBCover(filepath.Join(dir, "epub2.epub")); err == nil {
+		t.Errorf("expected a missing cover file to fail")
+	}
+}
+
+func TestISBN(t *testing.T) {
+	dd := []struct {
+		identifiers []Identifier
+		expected    string
+	}{
+		{[]Identifier{{Scheme: "uuid", Value: "1234567890"}, {Scheme: "isbn", Value: "978-1-59327-290-6"}}, "9781593272906"},
+		{[]Identifier{{Value: "ISBN: 1-59327-144-1"}}, "1593271441"},
+		{[]Identifier{{Value: "0-8044-2957-X"}}, "080442957X"},
+		{[]Identifier{{Scheme: "isbn", Value: "not an isbn"}}, ""},
+		{nil, ""},
+	}
+	for _, d := range dd {
+		m := Metadata{Identifiers: d.identifiers}
+		if isbn := m.ISBN(); isbn != d.expected {
+			t.Errorf("%+v: expected %q but got %q", d.identifiers, d.expected, isbn)
+		}
+	}
+}
+
+// pdfWriter builds PDF files for the tests, tracking the object offsets
+type pdfWriter struct {
+	bytes.Buffer
+	offsets map[int]int
+}
+
+func (w *pdfWriter) object(num int, body string) {
+	w.offsets[num] = w.Len()
+	fmt.Fprintf(w, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+func (w *pdfWriter) stream(num int, dict string, data []byte) {
+	w.offsets[num] = w.Len()
+	fmt.Fprintf(w, "%d 0 obj\n<< %s /Length %d >>\nstream\r\n", num, dict, len(data))
+	w.Write(data)
+	fmt.Fprintf(w, "\nendstream\nendobj\n")
+}
+
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write(data)
+	zw.Close()
+	return b.Bytes()
+}
+
+// testPDFTable is a PDF with a cross reference table, updated once so the Info of the second trailer wins
+func testPDFTable() []byte {
+	w := pdfWriter{offsets: map[int]int{}}
+	w.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	w.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	w.object(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+	w.object(3, "<< /Title (Old Title) >>")
+	xref := w.Len()
+	fmt.Fprintf(&w, "xref\n0 4\n0000000000 65535 f \n")
+	for i := 1; i <= 3; i++ {
+		fmt.Fprintf(&w, "%010d 00000 n \n", w.offsets[i])
+	}
+	fmt.Fprintf(&w, "trailer\n<< /Size 4 /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", xref)
+	// the incremental update replaces the info dictionary
+	utf16Title := "<FEFF004800610063006B0069006E0067003A00200054006800650020004100720074>"
+	w.object(4, "<< /Title "+utf16Title+" /Author (Jon Erickson \\(2nd ed\\)\\051 and No \\\nStarch) /Subject (ISBN 978-1-59327-144-2) /Producer (x) /Nested << /A [1 2 3] >> >>")
+	update := w.Len()
+	fmt.Fprintf(&w, "xref\n4 1\n%010d 00000 n \n", w.offsets[4])
+	fmt.Fprintf(&w, "trailer\n<< /Size 5 /Root 1 0 R /Info 4 0 R /Prev %d >>\nstartxref\n%d\n%%%%EOF\n", xref, update)
+	return w.Bytes()
+}
+
+// testPDFStream is a PDF 1.5 file with its info in an object stream and an xref stream using the up predictor
+func testPDFStream() []byte {
+	w := pdfWriter{offsets: map[int]int{}}
+	w.WriteString("%PDF-1.5\n")
+	w.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	w.object(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+	objects := []string{"<< /Title (Black Hat Python) /Author (Justin Seitz; Tim Arnold) /Keywords (python, 9781718501126) >>", "[/Other]"}
+	header, body := "", ""
+	for i, o := range objects {
+		header += fmt.Sprintf("%d %d ", 3+i, len(body))
+		body += o + "\n"
+	}
+	w.stream(5, fmt.Sprintf("/Type /ObjStm /N %d /First %d /Filter /FlateDecode", len(objects), len(header)), deflate([]byte(header+body)))
+
+	// rows of type, offset and index, 1, 2 and 1 bytes wide
+	rows := [][]int{{0, 0, 255}, {1, w.offsets[1], 0}, {1, w.offsets[2], 0}, {2, 5, 0}, {2, 5, 1}, {1, w.offsets[5], 0}, {1, w.Len(), 0}}
+	var raw []byte
+	prev := make([]byte, 4)
+	for _, r := range rows {
+		row := []byte{byte(r[0]), byte(r[1] >> 8), byte(r[1]), byte(r[2])}
+		raw = append(raw, 2)
+		for i := range row {
+			raw = append(raw, row[i]-prev[i])
+		}
+		prev = row
+	}
+	xref := w.Len()
+	w.stream(6, "/Type /XRef /Size 7 /W [1 2 1] /Root 1 0 R /Info 3 0 R /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 4 >>", deflate(raw))
+	fmt.Fprintf(&w, "startxref\n%d\n%%%%EOF\n", xref)
+	return w.Bytes()
+}
+
+func TestReadPDF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-ebookmeta.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dd := []struct {
+		name     string
+		pdf      []byte
+		expected Metadata
+	}{
+		{
+			name: "table",
+			pdf:  testPDFTable(),
+			expected: Metadata{
+				Title:       "Hacking: The Art",
+				Creators:    []string{"Jon Erickson (2nd ed))", "No Starch"},
+				Identifiers: []Identifier{{Scheme: "isbn", Value: "9781593271442"}},
+			},
+		},
+		{
+			name: "stream",
+			pdf:  testPDFStream(),
+			expected: Metadata{
+				Title:       "Black Hat Python",
+				Creators:    []string{"Justin Seitz", "Tim Arnold"},
+				Identifiers: []Identifier{{Scheme: "isbn", Value: "9781718501126"}},
+			},
+		},
+	}
+	for _, d := range dd {
+		path := filepath.Join(dir, d.name+".pdf")
+		if err := ioutil.WriteFile(path, d.pdf, 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+		meta, err := Read(path)
+		if err != nil {
+			t.Errorf("%s: Read: %v", d.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*meta, d.expected) {
+			t.Errorf("%s: expected %+v but got %+v", d.name, d.expected, *meta)
+		}
+	}
+
+	encrypted := bytes.Replace(testPDFTable(), []byte("/Prev"), []byte("/Encrypt 9 0 R /Prev"), 1)
+	path := filepath.Join(dir, "encrypted.pdf")
+	ioutil.WriteFile(path, encrypted, 0644)
+	if _, err := ReadPDF(path); err != ErrEncrypted {
+		t.Errorf("expected ErrEncrypted but got %v", err)
+	}
+	path = filepath.Join(dir, "notes.txt")
+	ioutil.WriteFile(path, []byte("not a pdf"), 0644)
+	if _, err := ReadPDF(path); err == nil {
+		t.Errorf("expected a text file to fail")
+	}
+	if _, err := Read(path); err != ErrUnsupported {
+		t.Errorf("expected ErrUnsupported but got %v", err)
+	}
+}
+
+// testPDFXref is a PDF with an uncompressed xref stream of the given dictionary entries and data
+func testPDFXref(dict string, data []byte) []byte {
+	w := pdfWriter{offsets: map[int]int{}}
+	w.WriteString("%PDF-1.5\n")
+	w.object(1, "<< /Type /Catalog >>")
+	xref := w.Len()
+	w.stream(2, "/Type /XRef /Size 4 /Root 1 0 R /Info 3 0 R "+dict, data)
+	fmt.Fprintf(&w, "startxref\n%d\n%%%%EOF\n", xref)
+	return w.Bytes()
+}
+
+func TestReadPDFMalformed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-ebookmeta.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the info stream length refers to the stream itself
+	w := pdfWriter{offsets: map[int]int{}}
+	w.WriteString("%PDF-1.4\n")
+	w.object(1, "<< /Type /Catalog >>")
+	w.offsets[2] = w.Len()
+	w.WriteString("2 0 obj\n<< /Length 2 0 R >>\nstream\nxxxx\nendstream\nendobj\n")
+	xref := w.Len()
+	fmt.Fprintf(&w, "xref\n0 3\n0000000000 65535 f \n%010d 00000 n \n%010d 00000 n \n", w.offsets[1], w.offsets[2])
+	fmt.Fprintf(&w, "trailer\n<< /Size 3 /Root 1 0 R /Info 2 0 R >>\nstartxref\n%d\n%%%%EOF\n", xref)
+
+	dd := []struct {
+		name      string
+		pdf       []byte
+		expectErr bool
+	}{
+		{name: "length-cycle", pdf: w.Bytes()},
+		// the info is object 3 in object stream 3
+		{name: "object-stream-cycle", pdf: testPDFXref("/W [1 1 1]", []byte{0, 0, 0, 1, 9, 0, 1, 9, 0, 2, 3, 0}), expectErr: true},
+		{name: "negative-width", pdf: testPDFXref("/W [-5 1 5]", []byte{1, 2, 3}), expectErr: true},
+		{name: "zero-widths", pdf: testPDFXref("/W [0 0 0] /Index [0 999999999999]", nil), expectErr: true},
+	}
+	for _, d := range dd {
+		path := filepath.Join(dir, d.name+".pdf")
+		if err := ioutil.WriteFile(path, d.pdf, 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+		if _, err := ReadPDF(path); d.expectErr != (err != nil) {
+			t.Errorf("%s: expected error %v but got %v", d.name, d.expectErr, err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Package ebookmeta reads the title, authors and other metadata embedded in ebook files.
+package ebookmeta
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata is what an ebook says about itself, fields are empty when the file doesn't tell
+type Metadata struct {
+	Title       string       `json:"title,omitempty"`
+	Creators    []string     `json:"creators,omitempty"`
+	Publisher   string       `json:"publisher,omitempty"`
+	Language    string       `json:"language,omitempty"`
+	Identifiers []Identifier `json:"identifiers,omitempty"`
+	// Cover is the path of the cover image inside an EPUB
+	Cover string `json:"cover,omitempty"`
+}
+
+// ISBN returns the first ISBN identifier with its hyphens removed, or an empty string
+func (m *Metadata) ISBN() string {
+	for _, id := range m.Identifiers {
+		if id.Scheme != "" && id.Scheme != "isbn" {
+			continue
+		}
+		if isbn := normalizeISBN(id.Value); isbn != "" {
+			return isbn
+		}
+	}
+	return ""
+}
+
+// normalizeISBN strips the hyphens and spaces of an ISBN 10 or 13, it returns
+// an empty string when the value isn't one
+func normalizeISBN(s string) string {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "isbn")
+	s = strings.TrimLeft(s, ": ")
+	isbn := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(s))
+	for i, r := range isbn {
+		if (r < '0' || r > '9') && !(r == 'X' && i == 9 && len(isbn) == 10) {
+			return ""
+		}
+	}
+	if len(isbn) != 10 && len(isbn) != 13 {
+		return ""
+	}
+	return isbn
+}
+
+// ErrUnsupported is returned by Read for files that aren't EPUBs or PDFs
+var ErrUnsupported = errors.New("unsupported ebook format")
+
+// Read reads the metadata of an EPUB or PDF file, picked by its extension
+func Read(filename string) (*Metadata, error) {
+	switch strings.ToLower(path.Ext(filepath.ToSlash(filename))) {
+	case ".epub":
+		return ReadEPUB(filename)
+	case ".pdf":
+		return ReadPDF(filename)
+	}
+	return nil, ErrUnsupported
+}
+
+// Identifier is an ebook identifier, eg; isbn or uuid
+type Identifier struct {
+	Scheme string `json:"scheme,omitempty"`
+	Value  string `json:"value"`
+}
+
+// container is META-INF/container.xml, it points to the OPF package document
+type container struct {
+	Rootfiles []struct {
+		Path      string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// opfPackage is the part of the OPF package document holding the metadata,
+// encoding/xml matches the dc elements by local name whatever their prefix
+type opfPackage struct {
+	Metadata struct {
+		Titles   []string `xml:"title"`
+		Creators []struct {
+			Name string `xml:",chardata"`
+			Role string `xml:"role,attr"`
+			ID   string `xml:"id,attr"`
+		} `xml:"creator"`
+		Publisher   string `xml:"publisher"`
+		Language    string `xml:"language"`
+		Identifiers []struct {
+			Value  string `xml:",chardata"`
+			Scheme string `xml:"scheme,attr"`
+		} `xml:"identifier"`
+		Metas []struct {
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Refines  string `xml:"refines,attr"`
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Items []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+}
+
+// ReadEPUB reads the OPF metadata of an EPUB file
+func ReadEPUB(filename string) (*Metadata, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "zip.OpenReader")
+	}
+	defer zr.Close()
+
+	c := container{}
+	if err := decodeZipXML(&zr.Reader, "META-INF/container.xml", &c); err != nil {
+		return nil, err
+	}
+	opfPath := ""
+	for _, r := range c.Rootfiles {
+		if r.MediaType == "" || r.MediaType == "application/oebps-package+xml" {
+			opfPath = r.Path
+			break
+		}
+	}
+	if opfPath == "" {
+		return nil, errors.New("epub container has no OPF package")
+	}
+	opf := opfPackage{}
+	if err := decodeZipXML(&zr.Reader, opfPath, &opf); err != nil {
+		return nil, err
+	}
+	m := opf.metadata()
+	// manifest hrefs are relative to the OPF document
+	if href, _ := opf.cover(); href != "" {
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		m.Cover = path.Join(path.Dir(opfPath), href)
+	}
+	return m, nil
+}
+
+// ReadEPUBCover reads the cover image of an EPUB file and its media type
+func ReadEPUBCover(filename string) ([]byte, string, error) {
+	m, err := ReadEPUB(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	if m.Cover == "" {
+		return nil, "", errors.New("epub has no cover")
+	}
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "zip.OpenReader")
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if path.Clean(f.Name) != m.Cover {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "opening %s", f.Name)
+		}
+		defer rc.Close()
+		by, err := ioutil.ReadAll(io.LimitReader(rc, maxCover))
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "reading %s", f.Name)
+		}
+		mediaType := mime.TypeByExtension(path.Ext(f.Name))
+		if mediaType == "" {
+			mediaType = http.DetectContentType(by)
+		}
+		return by, mediaType, nil
+	}
+	return nil, "", errors.Errorf("%s not found in epub", m.Cover)
+}
+
+// maxCover bounds the size of a cover image read into memory
+const maxCover = 16 << 20
+
+// cover finds the cover image of the manifest, EPUB 3 marks it with the
+// cover-image property and EPUB 2 names its id in a cover meta element
+func (p *opfPackage) cover() (string, string) {
+	id := ""
+	for _, meta := range p.Metadata.Metas {
+		if meta.Name == "cover" {
+			id = meta.Content
+		}
+	}
+	for _, item := range p.Items {
+		if strings.Contains(" "+item.Properties+" ", " cover-image ") || (id != "" && item.ID == id) {
+			if strings.HasPrefix(item.MediaType, "image/") || item.MediaType == "" {
+				return item.Href, item.MediaType
+			}
+		}
+	}
+	return "", ""
+}
+
+func (p *opfPackage) metadata() *Metadata {
+	m := Metadata{
+		Publisher: clean(p.Metadata.Publisher),
+		Language:  clean(p.Metadata.Language),
+	}
+	if len(p.Metadata.Titles) > 0 {
+		m.Title = clean(p.Metadata.Titles[0])
+	}
+	// EPUB 3 moves the creator role to a refining meta element
+	roles := map[string]string{}
+	for _, meta := range p.Metadata.Metas {
+		if meta.Property == "role" && meta.Refines != "" {
+			roles[strings.TrimPrefix(meta.Refines, "#")] = clean(meta.Value)
+		}
+	}
+	for _, c := range p.Metadata.Creators {
+		role := c.Role
+		if role == "" {
+			role = roles[c.ID]
+		}
+		if name := clean(c.Name); name != "" && (role == "" || role == "aut") {
+			m.Creators = append(m.Creators, name)
+		}
+	}
+	for _, id := range p.Metadata.Identifiers {
+		value := clean(id.Value)
+		if value == "" {
+			continue
+		}
+		scheme := strings.ToLower(id.Scheme)
+		// EPUB 3 drops the scheme attribute in favour of urn prefixes
+		if parts := strings.SplitN(value, ":", 3); scheme == "" && len(parts) == 3 && parts[0] == "urn" {
+			scheme, value = strings.ToLower(parts[1]), parts[2]
+		}
+		m.Identifiers = append(m.Identifiers, Identifier{Scheme: scheme, Value: value})
+	}
+	return &m
+}
+
+// decodeZipXML decodes an XML file of a zip archive
+func decodeZipXML(zr *zip.Reader, name string, v interface{}) error {
+	for _, f := range zr.File {
+		if path.Clean(f.Name) != path.Clean(name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return errors.Wrapf(err, "opening %s", name)
+		}
+		defer rc.Close()
+		dec := xml.NewDecoder(io.LimitReader(rc, 4<<20))
+		// OPF files aren't always utf-8, the metadata we need is ascii most of the time
+		dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+		if err := dec.Decode(v); err != nil {
+			return errors.Wrapf(err, "decoding %s", name)
+		}
+		return nil
+	}
+	return errors.Errorf("%s not found in epub", name)
+}
+
+// clean collapses the whitespace of an XML text value
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
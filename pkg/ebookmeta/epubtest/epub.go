@@ -0,0 +1,45 @@
+// Package epubtest builds minimal EPUB files for testing.
+package epubtest
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+)
+
+const container = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+// Write writes an EPUB with the given OPF package document to path, creating
+// its directory. The package document is OEBPS/content.opf, so the files it
+// references live under OEBPS/. extra are name and content pairs of other
+// files of the EPUB
+func Write(path, opf string, extra ...string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	files := []string{"mimetype", "application/epub+zip", "META-INF/container.xml", container, "OEBPS/content.opf", opf}
+	files = append(files, extra...)
+	for i := 0; i+1 < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,786 @@
+package ebookmeta
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEncrypted is returned for PDFs whose info strings are encrypted
+var ErrEncrypted = errors.New("encrypted pdf")
+
+// maxObject bounds how much of the file is read to parse a single object
+const maxObject = 1 << 20
+
+// ReadPDF reads the document information dictionary of a PDF file, it follows
+// the cross reference tables and streams so only a few KiB of large files are read
+func ReadPDF(filename string) (*Metadata, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.Open")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "f.Stat")
+	}
+	p := pdfFile{r: f, size: fi.Size(), xref: map[int]xrefEntry{}, resolving: map[int]bool{}}
+	info, err := p.info()
+	if err != nil {
+		return nil, err
+	}
+	m := Metadata{
+		Title:     pdfText(info["Title"]),
+		Creators:  splitAuthors(pdfText(info["Author"])),
+		Publisher: pdfText(info["Publisher"]),
+	}
+	// publishers often put the ISBN in the subject or keywords
+	for _, key := range []string{"ISBN", "Subject", "Keywords"} {
+		if match := isbnRe.FindString(pdfText(info[key])); match != "" {
+			if isbn := normalizeISBN(match); isbn != "" {
+				m.Identifiers = append(m.Identifiers, Identifier{Scheme: "isbn", Value: isbn})
+				break
+			}
+		}
+	}
+	return &m, nil
+}
+
+var isbnRe = regexp.MustCompile(`(97[89][- ]?)?\d{1,5}[- ]?\d+[- ]?\d+[- ]?[\dXx]`)
+
+// splitAuthors splits the author string of a PDF, eg; "Michael Sikorski and Andrew Honig"
+func splitAuthors(s string) []string {
+	if s == "" {
+		return nil
+	}
+	for _, sep := range []string{";", " and ", " & "} {
+		s = strings.Replace(s, sep, ",", -1)
+	}
+	authors := []string{}
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			authors = append(authors, a)
+		}
+	}
+	return authors
+}
+
+type (
+	pdfName string
+	pdfRef  struct{ num, gen int }
+	pdfDict map[string]interface{}
+)
+
+// xrefEntry locates an object, in the file or in an object stream
+type xrefEntry struct {
+	offset int64
+	// stream is the object stream holding the object, and index its position in it
+	stream, index int
+	compressed    bool
+}
+
+type pdfFile struct {
+	r    io.ReaderAt
+	size int64
+	xref map[int]xrefEntry
+	// resolving holds the objects being resolved, to stop at reference cycles
+	resolving map[int]bool
+}
+
+// info reads the trailers from the last one back and resolves the Info dictionary
+func (p *pdfFile) info() (pdfDict, error) {
+	offset, err := p.startXref()
+	if err != nil {
+		return nil, err
+	}
+	var infoRef interface{}
+	seen := map[int64]bool{}
+	for offset > 0 && !seen[offset] {
+		seen[offset] = true
+		trailer, err := p.readXref(offset)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := trailer["Encrypt"]; ok {
+			return nil, ErrEncrypted
+		}
+		if infoRef == nil {
+			infoRef = trailer["Info"]
+		}
+		// hybrid files keep the compressed objects in a separate xref stream
+		if stm, ok := trailer["XRefStm"].(int64); ok && !seen[stm] {
+			seen[stm] = true
+			if _, err := p.readXref(stm); err != nil {
+				return nil, err
+			}
+		}
+		prev, _ := trailer["Prev"].(int64)
+		offset = prev
+	}
+	if infoRef == nil {
+		return pdfDict{}, nil
+	}
+	v, err := p.resolve(infoRef)
+	if err != nil {
+		return nil, err
+	}
+	info, _ := v.(pdfDict)
+	return info, nil
+}
+
+// startXref finds the offset of the last cross reference section
+func (p *pdfFile) startXref() (int64, error) {
+	n := int64(2048)
+	if n > p.size {
+		n = p.size
+	}
+	tail := make([]byte, n)
+	if _, err := p.r.ReadAt(tail, p.size-n); err != nil && err != io.EOF {
+		return 0, errors.Wrap(err, "reading pdf trailer")
+	}
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i < 0 {
+		return 0, errors.New("not a pdf, startxref not found")
+	}
+	fields := bytes.Fields(tail[i+len("startxref"):])
+	if len(fields) == 0 {
+		return 0, errors.New("invalid startxref")
+	}
+	offset, err := strconv.ParseInt(string(fields[0]), 10, 64)
+	if err != nil || offset <= 0 || offset >= p.size {
+		return 0, errors.Errorf("invalid startxref %q", fields[0])
+	}
+	return offset, nil
+}
+
+// readXref reads a cross reference table or stream, entries already known from
+// a newer section are kept, and returns the trailer dictionary
+func (p *pdfFile) readXref(offset int64) (pdfDict, error) {
+	lx, err := p.lexerAt(offset)
+	if err != nil {
+		return nil, err
+	}
+	if lx.keyword("xref") {
+		return p.readXrefTable(lx)
+	}
+	obj, err := lx.indirect()
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing xref stream")
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok || stream.dict["Type"] != pdfName("XRef") {
+		return nil, errors.New("invalid xref section")
+	}
+	data, err := stream.decode()
+	if err != nil {
+		return nil, err
+	}
+	widths := []int{}
+	if ws, ok := stream.dict["W"].([]interface{}); ok {
+		for _, w := range ws {
+			n, _ := w.(int64)
+			widths = append(widths, int(n))
+		}
+	}
+	if len(widths) != 3 {
+		return nil, errors.New("invalid xref stream widths")
+	}
+	entryLen := widths[0] + widths[1] + widths[2]
+	for _, w := range widths {
+		if w < 0 || w > 8 {
+			entryLen = 0
+		}
+	}
+	if entryLen == 0 {
+		return nil, errors.Errorf("invalid xref stream widths %v", widths)
+	}
+	size, _ := stream.dict["Size"].(int64)
+	index := []interface{}{int64(0), size}
+	if i, ok := stream.dict["Index"].([]interface{}); ok {
+		index = i
+	}
+	pos := 0
+	field := func(w int, def int64) int64 {
+		if w == 0 {
+			return def
+		}
+		var v int64
+		for i := 0; i < w; i++ {
+			v = v<<8 | int64(data[pos+i])
+		}
+		pos += w
+		return v
+	}
+	for i := 0; i+1 < len(index); i += 2 {
+		first, _ := index[i].(int64)
+		count, _ := index[i+1].(int64)
+		// the data holds no more entries than its length allows
+		if max := int64(len(data) / entryLen); count > max {
+			count = max
+		}
+		for n := first; n < first+count; n++ {
+			if pos+entryLen > len(data) {
+				return stream.dict, nil
+			}
+			typ, f2, f3 := field(widths[0], 1), field(widths[1], 0), field(widths[2], 0)
+			if _, ok := p.xref[int(n)]; ok {
+				continue
+			}
+			switch typ {
+			case 1:
+				p.xref[int(n)] = xrefEntry{offset: f2}
+			case 2:
+				p.xref[int(n)] = xrefEntry{compressed: true, stream: int(f2), index: int(f3)}
+			}
+		}
+	}
+	return stream.dict, nil
+}
+
+func (p *pdfFile) readXrefTable(lx *lexer) (pdfDict, error) {
+	for {
+		if lx.keyword("trailer") {
+			obj, err := lx.object()
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing trailer")
+			}
+			trailer, ok := obj.(pdfDict)
+			if !ok {
+				return nil, errors.New("invalid trailer")
+			}
+			return trailer, nil
+		}
+		first, err1 := lx.int()
+		count, err2 := lx.int()
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("invalid xref subsection")
+		}
+		for n := first; n < first+count; n++ {
+			offset, err1 := lx.int()
+			_, err2 := lx.int()
+			kind := lx.token()
+			if err1 != nil || err2 != nil {
+				return nil, errors.New("invalid xref entry")
+			}
+			if _, ok := p.xref[int(n)]; !ok && kind == "n" {
+				p.xref[int(n)] = xrefEntry{offset: offset}
+			}
+		}
+	}
+}
+
+// resolve follows a reference to the object it points to
+func (p *pdfFile) resolve(v interface{}) (interface{}, error) {
+	ref, ok := v.(pdfRef)
+	if !ok {
+		return v, nil
+	}
+	e, ok := p.xref[ref.num]
+	if !ok {
+		return nil, errors.Errorf("object %d not found", ref.num)
+	}
+	if p.resolving[ref.num] {
+		return nil, errors.Errorf("object %d refers to itself", ref.num)
+	}
+	p.resolving[ref.num] = true
+	defer delete(p.resolving, ref.num)
+	if !e.compressed {
+		lx, err := p.lexerAt(e.offset)
+		if err != nil {
+			return nil, err
+		}
+		return lx.indirect()
+	}
+	// the object is in an object stream, after a header of number and offset pairs
+	obj, err := p.resolve(pdfRef{num: e.stream})
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		return nil, errors.Errorf("object stream %d not found", e.stream)
+	}
+	data, err := stream.decode()
+	if err != nil {
+		return nil, err
+	}
+	first, _ := stream.dict["First"].(int64)
+	header := &lexer{r: bufio.NewReader(bytes.NewReader(data))}
+	var offset int64 = -1
+	for i := 0; i <= e.index; i++ {
+		if _, err := header.int(); err != nil {
+			return nil, errors.New("invalid object stream header")
+		}
+		o, err := header.int()
+		if err != nil {
+			return nil, errors.New("invalid object stream header")
+		}
+		offset = o
+	}
+	if offset < 0 || first+offset >= int64(len(data)) {
+		return nil, errors.New("invalid object stream offset")
+	}
+	lx := &lexer{r: bufio.NewReader(bytes.NewReader(data[first+offset:]))}
+	return lx.object()
+}
+
+func (p *pdfFile) lexerAt(offset int64) (*lexer, error) {
+	if offset < 0 || offset >= p.size {
+		return nil, errors.Errorf("invalid offset %d", offset)
+	}
+	return &lexer{r: bufio.NewReader(io.NewSectionReader(p.r, offset, maxObject)), file: p}, nil
+}
+
+// pdfStream is a stream object, only flate compressed and raw streams are decoded
+type pdfStream struct {
+	dict pdfDict
+	data []byte
+}
+
+func (s *pdfStream) decode() ([]byte, error) {
+	switch filter := s.dict["Filter"]; filter {
+	case nil:
+		return s.data, nil
+	case pdfName("FlateDecode"):
+		zr, err := zlib.NewReader(bytes.NewReader(s.data))
+		if err != nil {
+			return nil, errors.Wrap(err, "zlib.NewReader")
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(zr, 16*maxObject))
+		if err != nil && len(data) == 0 {
+			return nil, errors.Wrap(err, "inflating stream")
+		}
+		if parms, ok := s.dict["DecodeParms"].(pdfDict); ok {
+			if predictor, _ := parms["Predictor"].(int64); predictor >= 10 {
+				columns, _ := parms["Columns"].(int64)
+				return unpredict(data, int(columns))
+			}
+		}
+		return data, nil
+	default:
+		return nil, errors.Errorf("unsupported stream filter %v", filter)
+	}
+}
+
+// unpredict reverses the PNG predictors xref streams are often encoded with
+func unpredict(data []byte, columns int) ([]byte, error) {
+	if columns <= 0 {
+		return nil, errors.New("invalid predictor columns")
+	}
+	rowLen := columns + 1
+	out := make([]byte, 0, len(data)/rowLen*columns)
+	prev := make([]byte, columns)
+	for i := 0; i+rowLen <= len(data); i += rowLen {
+		row := append([]byte{}, data[i+1:i+rowLen]...)
+		for j := range row {
+			var left, upLeft byte
+			if j > 0 {
+				left, upLeft = row[j-1], prev[j-1]
+			}
+			switch data[i] {
+			case 1:
+				row[j] += left
+			case 2:
+				row[j] += prev[j]
+			case 3:
+				row[j] += byte((int(left) + int(prev[j])) / 2)
+			case 4:
+				row[j] += paeth(left, prev[j], upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// lexer parses the PDF objects needed to reach the info dictionary
+type lexer struct {
+	r *bufio.Reader
+	// file resolves indirect stream lengths, nil inside object streams
+	file *pdfFile
+	// peeked holds tokens read ahead to tell numbers from references
+	peeked []string
+}
+
+func isDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func isSpace(c byte) bool {
+	return strings.IndexByte(" \t\r\n\f\x00", c) >= 0
+}
+
+func (lx *lexer) skipSpace() {
+	for {
+		c, err := lx.r.ReadByte()
+		if err != nil {
+			return
+		}
+		if c == '%' {
+			lx.r.ReadString('\n')
+			continue
+		}
+		if !isSpace(c) {
+			lx.r.UnreadByte()
+			return
+		}
+	}
+}
+
+// token reads a keyword, number or delimiter, strings and names are read by object
+func (lx *lexer) token() string {
+	if len(lx.peeked) > 0 {
+		t := lx.peeked[0]
+		lx.peeked = lx.peeked[1:]
+		return t
+	}
+	lx.skipSpace()
+	c, err := lx.r.ReadByte()
+	if err != nil {
+		return ""
+	}
+	switch c {
+	case '<', '>':
+		if next, err := lx.r.ReadByte(); err == nil {
+			if next == c {
+				return string([]byte{c, c})
+			}
+			lx.r.UnreadByte()
+		}
+		return string(c)
+	case '[', ']', '(', ')', '/', '{', '}':
+		return string(c)
+	}
+	var b strings.Builder
+	b.WriteByte(c)
+	for {
+		c, err := lx.r.ReadByte()
+		if err != nil {
+			break
+		}
+		if isSpace(c) || isDelim(c) {
+			lx.r.UnreadByte()
+			break
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func (lx *lexer) unread(t string) {
+	lx.peeked = append([]string{t}, lx.peeked...)
+}
+
+// keyword consumes the next token when it's kw
+func (lx *lexer) keyword(kw string) bool {
+	t := lx.token()
+	if t == kw {
+		return true
+	}
+	lx.unread(t)
+	return false
+}
+
+func (lx *lexer) int() (int64, error) {
+	return strconv.ParseInt(lx.token(), 10, 64)
+}
+
+// indirect parses "N G obj value endobj"
+func (lx *lexer) indirect() (interface{}, error) {
+	if _, err := lx.int(); err != nil {
+		return nil, errors.New("invalid indirect object")
+	}
+	if _, err := lx.int(); err != nil {
+		return nil, errors.New("invalid indirect object")
+	}
+	if !lx.keyword("obj") {
+		return nil, errors.New("invalid indirect object")
+	}
+	return lx.object()
+}
+
+func (lx *lexer) object() (interface{}, error) {
+	t := lx.token()
+	switch t {
+	case "":
+		return nil, io.ErrUnexpectedEOF
+	case "<<":
+		dict := pdfDict{}
+		for {
+			if lx.keyword(">>") {
+				break
+			}
+			if !lx.keyword("/") {
+				return nil, errors.New("invalid dictionary key")
+			}
+			key := lx.name()
+			v, err := lx.object()
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = v
+		}
+		if lx.keyword("stream") {
+			return lx.stream(dict)
+		}
+		return dict, nil
+	case "[":
+		arr := []interface{}{}
+		for !lx.keyword("]") {
+			v, err := lx.object()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case "/":
+		return pdfName(lx.name()), nil
+	case "(":
+		return lx.literal()
+	case "<":
+		return lx.hex()
+	case "true", "false":
+		return t == "true", nil
+	case "null":
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			return f, nil
+		}
+		return nil, errors.Errorf("unexpected token %q", t)
+	}
+	// a number followed by a generation and R is a reference
+	gen := lx.token()
+	if g, err := strconv.Atoi(gen); err == nil {
+		r := lx.token()
+		if r == "R" {
+			return pdfRef{num: int(n), gen: g}, nil
+		}
+		lx.unread(r)
+	}
+	lx.unread(gen)
+	return n, nil
+}
+
+// name reads a name after its slash, decoding #xx escapes
+func (lx *lexer) name() string {
+	var b strings.Builder
+	for {
+		c, err := lx.r.ReadByte()
+		if err != nil {
+			break
+		}
+		if isSpace(c) || isDelim(c) {
+			lx.r.UnreadByte()
+			break
+		}
+		b.WriteByte(c)
+	}
+	name := b.String()
+	if strings.Contains(name, "#") {
+		var d strings.Builder
+		for i := 0; i < len(name); i++ {
+			if name[i] == '#' && i+2 < len(name) {
+				if v, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+					d.WriteByte(byte(v))
+					i += 2
+					continue
+				}
+			}
+			d.WriteByte(name[i])
+		}
+		name = d.String()
+	}
+	return name
+}
+
+// literal reads a (string) after its opening parenthesis
+func (lx *lexer) literal() ([]byte, error) {
+	var b []byte
+	depth := 1
+	for {
+		c, err := lx.r.ReadByte()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return b, nil
+			}
+		case '\\':
+			c, err = lx.r.ReadByte()
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// a backslash at the end of a line continues the string
+				if next, err := lx.r.ReadByte(); err == nil && next != '\n' {
+					lx.r.UnreadByte()
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2; i++ {
+						next, err := lx.r.ReadByte()
+						if err != nil || next < '0' || next > '7' {
+							if err == nil {
+								lx.r.UnreadByte()
+							}
+							break
+						}
+						v = v*8 + int(next-'0')
+					}
+					c = byte(v)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+}
+
+// hex reads a <hex string> after its opening bracket
+func (lx *lexer) hex() ([]byte, error) {
+	digits := []byte{}
+	for {
+		c, err := lx.r.ReadByte()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if c == '>' {
+			break
+		}
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	for i := range b {
+		v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return nil, errors.New("invalid hex string")
+		}
+		b[i] = byte(v)
+	}
+	return b, nil
+}
+
+// stream reads the stream data following its dictionary
+func (lx *lexer) stream(dict pdfDict) (*pdfStream, error) {
+	// the keyword is followed by CRLF or LF
+	if c, err := lx.r.ReadByte(); err == nil && c == '\r' {
+		if c, err := lx.r.ReadByte(); err == nil && c != '\n' {
+			lx.r.UnreadByte()
+		}
+	} else if err == nil && c != '\n' {
+		lx.r.UnreadByte()
+	}
+	length := int64(-1)
+	switch l := dict["Length"].(type) {
+	case int64:
+		length = l
+	case pdfRef:
+		if lx.file != nil {
+			if v, err := lx.file.resolve(l); err == nil {
+				length, _ = v.(int64)
+			}
+		}
+	}
+	if length >= 0 && length <= 16*maxObject {
+		data := make([]byte, length)
+		if _, err := io.ReadFull(lx.r, data); err != nil {
+			return nil, errors.Wrap(err, "reading stream")
+		}
+		return &pdfStream{dict: dict, data: data}, nil
+	}
+	// without a usable length the data ends at endstream
+	var data []byte
+	for {
+		line, err := lx.r.ReadBytes('\n')
+		if i := bytes.Index(line, []byte("endstream")); i >= 0 {
+			data = append(data, line[:i]...)
+			return &pdfStream{dict: dict, data: bytes.TrimRight(data, "\r\n")}, nil
+		}
+		data = append(data, line...)
+		if err != nil {
+			return nil, errors.New("stream without endstream")
+		}
+	}
+}
+
+// pdfText decodes a text string, UTF-16BE with a byte order mark or PDFDocEncoding,
+// which matches latin-1 for the printable characters
+func pdfText(v interface{}) string {
+	b, ok := v.([]byte)
+	if !ok {
+		return ""
+	}
+	if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+		u := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return clean(string(utf16.Decode(u)))
+	}
+	if len(b) >= 3 && b[0] == 0xef && b[1] == 0xbb && b[2] == 0xbf {
+		return clean(string(b[3:]))
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return clean(string(r))
+}
@@ -0,0 +1,280 @@
+// Package extract unpacks the zip and tar archives of downloaded assets.
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// archive formats
+const (
+	FormatZip    = "zip"
+	FormatTar    = "tar"
+	FormatTarGz  = "tar.gz"
+	FormatTarBz2 = "tar.bz2"
+)
+
+// ErrUnsafePath is returned for archive entries escaping the extraction directory, eg; ../../.bashrc
+var ErrUnsafePath = errors.New("unsafe path in archive")
+
+// Result describes the extraction of an archive
+type Result struct {
+	Archive string `json:"archive"`
+	Format  string `json:"format"`
+	Dir     string `json:"dir"`
+	Files   int    `json:"files"`
+	Bytes   int64  `json:"bytes"`
+	// Deleted is set when the archive was removed after the extraction
+	Deleted bool   `json:"deleted,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Detect sniffs the format of an archive from its content, the asset file
+// names don't keep the archive extension, it returns an empty format for
+// files that aren't archives, including compressed files that aren't tars
+func Detect(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "os.Open")
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", nil
+		}
+		defer gz.Close()
+		if isTar(gz) {
+			return FormatTarGz, nil
+		}
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		if isTar(bzip2.NewReader(r)) {
+			return FormatTarBz2, nil
+		}
+	case isTar(r):
+		return FormatTar, nil
+	}
+	return "", nil
+}
+
+// isTar looks for the ustar magic of the first tar header
+func isTar(r io.Reader) bool {
+	header := make([]byte, 512)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false
+	}
+	return bytes.HasPrefix(header[257:], []byte("ustar"))
+}
+
+// Extract unpacks the archive into dir, creating it, and keeps the entry
+// mtimes, entries escaping dir fail the extraction with ErrUnsafePath
+func Extract(archive, dir string) (*Result, error) {
+	format, err := Detect(archive)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		return nil, errors.Errorf("%s isn't a zip or tar archive", archive)
+	}
+	result := Result{Archive: archive, Format: format, Dir: dir}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll")
+	}
+	x := extractor{dir: dir, result: &result, dirTimes: map[string]time.Time{}}
+	if format == FormatZip {
+		err = x.zip(archive)
+	} else {
+		err = x.tar(archive, format)
+	}
+	if err != nil {
+		return &result, err
+	}
+	x.setDirTimes()
+	return &result, nil
+}
+
+type extractor struct {
+	dir    string
+	result *Result
+	// dirTimes are set once all the files are written, writing a file changes its directory mtime
+	dirTimes map[string]time.Time
+}
+
+// path joins an entry name to the extraction directory, refusing absolute
+// names and names climbing out of it
+func (x *extractor) path(name string) (string, error) {
+	name = filepath.FromSlash(strings.Replace(name, `\`, "/", -1))
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", errors.Wrap(ErrUnsafePath, name)
+	}
+	path := filepath.Join(x.dir, name)
+	if path != x.dir && !strings.HasPrefix(path, filepath.Clean(x.dir)+string(filepath.Separator)) {
+		return "", errors.Wrap(ErrUnsafePath, name)
+	}
+	return path, nil
+}
+
+func (x *extractor) zip(archive string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return errors.Wrap(err, "zip.OpenReader")
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		path, err := x.path(f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return errors.Wrap(err, "os.MkdirAll")
+			}
+			x.dirTimes[path] = f.Modified
+		case mode&os.ModeSymlink != 0:
+			// links could point anywhere, archives of assets don't need them
+			continue
+		default:
+			rc, err := f.Open()
+			if err != nil {
+				return errors.Wrapf(err, "opening %s", f.Name)
+			}
+			err = x.writeFile(path, rc, mode, f.Modified)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tar(archive, format string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return errors.Wrap(err, "os.Open")
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	switch format {
+	case FormatTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "gzip.NewReader")
+		}
+		defer gz.Close()
+		r = gz
+	case FormatTarBz2:
+		r = bzip2.NewReader(r)
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "tar.Next")
+		}
+		path, err := x.path(h.Name)
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return errors.Wrap(err, "os.MkdirAll")
+			}
+			x.dirTimes[path] = h.ModTime
+		case tar.TypeReg, tar.TypeRegA:
+			if err := x.writeFile(path, tr, h.FileInfo().Mode(), h.ModTime); err != nil {
+				return err
+			}
+		default:
+			// links could point anywhere, devices and fifos have no place in an asset
+			continue
+		}
+	}
+}
+
+// writeFile writes an entry, replacing what a previous extraction left, with
+// the permission bits of the entry so linux builds stay executable
+func (x *extractor) writeFile(path string, r io.Reader, mode os.FileMode, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "os.MkdirAll")
+	}
+	perm := mode.Perm() | 0600
+	// an entry could be a symlink left by an older extraction, never write through it
+	if fi, err := os.Lstat(path); err == nil && !fi.Mode().IsRegular() {
+		return errors.Wrapf(ErrUnsafePath, "%s isn't a regular file", path)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile")
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "writing %s", path)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "closing %s", path)
+	}
+	if !modTime.IsZero() {
+		_ = os.Chtimes(path, modTime, modTime)
+	}
+	x.result.Files++
+	x.result.Bytes += n
+	return nil
+}
+
+// setDirTimes sets the directory mtimes deepest first so setting one doesn't bump its parent
+func (x *extractor) setDirTimes() {
+	dirs := make([]string, 0, len(x.dirTimes))
+	for dir := range x.dirTimes {
+		dirs = append(dirs, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if t := x.dirTimes[dir]; !t.IsZero() {
+			_ = os.Chtimes(dir, t, t)
+		}
+	}
+}
+
+// Dir expands the placeholders of an extraction directory template, {name} is
+// the archive name without its extension and the other values are given by
+// vars, eg; {product}, a relative directory is relative to the archive one
+func Dir(template, archive string, vars map[string]string) string {
+	name := filepath.Base(archive)
+	if ext := filepath.Ext(name); ext != "" && ext != name {
+		name = strings.TrimSuffix(name, ext)
+	}
+	pairs := []string{"{name}", name}
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", strings.Replace(v, "/", "_", -1))
+	}
+	dir := filepath.FromSlash(strings.NewReplacer(pairs...).Replace(template))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(archive), dir)
+	}
+	return dir
+}
@@ -0,0 +1,203 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var modTime = time.Date(2020, 5, 6, 10, 30, 15, 0, time.UTC)
+
+// gameTarBz2 is game/bin/start.sh, stdlib can't write bzip2
+const gameTarBz2 = "QlpoOTFBWSZTWdL1+w0AANF/kMmAAEhAAf+AAACAiHLjngAEAAAIIACSCKUnqaZNA9IGg0aeUEUqHqbU0eoeo0YmQ0Gg/st5UpYyQD1NRG6pJsYHR2NDrazUSFYEpbmx0kKN+OM25hDnA7b2GrBrYNWrKO0NsScCHWx4cW2beybIdqGbiHc+vmpTQ0a0MxqMnJm2X0ud+wbsAirNEP4u5IpwoSGl6/Ya"
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Modified: modTime, Method: zip.Deflate})
+		if err != nil {
+			t.Fatalf("zw.CreateHeader: %v", err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zw.Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func tarArchive(t *testing.T, gz bool, files map[string]string) []byte {
+	var (
+		buf bytes.Buffer
+		gzw *gzip.Writer
+	)
+	tw := tar.NewWriter(&buf)
+	if gz {
+		gzw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gzw)
+	}
+	for name, content := range files {
+		h := tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), ModTime: modTime, Typeflag: tar.TypeReg, Format: tar.FormatUSTAR}
+		if strings.HasSuffix(name, "/") {
+			h.Typeflag, h.Size = tar.TypeDir, 0
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatalf("tw.WriteHeader: %v", err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	if gzw != nil {
+		gzw.Close()
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-extract.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	bz2, _ := base64.StdEncoding.DecodeString(gameTarBz2)
+	var gzFile bytes.Buffer
+	gzw := gzip.NewWriter(&gzFile)
+	gzw.Write([]byte("just a compressed text file, not a tar"))
+	gzw.Close()
+
+	dd := []struct {
+		name       string
+		content    []byte
+		format     string
+		files      map[string]string
+		unsafe     bool
+		notArchive bool
+	}{
+		{
+			name:    "zip",
+			content: zipArchive(t, map[string]string{"mp3/01 - Intro.mp3": "intro", "mp3/02 - Theme.mp3": "theme"}),
+			format:  FormatZip,
+			files:   map[string]string{"mp3/01 - Intro.mp3": "intro", "mp3/02 - Theme.mp3": "theme"},
+		},
+		{
+			name:    "tar",
+			content: tarArchive(t, false, map[string]string{"extras/": "", "extras/map.png": "map"}),
+			format:  FormatTar,
+			files:   map[string]string{"extras/map.png": "map"},
+		},
+		{
+			name:    "tar.gz",
+			content: tarArchive(t, true, map[string]string{"game/start.sh": "run"}),
+			format:  FormatTarGz,
+			files:   map[string]string{"game/start.sh": "run"},
+		},
+		{
+			name:    "tar.bz2",
+			content: bz2,
+			format:  FormatTarBz2,
+			files:   map[string]string{"game/bin/start.sh": "run"},
+		},
+		{
+			name:    "zip-slip",
+			content: zipArchive(t, map[string]string{"../../evil.sh": "evil"}),
+			format:  FormatZip,
+			unsafe:  true,
+		},
+		{
+			name:    "tar-slip",
+			content: tarArchive(t, true, map[string]string{"/etc/evil": "evil"}),
+			format:  FormatTarGz,
+			unsafe:  true,
+		},
+		{
+			name:       "pdf",
+			content:    []byte("%PDF-1.4 not an archive"),
+			notArchive: true,
+		},
+		{
+			name:       "gzip",
+			content:    gzFile.Bytes(),
+			notArchive: true,
+		},
+	}
+	for _, d := range dd {
+		archive := filepath.Join(dir, d.name, "Asset.download")
+		os.MkdirAll(filepath.Dir(archive), 0755)
+		if err := ioutil.WriteFile(archive, d.content, 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+		format, err := Detect(archive)
+		if err != nil || format != d.format {
+			t.Errorf("%s: expected format %q but got %q %v", d.name, d.format, format, err)
+		}
+		out := filepath.Join(dir, d.name, "out")
+		result, err := Extract(archive, out)
+		if d.notArchive {
+			if err == nil {
+				t.Errorf("%s: expected error", d.name)
+			}
+			continue
+		}
+		if d.unsafe {
+			if errors.Cause(err) != ErrUnsafePath {
+				t.Errorf("%s: expected ErrUnsafePath but got %v", d.name, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "evil.sh")); !os.IsNotExist(err) {
+				t.Errorf("%s: expected nothing written outside the extraction directory", d.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Extract: %v", d.name, err)
+			continue
+		}
+		if result.Files != len(d.files) || result.Format != d.format || result.Dir != out {
+			t.Errorf("%s: unexpected result %+v", d.name, result)
+		}
+		for name, content := range d.files {
+			path := filepath.Join(out, filepath.FromSlash(name))
+			by, err := ioutil.ReadFile(path)
+			if err != nil || string(by) != content {
+				t.Errorf("%s: expected %s to be %q but got %q %v", d.name, name, content, by, err)
+				continue
+			}
+			fi, _ := os.Stat(path)
+			if !fi.ModTime().Equal(modTime) {
+				t.Errorf("%s: expected %s mtime %s but got %s", d.name, name, modTime, fi.ModTime())
+			}
+			if d.format != FormatZip && fi.Mode().Perm()&0100 == 0 {
+				t.Errorf("%s: expected %s to stay executable but got %s", d.name, name, fi.Mode())
+			}
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	vars := map[string]string{"product": "AC/DC Live", "platform": "audio"}
+	dd := []struct {
+		template string
+		expected string
+	}{
+		{template: "{name}", expected: "/dl/Bundle/Soundtrack"},
+		{template: "{product}/{platform}", expected: "/dl/Bundle/AC_DC Live/audio"},
+		{template: "/music/{product}", expected: "/music/AC_DC Live"},
+		{template: "../extracted/{name}", expected: "/dl/extracted/Soundtrack"},
+	}
+	for _, d := range dd {
+		got := filepath.ToSlash(Dir(d.template, filepath.FromSlash("/dl/Bundle/Soundtrack.mp3"), vars))
+		if got != d.expected {
+			t.Errorf("%s: expected %s but got %s", d.template, d.expected, got)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package hbclient
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// checksum algorithms reported by Asset.ChecksumAlgorithm
+const (
+	ChecksumSHA1 = "sha1"
+	ChecksumMD5  = "md5"
+)
+
+// Asset is a single downloadable file of an order, it references the parsed
+// API structs without modifying them
+type Asset struct {
+	Order    *Order
+	Product  *Product
+	Download *Download
+	Type     *DownloadType
+}
+
+// Assets is a list of assets that can be narrowed down with filters
+type Assets []Asset
+
+// Assets flattens the order products, downloads and download types
+func (o *Order) Assets() Assets {
+	assets := Assets{}
+	for _, prod := range o.Products {
+		if prod == nil {
+			continue
+		}
+		for _, download := range prod.Downloads {
+			if download == nil {
+				continue
+			}
+			for _, dt := range download.Types {
+				if dt == nil {
+					continue
+				}
+				assets = append(assets, Asset{
+					Order:    o,
+					Product:  prod,
+					Download: download,
+					Type:     dt,
+				})
+			}
+		}
+	}
+	return assets
+}
+
+// Platform is the download platform, eg; ebook, audio, windows, linux
+func (a Asset) Platform() string {
+	return strings.ToLower(a.Download.Platform)
+}
+
+// Extension is the lowercase file extension without the dot, taken from the
+// download URL when it has one and from the type name otherwise
+func (a Asset) Extension() string {
+	if u, err := url.Parse(a.Type.URL.Web); err == nil {
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(u.Path), "."))
+		if ext != "" && !strings.ContainsAny(ext, " /") {
+			return ext
+		}
+	}
+	return strings.ToLower(strings.TrimPrefix(a.Type.Name, "."))
+}
+
+// ID identifies the asset across orders as product/platform/type, eg;
+// practicalmalwareanalysis/ebook/pdf
+func (a Asset) ID() string {
+	product := a.Product.MachineName
+	if product == "" {
+		product = a.Product.HumanName
+	}
+	return strings.ToLower(fmt.Sprintf("%s/%s/%s", product, a.Download.Platform, strings.TrimPrefix(a.Type.Name, ".")))
+}
+
+// Size is the file size in bytes reported by the API
+func (a Asset) Size() int64 {
+	return a.Type.FileSize
+}
+
+// ChecksumAlgorithm is the strongest checksum available for the asset, empty when there is none
+func (a Asset) ChecksumAlgorithm() string {
+	switch {
+	case a.Type.SHA1 != "":
+		return ChecksumSHA1
+	case a.Type.MD5 != "":
+		return ChecksumMD5
+	default:
+		return ""
+	}
+}
+
+// Checksum is the value matching ChecksumAlgorithm
+func (a Asset) Checksum() string {
+	switch a.ChecksumAlgorithm() {
+	case ChecksumSHA1:
+		return a.Type.SHA1
+	case ChecksumMD5:
+		return a.Type.MD5
+	default:
+		return ""
+	}
+}
+
+// AssetFilter reports whether an asset should be kept
+type AssetFilter func(a Asset) bool
+
+// Filter returns the assets matching all the filters
+func (as Assets) Filter(filters ...AssetFilter) Assets {
+	keep := And(filters...)
+	out := Assets{}
+	for _, a := range as {
+		if keep(a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// TotalSize sums the size of all the assets
+func (as Assets) TotalSize() int64 {
+	var total int64
+	for _, a := range as {
+		total += a.Size()
+	}
+	return total
+}
+
+// ByType matches assets by type name, eg; pdf or epub, "all" matches every asset
+func ByType(types ...string) AssetFilter {
+	set := lowerSet(types)
+	if _, all := set["all"]; all {
+		return All
+	}
+	return func(a Asset) bool {
+		_, ok := set[strings.ToLower(strings.TrimPrefix(a.Type.Name, "."))]
+		return ok
+	}
+}
+
+// ByPlatform matches assets by download platform, eg; ebook or linux
+func ByPlatform(platforms ...string) AssetFilter {
+	set := lowerSet(platforms)
+	return func(a Asset) bool {
+		_, ok := set[a.Platform()]
+		return ok
+	}
+}
+
+// ByExtension matches assets by file extension
+func ByExtension(exts ...string) AssetFilter {
+	set := lowerSet(exts)
+	return func(a Asset) bool {
+		_, ok := set[a.Extension()]
+		return ok
+	}
+}
+
+// ByProductName matches assets whose product human or machine name contains substr, ignoring case
+func ByProductName(substr string) AssetFilter {
+	substr = strings.ToLower(substr)
+	return func(a Asset) bool {
+		return strings.Contains(strings.ToLower(a.Product.HumanName), substr) ||
+			strings.Contains(strings.ToLower(a.Product.MachineName), substr)
+	}
+}
+
+// ByID matches assets by ID, ignoring case
+func ByID(ids ...string) AssetFilter {
+	set := map[string]struct{}{}
+	for _, id := range ids {
+		set[strings.ToLower(strings.TrimSpace(id))] = struct{}{}
+	}
+	return func(a Asset) bool {
+		_, ok := set[a.ID()]
+		return ok
+	}
+}
+
+// All matches every asset
+func All(a Asset) bool {
+	return true
+}
+
+// And matches assets matching all the filters, no filters match everything
+func And(filters ...AssetFilter) AssetFilter {
+	return func(a Asset) bool {
+		for _, f := range filters {
+			if !f(a) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches assets matching any of the filters
+func Or(filters ...AssetFilter) AssetFilter {
+	return func(a Asset) bool {
+		for _, f := range filters {
+			if f(a) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not inverts a filter
+func Not(f AssetFilter) AssetFilter {
+	return func(a Asset) bool {
+		return !f(a)
+	}
+}
+
+func lowerSet(values []string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, v := range values {
+		set[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(v), "."))] = struct{}{}
+	}
+	return set
+}
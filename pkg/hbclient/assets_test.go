@@ -0,0 +1,132 @@
+package hbclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOrderAssets(t *testing.T) {
+	order := Order{
+		Products: []*Product{
+			&Product{
+				HumanName:   "Practical Malware Analysis",
+				MachineName: "practicalmalwareanalysis",
+				Downloads: []*Download{
+					&Download{
+						Platform: "ebook",
+						Types: []*DownloadType{
+							&DownloadType{Name: "PDF", MD5: "md5sum", FileSize: 10, URL: DownloadTypeURL{Web: "https://dl.humble.com/pma.pdf?gamekey=x"}},
+							&DownloadType{Name: "EPUB", SHA1: "sha1sum", MD5: "md5sum", FileSize: 20},
+						},
+					},
+				},
+			},
+			&Product{
+				HumanName: "FTL: Faster Than Light",
+				Downloads: []*Download{
+					&Download{
+						Platform: "Linux",
+						Types: []*DownloadType{
+							&DownloadType{Name: "64-bit .deb", FileSize: 300, URL: DownloadTypeURL{Web: "https://dl.humble.com/ftl_amd64.deb"}},
+						},
+					},
+					&Download{
+						Platform: "windows",
+						Types: []*DownloadType{
+							&DownloadType{Name: "Download", FileSize: 400, URL: DownloadTypeURL{Web: "https://dl.humble.com/ftl_setup.exe"}},
+						},
+					},
+				},
+			},
+		},
+	}
+	before, _ := json.Marshal(order)
+
+	assets := order.Assets()
+	if len(assets) != 4 {
+		t.Fatalf("expected 4 assets but got %d", len(assets))
+	}
+	if assets[0].Order != &order || assets[0].Product != order.Products[0] || assets[0].Type != order.Products[0].Downloads[0].Types[0] {
+		t.Errorf("expected assets to reference the order structs")
+	}
+
+	dd := []struct {
+		asset     Asset
+		platform  string
+		extension string
+		size      int64
+		algorithm string
+		checksum  string
+	}{
+		{asset: assets[0], platform: "ebook", extension: "pdf", size: 10, algorithm: ChecksumMD5, checksum: "md5sum"},
+		{asset: assets[1], platform: "ebook", extension: "epub", size: 20, algorithm: ChecksumSHA1, checksum: "sha1sum"},
+		{asset: assets[2], platform: "linux", extension: "deb", size: 300},
+		{asset: assets[3], platform: "windows", extension: "exe", size: 400},
+	}
+	for _, d := range dd {
+		name := d.asset.Type.Name
+		if got := d.asset.Platform(); got != d.platform {
+			t.Errorf("%s: expected platform %q but got %q", name, d.platform, got)
+		}
+		if got := d.asset.Extension(); got != d.extension {
+			t.Errorf("%s: expected extension %q but got %q", name, d.extension, got)
+		}
+		if got := d.asset.Size(); got != d.size {
+			t.Errorf("%s: expected size %d but got %d", name, d.size, got)
+		}
+		if got := d.asset.ChecksumAlgorithm(); got != d.algorithm {
+			t.Errorf("%s: expected checksum algorithm %q but got %q", name, d.algorithm, got)
+		}
+		if got := d.asset.Checksum(); got != d.checksum {
+			t.Errorf("%s: expected checksum %q but got %q", name, d.checksum, got)
+		}
+	}
+
+	filters := []struct {
+		name   string
+		filter AssetFilter
+		types  []string
+	}{
+		{name: "all", filter: ByType("all"), types: []string{"PDF", "EPUB", "64-bit .deb", "Download"}},
+		{name: "type", filter: ByType("pdf", "EPUB"), types: []string{"PDF", "EPUB"}},
+		{name: "platform", filter: ByPlatform("linux", "windows"), types: []string{"64-bit .deb", "Download"}},
+		{name: "extension", filter: ByExtension(".exe"), types: []string{"Download"}},
+		{name: "product", filter: ByProductName("malware"), types: []string{"PDF", "EPUB"}},
+		{name: "and", filter: And(ByPlatform("ebook"), ByType("epub")), types: []string{"EPUB"}},
+		{name: "or", filter: Or(ByType("pdf"), ByPlatform("windows")), types: []string{"PDF", "Download"}},
+		{name: "not", filter: Not(ByPlatform("ebook")), types: []string{"64-bit .deb", "Download"}},
+		{name: "id", filter: ByID("practicalmalwareanalysis/ebook/epub", "FTL: Faster Than Light/linux/64-bit .deb"), types: []string{"EPUB", "64-bit .deb"}},
+	}
+	for _, f := range filters {
+		types := []string{}
+		for _, a := range assets.Filter(f.filter) {
+			types = append(types, a.Type.Name)
+		}
+		if !reflect.DeepEqual(types, f.types) {
+			t.Errorf("%s: expected %v but got %v", f.name, f.types, types)
+		}
+	}
+	if total := assets.Filter(ByPlatform("ebook")).TotalSize(); total != 30 {
+		t.Errorf("expected ebooks to total 30 bytes but got %d", total)
+	}
+
+	var selection bytes.Buffer
+	if err := WriteSelection(&selection, assets.Filter(ByType("pdf", "download"))); err != nil {
+		t.Fatalf("WriteSelection: %v", err)
+	}
+	ids, err := ReadSelection(&selection)
+	if err != nil {
+		t.Fatalf("ReadSelection: %v", err)
+	}
+	expectedIDs := []string{"practicalmalwareanalysis/ebook/pdf", "ftl: faster than light/windows/download"}
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("expected selection %v but got %v", expectedIDs, ids)
+	}
+
+	after, _ := json.Marshal(order)
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected order to be left untouched")
+	}
+}
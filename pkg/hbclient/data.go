@@ -1,17 +1,60 @@
 package hbclient
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// HBError is an error response of the API
 type HBError struct {
 	Message string `json:"message"`
 	Status  string `json:"errors"`
+	// StatusCode is the HTTP status of the response
+	StatusCode int `json:"-"`
+}
+
+func (e *HBError) Error() string {
+	return fmt.Sprintf("%s %s", e.Status, e.Message)
 }
 
+// IsAuthError reports whether err is the API refusing the JWT, eg; because it expired
+func IsAuthError(err error) bool {
+	var hbError *HBError
+	if !errors.As(err, &hbError) {
+		return false
+	}
+	return hbError.StatusCode == http.StatusUnauthorized || hbError.StatusCode == http.StatusForbidden
+}
+
+// Order is a purchase, Raw keeps the JSON document as returned by the API
 type Order struct {
 	UID         string     `json:"uid"`
 	GameKey     string     `json:"gamekey"`
-	Created     string     `json:"created"`
+	Created     Time       `json:"created"`
 	AmountSpent float64    `json:"amount_spent"`
+	Currency    string     `json:"currency,omitempty"`
+	Total       float64    `json:"total,omitempty"`
+	Claimed     bool       `json:"claimed,omitempty"`
+	IsGiftee    bool       `json:"is_giftee,omitempty"`
+	PathIDs     []string   `json:"path_ids,omitempty"`
 	Product     *Product   `json:"product"`
 	Products    []*Product `json:"subproducts"`
+	TPKDict     *TPKDict   `json:"tpkd_dict,omitempty"`
+	Coupons     []*Coupon  `json:"all_coupon_data,omitempty"`
+
+	Raw   json.RawMessage            `json:"-"`
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// TPKs returns the third party keys of the order
+func (o *Order) TPKs() []*TPK {
+	if o.TPKDict == nil {
+		return nil
+	}
+	return o.TPKDict.AllTPKs
 }
 
 type Product struct {
@@ -19,26 +62,169 @@ type Product struct {
 	HumanName   string      `json:"human_name"`
 	URL         string      `json:"url"`
 	Downloads   []*Download `json:"downloads"`
+	Icon        string      `json:"icon,omitempty"`
+	Category    string      `json:"category,omitempty"`
+	Payee       *Payee      `json:"payee,omitempty"`
+	Publishers  []Publisher `json:"publishers,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Payee is who gets paid for a product, usually the publisher or developer
+type Payee struct {
+	MachineName string `json:"machine_name"`
+	HumanName   string `json:"human_name"`
+}
+
+type Publisher struct {
+	Name string `json:"publisher_name"`
+	URI  string `json:"publisher_uri,omitempty"`
 }
 
 type Download struct {
-	MachineName string          `json:"machine_name"`
-	HumanName   string          `json:"human_name"`
-	Platform    string          `json:"platform"`
-	Types       []*DownloadType `json:"download_struct"`
+	MachineName string                     `json:"machine_name"`
+	HumanName   string                     `json:"human_name"`
+	Platform    string                     `json:"platform"`
+	Types       []*DownloadType            `json:"download_struct"`
+	Options     map[string]json.RawMessage `json:"options_dict,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 type DownloadType struct {
-	Name      string          `json:"name"`
-	HumanName string          `json:"-"`
-	HumanSize string          `json:"human_size"`
-	MD5       string          `json:"md5"`
-	SHA1      string          `json:"sha1"`
-	URL       DownloadTypeURL `json:"url"`
-	FileSize  int64           `json:"file_size"`
+	Name       string          `json:"name"`
+	HumanSize  string          `json:"human_size"`
+	MD5        string          `json:"md5"`
+	SHA1       string          `json:"sha1"`
+	URL        DownloadTypeURL `json:"url"`
+	FileSize   int64           `json:"file_size"`
+	UploadedAt *Time           `json:"uploaded_at,omitempty"`
+	// Timestamp is the unix time the file was last updated
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Small is set to 1 for reduced size variants of an asset
+	Small int `json:"small,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 type DownloadTypeURL struct {
 	Web        string `json:"web"`
 	BitTorrent string `json:"bittorrent"`
 }
+
+// TPKDict holds the third party keys, eg; steam or gog keys, of an order
+type TPKDict struct {
+	AllTPKs []*TPK `json:"all_tpks"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// TPK is a third party key entry, the key value is only set once it's revealed
+type TPK struct {
+	MachineName         string   `json:"machine_name"`
+	HumanName           string   `json:"human_name"`
+	GameKey             string   `json:"gamekey"`
+	KeyIndex            int      `json:"keyindex"`
+	KeyType             string   `json:"key_type"`
+	KeyTypeHumanName    string   `json:"key_type_human_name"`
+	RedeemedKeyVal      string   `json:"redeemed_key_val,omitempty"`
+	IsGift              bool     `json:"is_gift,omitempty"`
+	IsExpired           bool     `json:"is_expired,omitempty"`
+	SoldOut             bool     `json:"sold_out,omitempty"`
+	SteamAppID          int64    `json:"steam_app_id,omitempty"`
+	InstructionsHTML    string   `json:"instructions_html,omitempty"`
+	ExclusiveCountries  []string `json:"exclusive_countries,omitempty"`
+	DisallowedCountries []string `json:"disallowed_countries,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Revealed reports whether the key value has been revealed
+func (k *TPK) Revealed() bool {
+	return k.RedeemedKeyVal != ""
+}
+
+// Coupon is a store coupon granted by an order
+type Coupon struct {
+	Key       string `json:"coupon_key,omitempty"`
+	HumanName string `json:"human_name,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// The types below keep the fields they don't know about in Extra so they
+// survive a decode/encode round trip
+
+func (o *Order) UnmarshalJSON(data []byte) error {
+	type plain Order
+	if err := unmarshalWithExtra(data, (*plain)(o), &o.Extra); err != nil {
+		return err
+	}
+	o.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+func (o Order) MarshalJSON() ([]byte, error) {
+	type plain Order
+	return marshalWithExtra(plain(o), o.Extra)
+}
+
+func (p *Product) UnmarshalJSON(data []byte) error {
+	type plain Product
+	return unmarshalWithExtra(data, (*plain)(p), &p.Extra)
+}
+
+func (p Product) MarshalJSON() ([]byte, error) {
+	type plain Product
+	return marshalWithExtra(plain(p), p.Extra)
+}
+
+func (d *Download) UnmarshalJSON(data []byte) error {
+	type plain Download
+	return unmarshalWithExtra(data, (*plain)(d), &d.Extra)
+}
+
+func (d Download) MarshalJSON() ([]byte, error) {
+	type plain Download
+	return marshalWithExtra(plain(d), d.Extra)
+}
+
+func (dt *DownloadType) UnmarshalJSON(data []byte) error {
+	type plain DownloadType
+	return unmarshalWithExtra(data, (*plain)(dt), &dt.Extra)
+}
+
+func (dt DownloadType) MarshalJSON() ([]byte, error) {
+	type plain DownloadType
+	return marshalWithExtra(plain(dt), dt.Extra)
+}
+
+func (t *TPKDict) UnmarshalJSON(data []byte) error {
+	type plain TPKDict
+	return unmarshalWithExtra(data, (*plain)(t), &t.Extra)
+}
+
+func (t TPKDict) MarshalJSON() ([]byte, error) {
+	type plain TPKDict
+	return marshalWithExtra(plain(t), t.Extra)
+}
+
+func (k *TPK) UnmarshalJSON(data []byte) error {
+	type plain TPK
+	return unmarshalWithExtra(data, (*plain)(k), &k.Extra)
+}
+
+func (k TPK) MarshalJSON() ([]byte, error) {
+	type plain TPK
+	return marshalWithExtra(plain(k), k.Extra)
+}
+
+func (c *Coupon) UnmarshalJSON(data []byte) error {
+	type plain Coupon
+	return unmarshalWithExtra(data, (*plain)(c), &c.Extra)
+}
+
+func (c Coupon) MarshalJSON() ([]byte, error) {
+	type plain Coupon
+	return marshalWithExtra(plain(c), c.Extra)
+}
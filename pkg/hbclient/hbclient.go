@@ -19,6 +19,7 @@ const (
 type HBDClient struct {
 	jwtCookie string
 	apiURL    string
+	siteURL   string
 }
 
 type HBClientOption = func(c *HBDClient)
@@ -48,49 +49,86 @@ func WithAPIURL(apiURL string) HBClientOption {
 	}
 }
 
+// WithSiteURL overrides the humble bundle website URL used by non API endpoints,
+// it defaults to the API URL without its /api/v1 suffix
+func WithSiteURL(siteURL string) HBClientOption {
+	return func(c *HBDClient) {
+		c.siteURL = siteURL
+	}
+}
+
 // GetOrder fetches an order details matching a given key
-func (c *HBDClient) GetOrder(key string) (*Order, error) {
+func (c *HBDClient) GetOrder(ctx context.Context, key string) (*Order, error) {
+	order := Order{}
+	// url; https://www.humblebundle.com/api/v1/order/Ms39KaHeZAZW6Xx7
+	if err := c.get(ctx, path.Join("order", key), &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetOrderKeys lists the keys of all the orders linked to the account, it requires a JWT
+func (c *HBDClient) GetOrderKeys(ctx context.Context) ([]string, error) {
+	orders := []struct {
+		GameKey string `json:"gamekey"`
+	}{}
+	// url; https://www.humblebundle.com/api/v1/user/order
+	if err := c.get(ctx, "user/order", &orders); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(orders))
+	for _, o := range orders {
+		keys = append(keys, o.GameKey)
+	}
+	return keys, nil
+}
+
+// get fetches an API resource relative to the API URL and decodes it into v
+func (c *HBDClient) get(ctx context.Context, resource string, v interface{}) error {
 	u, err := url.Parse(c.apiURL)
 	if err != nil {
-		return nil, errors.Wrapf(err, "url.Parse baseURL %q", baseURL)
+		return errors.Wrapf(err, "url.Parse baseURL %q", c.apiURL)
 	}
-	u.Path = path.Join(u.Path, "order")
-	u.Path = path.Join(u.Path, key)
-	// url; https://www.humblebundle.com/api/v1/order/Ms39KaHeZAZW6Xx7
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, u.String(), nil)
+	u.Path = path.Join(u.Path, resource)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "http.NewRequestWithContext order")
-	}
-	if c.jwtCookie != "" {
-		cookie := http.Cookie{
-			Name:  jwtCookieName,
-			Value: c.jwtCookie,
-		}
-		req.AddCookie(&cookie)
+		return errors.Wrapf(err, "http.NewRequestWithContext %s", resource)
 	}
+	c.addAuth(req)
 	httpClient := http.Client{}
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "httpClient.Do get order")
+		return errors.Wrapf(err, "httpClient.Do get %s", resource)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "ioutil.ReadAll order response")
+		return errors.Wrapf(err, "ioutil.ReadAll %s response", resource)
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		hbError := HBError{}
+		hbError := HBError{StatusCode: resp.StatusCode}
 		if err := json.Unmarshal(body, &hbError); err != nil {
-			return nil, errors.Wrap(err, "json.Unmarshal error")
+			// eg; an HTML error page, keep the status so callers can still tell auth errors apart
+			return &HBError{StatusCode: resp.StatusCode, Status: resp.Status, Message: "unexpected error response"}
 		}
-		return nil, errors.Errorf("%s %s", hbError.Status, hbError.Message)
+		return &hbError
 	}
 
-	order := Order{}
-	if err := json.Unmarshal(body, &order); err != nil {
-		return nil, errors.Wrap(err, "json.Unmarshal order")
+	if err := json.Unmarshal(body, v); err != nil {
+		return errors.Wrapf(err, "json.Unmarshal %s", resource)
+	}
+	return nil
+}
+
+// addAuth sets the JWT cookie on requests when the client has one
+func (c *HBDClient) addAuth(req *http.Request) {
+	if c.jwtCookie != "" {
+		cookie := http.Cookie{
+			Name:  jwtCookieName,
+			Value: c.jwtCookie,
+		}
+		req.AddCookie(&cookie)
 	}
-	return &order, nil
 }
@@ -1,13 +1,18 @@
 package hbclient
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -102,7 +107,7 @@ func TestHBClient(t *testing.T) {
 		},
 	}
 	for _, d := range dd {
-		o, err := hbClient.GetOrder(d.In)
+		o, err := hbClient.GetOrder(context.Background(), d.In)
 		if o == nil && err != nil {
 			if !reflect.DeepEqual([]byte(err.Error()), d.Out) {
 				t.Errorf("%s - expected errors to match", d.In)
@@ -117,3 +122,193 @@ func TestHBClient(t *testing.T) {
 		}
 	}
 }
+
+func TestOrderJSON(t *testing.T) {
+	fixture, err := ioutil.ReadFile("testdata/order.json")
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile: %v", err)
+	}
+
+	order := Order{}
+	if err := json.Unmarshal(fixture, &order); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	if !bytes.Equal(order.Raw, bytes.TrimSpace(fixture)) {
+		t.Errorf("expected the raw order JSON to be kept")
+	}
+	if expected := time.Date(2019, 6, 4, 17, 43, 12, 270590000, time.UTC); !order.Created.Equal(expected) {
+		t.Errorf("expected created %s but got %s", expected, order.Created)
+	}
+	prod := order.Products[0]
+	if prod.Icon != "https://hb.imgix.net/socialengineering.png" || prod.Payee.HumanName != "Wiley" || prod.Publishers[0].Name != "Wiley" {
+		t.Errorf("unexpected product %+v", prod)
+	}
+	download := prod.Downloads[0]
+	if string(download.Options["is_kindle_compatible"]) != "true" {
+		t.Errorf("expected options_dict to be decoded but got %v", download.Options)
+	}
+	dt := download.Types[0]
+	if dt.Small != 1 || dt.Timestamp != 1559670192 || dt.UploadedAt.Year() != 2019 {
+		t.Errorf("unexpected download type %+v", dt)
+	}
+	tpks := order.TPKs()
+	if len(tpks) != 2 {
+		t.Fatalf("expected 2 keys but got %d", len(tpks))
+	}
+	if !tpks[0].Revealed() || tpks[0].SteamAppID != 212680 || tpks[0].KeyType != "steam" {
+		t.Errorf("unexpected revealed key %+v", tpks[0])
+	}
+	if tpks[1].Revealed() || !tpks[1].IsExpired || tpks[1].KeyIndex != 1 {
+		t.Errorf("unexpected unrevealed key %+v", tpks[1])
+	}
+	if len(order.Coupons) != 1 || order.Coupons[0].Key != "HUMBLE10" {
+		t.Errorf("unexpected coupons %+v", order.Coupons)
+	}
+
+	// unknown fields at every level survive a round trip
+	for name, extra := range map[string]map[string]json.RawMessage{
+		"order":         order.Extra,
+		"product":       prod.Extra,
+		"download":      download.Extra,
+		"download_type": dt.Extra,
+		"tpkd_dict":     order.TPKDict.Extra,
+		"tpk":           tpks[0].Extra,
+		"coupon":        order.Coupons[0].Extra,
+	} {
+		if len(extra) == 0 {
+			t.Errorf("%s: expected unknown fields to be kept", name)
+		}
+	}
+	encoded, err := json.Marshal(order)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	var original, roundTrip interface{}
+	if err := json.Unmarshal(fixture, &original); err != nil {
+		t.Fatalf("json.Unmarshal fixture: %v", err)
+	}
+	if err := json.Unmarshal(encoded, &roundTrip); err != nil {
+		t.Fatalf("json.Unmarshal encoded: %v", err)
+	}
+	if !reflect.DeepEqual(original, roundTrip) {
+		t.Errorf("expected the order to round trip\nwant: %s\ngot:  %s", fixture, encoded)
+	}
+}
+
+func TestTimeJSON(t *testing.T) {
+	dd := []struct {
+		in       string
+		expected time.Time
+	}{
+		{in: `"2019-06-04T17:43:12.270590"`, expected: time.Date(2019, 6, 4, 17, 43, 12, 270590000, time.UTC)},
+		{in: `"2019-06-04T17:43:12.27+02:00"`, expected: time.Date(2019, 6, 4, 15, 43, 12, 270000000, time.UTC)},
+		// an unknown format decodes to the zero time and is kept as is
+		{in: `"June 4th, 2019"`},
+		{in: `""`},
+	}
+	for _, d := range dd {
+		var ts Time
+		if err := json.Unmarshal([]byte(d.in), &ts); err != nil {
+			t.Errorf("%s: json.Unmarshal: %v", d.in, err)
+			continue
+		}
+		if !ts.Equal(d.expected) {
+			t.Errorf("%s: expected %s but got %s", d.in, d.expected, ts.Time)
+		}
+		if by, err := json.Marshal(ts); err != nil || string(by) != d.in {
+			t.Errorf("%s: expected the timestamp to encode back unchanged but got %s %v", d.in, by, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(`1559670192`), &Time{}); err == nil {
+		t.Errorf("expected a number to fail")
+	}
+}
+
+func TestGetOrderKeys(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/order", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(jwtCookieName); err != nil || cookie.Value != "jwt" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors": "unauthorized", "message": "login required"}`))
+			return
+		}
+		w.Write([]byte(`[{"gamekey": "key1"}, {"gamekey": "key2"}]`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	keys, err := NewClient(WithAPIURL(srv.URL), WithJWT("jwt")).GetOrderKeys(context.Background())
+	if err != nil {
+		t.Fatalf("GetOrderKeys: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"key1", "key2"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	_, err = NewClient(WithAPIURL(srv.URL)).GetOrderKeys(context.Background())
+	if err == nil || err.Error() != "unauthorized login required" {
+		t.Errorf("expected unauthorized error but got %v", err)
+	}
+	if !IsAuthError(errors.Wrap(err, "sync")) {
+		t.Errorf("expected %v to be an auth error", err)
+	}
+}
+
+func TestRedeemKey(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/humbler/redeemkey", func(w http.ResponseWriter, r *http.Request) {
+		csrf, err := r.Cookie(csrfCookieName)
+		if r.Method != http.MethodPost || err != nil || csrf.Value != r.Header.Get("CSRF-Prevention-Token") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if cookie, err := r.Cookie(jwtCookieName); err != nil || cookie.Value != "jwt" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("<html>login</html>"))
+			return
+		}
+		switch r.FormValue("keytype") {
+		case "ftl_steam":
+			if r.FormValue("key") != "order1" || r.FormValue("keyindex") != "2" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"success": true, "key": "AAAAA-BBBBB-CCCCC"}`))
+		default:
+			w.Write([]byte(`{"success": false, "error_msg": "Sold out"}`))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dd := []struct {
+		name      string
+		jwt       string
+		tpk       TPK
+		key       string
+		errStatus int
+	}{
+		{name: "revealed", jwt: "jwt", tpk: TPK{MachineName: "ftl_steam", GameKey: "order1", KeyIndex: 2}, key: "AAAAA-BBBBB-CCCCC"},
+		{name: "sold-out", jwt: "jwt", tpk: TPK{MachineName: "other_steam", GameKey: "order1"}, errStatus: http.StatusOK},
+		{name: "unauthorized", tpk: TPK{MachineName: "ftl_steam", GameKey: "order1", KeyIndex: 2}, errStatus: http.StatusUnauthorized},
+	}
+	for _, d := range dd {
+		client := NewClient(WithAPIURL(srv.URL+"/api/v1"), WithJWT(d.jwt))
+		key, err := client.RedeemKey(context.Background(), &d.tpk)
+		if d.errStatus != 0 {
+			var redeemErr *RedeemError
+			if !errors.As(err, &redeemErr) {
+				t.Errorf("%s: expected RedeemError but got %v", d.name, err)
+			} else if redeemErr.StatusCode != d.errStatus {
+				t.Errorf("%s: expected status %d but got %d", d.name, d.errStatus, redeemErr.StatusCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: RedeemKey: %v", d.name, err)
+		} else if key != d.key {
+			t.Errorf("%s: expected key %q but got %q", d.name, d.key, key)
+		}
+	}
+}
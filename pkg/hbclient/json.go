@@ -0,0 +1,115 @@
+package hbclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// timeLayout is the timestamp format used by the API, eg; 2019-06-04T17:43:12.270590
+const timeLayout = "2006-01-02T15:04:05.999999999"
+
+// Time is an API timestamp, the original text is kept so it encodes back
+// unchanged, a timestamp in an unknown format is kept with a zero Time
+type Time struct {
+	time.Time
+	raw string
+}
+
+// NewTime wraps t as an API timestamp
+func NewTime(t time.Time) Time {
+	return Time{Time: t}
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*t = Time{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Wrap(err, "json.Unmarshal time")
+	}
+	if s == "" {
+		*t = Time{}
+		return nil
+	}
+	parsed, err := time.Parse(timeLayout, s)
+	if err != nil {
+		// some timestamps carry a zone, an odd one mustn't make the order unreadable
+		if parsed, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			parsed = time.Time{}
+		}
+	}
+	*t = Time{Time: parsed, raw: s}
+	return nil
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.raw != "" {
+		return json.Marshal(t.raw)
+	}
+	if t.IsZero() {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(t.UTC().Format(timeLayout))
+}
+
+// unmarshalWithExtra decodes data into v and keeps the object members v has no field for in extra
+func unmarshalWithExtra(data []byte, v interface{}, extra *map[string]json.RawMessage) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, name := range jsonFieldNames(reflect.TypeOf(v).Elem()) {
+		delete(all, name)
+	}
+	if len(all) == 0 {
+		all = nil
+	}
+	*extra = all
+	return nil
+}
+
+// marshalWithExtra encodes v and adds back the members kept in extra
+func marshalWithExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	by, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return by, err
+	}
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(by, &merged); err != nil {
+		return nil, err
+	}
+	for name, raw := range extra {
+		if _, ok := merged[name]; !ok {
+			merged[name] = raw
+		}
+	}
+	return json.Marshal(merged)
+}
+
+// jsonFieldNames lists the JSON member names of a struct type
+func jsonFieldNames(t reflect.Type) []string {
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
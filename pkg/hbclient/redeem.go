@@ -0,0 +1,105 @@
+package hbclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// csrfCookieName is the cookie the website expects to match the csrf header of POST requests
+const csrfCookieName = "csrf_cookie"
+
+// RedeemError is returned when humble bundle refuses to reveal a key
+type RedeemError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *RedeemError) Error() string {
+	return "redeem key: " + e.Message
+}
+
+// redeemResponse is the body returned by the redeemkey endpoint
+type redeemResponse struct {
+	Success  bool   `json:"success"`
+	Key      string `json:"key"`
+	ErrorMsg string `json:"error_msg"`
+}
+
+// RedeemKey reveals a third party key and returns its value, it requires a JWT
+func (c *HBDClient) RedeemKey(ctx context.Context, tpk *TPK) (string, error) {
+	form := url.Values{}
+	form.Set("keytype", tpk.MachineName)
+	form.Set("key", tpk.GameKey)
+	form.Set("keyindex", strconv.Itoa(tpk.KeyIndex))
+
+	res := redeemResponse{}
+	// url; https://www.humblebundle.com/humbler/redeemkey
+	status, err := c.postForm(ctx, "humbler/redeemkey", form, &res)
+	if err != nil {
+		return "", err
+	}
+	if status < 200 || status > 299 || !res.Success {
+		msg := res.ErrorMsg
+		if msg == "" {
+			msg = http.StatusText(status)
+		}
+		return "", &RedeemError{StatusCode: status, Message: msg}
+	}
+	if res.Key == "" {
+		return "", &RedeemError{StatusCode: status, Message: "empty key in response"}
+	}
+	return res.Key, nil
+}
+
+// postForm posts a form to a website endpoint and decodes the JSON response into v
+func (c *HBDClient) postForm(ctx context.Context, resource string, form url.Values, v interface{}) (int, error) {
+	siteURL := c.siteURL
+	if siteURL == "" {
+		siteURL = strings.TrimSuffix(strings.TrimSuffix(c.apiURL, "/"), "/api/v1")
+	}
+	u, err := url.Parse(siteURL)
+	if err != nil {
+		return 0, errors.Wrapf(err, "url.Parse siteURL %q", siteURL)
+	}
+	u.Path = path.Join(u.Path, resource)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, errors.Wrapf(err, "http.NewRequestWithContext %s", resource)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.addAuth(req)
+
+	// the website only accepts POSTs carrying the same token in a header and a cookie
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return 0, errors.Wrap(err, "rand.Read csrf token")
+	}
+	req.Header.Set("CSRF-Prevention-Token", hex.EncodeToString(token))
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: hex.EncodeToString(token)})
+
+	httpClient := http.Client{}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "httpClient.Do post %s", resource)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, errors.Wrapf(err, "ioutil.ReadAll %s response", resource)
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp.StatusCode, errors.Wrapf(err, "json.Unmarshal %s", resource)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,38 @@
+package hbclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReadSelection reads a selection file, one asset ID per line, blank lines
+// and lines starting with # are ignored
+func ReadSelection(r io.Reader) ([]string, error) {
+	ids := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading selection")
+	}
+	return ids, nil
+}
+
+// WriteSelection writes the IDs of assets as a selection file, the human
+// names are added as comments
+func WriteSelection(w io.Writer, assets Assets) error {
+	bw := bufio.NewWriter(w)
+	for _, a := range assets {
+		fmt.Fprintf(bw, "# %s, %s %s\n%s\n", a.Product.HumanName, a.Platform(), a.Type.Name, a.ID())
+	}
+	return errors.Wrap(bw.Flush(), "writing selection")
+}
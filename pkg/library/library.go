@@ -0,0 +1,130 @@
+// Package library keeps a local copy of the account orders so they can be
+// browsed and searched without calling the API every time.
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/pkg/errors"
+)
+
+// ordersDir is the state subdirectory holding one JSON file per order
+const ordersDir = "orders"
+
+// ErrNotCached is returned when an order hasn't been synced yet
+var ErrNotCached = errors.New("order not cached, sync the library first")
+
+// Library is the local cache of orders
+type Library struct {
+	dir    state.Dir
+	client *hbclient.HBDClient
+}
+
+// New creates a library cached in the state directory dir, client is used to sync it
+func New(dir state.Dir, client *hbclient.HBDClient) *Library {
+	return &Library{
+		dir:    dir,
+		client: client,
+	}
+}
+
+// Sync fetches the orders matching keys, or every order of the account when
+// no keys are given, and replaces their cached copies
+func (l *Library) Sync(ctx context.Context, keys ...string) ([]*hbclient.Order, error) {
+	if len(keys) == 0 {
+		var err error
+		keys, err = l.client.GetOrderKeys(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "HBClient.GetOrderKeys")
+		}
+	}
+	orders := make([]*hbclient.Order, 0, len(keys))
+	for _, key := range keys {
+		order, err := l.client.GetOrder(ctx, key)
+		if err != nil {
+			return orders, errors.Wrapf(err, "HBClient.GetOrder %s", key)
+		}
+		if err := l.Save(order); err != nil {
+			return orders, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// Save caches an order, the JSON returned by the API is kept as is
+func (l *Library) Save(order *hbclient.Order) error {
+	path, err := l.orderPath(order.GameKey)
+	if err != nil {
+		return err
+	}
+	by := []byte(order.Raw)
+	if len(by) == 0 {
+		if by, err = json.Marshal(order); err != nil {
+			return errors.Wrapf(err, "json.Marshal order %s", order.GameKey)
+		}
+	}
+	return state.WriteFileAtomic(path, by)
+}
+
+// Order loads a cached order
+func (l *Library) Order(key string) (*hbclient.Order, error) {
+	path, err := l.orderPath(key)
+	if err != nil {
+		return nil, err
+	}
+	by, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotCached, "order %s", key)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "ioutil.ReadFile %s", path)
+	}
+	order := hbclient.Order{}
+	if err := json.Unmarshal(by, &order); err != nil {
+		return nil, errors.Wrapf(err, "json.Unmarshal %s", path)
+	}
+	return &order, nil
+}
+
+// Orders loads all the cached orders, newest first
+func (l *Library) Orders() ([]*hbclient.Order, error) {
+	entries, err := ioutil.ReadDir(l.dir.Path(ordersDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadDir orders")
+	}
+	orders := []*hbclient.Order{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		order, err := l.Order(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].Created.After(orders[j].Created.Time)
+	})
+	return orders, nil
+}
+
+// orderPath is the cache file of an order, keys can't escape the orders directory
+func (l *Library) orderPath(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || strings.HasPrefix(key, ".") {
+		return "", errors.Errorf("invalid order key %q", key)
+	}
+	return l.dir.Path(filepath.Join(ordersDir, key+".json")), nil
+}
@@ -0,0 +1,74 @@
+package library
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/pkg/errors"
+)
+
+func TestLibrary(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/order", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"gamekey": "old"}, {"gamekey": "new"}]`))
+	})
+	mux.HandleFunc("/order/old", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"gamekey": "old", "created": "2018-01-02T10:00:00.000000", "product": {"human_name": "Old Bundle"}, "subproducts": [], "unknown": 1}`))
+	})
+	mux.HandleFunc("/order/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"gamekey": "new", "created": "2020-05-06T10:00:00.000000", "product": {"human_name": "New Bundle"}, "subproducts": []}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "hbd-library.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	lib := New(state.Dir(dir), hbclient.NewClient(hbclient.WithAPIURL(srv.URL)))
+
+	orders, err := lib.Orders()
+	if err != nil || len(orders) != 0 {
+		t.Fatalf("expected an empty library but got %d orders, %v", len(orders), err)
+	}
+	if _, err := lib.Order("old"); errors.Cause(err) != ErrNotCached {
+		t.Errorf("expected ErrNotCached but got %v", err)
+	}
+
+	synced, err := lib.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(synced) != 2 {
+		t.Errorf("expected 2 synced orders but got %d", len(synced))
+	}
+
+	orders, err = lib.Orders()
+	if err != nil {
+		t.Fatalf("Orders: %v", err)
+	}
+	if len(orders) != 2 || orders[0].GameKey != "new" || orders[1].GameKey != "old" {
+		t.Fatalf("expected orders new, old but got %v", orders)
+	}
+	if _, ok := orders[1].Extra["unknown"]; !ok {
+		t.Errorf("expected the cached order to keep unknown fields")
+	}
+
+	order, err := lib.Order("old")
+	if err != nil {
+		t.Fatalf("Order: %v", err)
+	}
+	if order.Product.HumanName != "Old Bundle" {
+		t.Errorf("expected Old Bundle but got %q", order.Product.HumanName)
+	}
+	if _, err := lib.Order("../keys"); err == nil {
+		t.Errorf("expected keys outside the orders directory to be rejected")
+	}
+}
@@ -0,0 +1,233 @@
+// Package manifest records which assets were downloaded into a destination
+// directory so later runs can tell new and changed assets apart.
+package manifest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/state"
+	"github.com/pkg/errors"
+)
+
+// Filename is the manifest file kept at the root of the destination directory
+const Filename = ".hbd-manifest.json"
+
+// Entry is a downloaded asset
+type Entry struct {
+	Order   string `json:"order"`
+	Bundle  string `json:"bundle"`
+	ID      string `json:"id"`
+	Product string `json:"product"`
+	// Path is the file path relative to the destination directory
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	MD5          string    `json:"md5,omitempty"`
+	SHA1         string    `json:"sha1,omitempty"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	// Extracted is the directory the archive was unpacked into, relative to the destination directory
+	Extracted string `json:"extracted,omitempty"`
+	// Meta is what an EPUB or PDF says about itself, eg; its title and authors
+	Meta *ebookmeta.Metadata `json:"meta,omitempty"`
+}
+
+// Key identifies an entry, the same asset bought in two orders has two entries
+func (e *Entry) Key() string {
+	return Key(e.Order, e.ID)
+}
+
+// Key identifies the entry of an asset of an order
+func Key(order, id string) string {
+	return order + "/" + id
+}
+
+// Manifest is the set of assets downloaded into a directory, it's safe for concurrent use
+type Manifest struct {
+	dir string
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// document is the JSON encoding of a manifest
+type document struct {
+	Entries []*Entry `json:"entries"`
+}
+
+// Load reads the manifest of the directory dir, a missing manifest is empty
+func Load(dir string) (*Manifest, error) {
+	m := Manifest{
+		dir:     dir,
+		entries: map[string]*Entry{},
+	}
+	by, err := ioutil.ReadFile(filepath.Join(dir, Filename))
+	if os.IsNotExist(err) {
+		return &m, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadFile manifest")
+	}
+	doc := document{}
+	if err := json.Unmarshal(by, &doc); err != nil {
+		return nil, errors.Wrapf(err, "json.Unmarshal %s", filepath.Join(dir, Filename))
+	}
+	for _, e := range doc.Entries {
+		m.entries[e.Key()] = e
+	}
+	return &m, nil
+}
+
+// Dir is the directory the manifest describes
+func (m *Manifest) Dir() string {
+	return m.dir
+}
+
+// Save writes the manifest into its directory
+func (m *Manifest) Save() error {
+	doc := document{Entries: m.Entries()}
+	by, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json.MarshalIndent manifest")
+	}
+	return state.WriteFileAtomic(filepath.Join(m.dir, Filename), by)
+}
+
+// Entries lists the entries sorted by path
+func (m *Manifest) Entries() []*Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]*Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Path != entries[j].Path {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Key() < entries[j].Key()
+	})
+	return entries
+}
+
+// Get returns the entry of an asset, nil when it was never downloaded
+func (m *Manifest) Get(asset hbclient.Asset) *Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.entries[Key(asset.Order.GameKey, asset.ID())]
+}
+
+// Put records an asset downloaded to path, relative to the manifest directory
+func (m *Manifest) Put(asset hbclient.Asset, path string) *Entry {
+	e := Entry{
+		Order:        asset.Order.GameKey,
+		ID:           asset.ID(),
+		Product:      asset.Product.HumanName,
+		Path:         filepath.ToSlash(path),
+		Size:         asset.Size(),
+		MD5:          asset.Type.MD5,
+		SHA1:         asset.Type.SHA1,
+		DownloadedAt: time.Now().UTC(),
+	}
+	if asset.Order.Product != nil {
+		e.Bundle = asset.Order.Product.HumanName
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[e.Key()] = &e
+	return &e
+}
+
+// SetExtracted records the directory an asset archive was unpacked into, relative to the manifest directory
+func (m *Manifest) SetExtracted(asset hbclient.Asset, dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.entries[Key(asset.Order.GameKey, asset.ID())]; e != nil {
+		e.Extracted = filepath.ToSlash(dir)
+	}
+}
+
+// SetPath records the path an asset file was moved to, relative to the manifest directory
+func (m *Manifest) SetPath(asset hbclient.Asset, path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.entries[Key(asset.Order.GameKey, asset.ID())]; e != nil {
+		e.Path = filepath.ToSlash(path)
+	}
+}
+
+// SetMeta records the metadata read from an asset file
+func (m *Manifest) SetMeta(asset hbclient.Asset, meta *ebookmeta.Metadata) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.entries[Key(asset.Order.GameKey, asset.ID())]; e != nil {
+		e.Meta = meta
+	}
+}
+
+// Remove forgets an entry
+func (m *Manifest) Remove(e *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, e.Key())
+}
+
+// Status compares an asset with its entry and the file on disk
+func (m *Manifest) Status(asset hbclient.Asset) Status {
+	e := m.Get(asset)
+	switch {
+	case e == nil:
+		return StatusNew
+	case e.Size != asset.Size() || e.MD5 != asset.Type.MD5 || e.SHA1 != asset.Type.SHA1:
+		return StatusChanged
+	}
+	if _, err := os.Stat(filepath.Join(m.dir, filepath.FromSlash(e.Path))); err == nil {
+		return StatusCurrent
+	}
+	// the archive may have been removed once extracted
+	if e.Extracted != "" {
+		if _, err := os.Stat(filepath.Join(m.dir, filepath.FromSlash(e.Extracted))); err == nil {
+			return StatusCurrent
+		}
+	}
+	return StatusMissing
+}
+
+// Status is how an asset compares with the manifest
+type Status int
+
+// asset statuses, only current assets don't need a download
+const (
+	StatusCurrent Status = iota
+	StatusNew
+	StatusChanged
+	StatusMissing
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusCurrent:
+		return "current"
+	case StatusNew:
+		return "new"
+	case StatusChanged:
+		return "changed"
+	case StatusMissing:
+		return "missing"
+	default:
+		return "unknown"
+	}
+}
+
+// Outdated matches the assets that are new, changed or missing on disk
+func (m *Manifest) Outdated() hbclient.AssetFilter {
+	return func(a hbclient.Asset) bool {
+		return m.Status(a) != StatusCurrent
+	}
+}
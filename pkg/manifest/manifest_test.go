@@ -0,0 +1,173 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"diogogmt.com/hbd/pkg/dedupe"
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/hbclient"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-manifest.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	order := hbclient.Order{
+		GameKey: "order1",
+		Product: &hbclient.Product{HumanName: "Bundle"},
+		Products: []*hbclient.Product{
+			{HumanName: "Book", MachineName: "book", Downloads: []*hbclient.Download{
+				{Platform: "ebook", Types: []*hbclient.DownloadType{
+					{Name: "PDF", MD5: "aaa", FileSize: 3},
+					{Name: "EPUB", SHA1: "bbb", FileSize: 4},
+				}},
+			}},
+		},
+	}
+	assets := order.Assets()
+	pdf, epub := assets[0], assets[1]
+
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if s := m.Status(pdf); s != StatusNew {
+		t.Errorf("expected new but got %s", s)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Book.pdf"), []byte("pdf"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+	m.Put(pdf, "Book.pdf")
+	m.Put(epub, "Book.epub")
+	m.SetMeta(pdf, &ebookmeta.Metadata{Title: "Practical Malware Analysis", Creators: []string{"Michael Sikorski"}})
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	m, err = Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(m.Entries()) != 2 {
+		t.Fatalf("expected 2 entries but got %d", len(m.Entries()))
+	}
+	if e := m.Get(pdf); e == nil || e.Bundle != "Bundle" || e.Path != "Book.pdf" || e.Key() != "order1/book/ebook/pdf" {
+		t.Errorf("expected the pdf entry to be saved but got %+v", e)
+	}
+	if e := m.Get(pdf); e == nil || e.Meta == nil || e.Meta.Title != "Practical Malware Analysis" || m.Get(epub).Meta != nil {
+		t.Errorf("expected the pdf metadata to be saved but got %+v", e)
+	}
+	dd := []struct {
+		name     string
+		asset    hbclient.Asset
+		edit     func()
+		expected Status
+	}{
+		{name: "current", asset: pdf, expected: StatusCurrent},
+		{name: "missing", asset: epub, expected: StatusMissing},
+		{name: "missing-extracted-dir", asset: epub, edit: func() { m.SetExtracted(epub, "Book") }, expected: StatusMissing},
+		{name: "extracted", asset: epub, edit: func() { os.Mkdir(filepath.Join(dir, "Book"), 0755) }, expected: StatusCurrent},
+		{name: "changed", asset: pdf, edit: func() { pdf.Type.MD5 = "ccc" }, expected: StatusChanged},
+	}
+	for _, d := range dd {
+		if d.edit != nil {
+			d.edit()
+		}
+		if s := m.Status(d.asset); s != d.expected {
+			t.Errorf("%s: expected %s but got %s", d.name, d.expected, s)
+		}
+	}
+	if outdated := assets.Filter(m.Outdated()); len(outdated) != 1 {
+		t.Errorf("expected 1 outdated asset but got %d", len(outdated))
+	}
+}
+
+func TestKeepVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-manifest.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "Bundle"), 0755); err != nil {
+		t.Fatalf("os.MkdirAll: %v", err)
+	}
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	e := Entry{Path: "Bundle/Book.pdf"}
+	for i, edition := range []string{"first", "second", "third"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "Bundle", "Book.pdf"), []byte(edition), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+		e.DownloadedAt = time.Date(2020, time.May, i+1, 0, 0, 0, 0, time.UTC)
+		if _, err := m.KeepVersion(&e, 2); err != nil {
+			t.Fatalf("%s: KeepVersion: %v", edition, err)
+		}
+		// the download replaces the file with a new inode
+		os.Remove(filepath.Join(dir, "Bundle", "Book.pdf"))
+	}
+	versions, err := m.Versions(&e)
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+	expected := []string{"Bundle/.old/Book.20200502T000000Z.pdf", "Bundle/.old/Book.20200503T000000Z.pdf"}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected versions %v but got %v", expected, versions)
+	}
+	by, _ := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(expected[1])))
+	if string(by) != "third" {
+		t.Errorf("expected the newest version to be the third edition but got %q", by)
+	}
+	if version, _ := m.KeepVersion(&e, 0); version != "" {
+		t.Errorf("expected no version to be kept with keep 0")
+	}
+}
+
+func TestKeepVersionSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-manifest.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "Bundle"), 0755); err != nil {
+		t.Fatalf("os.MkdirAll: %v", err)
+	}
+	path := filepath.Join(dir, "Bundle", "Book.pdf")
+	if err := ioutil.WriteFile(path, []byte("first"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+	// the store replaces the file with a relative symlink to its object
+	store, err := dedupe.Open(filepath.Join(dir, dedupe.DefaultDir), dedupe.LinkSymlink)
+	if err != nil {
+		t.Fatalf("dedupe.Open: %v", err)
+	}
+	if _, _, err := store.Add(path); err != nil {
+		t.Fatalf("store.Add: %v", err)
+	}
+	if fi, err := os.Lstat(path); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected the file to be a symlink but got %v %v", fi, err)
+	}
+
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	e := Entry{Path: "Bundle/Book.pdf", DownloadedAt: time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC)}
+	version, err := m.KeepVersion(&e, 1)
+	if err != nil {
+		t.Fatalf("KeepVersion: %v", err)
+	}
+	if by, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(version))); err != nil || string(by) != "first" {
+		t.Errorf("expected the kept version to hold the first edition but got %q %v", by, err)
+	}
+}
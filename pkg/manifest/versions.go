@@ -0,0 +1,119 @@
+package manifest
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OldDir is the directory, next to the downloaded files, keeping their previous versions
+const OldDir = ".old"
+
+// versionLayout is the timestamp added to the name of previous versions, it sorts by time
+const versionLayout = "20060102T150405Z"
+
+// VersionPath is where the previous version of an entry is kept, relative to
+// the manifest directory, eg; Bundle/.old/Book.20200506T103015Z.pdf
+func VersionPath(e *Entry) string {
+	dir, name := path.Split(e.Path)
+	ext := path.Ext(name)
+	at := e.DownloadedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return path.Join(dir, OldDir, strings.TrimSuffix(name, ext)+"."+at.UTC().Format(versionLayout)+ext)
+}
+
+// KeepVersion copies the file of an entry to its VersionPath before it's replaced,
+// and removes the oldest versions of the file beyond keep, a file is hard linked
+// when the filesystem allows it. Symlinks, eg; into a dedupe store, are
+// resolved first, their relative target doesn't resolve from the OldDir.
+func (m *Manifest) KeepVersion(e *Entry, keep int) (string, error) {
+	if keep <= 0 {
+		return "", nil
+	}
+	src, err := filepath.EvalSymlinks(filepath.Join(m.dir, filepath.FromSlash(e.Path)))
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving %s", e.Path)
+	}
+	version := VersionPath(e)
+	dst := filepath.Join(m.dir, filepath.FromSlash(version))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", errors.Wrapf(err, "os.MkdirAll %s", filepath.Dir(dst))
+	}
+	if err := os.Link(src, dst); err != nil {
+		if err := copyFile(src, dst); err != nil {
+			return "", err
+		}
+	}
+	if err := m.pruneVersions(e, keep); err != nil {
+		return version, err
+	}
+	return version, nil
+}
+
+// Versions lists the previous versions of an entry, oldest first, relative to the manifest directory
+func (m *Manifest) Versions(e *Entry) ([]string, error) {
+	dir, name := path.Split(e.Path)
+	ext := path.Ext(name)
+	prefix := strings.TrimSuffix(name, ext) + "."
+	files, err := ioutil.ReadDir(filepath.Join(m.dir, filepath.FromSlash(dir), OldDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadDir versions")
+	}
+	versions := []string{}
+	for _, f := range files {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(f.Name(), prefix), ext)
+		if !strings.HasPrefix(f.Name(), prefix) || !strings.HasSuffix(f.Name(), ext) || len(stamp) != len(versionLayout) {
+			continue
+		}
+		if _, err := time.Parse(versionLayout, stamp); err != nil {
+			continue
+		}
+		versions = append(versions, path.Join(dir, OldDir, f.Name()))
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// pruneVersions removes the oldest versions of an entry beyond keep
+func (m *Manifest) pruneVersions(e *Entry, keep int) error {
+	versions, err := m.Versions(e)
+	if err != nil {
+		return err
+	}
+	for len(versions) > keep {
+		if err := os.Remove(filepath.Join(m.dir, filepath.FromSlash(versions[0]))); err != nil {
+			return errors.Wrap(err, "os.Remove old version")
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "os.Open %s", src)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "os.OpenFile %s", dst)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return errors.Wrapf(err, "copying %s", src)
+	}
+	return errors.Wrapf(out.Close(), "closing %s", dst)
+}
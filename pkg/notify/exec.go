@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Exec runs a shell command for every notification, the notification is
+// passed as HBD_* env vars and as JSON on stdin
+type Exec struct {
+	Command string
+}
+
+func (e *Exec) String() string {
+	return "exec " + e.Command
+}
+
+// Notify runs the command and fails when it exits with a non zero status
+func (e *Exec) Notify(ctx context.Context, n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal notification")
+	}
+	cmd := shellCommand(ctx, e.Command)
+	cmd.Env = append(os.Environ(),
+		"HBD_EVENT="+n.Event,
+		"HBD_TIME="+n.Time.Format("2006-01-02T15:04:05Z07:00"),
+		"HBD_SUBJECT="+n.Subject,
+		"HBD_MESSAGE="+n.Message,
+		"HBD_PAYLOAD="+string(payload),
+	)
+	cmd.Stdin = bytes.NewReader(payload)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return errors.Wrapf(err, "%s", out)
+		}
+		return err
+	}
+	return nil
+}
+
+// shellCommand runs command through the platform shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
@@ -0,0 +1,135 @@
+// Package notify sends notifications about syncs to commands, webhooks and email.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// notification events
+const (
+	EventNewAssets      = "new_assets"
+	EventChecksumFailed = "checksum_failed"
+	EventAuthExpired    = "auth_expired"
+	EventRunFailed      = "run_failed"
+	EventRunFinished    = "run_finished"
+	// EventAll subscribes a notifier to every event
+	EventAll = "all"
+)
+
+// Events lists the events notifiers can subscribe to
+var Events = []string{EventNewAssets, EventChecksumFailed, EventAuthExpired, EventRunFailed, EventRunFinished}
+
+// Notification is the payload sent to notifiers, Data carries the event
+// details, eg; the run status, and is encoded as is in JSON payloads
+type Notification struct {
+	Event   string      `json:"event"`
+	Time    time.Time   `json:"time"`
+	Subject string      `json:"subject"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Notifier delivers notifications
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Dispatcher sends notifications to the notifiers subscribed to their event
+type Dispatcher struct {
+	mu      sync.Mutex
+	targets []target
+	timeout time.Duration
+}
+
+type target struct {
+	notifier Notifier
+	events   map[string]bool
+}
+
+// NewDispatcher creates a dispatcher giving each notifier timeout to deliver a notification
+func NewDispatcher(timeout time.Duration) *Dispatcher {
+	return &Dispatcher{timeout: timeout}
+}
+
+// Add subscribes a notifier to events, no events or EventAll subscribe it to all of them
+func (d *Dispatcher) Add(n Notifier, events ...string) {
+	t := target{notifier: n, events: map[string]bool{}}
+	for _, e := range events {
+		t.events[e] = true
+	}
+	if len(events) == 0 {
+		t.events[EventAll] = true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.targets = append(d.targets, t)
+}
+
+// Len is the number of notifiers
+func (d *Dispatcher) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.targets)
+}
+
+// Notify delivers n to the subscribed notifiers concurrently, it returns the
+// failed deliveries once all of them are done
+func (d *Dispatcher) Notify(ctx context.Context, n *Notification) error {
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+	d.mu.Lock()
+	targets := append([]target{}, d.targets...)
+	d.mu.Unlock()
+
+	var (
+		mu    sync.Mutex
+		errs  []string
+		group sync.WaitGroup
+	)
+	for _, t := range targets {
+		if !t.events[n.Event] && !t.events[EventAll] {
+			continue
+		}
+		t := t
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			notifyCtx := ctx
+			if d.timeout > 0 {
+				var cancel context.CancelFunc
+				notifyCtx, cancel = context.WithTimeout(ctx, d.timeout)
+				defer cancel()
+			}
+			if err := t.notifier.Notify(notifyCtx, n); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", t.notifier, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	group.Wait()
+	if len(errs) != 0 {
+		return errors.Errorf("notify %s: %s", n.Event, strings.Join(errs, " - "))
+	}
+	return nil
+}
+
+// ValidEvent reports whether e is a known event or EventAll
+func ValidEvent(e string) bool {
+	if e == EventAll {
+		return true
+	}
+	for _, known := range Events {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Spec is a notifier and the events it's subscribed to, parsed from
+// EVENTS:KIND:TARGET, eg; new_assets,auth_expired:webhook:https://example.com/hook
+type Spec struct {
+	Events   []string
+	Notifier Notifier
+}
+
+// ParseSpec parses a notifier spec, KIND is exec, webhook or smtp
+func ParseSpec(s string) (*Spec, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return nil, errors.Errorf("invalid notifier %q, expected EVENTS:KIND:TARGET, eg; all:exec:/path/to/script", redactURL(s))
+	}
+	spec := Spec{}
+	for _, e := range strings.Split(parts[0], ",") {
+		e = strings.TrimSpace(e)
+		if !ValidEvent(e) {
+			return nil, errors.Errorf("unknown event %q, expected all or one of %s", e, strings.Join(Events, ", "))
+		}
+		spec.Events = append(spec.Events, e)
+	}
+	target := parts[2]
+	switch parts[1] {
+	case "exec":
+		spec.Notifier = &Exec{Command: target}
+	case "webhook":
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.Errorf("invalid webhook url %q", redactURL(target))
+		}
+		spec.Notifier = &Webhook{URL: target}
+	case "smtp":
+		n, err := ParseSMTP(target)
+		if err != nil {
+			return nil, err
+		}
+		spec.Notifier = n
+	default:
+		return nil, errors.Errorf("unknown notifier %q, expected exec, webhook or smtp", parts[1])
+	}
+	return &spec, nil
+}
+
+// Specs is a repeatable flag of notifier specs
+type Specs []*Spec
+
+func (s *Specs) String() string {
+	if s == nil {
+		return ""
+	}
+	specs := []string{}
+	for _, spec := range *s {
+		specs = append(specs, strings.Join(spec.Events, ",")+" "+fmt.Sprint(spec.Notifier))
+	}
+	return strings.Join(specs, "; ")
+}
+
+// Set parses and appends a spec
+func (s *Specs) Set(v string) error {
+	spec, err := ParseSpec(v)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, spec)
+	return nil
+}
+
+// Dispatcher subscribes the notifiers of all specs to a new dispatcher
+func (s Specs) Dispatcher(d *Dispatcher) *Dispatcher {
+	for _, spec := range s {
+		d.Add(spec.Notifier, spec.Events...)
+	}
+	return d
+}
+
+// redactURL hides the credentials and query of a url so it can be logged
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	if u.User != nil {
+		u.User = url.User("xxx")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "xxx"
+	}
+	return u.String()
+}
@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook posts every notification as JSON to a URL
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *Webhook) String() string {
+	return "webhook " + redactURL(w.URL)
+}
+
+// Notify posts the notification and fails on non 2xx responses
+func (w *Webhook) Notify(ctx context.Context, n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal notification")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "http.NewRequestWithContext")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hbd")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "httpClient.Do")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("unexpected response %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package opds
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"diogogmt.com/hbd/pkg/manifest"
+)
+
+// mimeTypes are the ebook formats served, other files of the destination are left out
+var mimeTypes = map[string]string{
+	".epub": "application/epub+zip",
+	".pdf":  "application/pdf",
+	".mobi": "application/x-mobipocket-ebook",
+	".azw3": "application/vnd.amazon.ebook",
+	".cbz":  "application/vnd.comicbook+zip",
+	".cbr":  "application/vnd.comicbook-rar",
+	".djvu": "image/vnd.djvu",
+	".fb2":  "application/x-fictionbook+xml",
+}
+
+// MIMEType returns the media type of an ebook file, or an empty string when it isn't one
+func MIMEType(filename string) string {
+	return mimeTypes[strings.ToLower(filepath.Ext(filename))]
+}
+
+// Format is a file of a book
+type Format struct {
+	// Path is relative to the destination directory, with forward slashes
+	Path     string
+	MIMEType string
+	Size     int64
+}
+
+// Book is a product with its ebook formats
+type Book struct {
+	ID        string
+	Title     string
+	Authors   []string
+	Product   string
+	Bundle    *Bundle
+	Publisher string
+	Language  string
+	ISBN      string
+	Updated   time.Time
+	Formats   []*Format
+	// Cover is the EPUB format the cover image is read from
+	Cover *Format
+}
+
+// Bundle groups the books downloaded from an order
+type Bundle struct {
+	ID      string
+	Name    string
+	Updated time.Time
+	Books   []*Book
+}
+
+// Catalog is the set of books of a destination directory
+type Catalog struct {
+	Bundles []*Bundle
+	Books   []*Book
+	Updated time.Time
+
+	books map[string]*Book
+	files map[string]*Format
+}
+
+// Book returns a book by its id, or nil
+func (c *Catalog) Book(id string) *Book {
+	return c.books[id]
+}
+
+// Bundle returns a bundle by its id, or nil
+func (c *Catalog) Bundle(id string) *Bundle {
+	for _, b := range c.Bundles {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// Format returns a format by its path, or nil, only catalog files can be downloaded
+func (c *Catalog) Format(path string) *Format {
+	return c.files[path]
+}
+
+// Search returns the books matching every word of the query in their title,
+// authors, bundle or ISBN
+func (c *Catalog) Search(query string) []*Book {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil
+	}
+	books := []*Book{}
+	for _, b := range c.Books {
+		text := strings.ToLower(strings.Join(append([]string{b.Title, b.Product, b.Bundle.Name, b.ISBN}, b.Authors...), " "))
+		match := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				match = false
+				break
+			}
+		}
+		if match {
+			books = append(books, b)
+		}
+	}
+	return books
+}
+
+// file is an ebook file found in the destination directory
+type file struct {
+	path    string
+	bundle  string
+	product string
+	meta    *ebookmeta.Metadata
+	info    os.FileInfo
+}
+
+// catalogBuilder groups the files of a destination into bundles and books
+type catalogBuilder struct {
+	dest string
+	// readMeta reads the metadata of a file missing from the manifest
+	readMeta func(path string, info os.FileInfo) *ebookmeta.Metadata
+}
+
+// build reads the manifest of the destination, falling back to a scan of its
+// directories when nothing was recorded, eg; files downloaded with hbd download
+func (cb *catalogBuilder) build() (*Catalog, error) {
+	m, err := manifest.Load(cb.dest)
+	if err != nil {
+		return nil, err
+	}
+	files := []*file{}
+	for _, e := range m.Entries() {
+		if MIMEType(e.Path) == "" {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(cb.dest, filepath.FromSlash(e.Path)))
+		if err != nil {
+			continue
+		}
+		f := file{path: e.Path, bundle: e.Bundle, product: e.Product, meta: e.Meta, info: info}
+		if f.bundle == "" {
+			f.bundle = topDir(e.Path)
+		}
+		files = append(files, &f)
+	}
+	if len(files) == 0 {
+		if files, err = cb.scan(); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range files {
+		if f.meta == nil && cb.readMeta != nil {
+			f.meta = cb.readMeta(filepath.Join(cb.dest, filepath.FromSlash(f.path)), f.info)
+		}
+	}
+	return newCatalog(files), nil
+}
+
+// scan finds the ebook files of the destination, the bundle is the top
+// directory and files of a directory sharing a name are formats of a book
+func (cb *catalogBuilder) scan() ([]*file, error) {
+	files := []*file{}
+	err := filepath.Walk(cb.dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == cb.dest {
+				return err
+			}
+			return nil
+		}
+		// .old versions, changelogs and partial downloads aren't books
+		if strings.HasPrefix(info.Name(), ".") && path != cb.dest {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || MIMEType(path) == "" {
+			return nil
+		}
+		rel, err := filepath.Rel(cb.dest, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		name := filepath.Base(path)
+		files = append(files, &file{
+			path:    rel,
+			bundle:  topDir(rel),
+			product: strings.TrimSuffix(name, filepath.Ext(name)),
+			info:    info,
+		})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	return files, err
+}
+
+// topDir is the first directory of a relative path, files at the root belong to no bundle
+func topDir(path string) string {
+	if i := strings.Index(path, "/"); i > 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func newCatalog(files []*file) *Catalog {
+	c := Catalog{books: map[string]*Book{}, files: map[string]*Format{}}
+	bundles := map[string]*Bundle{}
+	// prefer the EPUB metadata, then the PDF, the other formats don't carry any
+	sort.SliceStable(files, func(i, j int) bool {
+		return metaRank(files[i].path) < metaRank(files[j].path)
+	})
+	for _, f := range files {
+		bundle := bundles[f.bundle]
+		if bundle == nil {
+			bundle = &Bundle{ID: shortID(f.bundle), Name: f.bundle}
+			if bundle.Name == "" {
+				bundle.Name = "Other"
+			}
+			bundles[f.bundle] = bundle
+			c.Bundles = append(c.Bundles, bundle)
+		}
+		// the books of a scan are grouped by directory too, calibre keeps one book per directory
+		id := shortID(f.bundle + "/" + parentDir(f.path) + "/" + f.product)
+		b := c.books[id]
+		if b == nil {
+			b = &Book{ID: id, Title: f.product, Product: f.product, Bundle: bundle}
+			c.books[id] = b
+			c.Books = append(c.Books, b)
+			bundle.Books = append(bundle.Books, b)
+		}
+		format := Format{Path: f.path, MIMEType: MIMEType(f.path), Size: f.info.Size()}
+		b.Formats = append(b.Formats, &format)
+		c.files[f.path] = &format
+		if f.meta != nil {
+			b.apply(f.meta, &format)
+		}
+		for _, t := range []*time.Time{&b.Updated, &bundle.Updated, &c.Updated} {
+			if f.info.ModTime().After(*t) {
+				*t = f.info.ModTime().UTC()
+			}
+		}
+	}
+	sort.Slice(c.Bundles, func(i, j int) bool {
+		return strings.ToLower(c.Bundles[i].Name) < strings.ToLower(c.Bundles[j].Name)
+	})
+	for _, books := range append([][]*Book{c.Books}, bundleBooks(c.Bundles)...) {
+		sortBooks(books)
+	}
+	for _, b := range c.Books {
+		sort.Slice(b.Formats, func(i, j int) bool { return b.Formats[i].Path < b.Formats[j].Path })
+	}
+	return &c
+}
+
+// apply takes what the first format with metadata says about the book
+func (b *Book) apply(meta *ebookmeta.Metadata, f *Format) {
+	if b.Cover == nil && meta.Cover != "" && f.MIMEType == mimeTypes[".epub"] {
+		b.Cover = f
+	}
+	if b.Title != b.Product || meta.Title == "" {
+		return
+	}
+	b.Title = meta.Title
+	b.Authors = meta.Creators
+	b.Publisher = meta.Publisher
+	b.Language = meta.Language
+	b.ISBN = meta.ISBN()
+}
+
+func metaRank(path string) int {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".epub":
+		return 0
+	case ".pdf":
+		return 1
+	}
+	return 2
+}
+
+// parentDir is the directory of a relative slash path
+func parentDir(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+func bundleBooks(bundles []*Bundle) [][]*Book {
+	books := make([][]*Book, len(bundles))
+	for i, b := range bundles {
+		books[i] = b.Books
+	}
+	return books
+}
+
+func sortBooks(books []*Book) {
+	sort.Slice(books, func(i, j int) bool {
+		return strings.ToLower(books[i].Title) < strings.ToLower(books[j].Title)
+	})
+}
+
+// shortID is a stable id safe in urls
+func shortID(s string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(s)))[:12]
+}
@@ -0,0 +1,139 @@
+package opds
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// feed is an Atom feed with the OPDS, Dublin Core and OpenSearch extensions
+type feed struct {
+	XMLName      xml.Name `xml:"feed"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	XmlnsDC      string   `xml:"xmlns:dc,attr"`
+	XmlnsOPDS    string   `xml:"xmlns:opds,attr"`
+	XmlnsOS      string   `xml:"xmlns:opensearch,attr"`
+	ID           string   `xml:"id"`
+	Title        string   `xml:"title"`
+	Updated      string   `xml:"updated"`
+	Author       person   `xml:"author"`
+	TotalResults int      `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage int      `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   int      `xml:"opensearch:startIndex,omitempty"`
+	Links        []link   `xml:"link"`
+	Entries      []entry  `xml:"entry"`
+
+	// self is the path of the feed, pages add their number to it
+	self string
+}
+
+type person struct {
+	Name string `xml:"name"`
+}
+
+type link struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Title  string `xml:"title,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type entry struct {
+	ID         string     `xml:"id"`
+	Title      string     `xml:"title"`
+	Updated    string     `xml:"updated"`
+	Authors    []person   `xml:"author"`
+	Publisher  string     `xml:"dc:publisher,omitempty"`
+	Language   string     `xml:"dc:language,omitempty"`
+	Identifier string     `xml:"dc:identifier,omitempty"`
+	Categories []category `xml:"category"`
+	Content    *content   `xml:"content"`
+	Links      []link     `xml:"link"`
+}
+
+type category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type content struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+func (s *Server) newFeed(id, title, self, mediaType string, updated time.Time) *feed {
+	return &feed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		XmlnsOS:   "http://a9.com/-/spec/opensearch/1.1/",
+		ID:        id,
+		Title:     title,
+		Updated:   atomTime(updated),
+		Author:    person{Name: "hbd"},
+		Links: []link{
+			{Rel: "self", Href: self, Type: mediaType},
+			{Rel: "start", Href: "/opds", Type: navigationType, Title: s.title},
+			{Rel: "up", Href: "/opds", Type: navigationType},
+			{Rel: "search", Href: "/opds/opensearch.xml", Type: openSearchType},
+		},
+		self: self,
+	}
+}
+
+func navEntry(id, title, text, href, rel string, updated time.Time) entry {
+	return entry{
+		ID:      id,
+		Title:   title,
+		Updated: atomTime(updated),
+		Content: &content{Type: "text", Text: text},
+		Links:   []link{{Rel: rel, Href: href, Type: acquisitionType}},
+	}
+}
+
+// bookEntry describes a book with an acquisition link per format
+func bookEntry(b *Book) entry {
+	e := entry{
+		ID:        "urn:hbd:book:" + b.ID,
+		Title:     b.Title,
+		Updated:   atomTime(b.Updated),
+		Publisher: b.Publisher,
+		Language:  b.Language,
+		Content:   &content{Type: "text", Text: b.Bundle.Name},
+	}
+	if b.ISBN != "" {
+		e.Identifier = "urn:isbn:" + b.ISBN
+	}
+	for _, a := range b.Authors {
+		e.Authors = append(e.Authors, person{Name: a})
+	}
+	e.Categories = append(e.Categories, category{Term: b.Bundle.ID, Label: b.Bundle.Name})
+	if b.Cover != nil {
+		e.Links = append(e.Links,
+			link{Rel: relImage, Href: "/opds/covers/" + b.ID},
+			link{Rel: relThumbnail, Href: "/opds/covers/" + b.ID + "/thumbnail", Type: "image/jpeg"},
+		)
+	}
+	for _, f := range b.Formats {
+		e.Links = append(e.Links, link{Rel: relAcquisition, Href: fileURL(f.Path), Type: f.MIMEType, Length: f.Size})
+	}
+	return e
+}
+
+// fileURL escapes every segment of a relative path
+func fileURL(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return "/opds/files/" + strings.Join(segments, "/")
+}
+
+func atomTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,265 @@
+package opds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"diogogmt.com/hbd/pkg/ebookmeta/epubtest"
+	"diogogmt.com/hbd/pkg/manifest"
+)
+
+// writeTestEPUB writes an EPUB with a title, an author and a png cover
+func writeTestEPUB(t *testing.T, path, title, author string, cover []byte) {
+	opf := `<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>` + title + `</dc:title><dc:creator>` + author + `</dc:creator>
+    <dc:identifier>urn:isbn:978-1-59327-590-7</dc:identifier><dc:language>en</dc:language>
+  </metadata>
+  <manifest><item id="cover" href="cover.png" media-type="image/png" properties="cover-image"/></manifest>
+</package>`
+	if err := epubtest.Write(path, opf, "OEBPS/cover.png", string(cover)); err != nil {
+		t.Fatalf("epubtest.Write: %v", err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("os.MkdirAll: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+}
+
+// testFeed is the part of a feed the tests look at
+type testFeed struct {
+	Title        string `xml:"title"`
+	TotalResults int    `xml:"totalResults"`
+	Links        []link `xml:"link"`
+	Entries      []struct {
+		ID      string   `xml:"id"`
+		Title   string   `xml:"title"`
+		Authors []person `xml:"author"`
+		ISBN    string   `xml:"identifier"`
+		Links   []link   `xml:"link"`
+	} `xml:"entry"`
+}
+
+func (f *testFeed) link(rel string) string {
+	for _, l := range f.Links {
+		if l.Rel == rel {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+func TestServer(t *testing.T) {
+	dest, err := ioutil.TempDir("", "hbd-opds.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dest)
+
+	img := image.NewRGBA(image.Rect(0, 0, 600, 900))
+	for y := 0; y < 900; y++ {
+		for x := 0; x < 600; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var cover bytes.Buffer
+	png.Encode(&cover, img)
+	writeTestEPUB(t, filepath.Join(dest, "Book Bundle", "Hacking Bundle Book 1.epub"), "Black Hat Go", "Tom Steele", cover.Bytes())
+	writeFile(t, filepath.Join(dest, "Book Bundle", "Hacking Bundle Book 1.pdf"), "pdf content")
+	writeFile(t, filepath.Join(dest, "Book Bundle", "Another Book.mobi"), "mobi content")
+	writeFile(t, filepath.Join(dest, "Book Bundle", ".old", "Another Book.20200506T103015Z.mobi"), "old mobi")
+	writeFile(t, filepath.Join(dest, "Comics Bundle", "Issue #1.cbz"), "cbz")
+	writeFile(t, filepath.Join(dest, "Comics Bundle", "Soundtrack.zip"), "zip")
+
+	s := New(dest, WithBasicAuth("reader", "secret"), WithPageSize(2))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	get := func(path string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.SetBasicAuth("reader", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, body
+	}
+	getFeed := func(path, mediaType string) *testFeed {
+		t.Helper()
+		resp, body := get(path)
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), mediaType) {
+			t.Fatalf("GET %s: expected a %s feed but got %d %s: %s", path, mediaType, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+		f := testFeed{}
+		if err := xml.Unmarshal(body, &f); err != nil {
+			t.Fatalf("GET %s: xml.Unmarshal: %v", path, err)
+		}
+		return &f
+	}
+
+	resp, err := http.Get(srv.URL + "/opds")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized without credentials but got %v %v", resp, err)
+	}
+
+	root := getFeed("/opds", navigationType)
+	titles := []string{}
+	for _, e := range root.Entries {
+		titles = append(titles, e.Title)
+	}
+	if strings.Join(titles, ",") != "All books,Recently downloaded,Book Bundle,Comics Bundle" {
+		t.Fatalf("expected the books, new and bundle entries but got %v", titles)
+	}
+	if root.link("search") != "/opds/opensearch.xml" {
+		t.Errorf("expected a search link but got %+v", root.Links)
+	}
+
+	bundle := getFeed(root.Entries[2].Links[0].Href, acquisitionType)
+	if bundle.Title != "Book Bundle" || len(bundle.Entries) != 2 {
+		t.Fatalf("expected the 2 books of the bundle but got %+v", bundle)
+	}
+	another, book := bundle.Entries[0], bundle.Entries[1]
+	if another.Title != "Another Book" || len(another.Links) != 1 || another.Links[0].Type != "application/x-mobipocket-ebook" {
+		t.Errorf("expected the mobi book without cover but got %+v", another)
+	}
+	if book.Title != "Black Hat Go" || len(book.Authors) != 1 || book.Authors[0].Name != "Tom Steele" || book.ISBN != "urn:isbn:9781593275907" {
+		t.Errorf("expected the epub metadata but got %+v", book)
+	}
+	links := map[string]link{}
+	for _, l := range book.Links {
+		links[l.Type+" "+l.Rel] = l
+	}
+	epub, pdf := links["application/epub+zip "+relAcquisition], links["application/pdf "+relAcquisition]
+	if epub.Href != "/opds/files/Book%20Bundle/Hacking%20Bundle%20Book%201.epub" || pdf.Length != int64(len("pdf content")) {
+		t.Errorf("expected epub and pdf acquisition links but got %+v", book.Links)
+	}
+
+	resp, body := get(pdf.Href)
+	if resp.StatusCode != http.StatusOK || string(body) != "pdf content" || resp.Header.Get("Content-Type") != "application/pdf" {
+		t.Errorf("expected the pdf but got %d %s %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	for _, path := range []string{"/opds/files/Book%20Bundle/.old/Another%20Book.20200506T103015Z.mobi", "/opds/files/Comics%20Bundle/Soundtrack.zip", "/opds/files/../../etc/passwd"} {
+		if resp, _ := get(path); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: expected not found but got %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, body = get(links[" "+relImage].Href)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, cover.Bytes()) || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("expected the png cover but got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	resp, body = get(links["image/jpeg "+relThumbnail].Href)
+	thumb, err := jpeg.Decode(bytes.NewReader(body))
+	if err != nil || thumb.Bounds().Dx() != 200 || thumb.Bounds().Dy() != 300 {
+		t.Errorf("expected a 200x300 jpeg thumbnail but got %v %v", thumb, err)
+	}
+
+	// the all books feed is paginated
+	all := getFeed("/opds/books", acquisitionType)
+	if all.TotalResults != 3 || len(all.Entries) != 2 || all.link("next") != "/opds/books?page=2" {
+		t.Fatalf("expected the first page of 3 books but got %+v", all)
+	}
+	if next := getFeed(all.link("next"), acquisitionType); len(next.Entries) != 1 || next.Entries[0].Title != "Issue #1" || next.link("previous") != "/opds/books?page=1" {
+		t.Errorf("expected the last book on the second page but got %+v", next)
+	}
+
+	// the catalog is reused until it expires
+	writeFile(t, filepath.Join(dest, "Comics Bundle", "Issue #2.cbz"), "cbz")
+	if all := getFeed("/opds/books", acquisitionType); all.TotalResults != 3 {
+		t.Errorf("expected the cached catalog but got %d books", all.TotalResults)
+	}
+	s.mu.Lock()
+	s.cachedAt = s.cachedAt.Add(-catalogTTL)
+	s.mu.Unlock()
+	if all := getFeed("/opds/books", acquisitionType); all.TotalResults != 4 {
+		t.Errorf("expected the new book once the catalog expired but got %d books", all.TotalResults)
+	}
+	os.Remove(filepath.Join(dest, "Comics Bundle", "Issue #2.cbz"))
+	s.mu.Lock()
+	s.cachedAt = time.Time{}
+	s.mu.Unlock()
+
+	resp, body = get("/opds/opensearch.xml")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `template="/opds/search?q={searchTerms}"`) {
+		t.Errorf("expected an opensearch description but got %s", body)
+	}
+	for q, expected := range map[string]int{"steele": 1, "black go": 1, "bundle": 3, "9781593275907": 1, "missing": 0} {
+		if results := getFeed("/opds/search?q="+url.QueryEscape(q), acquisitionType); results.TotalResults != expected {
+			t.Errorf("search %q: expected %d books but got %d", q, expected, results.TotalResults)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	// a png header declaring a 60000x60000 image, decoding it would take gigabytes
+	ihdr := []byte("IHDR\x00\x00\xea\x60\x00\x00\xea\x60\x08\x06\x00\x00\x00")
+	var huge bytes.Buffer
+	huge.WriteString("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d")
+	huge.Write(ihdr)
+	binary.Write(&huge, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	if _, err := thumbnail(huge.Bytes(), 300); err == nil || !strings.Contains(err.Error(), "60000x60000") {
+		t.Errorf("expected a huge cover to be refused but got %v", err)
+	}
+
+	var small bytes.Buffer
+	png.Encode(&small, image.NewRGBA(image.Rect(0, 0, 20, 30)))
+	thumb, err := thumbnail(small.Bytes(), 300)
+	if err != nil {
+		t.Fatalf("thumbnail: %v", err)
+	}
+	if config, err := jpeg.DecodeConfig(bytes.NewReader(thumb)); err != nil || config.Width != 20 || config.Height != 30 {
+		t.Errorf("expected a small cover to keep its size but got %+v %v", config, err)
+	}
+}
+
+func TestManifestCatalog(t *testing.T) {
+	dest, err := ioutil.TempDir("", "hbd-opds.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dest)
+	writeFile(t, filepath.Join(dest, "Book Bundle", "Book.pdf"), "pdf")
+	writeFile(t, filepath.Join(dest, "Book Bundle", "Book.epub"), "not an epub")
+	writeFile(t, filepath.Join(dest, "Book Bundle", "Unrecorded.pdf"), "pdf")
+	writeFile(t, filepath.Join(dest, manifest.Filename), `{"entries": [
+		{"order": "o1", "bundle": "Humble Book Bundle", "id": "book/ebook/pdf", "product": "Book", "path": "Book Bundle/Book.pdf",
+			"meta": {"title": "Practical Malware Analysis", "creators": ["Michael Sikorski", "Andrew Honig"]}},
+		{"order": "o1", "bundle": "Humble Book Bundle", "id": "book/ebook/epub", "product": "Book", "path": "Book Bundle/Book.epub"},
+		{"order": "o1", "bundle": "Humble Book Bundle", "id": "gone/ebook/pdf", "product": "Gone", "path": "Book Bundle/Gone.pdf"}
+	]}`)
+
+	c, err := New(dest).Catalog()
+	if err != nil {
+		t.Fatalf("Catalog: %v", err)
+	}
+	if len(c.Bundles) != 1 || c.Bundles[0].Name != "Humble Book Bundle" || len(c.Books) != 1 {
+		t.Fatalf("expected the recorded book of the bundle but got %+v", c.Bundles)
+	}
+	b := c.Books[0]
+	if b.Title != "Practical Malware Analysis" || len(b.Authors) != 2 || len(b.Formats) != 2 || b.Cover != nil {
+		t.Errorf("expected the recorded metadata and formats but got %+v", b)
+	}
+}
@@ -0,0 +1,393 @@
+// Package opds serves the ebooks of a destination directory as an OPDS 1.2
+// catalog, the Atom feeds e-reader apps browse and download books from.
+package opds
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"diogogmt.com/hbd/pkg/ebookmeta"
+	"github.com/pkg/errors"
+)
+
+// feed media types
+const (
+	navigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	acquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	openSearchType  = "application/opensearchdescription+xml"
+)
+
+// link relations of the OPDS spec
+const (
+	relAcquisition = "http://opds-spec.org/acquisition"
+	relImage       = "http://opds-spec.org/image"
+	relThumbnail   = "http://opds-spec.org/image/thumbnail"
+	relNew         = "http://opds-spec.org/sort/new"
+)
+
+// Server is the OPDS catalog http.Handler
+type Server struct {
+	dest     string
+	title    string
+	user     string
+	password string
+	pageSize int
+	thumb    int
+	mux      *http.ServeMux
+
+	mu sync.Mutex
+	// meta caches the metadata of files missing from the manifest by path, size and mtime
+	meta map[string]cachedMeta
+	// cached is the catalog read at cachedAt, reused for catalogTTL
+	cached   *Catalog
+	cachedAt time.Time
+}
+
+// catalogTTL is how long a catalog is reused, e-readers fetch a feed, its
+// covers and files in a burst of requests
+const catalogTTL = 10 * time.Second
+
+type cachedMeta struct {
+	size    int64
+	modTime time.Time
+	meta    *ebookmeta.Metadata
+}
+
+// Option defines the signature for functional options to be applied to the server
+type Option = func(s *Server)
+
+// New creates an OPDS server for the ebooks downloaded into dest
+func New(dest string, opts ...Option) *Server {
+	s := Server{
+		dest:     dest,
+		title:    "Humble Bundle",
+		pageSize: 50,
+		thumb:    300,
+		mux:      http.NewServeMux(),
+		meta:     map[string]cachedMeta{},
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	s.mux.HandleFunc("/", s.handleRoot)
+	s.mux.HandleFunc("/opds", s.handleNavigation)
+	s.mux.HandleFunc("/opds/books", s.handleBooks)
+	s.mux.HandleFunc("/opds/new", s.handleNew)
+	s.mux.HandleFunc("/opds/bundles/", s.handleBundle)
+	s.mux.HandleFunc("/opds/search", s.handleSearch)
+	s.mux.HandleFunc("/opds/opensearch.xml", s.handleOpenSearch)
+	s.mux.HandleFunc("/opds/covers/", s.handleCover)
+	s.mux.HandleFunc("/opds/files/", s.handleFile)
+	return &s
+}
+
+// WithBasicAuth requires every request to carry the user and password
+func WithBasicAuth(user, password string) Option {
+	return func(s *Server) {
+		s.user = user
+		s.password = password
+	}
+}
+
+// WithTitle sets the title of the catalog shown by e-readers
+func WithTitle(title string) Option {
+	return func(s *Server) {
+		s.title = title
+	}
+}
+
+// WithPageSize sets how many books an acquisition feed lists per page
+func WithPageSize(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.pageSize = n
+		}
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.user != "" || s.password != "" {
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hbd"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve listens on addr until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	srv := http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return errors.Wrap(err, "http.ListenAndServe")
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return errors.Wrap(srv.Shutdown(shutdownCtx), "http.Shutdown")
+	}
+}
+
+// Catalog reads the books of the destination, requests reuse it for a few
+// seconds so new downloads show up without a restart
+func (s *Server) Catalog() (*Catalog, error) {
+	cb := catalogBuilder{dest: s.dest, readMeta: s.readMeta}
+	return cb.build()
+}
+
+func (s *Server) readMeta(path string, info os.FileInfo) *ebookmeta.Metadata {
+	s.mu.Lock()
+	cached, ok := s.meta[path]
+	s.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.meta
+	}
+	meta, err := ebookmeta.Read(path)
+	if err != nil {
+		meta = nil
+	}
+	s.mu.Lock()
+	s.meta[path] = cachedMeta{size: info.Size(), modTime: info.ModTime(), meta: meta}
+	s.mu.Unlock()
+	return meta
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, "/opds", http.StatusFound)
+}
+
+// handleNavigation lists the all books and new books feeds and a feed per bundle
+func (s *Server) handleNavigation(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.catalog(w)
+	if !ok {
+		return
+	}
+	f := s.newFeed("urn:hbd:root", s.title, "/opds", navigationType, c.Updated)
+	f.Entries = append(f.Entries,
+		navEntry("urn:hbd:books", "All books", fmt.Sprintf("%d books", len(c.Books)), "/opds/books", "subsection", c.Updated),
+		navEntry("urn:hbd:new", "Recently downloaded", "The latest books first", "/opds/new", relNew, c.Updated),
+	)
+	for _, b := range c.Bundles {
+		f.Entries = append(f.Entries, navEntry("urn:hbd:bundle:"+b.ID, b.Name, fmt.Sprintf("%d books", len(b.Books)), "/opds/bundles/"+b.ID, "subsection", b.Updated))
+	}
+	writeXML(w, f, navigationType)
+}
+
+func (s *Server) handleBooks(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.catalog(w)
+	if !ok {
+		return
+	}
+	s.writeBooks(w, r, s.newFeed("urn:hbd:books", "All books", "/opds/books", acquisitionType, c.Updated), c.Books)
+}
+
+func (s *Server) handleNew(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.catalog(w)
+	if !ok {
+		return
+	}
+	books := append([]*Book{}, c.Books...)
+	sort.SliceStable(books, func(i, j int) bool { return books[i].Updated.After(books[j].Updated) })
+	s.writeBooks(w, r, s.newFeed("urn:hbd:new", "Recently downloaded", "/opds/new", acquisitionType, c.Updated), books)
+}
+
+func (s *Server) handleBundle(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.catalog(w)
+	if !ok {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/opds/bundles/")
+	b := c.Bundle(id)
+	if b == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.writeBooks(w, r, s.newFeed("urn:hbd:bundle:"+b.ID, b.Name, "/opds/bundles/"+b.ID, acquisitionType, b.Updated), b.Books)
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.catalog(w)
+	if !ok {
+		return
+	}
+	q := r.URL.Query().Get("q")
+	self := "/opds/search?q=" + url.QueryEscape(q)
+	s.writeBooks(w, r, s.newFeed("urn:hbd:search:"+q, "Search: "+q, self, acquisitionType, c.Updated), c.Search(q))
+}
+
+// openSearch is the OpenSearch description e-readers fill the search template of
+type openSearch struct {
+	XMLName     xml.Name `xml:"OpenSearchDescription"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	ShortName   string   `xml:"ShortName"`
+	Description string   `xml:"Description"`
+	InputEnc    string   `xml:"InputEncoding"`
+	OutputEnc   string   `xml:"OutputEncoding"`
+	URL         struct {
+		Type     string `xml:"type,attr"`
+		Template string `xml:"template,attr"`
+	} `xml:"Url"`
+}
+
+func (s *Server) handleOpenSearch(w http.ResponseWriter, r *http.Request) {
+	d := openSearch{
+		Xmlns:       "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:   "hbd",
+		Description: "Search " + s.title + " by title, author, bundle or ISBN",
+		InputEnc:    "UTF-8",
+		OutputEnc:   "UTF-8",
+	}
+	d.URL.Type = acquisitionType
+	d.URL.Template = "/opds/search?q={searchTerms}"
+	writeXML(w, d, openSearchType)
+}
+
+// handleCover serves the cover of an EPUB, /opds/covers/ID/thumbnail scales it down
+func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.catalog(w)
+	if !ok {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/opds/covers/"), "/")
+	b := c.Book(parts[0])
+	if b == nil || b.Cover == nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "thumbnail") {
+		http.NotFound(w, r)
+		return
+	}
+	image, mediaType, err := ebookmeta.ReadEPUBCover(filepath.Join(s.dest, filepath.FromSlash(b.Cover.Path)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 2 {
+		// covers that can't be decoded are served as they are
+		if thumb, err := thumbnail(image, s.thumb); err == nil {
+			image, mediaType = thumb, "image/jpeg"
+		}
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.Write(image)
+}
+
+// handleFile serves the formats of the catalog, other files of the destination aren't reachable
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.catalog(w)
+	if !ok {
+		return
+	}
+	format := c.Format(strings.TrimPrefix(r.URL.Path, "/opds/files/"))
+	if format == nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(s.dest, filepath.FromSlash(format.Path)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.MIMEType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(filepath.Base(format.Path))))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+func (s *Server) catalog(w http.ResponseWriter) (*Catalog, bool) {
+	s.mu.Lock()
+	c, cachedAt := s.cached, s.cachedAt
+	s.mu.Unlock()
+	if c != nil && time.Since(cachedAt) < catalogTTL {
+		return c, true
+	}
+	c, err := s.Catalog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	s.mu.Lock()
+	s.cached, s.cachedAt = c, time.Now()
+	s.mu.Unlock()
+	return c, true
+}
+
+// writeBooks writes a page of an acquisition feed, ?page=N starts at 1
+func (s *Server) writeBooks(w http.ResponseWriter, r *http.Request, f *feed, books []*Book) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pages := (len(books) + s.pageSize - 1) / s.pageSize
+	pageURL := func(n int) string {
+		sep := "?"
+		if strings.Contains(f.self, "?") {
+			sep = "&"
+		}
+		return f.self + sep + "page=" + strconv.Itoa(n)
+	}
+	if page > 1 {
+		f.Links = append(f.Links, link{Rel: "previous", Href: pageURL(page - 1), Type: acquisitionType})
+	}
+	if page < pages {
+		f.Links = append(f.Links, link{Rel: "next", Href: pageURL(page + 1), Type: acquisitionType})
+	}
+	f.TotalResults = len(books)
+	f.ItemsPerPage = s.pageSize
+	f.StartIndex = (page-1)*s.pageSize + 1
+	start, end := (page-1)*s.pageSize, page*s.pageSize
+	if start > len(books) {
+		start = len(books)
+	}
+	if end > len(books) {
+		end = len(books)
+	}
+	for _, b := range books[start:end] {
+		f.Entries = append(f.Entries, bookEntry(b))
+	}
+	writeXML(w, f, acquisitionType)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}, mediaType string) {
+	by, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType+";charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(by)
+}
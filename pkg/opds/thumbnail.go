@@ -0,0 +1,60 @@
+package opds
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif" // covers can be gifs
+	"image/jpeg"
+	_ "image/png" // covers are often pngs
+
+	"github.com/pkg/errors"
+)
+
+// maxCoverPixels bounds the size of a decoded cover, a small file can declare
+// an image taking gigabytes once decoded
+const maxCoverPixels = 5000 * 5000
+
+// thumbnail scales an image down to height pixels, averaging the source
+// pixels of every thumbnail pixel, and encodes it as a jpeg
+func thumbnail(by []byte, height int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(by))
+	if err != nil {
+		return nil, errors.Wrap(err, "image.DecodeConfig")
+	}
+	if int64(config.Width)*int64(config.Height) > maxCoverPixels {
+		return nil, errors.Errorf("cover of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(by))
+	if err != nil {
+		return nil, errors.Wrap(err, "image.Decode")
+	}
+	b := src.Bounds()
+	if b.Dy() <= height {
+		height = b.Dy()
+	}
+	width := b.Dx() * height / b.Dy()
+	if width < 1 {
+		width = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1 || sy == y0; sy++ {
+				for sx := x0; sx < x1 || sx == x0; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+cr, g+cg, bl+cb, a+ca, n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, errors.Wrap(err, "jpeg.Encode")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,205 @@
+// Package ratelimit throttles download throughput with token buckets that can
+// be shared between concurrent transfers.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// chunkSize bounds how many bytes are accounted at once so throttled writes stay smooth
+const chunkSize = 32 * 1024
+
+// Limiter is a token bucket refilled at a rate in bytes per second, a rate of 0 is unlimited
+type Limiter struct {
+	mu       sync.Mutex
+	rate     int64
+	schedule Schedule
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+// NewLimiter creates a limiter allowing bytesPerSec, 0 disables the limit
+func NewLimiter(bytesPerSec int64) *Limiter {
+	return &Limiter{
+		rate: bytesPerSec,
+		now:  time.Now,
+	}
+}
+
+// SetSchedule overrides the limiter rate during the schedule windows
+func (l *Limiter) SetSchedule(s Schedule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedule = s
+}
+
+// Rate returns the rate in effect at t
+func (l *Limiter) Rate(t time.Time) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rateAt(t)
+}
+
+func (l *Limiter) rateAt(t time.Time) int64 {
+	if rate, ok := l.schedule.RateAt(t); ok {
+		return rate
+	}
+	return l.rate
+}
+
+// WaitN blocks until n bytes may be transferred
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := l.now()
+	rate := l.rateAt(now)
+	if rate <= 0 {
+		l.last = now
+		l.mu.Unlock()
+		return nil
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	} else {
+		l.tokens = float64(rate)
+	}
+	// allow bursts of at most a second worth of data
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.last = now
+	// reserve the tokens now and sleep off the debt so concurrent callers queue up fairly
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Writer throttles writes to an underlying writer with one or more limiters
+type Writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+}
+
+// NewWriter wraps w so every write waits on all the limiters, nil limiters are ignored
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) *Writer {
+	active := []*Limiter{}
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+	return &Writer{ctx: ctx, w: w, limiters: active}
+}
+
+func (w *Writer) wait(n int) error {
+	for _, l := range w.limiters {
+		if err := l.WaitN(w.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write implements io.Writer
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if err := w.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// WriteAt implements io.WriterAt when the underlying writer does
+func (w *Writer) WriteAt(p []byte, off int64) (int, error) {
+	wa, ok := w.w.(io.WriterAt)
+	if !ok {
+		return 0, errors.New("ratelimit: underlying writer doesn't implement io.WriterAt")
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if err := w.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := wa.WriteAt(chunk, off)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		off += int64(n)
+	}
+	return written, nil
+}
+
+// ParseRate parses a rate such as 500K, 5M or 1.5G into bytes per second, 0 is
+// unlimited so other rates below 1 byte per second are refused
+func ParseRate(rate string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(rate))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/S"), "B")
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := float64(1)
+	switch s[len(s)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || v < 0 || v*multiplier >= math.MaxInt64 {
+		return 0, errors.Errorf("invalid rate %q", rate)
+	}
+	if v != 0 && v*multiplier < 1 {
+		return 0, errors.Errorf("invalid rate %q, the lowest rate is 1 byte per second", rate)
+	}
+	return int64(v * multiplier), nil
+}
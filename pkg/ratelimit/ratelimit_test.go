@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	dd := []struct {
+		in        string
+		out       int64
+		expectErr bool
+	}{
+		{in: "0", out: 0},
+		{in: "", out: 0},
+		{in: "512", out: 512},
+		{in: "500K", out: 500 << 10},
+		{in: "5M", out: 5 << 20},
+		{in: "5mb/s", out: 5 << 20},
+		{in: "1.5G", out: 3 << 29},
+		{in: "fast", expectErr: true},
+		{in: "-1M", expectErr: true},
+		{in: "0.5", expectErr: true},
+		{in: "0.0001K", expectErr: true},
+		{in: "0.001K", out: 1},
+		{in: "NaN", expectErr: true},
+		{in: "Inf", expectErr: true},
+		{in: "1e30G", expectErr: true},
+	}
+	for _, d := range dd {
+		out, err := ParseRate(d.in)
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%q: expected error", d.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: ParseRate: %v", d.in, err)
+		} else if out != d.out {
+			t.Errorf("%q: expected %d but got %d", d.in, d.out, out)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("01:00-07:00=0, 22:00-00:30=1M, 09:00-18:00=200K")
+	if err != nil {
+		t.Fatalf("ParseSchedule: %v", err)
+	}
+	l := NewLimiter(5 << 20)
+	l.SetSchedule(schedule)
+
+	dd := []struct {
+		at   string
+		rate int64
+	}{
+		{at: "03:00", rate: 0},
+		{at: "07:00", rate: 5 << 20},
+		{at: "12:15", rate: 200 << 10},
+		{at: "23:59", rate: 1 << 20},
+		{at: "00:10", rate: 1 << 20},
+		{at: "00:30", rate: 5 << 20},
+	}
+	for _, d := range dd {
+		at, _ := time.Parse("15:04", d.at)
+		if rate := l.Rate(at); rate != d.rate {
+			t.Errorf("%s: expected rate %d but got %d", d.at, d.rate, rate)
+		}
+	}
+
+	// a window ending where it starts lasts all day
+	allDay, err := ParseSchedule("00:00-00:00=1M")
+	if err != nil {
+		t.Fatalf("ParseSchedule: %v", err)
+	}
+	for _, at := range []string{"00:00", "12:00", "23:59"} {
+		tod, _ := time.Parse("15:04", at)
+		if rate, ok := allDay.RateAt(tod); !ok || rate != 1<<20 {
+			t.Errorf("%s: expected the all day window but got %d %v", at, rate, ok)
+		}
+	}
+
+	for _, invalid := range []string{"01:00=0", "1am-2am=0", "01:00-02:00=fast"} {
+		if _, err := ParseSchedule(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	// both writers share the limiter, after the one second burst the
+	// remaining 100K must take about a second
+	l := NewLimiter(100 << 10)
+	var a, b bytes.Buffer
+	wa := NewWriter(context.Background(), &a, l)
+	wb := NewWriter(context.Background(), &b, l, nil)
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		wa.Write(make([]byte, 100<<10))
+		close(done)
+	}()
+	wb.Write(make([]byte, 100<<10))
+	<-done
+	elapsed := time.Since(start)
+
+	if a.Len() != 100<<10 || b.Len() != 100<<10 {
+		t.Fatalf("expected all bytes to be written")
+	}
+	if elapsed < 800*time.Millisecond {
+		t.Errorf("expected shared limit to throttle writes but took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewWriter(ctx, &a, NewLimiter(1)).Write(make([]byte, 1024)); err == nil {
+		t.Errorf("expected cancelled context to abort the write")
+	}
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Window is a daily time range with its own rate, windows may wrap around
+// midnight and one starting where it ends, eg; 00:00-00:00, lasts all day
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+	Rate  int64
+}
+
+// Schedule is a list of daily windows, the first window matching a time wins
+type Schedule []Window
+
+// contains reports whether the time of day falls inside the window
+func (w Window) contains(tod time.Duration) bool {
+	if w.Start == w.End {
+		return true
+	}
+	if w.Start < w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// RateAt returns the rate of the window containing t in its location
+func (s Schedule) RateAt(t time.Time) (int64, bool) {
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	for _, w := range s {
+		if w.contains(tod) {
+			return w.Rate, true
+		}
+	}
+	return 0, false
+}
+
+// ParseSchedule parses a comma separated list of windows, eg; 01:00-07:00=0,09:00-18:00=1M
+func ParseSchedule(s string) (Schedule, error) {
+	schedule := Schedule{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid schedule window %q, expected HH:MM-HH:MM=RATE", entry)
+		}
+		times := strings.SplitN(parts[0], "-", 2)
+		if len(times) != 2 {
+			return nil, errors.Errorf("invalid schedule window %q, expected HH:MM-HH:MM=RATE", entry)
+		}
+		start, err := parseTimeOfDay(times[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(times[1])
+		if err != nil {
+			return nil, err
+		}
+		rate, err := ParseRate(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, Window{Start: start, End: end, Rate: rate})
+	}
+	return schedule, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
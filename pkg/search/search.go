@@ -0,0 +1,210 @@
+// Package search finds products across orders by their names, bundle,
+// platforms and file types. Query terms match as substrings first and fall
+// back to fuzzy subsequences so typos and abbreviations still find something.
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"diogogmt.com/hbd/pkg/downloader"
+	"diogogmt.com/hbd/pkg/hbclient"
+)
+
+// fields a query term can match, reported by Hit.Matched
+const (
+	FieldName     = "name"
+	FieldMachine  = "machine_name"
+	FieldBundle   = "bundle"
+	FieldPlatform = "platform"
+	FieldType     = "type"
+)
+
+// scores of a term matching a value, before the field weight
+const (
+	scoreExact     = 100
+	scoreWordStart = 80
+	scoreSubstring = 60
+	scoreFuzzy     = 40
+)
+
+// weights rank product name matches above the bundle and the file details
+var weights = map[string]int{
+	FieldName:     3,
+	FieldMachine:  3,
+	FieldBundle:   2,
+	FieldPlatform: 1,
+	FieldType:     1,
+}
+
+// Hit is a product matching every term of a query
+type Hit struct {
+	Order   *hbclient.Order
+	Product *hbclient.Product
+	Assets  hbclient.Assets
+	Score   int
+	// Matched lists the fields the terms matched, eg; name, bundle
+	Matched []string
+}
+
+// Bundle is the name of the bundle the product was bought in
+func (h *Hit) Bundle() string {
+	return downloader.BundleDir(h.Order)
+}
+
+// Platforms lists the platforms of the product assets, eg; ebook, audio
+func (h *Hit) Platforms() []string {
+	return unique(h.Assets, hbclient.Asset.Platform)
+}
+
+// Types lists the file types of the product assets, eg; pdf, epub
+func (h *Hit) Types() []string {
+	return unique(h.Assets, func(a hbclient.Asset) string {
+		return strings.ToLower(strings.TrimPrefix(a.Type.Name, "."))
+	})
+}
+
+// Search matches the products of orders against the whitespace separated
+// terms of query, the best hits first and newer orders first on ties
+func Search(orders []*hbclient.Order, query string) []*Hit {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil
+	}
+	hits := []*Hit{}
+	for _, o := range orders {
+		assets := o.Assets()
+		for _, p := range o.Products {
+			if p == nil {
+				continue
+			}
+			hit := Hit{Order: o, Product: p, Assets: assets.Filter(func(a hbclient.Asset) bool { return a.Product == p })}
+			if match(&hit, terms) {
+				hits = append(hits, &hit)
+			}
+		}
+	}
+	// orders come newest first, a stable sort keeps them that way on ties
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	return hits
+}
+
+// match scores a hit, every term has to match one of its fields
+func match(hit *Hit, terms []string) bool {
+	fields := []struct {
+		name   string
+		values []string
+	}{
+		{FieldName, []string{hit.Product.HumanName}},
+		{FieldMachine, []string{hit.Product.MachineName}},
+		{FieldBundle, []string{hit.Bundle()}},
+		{FieldPlatform, hit.Platforms()},
+		{FieldType, hit.Types()},
+	}
+	matched := map[string]bool{}
+	for _, term := range terms {
+		best, bestField := 0, ""
+		for _, f := range fields {
+			for _, v := range f.values {
+				if score := Score(term, strings.ToLower(v)) * weights[f.name]; score > best {
+					best, bestField = score, f.name
+				}
+			}
+		}
+		if best == 0 {
+			return false
+		}
+		hit.Score += best
+		if !matched[bestField] {
+			matched[bestField] = true
+			hit.Matched = append(hit.Matched, bestField)
+		}
+	}
+	return true
+}
+
+// Score rates how well a lowercase term matches a lowercase value: exactly,
+// at the start of a word, anywhere, or as a subsequence, 0 means no match
+func Score(term, value string) int {
+	if term == "" || value == "" {
+		return 0
+	}
+	if term == value {
+		return scoreExact
+	}
+	if i := strings.Index(value, term); i >= 0 {
+		for ; i >= 0; i = nextIndex(value, term, i) {
+			if i == 0 || !isWordRune(lastRune(value[:i])) {
+				return scoreWordStart
+			}
+		}
+		return scoreSubstring
+	}
+	return fuzzy([]rune(term), []rune(value))
+}
+
+// fuzzy scores term as a subsequence of value by the shortest window holding
+// it, terms too short or spread too thin don't match
+func fuzzy(term, value []rune) int {
+	if len(term) < 3 {
+		return 0
+	}
+	span := 0
+	for start := range value {
+		if value[start] != term[0] {
+			continue
+		}
+		j := 1
+		end := start
+		for k := start + 1; k < len(value) && j < len(term); k++ {
+			if value[k] == term[j] {
+				j++
+				end = k
+			}
+		}
+		if j < len(term) {
+			// no later start can hold the whole term either
+			break
+		}
+		if s := end - start + 1; span == 0 || s < span {
+			span = s
+		}
+	}
+	if span == 0 || span > len(term)*3/2 {
+		return 0
+	}
+	return scoreFuzzy * len(term) / span
+}
+
+func nextIndex(s, substr string, i int) int {
+	j := strings.Index(s[i+1:], substr)
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+func lastRune(s string) rune {
+	r := []rune(s)
+	return r[len(r)-1]
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// unique lists the values of the assets in order, without repetitions
+func unique(assets hbclient.Assets, value func(hbclient.Asset) string) []string {
+	values := []string{}
+	seen := map[string]bool{}
+	for _, a := range assets {
+		if v := value(a); v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+)
+
+func testOrders() []*hbclient.Order {
+	product := func(human, machine string, downloads ...*hbclient.Download) *hbclient.Product {
+		return &hbclient.Product{HumanName: human, MachineName: machine, Downloads: downloads}
+	}
+	download := func(platform string, types ...string) *hbclient.Download {
+		d := hbclient.Download{Platform: platform}
+		for _, t := range types {
+			d.Types = append(d.Types, &hbclient.DownloadType{Name: t, FileSize: 1024})
+		}
+		return &d
+	}
+	return []*hbclient.Order{
+		{
+			GameKey: "books",
+			Created: hbclient.Time{Time: time.Date(2020, 5, 6, 0, 0, 0, 0, time.UTC)},
+			Product: &hbclient.Product{HumanName: "Humble Book Bundle: Cybersecurity"},
+			Products: []*hbclient.Product{
+				product("Practical Malware Analysis", "practicalmalwareanalysis", download("ebook", "PDF", "EPUB", "MOBI")),
+				product("Black Hat Go", "blackhatgo", download("ebook", "PDF")),
+				nil,
+			},
+		},
+		{
+			GameKey: "games",
+			Created: hbclient.Time{Time: time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)},
+			Product: &hbclient.Product{HumanName: "Humble Indie Bundle"},
+			Products: []*hbclient.Product{
+				product("Malware Hunter", "malwarehunter", download("windows", "Download"), download("linux", ".deb")),
+				product("Steam Key", "steamkey"),
+			},
+		},
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dd := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "", expected: []string{}},
+		{query: "practical malware", expected: []string{"Practical Malware Analysis"}},
+		// name matches rank above bundle matches, newer orders first on ties
+		{query: "malware", expected: []string{"Practical Malware Analysis", "Malware Hunter"}},
+		{query: "MALWARE linux", expected: []string{"Malware Hunter"}},
+		{query: "blackhatgo", expected: []string{"Black Hat Go"}},
+		{query: "indie", expected: []string{"Malware Hunter", "Steam Key"}},
+		{query: "epub", expected: []string{"Practical Malware Analysis"}},
+		{query: "deb", expected: []string{"Malware Hunter"}},
+		// typos still match as subsequences
+		{query: "malwre anlysis", expected: []string{"Practical Malware Analysis"}},
+		{query: "zzz", expected: []string{}},
+	}
+	for _, d := range dd {
+		names := []string{}
+		for _, hit := range Search(testOrders(), d.query) {
+			names = append(names, hit.Product.HumanName)
+		}
+		if strings.Join(names, ",") != strings.Join(d.expected, ",") {
+			t.Errorf("%q: expected %v but got %v", d.query, d.expected, names)
+		}
+	}
+
+	hits := Search(testOrders(), "hunter windows")
+	if len(hits) != 1 {
+		t.Fatalf("expected a hit but got %d", len(hits))
+	}
+	hit := hits[0]
+	if hit.Order.GameKey != "games" || hit.Bundle() != "Humble Indie Bundle" || len(hit.Assets) != 2 {
+		t.Errorf("expected the hit order and assets but got %+v", hit)
+	}
+	if strings.Join(hit.Matched, ",") != "name,platform" || strings.Join(hit.Platforms(), ",") != "windows,linux" || strings.Join(hit.Types(), ",") != "download,deb" {
+		t.Errorf("expected the matched fields, platforms and types but got %v %v %v", hit.Matched, hit.Platforms(), hit.Types())
+	}
+}
+
+func TestScore(t *testing.T) {
+	dd := []struct {
+		term, value string
+		expected    int
+	}{
+		{"pdf", "pdf", scoreExact},
+		{"mal", "practical malware", scoreWordStart},
+		{"ware", "practical malware", scoreSubstring},
+		// the second occurrence starts a word
+		{"go", "algo go", scoreWordStart},
+		{"pma", "practicalmalwareanalysis", 0},
+		{"malwre", "practical malware", scoreFuzzy * 6 / 7},
+		{"mw", "malware", 0},
+		{"", "malware", 0},
+	}
+	for _, d := range dd {
+		if score := Score(d.term, d.value); score != d.expected {
+			t.Errorf("Score(%q, %q): expected %d but got %d", d.term, d.value, d.expected, score)
+		}
+	}
+}
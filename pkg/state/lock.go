@@ -0,0 +1,54 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned when another process holds a lock
+var ErrLocked = errors.New("locked by another process")
+
+// Lock is an exclusive lock on a file, it's released when the process exits
+type Lock struct {
+	f *os.File
+}
+
+// Lock takes the lock file name in the state directory, it fails with
+// ErrLocked when another process holds it
+func (d Dir) Lock(name string) (*Lock, error) {
+	path := d.Path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrapf(err, "os.MkdirAll %s", filepath.Dir(path))
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "os.OpenFile %s", path)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if err == ErrLocked {
+			by, _ := ioutil.ReadFile(path)
+			return nil, errors.Wrapf(ErrLocked, "%s held by pid %s", path, strings.TrimSpace(string(by)))
+		}
+		return nil, errors.Wrapf(err, "lock %s", path)
+	}
+	// the pid is only informative, the lock is held by the open file
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock
+func (l *Lock) Unlock() error {
+	if err := unlockFile(l.f); err != nil {
+		l.f.Close()
+		return errors.Wrap(err, "unlock")
+	}
+	return errors.Wrap(l.f.Close(), "close lock file")
+}
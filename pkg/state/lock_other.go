@@ -0,0 +1,33 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package state
+
+import (
+	"os"
+	"sync"
+)
+
+// locked tracks the lock files held by this process, other processes
+// aren't excluded on platforms without flock
+var (
+	lockedMu sync.Mutex
+	locked   = map[string]bool{}
+)
+
+func lockFile(f *os.File) error {
+	lockedMu.Lock()
+	defer lockedMu.Unlock()
+	if locked[f.Name()] {
+		return ErrLocked
+	}
+	locked[f.Name()] = true
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	lockedMu.Lock()
+	defer lockedMu.Unlock()
+	delete(locked, f.Name())
+	return nil
+}
@@ -0,0 +1,82 @@
+// Package state persists hbd data between runs as JSON files in a directory.
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Dir is a directory holding state files
+type Dir string
+
+// DefaultDir is the per user config directory, eg; ~/.config/hbd
+func DefaultDir() Dir {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return Dir(".hbd")
+	}
+	return Dir(filepath.Join(dir, "hbd"))
+}
+
+// Path returns the path of a state file
+func (d Dir) Path(name string) string {
+	return filepath.Join(string(d), name)
+}
+
+// Load decodes the JSON state file name into v, a missing file leaves v untouched
+func (d Dir) Load(name string, v interface{}) error {
+	by, err := ioutil.ReadFile(d.Path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "ioutil.ReadFile %s", d.Path(name))
+	}
+	if err := json.Unmarshal(by, v); err != nil {
+		return errors.Wrapf(err, "json.Unmarshal %s", d.Path(name))
+	}
+	return nil
+}
+
+// Save encodes v into the state file name, replacing it atomically
+func (d Dir) Save(name string, v interface{}) error {
+	by, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "json.MarshalIndent %s", name)
+	}
+	return WriteFileAtomic(d.Path(name), by)
+}
+
+// WriteFileAtomic writes data to a temp file next to path and renames it into place
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "os.MkdirAll %s", filepath.Dir(path))
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "ioutil.TempFile %s", path)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return errors.Wrapf(err, "writting %s", path)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return errors.Wrapf(err, "tmpFile.Sync %s", path)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrapf(err, "tmpFile.Close %s", path)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return errors.Wrap(err, "os.Chmod")
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return errors.Wrapf(err, "os.Rename %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDir(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "hbd-state.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	dir := Dir(tempDir + "/nested")
+
+	v := map[string]int{"untouched": 1}
+	if err := dir.Load("missing.json", &v); err != nil || v["untouched"] != 1 {
+		t.Errorf("expected a missing file to leave v untouched but got %v, %v", v, err)
+	}
+	if err := dir.Save("counts.json", map[string]int{"a": 2}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded := map[string]int{}
+	if err := dir.Load("counts.json", &loaded); err != nil || loaded["a"] != 2 {
+		t.Errorf("expected saved counts but got %v, %v", loaded, err)
+	}
+
+	lock, err := dir.Lock("daemon.lock")
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if _, err := dir.Lock("daemon.lock"); errors.Cause(err) != ErrLocked {
+		t.Errorf("expected ErrLocked but got %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	lock, err = dir.Lock("daemon.lock")
+	if err != nil {
+		t.Fatalf("expected the released lock to be taken again: %v", err)
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Local stores files in a directory
+type Local struct {
+	root string
+}
+
+// NewLocal creates a storage rooted at the directory root, it's created with the first file
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// Root is the directory holding the files
+func (l *Local) Root() string {
+	return l.root
+}
+
+// Path is the path of the file name
+func (l *Local) Path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(name))
+}
+
+// Create implements Storage, the content is written into a temp file next
+// to the destination so a failed or interrupted download never replaces a
+// previously good file
+func (l *Local) Create(ctx context.Context, name string, opts CreateOptions) (File, error) {
+	path := l.Path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll")
+	}
+	f, err := createTemp(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating temp file in %s", l.root)
+	}
+	if err := preallocate(f, opts.Size); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, errors.Wrapf(err, "preallocate %s", path)
+	}
+	return &localFile{File: f, path: path}, nil
+}
+
+// maxNameLen is the longest file name most filesystems accept, NAME_MAX
+const maxNameLen = 255
+
+// createTemp creates the temp file of path next to it, .NAME.RANDOM.part, with
+// the mode os.Create gives, 0666 less the umask, NAME is shortened so the temp
+// name fits in maxNameLen
+func createTemp(path string) (*os.File, error) {
+	base := filepath.Base(path)
+	if max := maxNameLen - len("..") - 8 - len(".part"); len(base) > max {
+		base = base[:max]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+	}
+	for i := 0; ; i++ {
+		random := make([]byte, 4)
+		if _, err := rand.Read(random); err != nil {
+			return nil, errors.Wrap(err, "rand.Read")
+		}
+		name := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%x.part", base, random))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 10 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// localFile is a temp file renamed over its destination on commit, it can be
+// read back to verify the content before that
+type localFile struct {
+	*os.File
+	path string
+}
+
+func (f *localFile) Commit(modTime time.Time) error {
+	if err := f.Sync(); err != nil {
+		return errors.Wrap(err, "tmpFile.Sync")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "tmpFile.Close")
+	}
+	// the mtime is best-effort, some filesystems don't support setting it
+	if !modTime.IsZero() {
+		_ = os.Chtimes(f.Name(), modTime, modTime)
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return errors.Wrapf(err, "os.Rename %s", f.path)
+	}
+	return nil
+}
+
+func (f *localFile) Abort() error {
+	_ = f.Close()
+	if err := os.Remove(f.Name()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "os.Remove")
+	}
+	return nil
+}
+
+// Stat implements Storage, local files have no metadata
+func (l *Local) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	fi, err := os.Stat(l.Path(name))
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Rename implements Storage
+func (l *Local) Rename(ctx context.Context, from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(l.Path(to)), 0777); err != nil {
+		return errors.Wrap(err, "os.MkdirAll")
+	}
+	return errors.Wrapf(os.Rename(l.Path(from), l.Path(to)), "os.Rename %s", from)
+}
+
+// Remove implements Storage
+func (l *Local) Remove(ctx context.Context, name string) error {
+	if err := os.Remove(l.Path(name)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "os.Remove")
+	}
+	return nil
+}
+
+// List implements Storage, temp files of downloads in progress are left out
+func (l *Local) List(ctx context.Context, prefix string) ([]*FileInfo, error) {
+	files := []*FileInfo{}
+	err := filepath.Walk(l.root, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == l.root {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || strings.HasSuffix(fi.Name(), ".part") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			files = append(files, &FileInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing %s", l.root)
+	}
+	return files, nil
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize reserves the blocks without changing the file size, a file
+// shorter than announced doesn't end up padded with zeros
+const fallocKeepSize = 0x01
+
+// preallocate reserves size bytes for f so a download fails early, rather than
+// midway, when the disk fills up, filesystems without fallocate are ignored
+func preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package storage
+
+import "os"
+
+// preallocate is a no-op where fallocate isn't available
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
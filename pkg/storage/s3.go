@@ -0,0 +1,522 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3 part sizes, parts but the last one can't be smaller than minPartSize
+// and an upload can't have more than maxParts parts
+const (
+	minPartSize     = 5 << 20
+	defaultPartSize = 16 << 20
+	maxParts        = 10000
+	// maxCopySize is the largest object CopyObject copies in a single request
+	maxCopySize  = 5 << 30
+	copyPartSize = 1 << 30
+)
+
+// S3Error is an error response of S3
+type S3Error struct {
+	Op         string
+	Key        string
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	msg := fmt.Sprintf("s3 %s %s: %d", e.Op, e.Key, e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is matches ErrNotExist for missing objects
+func (e *S3Error) Is(target error) bool {
+	return target == ErrNotExist && e.StatusCode == http.StatusNotFound && (e.Code == "" || e.Code == "NoSuchKey")
+}
+
+// S3 stores files as the objects of a bucket under a key prefix
+type S3 struct {
+	bucket    string
+	prefix    string
+	endpoint  *url.URL
+	pathStyle bool
+	region    string
+	creds     Credentials
+	client    *http.Client
+	partSize  int64
+	now       func() time.Time
+}
+
+// S3Option defines the signature for functional options to be applied to the S3 storage
+type S3Option = func(s *S3)
+
+// WithS3Endpoint uses an S3 compatible service, eg; http://localhost:9000
+// for MinIO, its buckets are addressed in the path
+func WithS3Endpoint(endpoint string) S3Option {
+	return func(s *S3) {
+		if u, err := url.Parse(strings.TrimSuffix(endpoint, "/")); err == nil && u.Host != "" {
+			s.endpoint = u
+			s.pathStyle = true
+		}
+	}
+}
+
+// WithS3Region sets the region of the bucket, us-east-1 by default
+func WithS3Region(region string) S3Option {
+	return func(s *S3) {
+		s.region = region
+	}
+}
+
+// WithS3Credentials sets the keys signing the requests
+func WithS3Credentials(creds Credentials) S3Option {
+	return func(s *S3) {
+		s.creds = creds
+	}
+}
+
+// WithS3HTTPClient sets the http client used to reach the bucket
+func WithS3HTTPClient(client *http.Client) S3Option {
+	return func(s *S3) {
+		s.client = client
+	}
+}
+
+// WithS3PartSize sets the size of the multipart upload parts, at least 5MiB
+func WithS3PartSize(size int64) S3Option {
+	return func(s *S3) {
+		if size < minPartSize {
+			size = minPartSize
+		}
+		s.partSize = size
+	}
+}
+
+// S3FromEnv reads the region, credentials and endpoint from the usual AWS
+// environment variables, AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL selects an
+// S3 compatible service
+func S3FromEnv() []S3Option {
+	opts := []S3Option{
+		WithS3Credentials(Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}),
+	}
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			opts = append(opts, WithS3Region(region))
+			break
+		}
+	}
+	for _, name := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+		if endpoint := os.Getenv(name); endpoint != "" {
+			opts = append(opts, WithS3Endpoint(endpoint))
+			break
+		}
+	}
+	return opts
+}
+
+// NewS3 creates a storage writing into bucket under prefix
+func NewS3(bucket, prefix string, opts ...S3Option) (*S3, error) {
+	s := S3{
+		bucket:   bucket,
+		prefix:   strings.Trim(prefix, "/"),
+		region:   "us-east-1",
+		client:   http.DefaultClient,
+		partSize: defaultPartSize,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.creds.AccessKeyID == "" || s.creds.SecretAccessKey == "" {
+		return nil, errors.New("missing S3 credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if s.endpoint == nil {
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", s.region)}
+	}
+	return &s, nil
+}
+
+// String is the s3://bucket/prefix URL of the storage
+func (s *S3) String() string {
+	return strings.TrimSuffix("s3://"+s.bucket+"/"+s.prefix, "/")
+}
+
+// key is the object key of the file name
+func (s *S3) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, name), "/")
+}
+
+// name is the file name of an object key
+func (s *S3) name(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, s.prefix+"/")
+}
+
+// Create implements Storage, the content is uploaded in parts as it's
+// written and the object only appears once committed, small files are
+// uploaded with a single request. The file only accepts sequential writes.
+func (s *S3) Create(ctx context.Context, name string, opts CreateOptions) (File, error) {
+	return &s3File{s: s, ctx: ctx, key: s.key(name), meta: opts.Metadata}, nil
+}
+
+// Stat implements Storage
+func (s *S3) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	key := s.key(name)
+	resp, err := s.do(ctx, "HeadObject", http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	fi := FileInfo{Name: name, Size: resp.ContentLength, Metadata: map[string]string{}}
+	fi.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	for header, values := range resp.Header {
+		if h := strings.ToLower(header); strings.HasPrefix(h, "x-amz-meta-") && len(values) != 0 {
+			fi.Metadata[strings.TrimPrefix(h, "x-amz-meta-")] = values[0]
+		}
+	}
+	return &fi, nil
+}
+
+// Rename implements Storage by copying the object and deleting the original,
+// the metadata is copied along
+func (s *S3) Rename(ctx context.Context, from, to string) error {
+	fi, err := s.Stat(ctx, from)
+	if err != nil {
+		return err
+	}
+	if fi.Size > maxCopySize {
+		err = s.copyMultipart(ctx, s.key(from), s.key(to), fi)
+	} else {
+		err = s.copy(ctx, s.key(from), s.key(to))
+	}
+	if err != nil {
+		return err
+	}
+	return s.Remove(ctx, from)
+}
+
+// Remove implements Storage
+func (s *S3) Remove(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, "DeleteObject", http.MethodDelete, s.key(name), nil, nil, nil)
+	if errors.Is(err, ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List implements Storage, the objects metadata isn't listed
+func (s *S3) List(ctx context.Context, prefix string) ([]*FileInfo, error) {
+	files := []*FileInfo{}
+	keyPrefix := prefix
+	if s.prefix != "" {
+		keyPrefix = s.prefix + "/" + prefix
+	}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {keyPrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		result := struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}{}
+		if err := s.doXML(ctx, "ListObjectsV2", http.MethodGet, "", query, nil, nil, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			files = append(files, &FileInfo{Name: s.name(c.Key), Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) copy(ctx context.Context, from, to string) error {
+	headers := http.Header{"X-Amz-Copy-Source": {"/" + s.bucket + "/" + uriEscape(from, false)}}
+	return s.doXML(ctx, "CopyObject", http.MethodPut, to, nil, headers, nil, nil)
+}
+
+// copyMultipart copies objects too large for CopyObject a range at a time
+func (s *S3) copyMultipart(ctx context.Context, from, to string, fi *FileInfo) error {
+	uploadID, err := s.createMultipart(ctx, to, fi.Metadata)
+	if err != nil {
+		return err
+	}
+	parts := []completedPart{}
+	for start := int64(0); start < fi.Size; start += copyPartSize {
+		end := start + copyPartSize - 1
+		if end >= fi.Size {
+			end = fi.Size - 1
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(len(parts) + 1)}, "uploadId": {uploadID}}
+		headers := http.Header{
+			"X-Amz-Copy-Source":       {"/" + s.bucket + "/" + uriEscape(from, false)},
+			"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", start, end)},
+		}
+		result := struct {
+			ETag string `xml:"ETag"`
+		}{}
+		if err := s.doXML(ctx, "UploadPartCopy", http.MethodPut, to, query, headers, nil, &result); err != nil {
+			_ = s.abortMultipart(ctx, to, uploadID)
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: len(parts) + 1, ETag: result.ETag})
+	}
+	return s.completeMultipart(ctx, to, uploadID, parts)
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *S3) createMultipart(ctx context.Context, key string, meta map[string]string) (string, error) {
+	result := struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+	if err := s.doXML(ctx, "CreateMultipartUpload", http.MethodPost, key, url.Values{"uploads": {""}}, metaHeaders(meta), nil, &result); err != nil {
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3) uploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	resp, err := s.do(ctx, "UploadPart", http.MethodPut, key, query, nil, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (s *S3) completeMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return errors.Wrap(err, "xml.Marshal")
+	}
+	return s.doXML(ctx, "CompleteMultipartUpload", http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body, nil)
+}
+
+func (s *S3) abortMultipart(ctx context.Context, key, uploadID string) error {
+	resp, err := s.do(ctx, "AbortMultipartUpload", http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) putObject(ctx context.Context, key string, meta map[string]string, data []byte) error {
+	resp, err := s.do(ctx, "PutObject", http.MethodPut, key, nil, metaHeaders(meta), data)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// doXML sends a request and decodes its XML response into v, S3 reports some
+// failures with a 200 and an error document
+func (s *S3) doXML(ctx context.Context, op, method, key string, query url.Values, headers http.Header, body []byte, v interface{}) error {
+	resp, err := s.do(ctx, op, method, key, query, headers, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	by, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "s3 %s %s", op, key)
+	}
+	if bytes.Contains(by, []byte("<Error>")) {
+		s3Err := S3Error{Op: op, Key: key, StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(by, &s3Err)
+		return &s3Err
+	}
+	if v == nil {
+		return nil
+	}
+	return errors.Wrapf(xml.Unmarshal(by, v), "s3 %s %s", op, key)
+}
+
+// do sends a signed request for the object key, the bucket itself when key is empty
+func (s *S3) do(ctx context.Context, op, method, key string, query url.Values, headers http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	objectPath := "/" + key
+	if s.pathStyle {
+		objectPath = "/" + s.bucket + objectPath
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	u.Path = base + objectPath
+	// keys are escaped the way they're signed, url.URL leaves some characters as is
+	u.RawPath = uriEscape(base, false) + uriEscape(objectPath, false)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "s3 %s %s", op, key)
+	}
+	req = req.WithContext(ctx)
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	payloadHash := emptySHA256
+	if len(body) != 0 {
+		payloadHash = sha256Hex(body)
+	}
+	signV4(req, s.creds, s.region, payloadHash, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "s3 %s %s", op, key)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		s3Err := S3Error{Op: op, Key: key, StatusCode: resp.StatusCode}
+		if by, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil {
+			_ = xml.Unmarshal(by, &s3Err)
+		}
+		return nil, &s3Err
+	}
+	return resp, nil
+}
+
+// metaHeaders turns metadata into x-amz-meta-* headers
+func metaHeaders(meta map[string]string) http.Header {
+	headers := http.Header{}
+	for k, v := range meta {
+		headers.Set("X-Amz-Meta-"+k, v)
+	}
+	return headers
+}
+
+// s3File buffers a part at a time and uploads it once full, the multipart
+// upload only starts with the first full part
+type s3File struct {
+	s    *S3
+	ctx  context.Context
+	key  string
+	meta map[string]string
+
+	buf      bytes.Buffer
+	written  int64
+	uploadID string
+	parts    []completedPart
+}
+
+// Write buffers p a part at a time and uploads every full part, a failed
+// upload keeps its part buffered and only the bytes before it count as written
+func (f *s3File) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if room := int(f.s.partSize) - f.buf.Len(); len(chunk) > room {
+			chunk = chunk[:room]
+		}
+		f.buf.Write(chunk)
+		if int64(f.buf.Len()) == f.s.partSize {
+			if err := f.flush(f.buf.Bytes()); err != nil {
+				f.buf.Truncate(f.buf.Len() - len(chunk))
+				return n, err
+			}
+			f.buf.Reset()
+		}
+		n += len(chunk)
+		f.written += int64(len(chunk))
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// WriteAt only accepts the offset following the bytes written so far, the
+// object is uploaded in order, any other fails with ErrSequential
+func (f *s3File) WriteAt(p []byte, off int64) (int, error) {
+	if off != f.written {
+		return 0, errors.Wrapf(ErrSequential, "s3 %s: writing at %d after %d bytes", f.key, off, f.written)
+	}
+	return f.Write(p)
+}
+
+func (f *s3File) flush(part []byte) error {
+	if len(f.parts) == maxParts {
+		return errors.Errorf("s3 %s: more than %d parts, raise the part size", f.key, maxParts)
+	}
+	if f.uploadID == "" {
+		uploadID, err := f.s.createMultipart(f.ctx, f.key, f.meta)
+		if err != nil {
+			return err
+		}
+		f.uploadID = uploadID
+	}
+	etag, err := f.s.uploadPart(f.ctx, f.key, f.uploadID, len(f.parts)+1, part)
+	if err != nil {
+		return err
+	}
+	f.parts = append(f.parts, completedPart{PartNumber: len(f.parts) + 1, ETag: etag})
+	return nil
+}
+
+// Commit uploads what's left, S3 sets the modification time of objects itself
+func (f *s3File) Commit(modTime time.Time) error {
+	if f.uploadID == "" {
+		return f.s.putObject(f.ctx, f.key, f.meta, f.buf.Bytes())
+	}
+	if f.buf.Len() > 0 {
+		if err := f.flush(f.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return f.s.completeMultipart(f.ctx, f.key, f.uploadID, f.parts)
+}
+
+// Abort discards the uploaded parts, it uses a fresh context since the
+// upload is usually aborted because its context was cancelled
+func (f *s3File) Abort() error {
+	f.buf.Reset()
+	if f.uploadID == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return f.s.abortMultipart(ctx, f.key, f.uploadID)
+}
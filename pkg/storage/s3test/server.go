@@ -0,0 +1,368 @@
+// Package s3test provides an in-memory S3 stand-in for testing uploads.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minPartSize is the smallest part S3 accepts but for the last one
+const minPartSize = 5 << 20
+
+// Object is a stored object
+type Object struct {
+	Data     []byte
+	Metadata map[string]string
+	ModTime  time.Time
+}
+
+// Server serves path style requests for its buckets, requests must be
+// signed and their payload hash must match the body but the signature
+// itself isn't checked
+type Server struct {
+	// URL is the endpoint of the server
+	URL string
+
+	http    *httptest.Server
+	mu      sync.Mutex
+	buckets map[string]map[string]*Object
+	uploads map[string]*upload
+	nextID  int
+	parts   int
+}
+
+type upload struct {
+	bucket   string
+	key      string
+	metadata map[string]string
+	parts    map[int][]byte
+}
+
+// NewServer starts a server holding the empty buckets
+func NewServer(buckets ...string) *Server {
+	s := Server{
+		buckets: map[string]map[string]*Object{},
+		uploads: map[string]*upload{},
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = map[string]*Object{}
+	}
+	s.http = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.http.URL
+	return &s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.http.Close()
+}
+
+// Object returns the object key of bucket, nil when there's none
+func (s *Server) Object(bucket, key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[bucket][key]
+}
+
+// Keys lists the object keys of bucket
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Parts is the number of multipart upload parts received
+func (s *Server) Parts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.parts
+}
+
+// Uploads is the number of multipart uploads neither completed nor aborted
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(s3Error{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		writeError(w, http.StatusForbidden, "AccessDenied", "missing signature")
+		return
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash doesn't match the body")
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		s.list(w, objects, query)
+	case key == "":
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" bucket")
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &upload{bucket: bucket, key: key, metadata: metadata(r.Header), parts: map[int][]byte{}}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string   `xml:"Bucket"`
+			Key      string   `xml:"Key"`
+			UploadID string   `xml:"UploadId"`
+		}{Bucket: bucket, Key: key, UploadID: id})
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		s.complete(w, objects, query.Get("uploadId"), body)
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		s.uploadPart(w, r, query, body)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, ok := s.source(w, r.Header.Get("X-Amz-Copy-Source"))
+		if !ok {
+			return
+		}
+		objects[key] = &Object{Data: src.Data, Metadata: src.Metadata, ModTime: time.Now()}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string   `xml:"ETag"`
+		}{ETag: etag(src.Data)})
+	case r.Method == http.MethodPut:
+		objects[key] = &Object{Data: body, Metadata: metadata(r.Header), ModTime: time.Now()}
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		if _, ok := s.uploads[query.Get("uploadId")]; !ok {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+			return
+		}
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeError(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		for k, v := range object.Metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		w.Header().Set("ETag", etag(object.Data))
+		w.Header().Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.Data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object.Data)
+		}
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, objects map[string]*Object, query url.Values) {
+	maxKeys := 1000
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n > 0 {
+		maxKeys = n
+	}
+	keys := []string{}
+	for key := range objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string `xml:"Key"`
+		Size         int    `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	}
+	result := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Contents              []content `xml:"Contents"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+	}{}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			Size:         len(objects[key].Data),
+			LastModified: objects[key].ModTime.UTC().Format(time.RFC3339),
+		})
+	}
+	writeXML(w, result)
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, query url.Values, body []byte) {
+	u, ok := s.uploads[query.Get("uploadId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+		return
+	}
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || number < 1 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+	s.parts++
+	source := r.Header.Get("X-Amz-Copy-Source")
+	if source == "" {
+		u.parts[number] = body
+		w.Header().Set("ETag", etag(body))
+		return
+	}
+	src, ok := s.source(w, source)
+	if !ok {
+		return
+	}
+	data := src.Data
+	if rng := r.Header.Get("X-Amz-Copy-Source-Range"); rng != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil || start > end || end >= len(data) {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid copy range "+rng)
+			return
+		}
+		data = data[start : end+1]
+	}
+	u.parts[number] = data
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyPartResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: etag(data)})
+}
+
+func (s *Server) complete(w http.ResponseWriter, objects map[string]*Object, id string, body []byte) {
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", id)
+		return
+	}
+	req := struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}{}
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "invalid part list")
+		return
+	}
+	data := bytes.Buffer{}
+	for i, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if !ok || etag(part) != p.ETag || (i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber) {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d", p.PartNumber))
+			return
+		}
+		if i < len(req.Parts)-1 && len(part) < minPartSize {
+			writeError(w, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("part %d", p.PartNumber))
+			return
+		}
+		data.Write(part)
+	}
+	delete(s.uploads, id)
+	objects[u.key] = &Object{Data: data.Bytes(), Metadata: u.metadata, ModTime: time.Now()}
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{Key: u.key, ETag: fmt.Sprintf("%q", fmt.Sprintf("%x-%d", md5.Sum(data.Bytes()), len(req.Parts)))})
+}
+
+// source finds the object of an x-amz-copy-source header, /bucket/key
+func (s *Server) source(w http.ResponseWriter, header string) (*Object, bool) {
+	source, err := url.PathUnescape(header)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+		return nil, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+	if len(parts) != 2 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+		return nil, false
+	}
+	object, ok := s.buckets[parts[0]][parts[1]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", parts[1])
+		return nil, false
+	}
+	return object, true
+}
+
+// metadata reads the x-amz-meta-* headers
+func metadata(header http.Header) map[string]string {
+	meta := map[string]string{}
+	for name, values := range header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-amz-meta-") && len(values) != 0 {
+			meta[strings.TrimPrefix(name, "x-amz-meta-")] = values[0]
+		}
+	}
+	return meta
+}
+
+func etag(data []byte) string {
+	return fmt.Sprintf("\"%x\"", md5.Sum(data))
+}
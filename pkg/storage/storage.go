@@ -0,0 +1,96 @@
+// Package storage abstracts where downloaded files are written, a local
+// directory or an S3 compatible bucket.
+package storage
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotExist is returned for missing files, it matches os.ErrNotExist too
+var ErrNotExist = os.ErrNotExist
+
+// ErrSequential is returned by the WriteAt of files uploaded as they're
+// written for any offset but the end of the content written so far
+var ErrSequential = errors.New("only sequential writes are supported")
+
+// metadata keys recording the checksums reported by the API
+const (
+	MetaMD5  = "md5"
+	MetaSHA1 = "sha1"
+)
+
+// Storage holds files by their slash separated name relative to its root
+type Storage interface {
+	// Create starts writing the file name, it only replaces an existing file once committed
+	Create(ctx context.Context, name string, opts CreateOptions) (File, error)
+	// Stat describes the file name, ErrNotExist when there's none
+	Stat(ctx context.Context, name string) (*FileInfo, error)
+	// Rename moves a file, replacing the one at the destination
+	Rename(ctx context.Context, from, to string) error
+	// Remove deletes a file, missing files aren't an error
+	Remove(ctx context.Context, name string) error
+	// List describes the files whose name starts with prefix
+	List(ctx context.Context, prefix string) ([]*FileInfo, error)
+}
+
+// CreateOptions describes the file about to be written
+type CreateOptions struct {
+	// Size is the expected size, local files are preallocated to it
+	Size int64
+	// Metadata is kept with the file where the backend supports it, eg; as S3 user metadata
+	Metadata map[string]string
+}
+
+// File is a file being written, fetchers write it sequentially or, for
+// torrents, piece by piece. Backends uploading the content as it's written,
+// eg; S3, fail the WriteAt out of order with ErrSequential.
+type File interface {
+	io.Writer
+	io.WriterAt
+	// Commit makes the content visible under the file name, modTime is kept
+	// where the backend supports it
+	Commit(modTime time.Time) error
+	// Abort discards the content, the previous file, if any, is left as is
+	Abort() error
+}
+
+// FileInfo describes a stored file
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Metadata is the metadata given on Create, List doesn't return it
+	Metadata map[string]string
+}
+
+// Open opens the storage of a -dest flag, s3://bucket/prefix for a bucket
+// configured by the AWS environment variables, a local directory otherwise
+func Open(dest string) (Storage, error) {
+	if !IsS3(dest) {
+		return NewLocal(dest), nil
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid destination %s", dest)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("invalid destination %s, expected s3://bucket/prefix", dest)
+	}
+	s, err := NewS3(u.Host, strings.Trim(u.Path, "/"), S3FromEnv()...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// IsS3 reports whether a -dest flag names a bucket
+func IsS3(dest string) bool {
+	return strings.HasPrefix(dest, "s3://")
+}
@@ -0,0 +1,165 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// decoder parses bencoded values into int64, string, []interface{} and map[string]interface{}
+type decoder struct {
+	data []byte
+	pos  int
+	// spans records the raw bytes of top level dictionary values, the info dict
+	// must be hashed exactly as it was encoded
+	spans map[string][]byte
+}
+
+// Decode parses a single bencoded value
+func Decode(data []byte) (interface{}, error) {
+	d := decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.Errorf("bencode: trailing data at offset %d", d.pos)
+	}
+	return v, nil
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, errors.New("bencode: unexpected end of data")
+	}
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		d.pos++
+		end := bytes.IndexByte(d.data[d.pos:], 'e')
+		if end < 0 {
+			return nil, errors.New("bencode: unterminated integer")
+		}
+		n, err := strconv.ParseInt(string(d.data[d.pos:d.pos+end]), 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "bencode: invalid integer")
+		}
+		d.pos += end + 1
+		return n, nil
+	case c == 'l':
+		d.pos++
+		list := []interface{}{}
+		for {
+			if d.pos >= len(d.data) {
+				return nil, errors.New("bencode: unterminated list")
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return list, nil
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		d.pos++
+		dict := map[string]interface{}{}
+		for {
+			if d.pos >= len(d.data) {
+				return nil, errors.New("bencode: unterminated dictionary")
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return dict, nil
+			}
+			key, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			start := d.pos
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if depth == 0 && d.spans != nil {
+				d.spans[key] = d.data[start:d.pos]
+			}
+			dict[key] = v
+		}
+	case c >= '0' && c <= '9':
+		return d.str()
+	default:
+		return nil, errors.Errorf("bencode: unexpected %q at offset %d", c, d.pos)
+	}
+}
+
+func (d *decoder) str() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", errors.New("bencode: invalid string length")
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || n < 0 {
+		return "", errors.Errorf("bencode: invalid string length at offset %d", d.pos)
+	}
+	d.pos += colon + 1
+	if d.pos+n > len(d.data) {
+		return "", errors.New("bencode: string exceeds data")
+	}
+	s := string(d.data[d.pos : d.pos+n])
+	d.pos += n
+	return s, nil
+}
+
+// Encode bencodes v, which must be made of integers, strings, []byte, slices and string keyed maps
+func Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(v))
+		buf.Write(v)
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// keys must be sorted as raw strings
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			fmt.Fprintf(buf, "%d:%s", len(k), k)
+			if err := encode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return errors.Errorf("bencode: unsupported type %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+// Package torrent implements a minimal BitTorrent client able to download the
+// content of a single torrent from the peers announced by its trackers.
+package torrent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// blockSize is the amount of data requested from a peer at once
+	blockSize = 16 * 1024
+	// maxBacklog is the number of unfulfilled requests kept in flight per peer
+	maxBacklog = 5
+)
+
+// Client downloads torrents
+type Client struct {
+	PeerID     [20]byte
+	Port       int
+	MaxPeers   int
+	HTTPClient *http.Client
+	// DialTimeout bounds connecting and handshaking with a peer
+	DialTimeout time.Duration
+	// PieceTimeout bounds downloading a single piece from a peer
+	PieceTimeout time.Duration
+}
+
+// NewClient creates a client with a random peer id
+func NewClient() *Client {
+	c := Client{
+		Port:         6881,
+		MaxPeers:     30,
+		HTTPClient:   http.DefaultClient,
+		DialTimeout:  10 * time.Second,
+		PieceTimeout: 60 * time.Second,
+	}
+	copy(c.PeerID[:], "-HB0001-")
+	_, _ = rand.Read(c.PeerID[8:])
+	return &c
+}
+
+type pieceResult struct {
+	index int
+	data  []byte
+}
+
+// Download fetches the whole torrent content and writes it to w, multi file
+// torrents are written as the concatenation of their files
+func (c *Client) Download(ctx context.Context, mi *MetaInfo, w io.WriterAt) error {
+	numPieces := len(mi.Info.Pieces)
+	if numPieces == 0 {
+		return nil
+	}
+
+	peers, err := c.peers(ctx, mi)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan int, numPieces)
+	for i := 0; i < numPieces; i++ {
+		work <- i
+	}
+	results := make(chan pieceResult)
+
+	var group sync.WaitGroup
+	var (
+		lastErr error
+		errMu   sync.Mutex
+	)
+	for _, addr := range peers {
+		addr := addr
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			if err := c.worker(ctx, addr, mi, work, results); err != nil {
+				errMu.Lock()
+				lastErr = errors.Wrapf(err, "peer %s", addr)
+				errMu.Unlock()
+			}
+		}()
+	}
+	workersDone := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(workersDone)
+	}()
+
+	for done := 0; done < numPieces; {
+		select {
+		case res := <-results:
+			if _, err := w.WriteAt(res.data, int64(res.index)*mi.Info.PieceLength); err != nil {
+				return errors.Wrapf(err, "write piece %d", res.index)
+			}
+			done++
+		case <-workersDone:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errMu.Lock()
+			defer errMu.Unlock()
+			if lastErr == nil {
+				lastErr = errors.New("peers disconnected")
+			}
+			return errors.Wrapf(lastErr, "no peer left with %d missing pieces", numPieces-done)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// peers announces to the torrent trackers until one of them returns peers
+func (c *Client) peers(ctx context.Context, mi *MetaInfo) ([]string, error) {
+	req := announceRequest{
+		InfoHash: mi.InfoHash,
+		PeerID:   c.PeerID,
+		Port:     c.Port,
+		Left:     mi.Info.TotalLength(),
+	}
+	trackers := mi.Trackers()
+	if len(trackers) == 0 {
+		return nil, errors.New("torrent has no trackers")
+	}
+	var lastErr error
+	for _, tracker := range trackers {
+		peers, err := announce(ctx, c.HTTPClient, tracker, req)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "announce %s", tracker)
+			continue
+		}
+		if len(peers) == 0 {
+			lastErr = errors.Errorf("announce %s: no peers", tracker)
+			continue
+		}
+		if c.MaxPeers > 0 && len(peers) > c.MaxPeers {
+			peers = peers[:c.MaxPeers]
+		}
+		return peers, nil
+	}
+	return nil, lastErr
+}
+
+// worker downloads pieces from a single peer until the work queue is drained or the peer fails
+func (c *Client) worker(ctx context.Context, addr string, mi *MetaInfo, work chan int, results chan<- pieceResult) error {
+	dialer := net.Dialer{Timeout: c.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "dial")
+	}
+	defer conn.Close()
+
+	// unblock reads when the download is cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	pc := peerConn{
+		conn:     conn,
+		choked:   true,
+		bitfield: NewBitfield(len(mi.Info.Pieces)),
+	}
+	_ = conn.SetDeadline(time.Now().Add(c.DialTimeout))
+	if err := WriteHandshake(conn, mi.InfoHash, c.PeerID); err != nil {
+		return errors.Wrap(err, "write handshake")
+	}
+	infoHash, _, err := ReadHandshake(conn)
+	if err != nil {
+		return err
+	}
+	if infoHash != mi.InfoHash {
+		return errors.New("peer info hash mismatch")
+	}
+	if err := WriteMessage(conn, &Message{ID: MsgInterested}); err != nil {
+		return errors.Wrap(err, "write interested")
+	}
+	for pc.choked {
+		msg, err := ReadMessage(conn)
+		if err != nil {
+			return errors.Wrap(err, "waiting for unchoke")
+		}
+		pc.handle(msg)
+	}
+
+	misses := 0
+	for {
+		var index int
+		select {
+		case index = <-work:
+		case <-ctx.Done():
+			return nil
+		}
+		if !pc.bitfield.Has(index) {
+			work <- index
+			// give up on peers that don't have anything we still need
+			if misses++; misses > len(mi.Info.Pieces) {
+				return nil
+			}
+			continue
+		}
+		misses = 0
+
+		_ = conn.SetDeadline(time.Now().Add(c.PieceTimeout))
+		data, err := pc.downloadPiece(index, int(mi.Info.pieceSize(index)))
+		if err == nil && sha1.Sum(data) != mi.Info.Pieces[index] {
+			err = errors.Errorf("piece %d failed hash check", index)
+		}
+		if err != nil {
+			work <- index
+			return err
+		}
+		select {
+		case results <- pieceResult{index: index, data: data}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handle updates the peer state from a control message
+func (pc *peerConn) handle(msg *Message) {
+	if msg == nil {
+		return
+	}
+	switch msg.ID {
+	case MsgChoke:
+		pc.choked = true
+	case MsgUnchoke:
+		pc.choked = false
+	case MsgHave:
+		if len(msg.Payload) == 4 {
+			pc.bitfield.Set(int(binary.BigEndian.Uint32(msg.Payload)))
+		}
+	case MsgBitfield:
+		copy(pc.bitfield, msg.Payload)
+	}
+}
+
+// downloadPiece requests all the blocks of a piece, keeping a few requests in flight
+func (pc *peerConn) downloadPiece(index, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	received := map[int]struct{}{}
+	downloaded, requested, backlog := 0, 0, 0
+	for downloaded < size {
+		if !pc.choked {
+			for backlog < maxBacklog && requested < size {
+				length := blockSize
+				if size-requested < length {
+					length = size - requested
+				}
+				if _, ok := received[requested]; !ok {
+					if err := WriteMessage(pc.conn, RequestMessage(index, requested, length)); err != nil {
+						return nil, errors.Wrap(err, "write request")
+					}
+					backlog++
+				}
+				requested += length
+			}
+		}
+
+		msg, err := ReadMessage(pc.conn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read piece %d", index)
+		}
+		if msg == nil {
+			continue
+		}
+		if msg.ID != MsgPiece {
+			pc.handle(msg)
+			if msg.ID == MsgChoke {
+				// a choke discards the pending requests
+				backlog, requested = 0, 0
+			}
+			continue
+		}
+		if len(msg.Payload) < 8 || int(binary.BigEndian.Uint32(msg.Payload)) != index {
+			continue
+		}
+		begin := int(binary.BigEndian.Uint32(msg.Payload[4:]))
+		block := msg.Payload[8:]
+		if begin < 0 || begin+len(block) > size {
+			return nil, errors.Errorf("block out of range for piece %d", index)
+		}
+		if backlog > 0 {
+			backlog--
+		}
+		if _, ok := received[begin]; ok {
+			continue
+		}
+		received[begin] = struct{}{}
+		copy(buf[begin:], block)
+		downloaded += len(block)
+	}
+	return buf, nil
+}
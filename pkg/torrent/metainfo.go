@@ -0,0 +1,153 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// MaxPieceLength bounds the pieces buffered in memory while they're verified,
+// clients don't create pieces larger than a few MiB
+const MaxPieceLength = 64 << 20
+
+// MetaInfo is the parsed content of a .torrent file
+type MetaInfo struct {
+	Announce     string
+	AnnounceList [][]string
+	Info         Info
+	InfoHash     [20]byte
+}
+
+// Info is the info dictionary of a torrent
+type Info struct {
+	Name        string
+	PieceLength int64
+	Pieces      [][20]byte
+	Length      int64
+	Files       []File
+}
+
+// File is an entry of a multi file torrent
+type File struct {
+	Path   []string
+	Length int64
+}
+
+// TotalLength is the size of all the torrent content
+func (i *Info) TotalLength() int64 {
+	if len(i.Files) == 0 {
+		return i.Length
+	}
+	var total int64
+	for _, f := range i.Files {
+		total += f.Length
+	}
+	return total
+}
+
+// pieceSize returns the size of the piece at index, the last piece may be shorter
+func (i *Info) pieceSize(index int) int64 {
+	begin := int64(index) * i.PieceLength
+	end := begin + i.PieceLength
+	if total := i.TotalLength(); end > total {
+		end = total
+	}
+	return end - begin
+}
+
+// Trackers returns the announce URLs in the order they should be tried
+func (m *MetaInfo) Trackers() []string {
+	seen := map[string]struct{}{}
+	urls := []string{}
+	add := func(u string) {
+		if _, ok := seen[u]; ok || u == "" {
+			return
+		}
+		seen[u] = struct{}{}
+		urls = append(urls, u)
+	}
+	for _, tier := range m.AnnounceList {
+		for _, u := range tier {
+			add(u)
+		}
+	}
+	add(m.Announce)
+	return urls
+}
+
+// ReadMetaInfo parses a .torrent file
+func ReadMetaInfo(r io.Reader) (*MetaInfo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadAll torrent")
+	}
+	d := decoder{data: data, spans: map[string][]byte{}}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent: metainfo is not a dictionary")
+	}
+	infoDict, ok := root["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent: missing info dictionary")
+	}
+
+	mi := MetaInfo{
+		InfoHash: sha1.Sum(d.spans["info"]),
+	}
+	mi.Announce, _ = root["announce"].(string)
+	if tiers, ok := root["announce-list"].([]interface{}); ok {
+		for _, t := range tiers {
+			list, _ := t.([]interface{})
+			tier := []string{}
+			for _, u := range list {
+				if s, ok := u.(string); ok {
+					tier = append(tier, s)
+				}
+			}
+			mi.AnnounceList = append(mi.AnnounceList, tier)
+		}
+	}
+
+	info := &mi.Info
+	info.Name, _ = infoDict["name"].(string)
+	info.PieceLength, _ = infoDict["piece length"].(int64)
+	if info.PieceLength <= 0 || info.PieceLength > MaxPieceLength {
+		return nil, errors.Errorf("torrent: invalid piece length %d", info.PieceLength)
+	}
+	pieces, _ := infoDict["pieces"].(string)
+	if len(pieces)%20 != 0 {
+		return nil, errors.New("torrent: malformed pieces")
+	}
+	for i := 0; i < len(pieces); i += 20 {
+		var h [20]byte
+		copy(h[:], pieces[i:i+20])
+		info.Pieces = append(info.Pieces, h)
+	}
+	info.Length, _ = infoDict["length"].(int64)
+	if files, ok := infoDict["files"].([]interface{}); ok {
+		for _, f := range files {
+			fd, _ := f.(map[string]interface{})
+			file := File{}
+			file.Length, _ = fd["length"].(int64)
+			parts, _ := fd["path"].([]interface{})
+			for _, p := range parts {
+				if s, ok := p.(string); ok {
+					file.Path = append(file.Path, s)
+				}
+			}
+			info.Files = append(info.Files, file)
+		}
+	}
+
+	total := info.TotalLength()
+	if want := (total + info.PieceLength - 1) / info.PieceLength; int64(len(info.Pieces)) != want {
+		return nil, errors.Errorf("torrent: expected %d pieces but got %d", want, len(info.Pieces))
+	}
+	return &mi, nil
+}
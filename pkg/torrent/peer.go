@@ -0,0 +1,136 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// peer wire message ids
+const (
+	MsgChoke         uint8 = 0
+	MsgUnchoke       uint8 = 1
+	MsgInterested    uint8 = 2
+	MsgNotInterested uint8 = 3
+	MsgHave          uint8 = 4
+	MsgBitfield      uint8 = 5
+	MsgRequest       uint8 = 6
+	MsgPiece         uint8 = 7
+	MsgCancel        uint8 = 8
+)
+
+const protocolName = "BitTorrent protocol"
+
+// maxMessageLength bounds the size of a single peer message, blocks are 16KiB
+const maxMessageLength = 1 << 20
+
+// Message is a single peer wire message, a nil *Message is a keep-alive
+type Message struct {
+	ID      uint8
+	Payload []byte
+}
+
+// WriteHandshake sends the protocol handshake
+func WriteHandshake(w io.Writer, infoHash, peerID [20]byte) error {
+	buf := make([]byte, 0, 68)
+	buf = append(buf, byte(len(protocolName)))
+	buf = append(buf, protocolName...)
+	buf = append(buf, make([]byte, 8)...)
+	buf = append(buf, infoHash[:]...)
+	buf = append(buf, peerID[:]...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadHandshake reads a handshake and returns the info hash and peer id it carries
+func ReadHandshake(r io.Reader) (infoHash, peerID [20]byte, err error) {
+	buf := make([]byte, 68)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return infoHash, peerID, errors.Wrap(err, "read handshake")
+	}
+	if int(buf[0]) != len(protocolName) || !bytes.Equal(buf[1:20], []byte(protocolName)) {
+		return infoHash, peerID, errors.New("unknown peer protocol")
+	}
+	copy(infoHash[:], buf[28:48])
+	copy(peerID[:], buf[48:68])
+	return infoHash, peerID, nil
+}
+
+// WriteMessage sends a message, nil sends a keep-alive
+func WriteMessage(w io.Writer, m *Message) error {
+	if m == nil {
+		_, err := w.Write(make([]byte, 4))
+		return err
+	}
+	buf := make([]byte, 5+len(m.Payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(m.Payload)))
+	buf[4] = m.ID
+	copy(buf[5:], m.Payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadMessage reads a single message, keep-alives are returned as nil
+func ReadMessage(r io.Reader) (*Message, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lenBuf[:])
+	if length == 0 {
+		return nil, nil
+	}
+	if length > maxMessageLength {
+		return nil, errors.Errorf("peer message too large: %d bytes", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return &Message{ID: buf[0], Payload: buf[1:]}, nil
+}
+
+// RequestMessage builds a request for a block of a piece
+func RequestMessage(index, begin, length int) *Message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:], uint32(length))
+	return &Message{ID: MsgRequest, Payload: payload}
+}
+
+// Bitfield tracks which pieces a peer has
+type Bitfield []byte
+
+// NewBitfield creates a bitfield large enough for n pieces
+func NewBitfield(n int) Bitfield {
+	return make(Bitfield, (n+7)/8)
+}
+
+// Has reports whether the piece at index is set
+func (b Bitfield) Has(index int) bool {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(b) {
+		return false
+	}
+	return b[byteIndex]>>(7-uint(index%8))&1 != 0
+}
+
+// Set marks the piece at index
+func (b Bitfield) Set(index int) {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(b) {
+		return
+	}
+	b[byteIndex] |= 1 << (7 - uint(index%8))
+}
+
+// peerConn is an established connection to a remote peer
+type peerConn struct {
+	conn     net.Conn
+	choked   bool
+	bitfield Bitfield
+}
@@ -0,0 +1,128 @@
+package torrent_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"diogogmt.com/hbd/pkg/torrent"
+	"diogogmt.com/hbd/pkg/torrent/torrenttest"
+)
+
+func TestBencode(t *testing.T) {
+	dd := []struct {
+		in      interface{}
+		encoded string
+	}{
+		{in: int64(42), encoded: "i42e"},
+		{in: int64(-3), encoded: "i-3e"},
+		{in: "spam", encoded: "4:spam"},
+		{in: []interface{}{"spam", int64(1)}, encoded: "l4:spami1ee"},
+		{
+			in:      map[string]interface{}{"zeta": "z", "alpha": []interface{}{}},
+			encoded: "d5:alphale4:zeta1:ze",
+		},
+	}
+	for _, d := range dd {
+		by, err := torrent.Encode(d.in)
+		if err != nil {
+			t.Fatalf("%s: torrent.Encode: %v", d.encoded, err)
+		}
+		if string(by) != d.encoded {
+			t.Errorf("expected %q but got %q", d.encoded, by)
+		}
+		v, err := torrent.Decode(by)
+		if err != nil {
+			t.Fatalf("%s: torrent.Decode: %v", d.encoded, err)
+		}
+		if !reflect.DeepEqual(v, d.in) {
+			t.Errorf("%s: expected %#v but got %#v", d.encoded, d.in, v)
+		}
+	}
+
+	for _, invalid := range []string{"i42", "5:spam", "l4:spam", "x", "i1ei2e"} {
+		if _, err := torrent.Decode([]byte(invalid)); err == nil {
+			t.Errorf("%s: expected decode error", invalid)
+		}
+	}
+}
+
+func TestReadMetaInfo(t *testing.T) {
+	dd := []struct {
+		pieceLength int64
+		expectErr   bool
+	}{
+		{pieceLength: 1 << 18},
+		{pieceLength: torrent.MaxPieceLength},
+		{pieceLength: 0, expectErr: true},
+		{pieceLength: torrent.MaxPieceLength + 1, expectErr: true},
+		{pieceLength: 1 << 40, expectErr: true},
+	}
+	for _, d := range dd {
+		by, err := torrent.Encode(map[string]interface{}{
+			"announce": "http://tracker/announce",
+			"info": map[string]interface{}{
+				"name":         "book.pdf",
+				"piece length": d.pieceLength,
+				"pieces":       string(make([]byte, 20)),
+				"length":       int64(10),
+			},
+		})
+		if err != nil {
+			t.Fatalf("torrent.Encode: %v", err)
+		}
+		mi, err := torrent.ReadMetaInfo(bytes.NewReader(by))
+		if d.expectErr {
+			if err == nil {
+				t.Errorf("%d: expected the piece length to be refused", d.pieceLength)
+			}
+			continue
+		}
+		if err != nil || mi.Info.PieceLength != d.pieceLength {
+			t.Errorf("%d: expected the piece length to be kept but got %+v %v", d.pieceLength, mi, err)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	data := make([]byte, 100*1024+123)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	seeder := torrenttest.NewSeeder("book.pdf", data, 32*1024)
+	defer seeder.Close()
+
+	mi, err := torrent.ReadMetaInfo(bytes.NewReader(seeder.Torrent))
+	if err != nil {
+		t.Fatalf("torrent.ReadMetaInfo: %v", err)
+	}
+	if mi.Info.Name != "book.pdf" || mi.Info.TotalLength() != int64(len(data)) || len(mi.Info.Pieces) != 4 {
+		t.Fatalf("unexpected metainfo %+v", mi.Info)
+	}
+
+	f, err := ioutil.TempFile("", "hbd-torrent.")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := torrent.NewClient().Download(ctx, mi, f); err != nil {
+		t.Fatalf("client.Download: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile: %v", err)
+	}
+	if sha1.Sum(got) != sha1.Sum(data) {
+		t.Errorf("downloaded content doesn't match the seeded data")
+	}
+}
@@ -0,0 +1,182 @@
+// Package torrenttest provides a local tracker and seeder for testing torrent downloads.
+package torrenttest
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"diogogmt.com/hbd/pkg/torrent"
+)
+
+// Seeder serves a single file torrent, its metainfo and an HTTP tracker announcing itself
+type Seeder struct {
+	// URL of the .torrent file
+	TorrentURL string
+	// Torrent is the encoded metainfo
+	Torrent []byte
+	// Data is the content being seeded
+	Data []byte
+
+	listener  net.Listener
+	http      *httptest.Server
+	pieceSize int
+	mu        sync.Mutex
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewSeeder starts seeding data as a torrent named name
+func NewSeeder(name string, data []byte, pieceSize int) *Seeder {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("torrenttest: net.Listen: %v", err))
+	}
+	s := Seeder{
+		Data:      data,
+		listener:  listener,
+		pieceSize: pieceSize,
+		conns:     map[net.Conn]struct{}{},
+	}
+
+	mux := http.NewServeMux()
+	s.http = httptest.NewServer(mux)
+
+	pieces := []byte{}
+	for i := 0; i < len(data); i += pieceSize {
+		end := i + pieceSize
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha1.Sum(data[i:end])
+		pieces = append(pieces, h[:]...)
+	}
+	s.Torrent, err = torrent.Encode(map[string]interface{}{
+		"announce": s.http.URL + "/announce",
+		"info": map[string]interface{}{
+			"name":         name,
+			"length":       len(data),
+			"piece length": pieceSize,
+			"pieces":       pieces,
+		},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("torrenttest: torrent.Encode: %v", err))
+	}
+	s.TorrentURL = s.http.URL + "/" + name + ".torrent"
+
+	mux.HandleFunc("/"+name+".torrent", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		w.Write(s.Torrent)
+	})
+	mux.HandleFunc("/announce", s.announce)
+
+	s.wg.Add(1)
+	go s.serve()
+	return &s
+}
+
+// announce returns the seeder as the only peer
+func (s *Seeder) announce(w http.ResponseWriter, r *http.Request) {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	peer := make([]byte, 6)
+	copy(peer, addr.IP.To4())
+	binary.BigEndian.PutUint16(peer[4:], uint16(addr.Port))
+	by, _ := torrent.Encode(map[string]interface{}{
+		"interval": 1800,
+		"peers":    peer,
+	})
+	w.Write(by)
+}
+
+func (s *Seeder) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (s *Seeder) handle(conn net.Conn) {
+	infoHash, _, err := torrent.ReadHandshake(conn)
+	if err != nil {
+		return
+	}
+	var peerID [20]byte
+	copy(peerID[:], "-TT0001-seeder000000")
+	if err := torrent.WriteHandshake(conn, infoHash, peerID); err != nil {
+		return
+	}
+
+	numPieces := (len(s.Data) + s.pieceSize - 1) / s.pieceSize
+	bitfield := torrent.NewBitfield(numPieces)
+	for i := 0; i < numPieces; i++ {
+		bitfield.Set(i)
+	}
+	if err := torrent.WriteMessage(conn, &torrent.Message{ID: torrent.MsgBitfield, Payload: bitfield}); err != nil {
+		return
+	}
+
+	for {
+		msg, err := torrent.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if msg == nil {
+			continue
+		}
+		switch msg.ID {
+		case torrent.MsgInterested:
+			if err := torrent.WriteMessage(conn, &torrent.Message{ID: torrent.MsgUnchoke}); err != nil {
+				return
+			}
+		case torrent.MsgRequest:
+			if len(msg.Payload) != 12 {
+				return
+			}
+			index := int(binary.BigEndian.Uint32(msg.Payload[0:]))
+			begin := int(binary.BigEndian.Uint32(msg.Payload[4:]))
+			length := int(binary.BigEndian.Uint32(msg.Payload[8:]))
+			offset := index*s.pieceSize + begin
+			if offset+length > len(s.Data) {
+				return
+			}
+			payload := make([]byte, 8+length)
+			copy(payload, msg.Payload[:8])
+			copy(payload[8:], s.Data[offset:offset+length])
+			if err := torrent.WriteMessage(conn, &torrent.Message{ID: torrent.MsgPiece, Payload: payload}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close stops the tracker and the seeder
+func (s *Seeder) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.http.Close()
+	s.wg.Wait()
+}
@@ -0,0 +1,189 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// announceRequest holds the parameters sent to a tracker
+type announceRequest struct {
+	InfoHash [20]byte
+	PeerID   [20]byte
+	Port     int
+	Left     int64
+}
+
+// announce asks a tracker for peers, http(s) and udp trackers are supported
+func announce(ctx context.Context, httpClient *http.Client, tracker string, req announceRequest) ([]string, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, errors.Wrapf(err, "url.Parse tracker %q", tracker)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return announceHTTP(ctx, httpClient, u, req)
+	case "udp":
+		return announceUDP(ctx, u.Host, req)
+	default:
+		return nil, errors.Errorf("unsupported tracker scheme %q", u.Scheme)
+	}
+}
+
+func announceHTTP(ctx context.Context, httpClient *http.Client, u *url.URL, req announceRequest) ([]string, error) {
+	q := u.Query()
+	q.Set("info_hash", string(req.InfoHash[:]))
+	q.Set("peer_id", string(req.PeerID[:]))
+	q.Set("port", strconv.Itoa(req.Port))
+	q.Set("uploaded", "0")
+	q.Set("downloaded", "0")
+	q.Set("left", strconv.FormatInt(req.Left, 10))
+	q.Set("compact", "1")
+	q.Set("event", "started")
+	u.RawQuery = q.Encode()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "http.NewRequestWithContext announce")
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "httpClient.Do announce")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadAll announce response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.Errorf("tracker responded with status %d", resp.StatusCode)
+	}
+
+	v, err := Decode(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode announce response")
+	}
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("tracker response is not a dictionary")
+	}
+	if reason, ok := dict["failure reason"].(string); ok {
+		return nil, errors.Errorf("tracker failure: %s", reason)
+	}
+	switch peers := dict["peers"].(type) {
+	case string:
+		return compactPeers([]byte(peers))
+	case []interface{}:
+		addrs := []string{}
+		for _, p := range peers {
+			pd, _ := p.(map[string]interface{})
+			ip, _ := pd["ip"].(string)
+			port, _ := pd["port"].(int64)
+			if ip == "" || port == 0 {
+				continue
+			}
+			addrs = append(addrs, net.JoinHostPort(ip, strconv.FormatInt(port, 10)))
+		}
+		return addrs, nil
+	default:
+		return nil, errors.New("tracker response has no peers")
+	}
+}
+
+// compactPeers parses the 6 bytes per peer compact format
+func compactPeers(b []byte) ([]string, error) {
+	if len(b)%6 != 0 {
+		return nil, errors.New("malformed compact peers")
+	}
+	addrs := make([]string, 0, len(b)/6)
+	for i := 0; i < len(b); i += 6 {
+		ip := net.IP(b[i : i+4])
+		port := binary.BigEndian.Uint16(b[i+4 : i+6])
+		addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return addrs, nil
+}
+
+// udp tracker protocol, see BEP 15
+const (
+	udpProtocolID     = 0x41727101980
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionError    = 3
+)
+
+func announceUDP(ctx context.Context, host string, req announceRequest) ([]string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial udp tracker %s", host)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(15 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	var txID [4]byte
+	if _, err := rand.Read(txID[:]); err != nil {
+		return nil, errors.Wrap(err, "rand.Read")
+	}
+
+	connectReq := make([]byte, 16)
+	binary.BigEndian.PutUint64(connectReq[0:], udpProtocolID)
+	binary.BigEndian.PutUint32(connectReq[8:], udpActionConnect)
+	copy(connectReq[12:], txID[:])
+	resp, err := udpRoundTrip(conn, connectReq, txID, udpActionConnect, 16)
+	if err != nil {
+		return nil, errors.Wrap(err, "udp tracker connect")
+	}
+	connectionID := resp[8:16]
+
+	announceReq := make([]byte, 98)
+	copy(announceReq[0:], connectionID)
+	binary.BigEndian.PutUint32(announceReq[8:], udpActionAnnounce)
+	copy(announceReq[12:], txID[:])
+	copy(announceReq[16:], req.InfoHash[:])
+	copy(announceReq[36:], req.PeerID[:])
+	binary.BigEndian.PutUint64(announceReq[64:], uint64(req.Left))
+	binary.BigEndian.PutUint32(announceReq[80:], 2) // event started
+	binary.BigEndian.PutUint32(announceReq[92:], 0xFFFFFFFF)
+	binary.BigEndian.PutUint16(announceReq[96:], uint16(req.Port))
+	resp, err = udpRoundTrip(conn, announceReq, txID, udpActionAnnounce, 20)
+	if err != nil {
+		return nil, errors.Wrap(err, "udp tracker announce")
+	}
+	return compactPeers(resp[20:])
+}
+
+func udpRoundTrip(conn net.Conn, msg []byte, txID [4]byte, action uint32, minLen int) ([]byte, error) {
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	buf = buf[:n]
+	if n < 8 || !bytes.Equal(buf[4:8], txID[:]) {
+		return nil, errors.New("unexpected udp tracker response")
+	}
+	if got := binary.BigEndian.Uint32(buf[0:4]); got == udpActionError {
+		return nil, errors.Errorf("tracker failure: %s", buf[8:])
+	} else if got != action || n < minLen {
+		return nil, errors.New("unexpected udp tracker response")
+	}
+	return buf, nil
+}
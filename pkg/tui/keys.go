@@ -0,0 +1,109 @@
+package tui
+
+import (
+	"bufio"
+)
+
+// KeyCode identifies a key press, printable keys are KeyRune
+type KeyCode int
+
+// keys understood by the picker
+const (
+	KeyRune KeyCode = iota
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyPgUp
+	KeyPgDn
+	KeyHome
+	KeyEnd
+	KeyEnter
+	KeyBackspace
+	KeyEsc
+	KeyTab
+	KeyCtrlC
+	KeyUnknown
+)
+
+// Key is a decoded key press
+type Key struct {
+	Code KeyCode
+	Rune rune
+}
+
+// readKey decodes the next key press, including the ANSI escape sequences
+// sent by arrow and paging keys
+func readKey(r *bufio.Reader) (Key, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return Key{}, err
+	}
+	switch c {
+	case '\r', '\n':
+		return Key{Code: KeyEnter}, nil
+	case 127, 8:
+		return Key{Code: KeyBackspace}, nil
+	case '\t':
+		return Key{Code: KeyTab}, nil
+	case 3:
+		return Key{Code: KeyCtrlC}, nil
+	case 14:
+		return Key{Code: KeyDown}, nil
+	case 16:
+		return Key{Code: KeyUp}, nil
+	case 27:
+		return readEscape(r)
+	}
+	if c < 32 {
+		return Key{Code: KeyUnknown}, nil
+	}
+	return Key{Code: KeyRune, Rune: c}, nil
+}
+
+// readEscape decodes the rest of an escape sequence, a lone escape is the Esc key
+func readEscape(r *bufio.Reader) (Key, error) {
+	if r.Buffered() == 0 {
+		return Key{Code: KeyEsc}, nil
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return Key{}, err
+	}
+	if b != '[' && b != 'O' {
+		r.UnreadByte()
+		return Key{Code: KeyEsc}, nil
+	}
+	// CSI sequences end with a byte in the @-~ range, eg; ESC [ A or ESC [ 5 ~
+	seq := []byte{}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return Key{}, err
+		}
+		seq = append(seq, b)
+		if b >= '@' && b <= '~' {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		return Key{Code: KeyUp}, nil
+	case "B":
+		return Key{Code: KeyDown}, nil
+	case "C":
+		return Key{Code: KeyRight}, nil
+	case "D":
+		return Key{Code: KeyLeft}, nil
+	case "H", "1~", "7~":
+		return Key{Code: KeyHome}, nil
+	case "F", "4~", "8~":
+		return Key{Code: KeyEnd}, nil
+	case "5~":
+		return Key{Code: KeyPgUp}, nil
+	case "6~":
+		return Key{Code: KeyPgDn}, nil
+	default:
+		return Key{Code: KeyUnknown}, nil
+	}
+}
@@ -0,0 +1,448 @@
+// Package tui implements an interactive terminal picker to choose which
+// assets of an order to download.
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/units"
+	"github.com/pkg/errors"
+)
+
+// ErrCancelled is returned when the picker is closed without confirming a selection
+var ErrCancelled = errors.New("selection cancelled")
+
+// ErrInterrupted is returned when the context is done before a selection is confirmed
+var ErrInterrupted = errors.New("interrupted")
+
+// node is a row of the tree, products hold platforms and platforms hold asset leaves
+type node struct {
+	label    string
+	depth    int
+	asset    int // index of the leaf asset, -1 for products and platforms
+	leaves   []int
+	children []*node
+	expanded bool
+}
+
+// row is a visible node with the leaves matching the search query
+type row struct {
+	node   *node
+	leaves []int
+}
+
+// Picker is a tree of products, platforms and types where assets are checked
+// for download, it's driven by key presses so it can be tested without a terminal
+type Picker struct {
+	assets   hbclient.Assets
+	selected []bool
+	roots    []*node
+
+	query     string
+	searching bool
+	cursor    int
+	offset    int
+	done      bool
+	cancelled bool
+}
+
+// NewPicker builds the tree of products, platforms and types of the assets,
+// the assets matching preselect start checked
+func NewPicker(assets hbclient.Assets, preselect hbclient.AssetFilter) *Picker {
+	p := Picker{
+		assets:   assets,
+		selected: make([]bool, len(assets)),
+	}
+	products := map[*hbclient.Product]*node{}
+	platforms := map[*hbclient.Download]*node{}
+	for i, a := range assets {
+		prod, ok := products[a.Product]
+		if !ok {
+			prod = &node{label: a.Product.HumanName, asset: -1}
+			products[a.Product] = prod
+			p.roots = append(p.roots, prod)
+		}
+		platform, ok := platforms[a.Download]
+		if !ok {
+			platform = &node{label: a.Platform(), depth: 1, asset: -1, expanded: true}
+			platforms[a.Download] = platform
+			prod.children = append(prod.children, platform)
+		}
+		platform.children = append(platform.children, &node{label: a.Type.Name, depth: 2, asset: i, leaves: []int{i}})
+		platform.leaves = append(platform.leaves, i)
+		prod.leaves = append(prod.leaves, i)
+		if preselect != nil && preselect(a) {
+			p.selected[i] = true
+		}
+	}
+	return &p
+}
+
+// Selected returns the checked assets in their original order
+func (p *Picker) Selected() hbclient.Assets {
+	selected := hbclient.Assets{}
+	for i, a := range p.assets {
+		if p.selected[i] {
+			selected = append(selected, a)
+		}
+	}
+	return selected
+}
+
+// Done reports whether the picker was confirmed or cancelled
+func (p *Picker) Done() bool {
+	return p.done
+}
+
+// Cancelled reports whether the picker was closed without confirming
+func (p *Picker) Cancelled() bool {
+	return p.cancelled
+}
+
+// HandleKey applies a key press
+func (p *Picker) HandleKey(k Key) {
+	rows := p.rows()
+	if k.Code == KeyCtrlC {
+		p.done, p.cancelled = true, true
+		return
+	}
+	if p.searching {
+		switch k.Code {
+		case KeyRune:
+			p.query += string(k.Rune)
+			p.cursor = 0
+			return
+		case KeyBackspace:
+			if q := []rune(p.query); len(q) > 0 {
+				p.query = string(q[:len(q)-1])
+			}
+			p.cursor = 0
+			return
+		case KeyEnter:
+			p.searching = false
+			return
+		case KeyEsc:
+			p.searching = false
+			p.query = ""
+			p.cursor = 0
+			return
+		}
+	}
+
+	switch k.Code {
+	case KeyUp:
+		p.cursor--
+	case KeyDown, KeyTab:
+		p.cursor++
+	case KeyPgUp:
+		p.cursor -= 10
+	case KeyPgDn:
+		p.cursor += 10
+	case KeyHome:
+		p.cursor = 0
+	case KeyEnd:
+		p.cursor = len(rows) - 1
+	case KeyRight:
+		if r := p.rowAt(rows); r != nil {
+			r.node.expanded = true
+		}
+	case KeyLeft:
+		p.collapse(rows)
+	case KeyEnter:
+		p.done = true
+	case KeyEsc:
+		p.done, p.cancelled = true, true
+	case KeyRune:
+		switch k.Rune {
+		case ' ', 'x':
+			if r := p.rowAt(rows); r != nil {
+				p.toggle(r.leaves)
+			}
+		case 't':
+			p.toggleType(rows)
+		case 'a':
+			all := []int{}
+			for _, r := range rows {
+				if r.node.depth == 0 {
+					all = append(all, r.leaves...)
+				}
+			}
+			p.toggle(all)
+		case '/':
+			p.searching = true
+		case 'j':
+			p.cursor++
+		case 'k':
+			p.cursor--
+		case 'l':
+			if r := p.rowAt(rows); r != nil {
+				r.node.expanded = true
+			}
+		case 'h':
+			p.collapse(rows)
+		case 'q':
+			p.done, p.cancelled = true, true
+		}
+	}
+	p.clampCursor(len(p.rows()))
+}
+
+// rowAt returns the row under the cursor
+func (p *Picker) rowAt(rows []row) *row {
+	if p.cursor < 0 || p.cursor >= len(rows) {
+		return nil
+	}
+	return &rows[p.cursor]
+}
+
+// collapse folds the node under the cursor or moves the cursor to its parent
+func (p *Picker) collapse(rows []row) {
+	r := p.rowAt(rows)
+	if r == nil {
+		return
+	}
+	if r.node.expanded && len(r.node.children) > 0 {
+		r.node.expanded = false
+		return
+	}
+	for i := p.cursor - 1; i >= 0; i-- {
+		if rows[i].node.depth < r.node.depth {
+			p.cursor = i
+			return
+		}
+	}
+}
+
+// toggle checks all the leaves, or unchecks them when they are all checked already
+func (p *Picker) toggle(leaves []int) {
+	all := true
+	for _, i := range leaves {
+		all = all && p.selected[i]
+	}
+	for _, i := range leaves {
+		p.selected[i] = !all
+	}
+}
+
+// toggleType toggles every visible asset with the same type as the one under the cursor
+func (p *Picker) toggleType(rows []row) {
+	r := p.rowAt(rows)
+	if r == nil || len(r.leaves) == 0 {
+		return
+	}
+	typeName := strings.ToLower(p.assets[r.leaves[0]].Type.Name)
+	leaves := []int{}
+	for _, r := range rows {
+		if r.node.depth != 0 {
+			continue
+		}
+		for _, i := range r.leaves {
+			if strings.ToLower(p.assets[i].Type.Name) == typeName {
+				leaves = append(leaves, i)
+			}
+		}
+	}
+	p.toggle(leaves)
+}
+
+func (p *Picker) clampCursor(n int) {
+	if p.cursor >= n {
+		p.cursor = n - 1
+	}
+	if p.cursor < 0 {
+		p.cursor = 0
+	}
+}
+
+// matches reports whether an asset matches the search query
+func (p *Picker) matches(i int) bool {
+	if p.query == "" {
+		return true
+	}
+	a := p.assets[i]
+	text := strings.ToLower(strings.Join([]string{a.Product.HumanName, a.Platform(), a.Type.Name}, " "))
+	for _, word := range strings.Fields(strings.ToLower(p.query)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// rows lists the visible rows, every node with a match is expanded while searching
+func (p *Picker) rows() []row {
+	rows := []row{}
+	var walk func(nodes []*node)
+	walk = func(nodes []*node) {
+		for _, n := range nodes {
+			leaves := []int{}
+			for _, i := range n.leaves {
+				if p.matches(i) {
+					leaves = append(leaves, i)
+				}
+			}
+			if len(leaves) == 0 {
+				continue
+			}
+			rows = append(rows, row{node: n, leaves: leaves})
+			if n.expanded || p.query != "" {
+				walk(n.children)
+			}
+		}
+	}
+	walk(p.roots)
+	return rows
+}
+
+// Render draws the picker on a screen of the given size
+func (p *Picker) Render(w io.Writer, width, height int) {
+	rows := p.rows()
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	writeLine(&b, width, "space toggle, t toggle type, a toggle all, / search, arrows move/expand, enter download, q quit")
+	search := "search: " + p.query
+	if p.searching {
+		search += "_"
+	}
+	writeLine(&b, width, search)
+
+	// keep the cursor inside the rows that fit between the header and footer
+	visible := height - 4
+	if visible < 1 {
+		visible = 1
+	}
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	}
+	if p.cursor >= p.offset+visible {
+		p.offset = p.cursor - visible + 1
+	}
+	for i := p.offset; i < len(rows) && i < p.offset+visible; i++ {
+		r := rows[i]
+		cursor := " "
+		if i == p.cursor {
+			cursor = ">"
+		}
+		fold := " "
+		if len(r.node.children) > 0 {
+			fold = "+"
+			if r.node.expanded || p.query != "" {
+				fold = "-"
+			}
+		}
+		var size int64
+		for _, leaf := range r.leaves {
+			size += p.assets[leaf].Size()
+		}
+		writeLine(&b, width, fmt.Sprintf("%s%s%s %s %s (%s)", cursor, strings.Repeat("  ", r.node.depth), fold, p.checkbox(r.leaves), r.node.label, units.FormatSize(size)))
+	}
+	if len(rows) == 0 {
+		writeLine(&b, width, "  no assets match the search")
+	}
+	selected := p.Selected()
+	writeLine(&b, width, fmt.Sprintf("selected %d/%d assets, %s of %s", len(selected), len(p.assets), units.FormatSize(selected.TotalSize()), units.FormatSize(p.assets.TotalSize())))
+	io.WriteString(w, b.String())
+}
+
+// checkbox shows whether none, some or all the leaves are checked
+func (p *Picker) checkbox(leaves []int) string {
+	checked := 0
+	for _, i := range leaves {
+		if p.selected[i] {
+			checked++
+		}
+	}
+	switch checked {
+	case 0:
+		return "[ ]"
+	case len(leaves):
+		return "[x]"
+	default:
+		return "[-]"
+	}
+}
+
+// writeLine writes a line cut to the screen width, raw mode needs explicit carriage returns
+func writeLine(b *strings.Builder, width int, line string) {
+	if r := []rune(line); width > 0 && len(r) > width {
+		line = string(r[:width])
+	}
+	b.WriteString(line)
+	b.WriteString("\x1b[K\r\n")
+}
+
+// keyRead is a key press, or the error that stopped reading keys
+type keyRead struct {
+	key Key
+	err error
+}
+
+// Run reads key presses from in and redraws the picker on out until it's confirmed, cancelled
+// or ctx is done
+func (p *Picker) Run(ctx context.Context, in io.Reader, out io.Writer, width, height int) (hbclient.Assets, error) {
+	// keys are read aside so a blocked read doesn't hold off ctx
+	keys := make(chan keyRead)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		r := bufio.NewReader(in)
+		for {
+			k, err := readKey(r)
+			select {
+			case keys <- keyRead{key: k, err: err}:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for !p.done {
+		p.Render(out, width, height)
+		select {
+		case <-ctx.Done():
+			io.WriteString(out, "\x1b[H\x1b[2J")
+			return nil, ErrInterrupted
+		case k := <-keys:
+			if k.err != nil {
+				return nil, errors.Wrap(k.err, "reading key")
+			}
+			p.HandleKey(k.key)
+		}
+	}
+	io.WriteString(out, "\x1b[H\x1b[2J")
+	if p.cancelled {
+		return nil, ErrCancelled
+	}
+	return p.Selected(), nil
+}
+
+// Pick runs a picker on the terminal attached to in, it fails when in isn't a terminal.
+// Raw mode turns signal keys into key presses, so ctx is the only way to stop it from outside
+func Pick(ctx context.Context, in io.Reader, out io.Writer, assets hbclient.Assets, preselect hbclient.AssetFilter) (hbclient.Assets, error) {
+	f, ok := in.(*os.File)
+	if !ok || !isTerminal(f.Fd()) {
+		return nil, errors.New("interactive mode needs a terminal")
+	}
+	width, height, err := terminalSize(f.Fd())
+	if err != nil {
+		width, height = 80, 24
+	}
+	restore, err := makeRaw(f.Fd())
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	// hide the cursor while picking
+	io.WriteString(out, "\x1b[?25l")
+	defer io.WriteString(out, "\x1b[?25h")
+	return NewPicker(assets, preselect).Run(ctx, f, out, width, height)
+}
@@ -0,0 +1,190 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+)
+
+func testAssets() hbclient.Assets {
+	order := hbclient.Order{
+		Products: []*hbclient.Product{
+			{
+				HumanName: "Practical Malware Analysis",
+				Downloads: []*hbclient.Download{
+					{Platform: "ebook", Types: []*hbclient.DownloadType{
+						{Name: "PDF", FileSize: 1024},
+						{Name: "EPUB", FileSize: 2048},
+					}},
+				},
+			},
+			{
+				HumanName: "Black Hat Python",
+				Downloads: []*hbclient.Download{
+					{Platform: "ebook", Types: []*hbclient.DownloadType{
+						{Name: "PDF", FileSize: 4096},
+						{Name: "MOBI", FileSize: 8192},
+					}},
+				},
+			},
+			{
+				HumanName: "FTL: Faster Than Light",
+				Downloads: []*hbclient.Download{
+					{Platform: "linux", Types: []*hbclient.DownloadType{
+						{Name: "64-bit .deb", FileSize: 1 << 20},
+					}},
+				},
+			},
+		},
+	}
+	return order.Assets()
+}
+
+func TestPicker(t *testing.T) {
+	dd := []struct {
+		name      string
+		input     string
+		preselect hbclient.AssetFilter
+		expected  []string
+		cancelled bool
+	}{
+		{
+			name:     "toggle-product",
+			input:    " \r",
+			expected: []string{"Practical Malware Analysis PDF", "Practical Malware Analysis EPUB"},
+		},
+		{
+			name:     "expand-and-toggle-type",
+			input:    "\x1b[C\x1b[B\x1b[B \r",
+			expected: []string{"Practical Malware Analysis PDF"},
+		},
+		{
+			name:     "select-all-of-type",
+			input:    "lj\x1b[Bt\r",
+			expected: []string{"Practical Malware Analysis PDF", "Black Hat Python PDF"},
+		},
+		{
+			name:     "search",
+			input:    "/python pdf\r \r",
+			expected: []string{"Black Hat Python PDF"},
+		},
+		{
+			name:     "search-cleared",
+			input:    "/python\x1b" + "a\r",
+			expected: []string{"Practical Malware Analysis PDF", "Practical Malware Analysis EPUB", "Black Hat Python PDF", "Black Hat Python MOBI", "FTL: Faster Than Light 64-bit .deb"},
+		},
+		{
+			name:      "preselected-select-all",
+			input:     "a\r",
+			preselect: hbclient.ByType("mobi"),
+			expected:  []string{"Practical Malware Analysis PDF", "Practical Malware Analysis EPUB", "Black Hat Python PDF", "Black Hat Python MOBI", "FTL: Faster Than Light 64-bit .deb"},
+		},
+		{
+			name:      "preselected",
+			input:     "\r",
+			preselect: hbclient.ByType("mobi"),
+			expected:  []string{"Black Hat Python MOBI"},
+		},
+		{
+			name:      "quit",
+			input:     " q",
+			cancelled: true,
+		},
+		{
+			name:      "ctrl-c",
+			input:     "/abc\x03",
+			cancelled: true,
+		},
+	}
+	for _, d := range dd {
+		p := NewPicker(testAssets(), d.preselect)
+		var out strings.Builder
+		selected, err := p.Run(context.Background(), strings.NewReader(d.input), &out, 80, 24)
+		if d.cancelled {
+			if err != ErrCancelled {
+				t.Errorf("%s: expected ErrCancelled but got %v", d.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Run: %v", d.name, err)
+			continue
+		}
+		names := []string{}
+		for _, a := range selected {
+			names = append(names, a.Product.HumanName+" "+a.Type.Name)
+		}
+		if !reflect.DeepEqual(names, d.expected) {
+			t.Errorf("%s: expected %v but got %v", d.name, d.expected, names)
+		}
+	}
+}
+
+func TestPickerInterrupted(t *testing.T) {
+	// nothing is ever typed, only ctx can stop the picker
+	in, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	var out strings.Builder
+	if _, err := NewPicker(testAssets(), nil).Run(ctx, in, &out, 80, 24); err != ErrInterrupted {
+		t.Errorf("expected ErrInterrupted but got %v", err)
+	}
+}
+
+func TestPickerRender(t *testing.T) {
+	p := NewPicker(testAssets(), nil)
+	for _, r := range "l /pdf" {
+		p.HandleKey(Key{Code: KeyRune, Rune: r})
+	}
+
+	var out strings.Builder
+	p.Render(&out, 80, 24)
+	screen := out.String()
+	expected := []string{
+		"search: pdf_",
+		">- [x] Practical Malware Analysis (1.0 KiB)",
+		"   - [x] ebook (1.0 KiB)",
+		"       [x] PDF (1.0 KiB)",
+		" - [ ] Black Hat Python (4.0 KiB)",
+		"selected 2/5 assets, 3.0 KiB of 1.0 MiB",
+	}
+	for _, line := range expected {
+		if !strings.Contains(screen, line+"\x1b[K\r\n") {
+			t.Errorf("expected screen to have line %q\n%q", line, screen)
+		}
+	}
+	if strings.Contains(screen, "FTL") {
+		t.Errorf("expected search to hide FTL")
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("a\x1b[A\x1b[B\x1b[5~\x1b[6~\x7f\r\x03"))
+	expected := []Key{
+		{Code: KeyRune, Rune: 'a'},
+		{Code: KeyUp},
+		{Code: KeyDown},
+		{Code: KeyPgUp},
+		{Code: KeyPgDn},
+		{Code: KeyBackspace},
+		{Code: KeyEnter},
+		{Code: KeyCtrlC},
+	}
+	for i, e := range expected {
+		k, err := readKey(r)
+		if err != nil {
+			t.Fatalf("%d: readKey: %v", i, err)
+		}
+		if k != e {
+			t.Errorf("%d: expected %+v but got %+v", i, e, k)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package tui
+
+import "github.com/pkg/errors"
+
+// isTerminal always reports false, raw mode isn't supported on this platform
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (func() error, error) {
+	return nil, errors.New("terminal raw mode is not supported on this platform")
+}
+
+func terminalSize(fd uintptr) (int, int, error) {
+	return 0, 0, errors.New("terminal size is not supported on this platform")
+}
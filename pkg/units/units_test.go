@@ -0,0 +1,21 @@
+package units
+
+import "testing"
+
+func TestFormatSize(t *testing.T) {
+	dd := []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+		{1 << 60, "1.0 EiB"},
+	}
+	for _, d := range dd {
+		if s := FormatSize(d.n); s != d.expected {
+			t.Errorf("%d: expected %s but got %s", d.n, d.expected, s)
+		}
+	}
+}
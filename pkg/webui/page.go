@@ -0,0 +1,160 @@
+package webui
+
+// indexHTML is the whole UI, it only talks to the JSON API and the event stream
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>hbd</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+nav { width: 30%; overflow-y: auto; border-right: 1px solid #ccc; }
+main { flex: 1; overflow-y: auto; padding: 0 1em; }
+aside { width: 25%; overflow-y: auto; border-left: 1px solid #ccc; padding: 0 1em; }
+nav div { padding: .5em 1em; cursor: pointer; border-bottom: 1px solid #eee; }
+nav div:hover, nav div.active { background: #eef; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .2em .4em; border-bottom: 1px solid #eee; }
+.muted { color: #888; font-size: .9em; }
+.error { color: #b00; }
+progress { width: 100%; }
+</style>
+</head>
+<body>
+<nav>
+  <p style="padding: 0 1em"><button id="sync">Sync library</button> <span id="sync-status" class="muted"></span></p>
+  <div id="orders"></div>
+</nav>
+<main>
+  <h2 id="title">Select a bundle</h2>
+  <p id="toolbar" hidden>
+    <input id="filter" placeholder="filter, eg; pdf">
+    <button id="select-visible">Select visible</button>
+    <button id="download">Download selected</button>
+    <span id="selected-size" class="muted"></span>
+  </p>
+  <table><tbody id="assets"></tbody></table>
+</main>
+<aside>
+  <h3>Downloads</h3>
+  <div id="jobs"></div>
+</aside>
+<script>
+var current = null;
+
+function $(id) { return document.getElementById(id); }
+
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, function (c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;"}[c];
+  });
+}
+
+function size(n) {
+  var units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  var i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i ? n.toFixed(1) : n) + " " + units[i];
+}
+
+function api(method, path, body) {
+  return fetch(path, {
+    method: method,
+    headers: {"Content-Type": "application/json"},
+    body: body ? JSON.stringify(body) : undefined
+  }).then(function (resp) {
+    return resp.json().then(function (data) {
+      if (!resp.ok) { throw new Error(data.error || resp.statusText); }
+      return data;
+    });
+  });
+}
+
+function loadOrders() {
+  api("GET", "/api/orders").then(function (orders) {
+    $("orders").innerHTML = orders.map(function (o) {
+      return '<div data-key="' + esc(o.key) + '"><b>' + esc(o.name) + '</b><br><span class="muted">' +
+        esc(o.created.slice(0, 10)) + ", " + o.assets + " assets, " + size(o.size) + "</span></div>";
+    }).join("") || '<p class="muted" style="padding: 0 1em">The library is empty, sync it first.</p>';
+  });
+}
+
+function loadOrder(key) {
+  api("GET", "/api/orders/" + encodeURIComponent(key)).then(function (order) {
+    current = order;
+    $("title").textContent = order.name;
+    $("toolbar").hidden = false;
+    renderAssets();
+  });
+}
+
+function renderAssets() {
+  var filter = $("filter").value.toLowerCase();
+  $("assets").innerHTML = current.assets.map(function (a) {
+    var text = (a.product + " " + a.platform + " " + a.type).toLowerCase();
+    var hidden = filter && filter.split(/\s+/).some(function (w) { return text.indexOf(w) < 0; });
+    return "<tr" + (hidden ? " hidden" : "") + '><td><input type="checkbox" value="' + esc(a.id) + '" data-size="' + a.size + '"></td>' +
+      "<td>" + esc(a.product) + "</td><td>" + esc(a.platform) + "</td><td>" + esc(a.type) + "</td><td>" + size(a.size) + "</td>" +
+      '<td class="muted">' + (a.downloaded ? "downloaded" : "") + "</td></tr>";
+  }).join("");
+  updateSelected();
+}
+
+function selectedBoxes() {
+  return Array.prototype.slice.call(document.querySelectorAll("#assets input:checked"));
+}
+
+function updateSelected() {
+  var boxes = selectedBoxes();
+  var total = boxes.reduce(function (sum, b) { return sum + Number(b.dataset.size); }, 0);
+  $("selected-size").textContent = boxes.length + " selected, " + size(total);
+}
+
+function renderJobs(jobs) {
+  $("jobs").innerHTML = jobs.map(function (j) {
+    var done = 0, total = 0;
+    j.items.forEach(function (i) { done += i.written; total += i.size; });
+    return "<p><b>" + esc(j.bundle || j.order) + "</b> " + esc(j.status) +
+      (j.error ? '<br><span class="error">' + esc(j.error) + "</span>" : "") +
+      '<br><progress max="' + (total || 1) + '" value="' + done + '"></progress>' +
+      '<br><span class="muted">' + j.items.length + " files, " + size(done) + " of " + size(total) + "</span></p>";
+  }).join("") || '<p class="muted">Nothing queued.</p>';
+}
+
+$("orders").addEventListener("click", function (e) {
+  var el = e.target.closest("[data-key]");
+  if (!el) { return; }
+  Array.prototype.forEach.call(document.querySelectorAll("nav div"), function (d) { d.classList.remove("active"); });
+  el.classList.add("active");
+  loadOrder(el.dataset.key);
+});
+$("assets").addEventListener("change", updateSelected);
+$("filter").addEventListener("input", renderAssets);
+$("select-visible").addEventListener("click", function () {
+  Array.prototype.forEach.call(document.querySelectorAll("#assets tr:not([hidden]) input"), function (b) { b.checked = true; });
+  updateSelected();
+});
+$("download").addEventListener("click", function () {
+  var ids = selectedBoxes().map(function (b) { return b.value; });
+  if (!ids.length) { return; }
+  api("POST", "/api/downloads", {order: current.key, ids: ids}).catch(function (err) { alert(err.message); });
+});
+$("sync").addEventListener("click", function () {
+  $("sync-status").textContent = "syncing...";
+  api("POST", "/api/sync").then(function (r) {
+    $("sync-status").textContent = r.synced + " orders synced";
+    loadOrders();
+  }).catch(function (err) {
+    $("sync-status").textContent = err.message;
+  });
+});
+
+new EventSource("/api/events").addEventListener("jobs", function (e) {
+  renderJobs(JSON.parse(e.data));
+});
+loadOrders();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,228 @@
+package webui
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"diogogmt.com/hbd/pkg/downloader"
+)
+
+// job and item statuses reported to the UI
+const (
+	StatusQueued      = "queued"
+	StatusRunning     = "running"
+	StatusDone        = "done"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+)
+
+// Job is a queued download of some assets of an order
+type Job struct {
+	ID       int        `json:"id"`
+	Order    string     `json:"order"`
+	Bundle   string     `json:"bundle"`
+	Dest     string     `json:"dest"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Items    []*JobItem `json:"items"`
+	Queued   time.Time  `json:"queued"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	plan  *downloader.Plan
+	items map[*downloader.Item]*JobItem
+}
+
+// JobItem is the progress of a single file of a job
+type JobItem struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Written  int64  `json:"written"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Queue downloads jobs one at a time and notifies subscribers of every change
+type Queue struct {
+	dest string
+	opts []downloader.Option
+
+	mu     sync.Mutex
+	jobs   []*Job
+	nextID int
+	wake   chan struct{}
+	subs   map[chan struct{}]struct{}
+}
+
+// NewQueue creates a queue downloading bundles into subdirectories of dest
+func NewQueue(dest string, opts ...downloader.Option) *Queue {
+	return &Queue{
+		dest:   dest,
+		opts:   opts,
+		nextID: 1,
+		wake:   make(chan struct{}, 1),
+		subs:   map[chan struct{}]struct{}{},
+	}
+}
+
+// Add queues the download of a plan and returns a copy of the job
+func (q *Queue) Add(plan *downloader.Plan) Job {
+	job := Job{
+		Order:  plan.Order.GameKey,
+		Dest:   filepath.Join(q.dest, downloader.BundleDir(plan.Order)),
+		Status: StatusQueued,
+		Items:  []*JobItem{},
+		Queued: time.Now().UTC(),
+		plan:   plan,
+		items:  map[*downloader.Item]*JobItem{},
+	}
+	if plan.Order.Product != nil {
+		job.Bundle = plan.Order.Product.HumanName
+	}
+	for _, item := range plan.Items {
+		jobItem := JobItem{ID: item.ID(), Filename: item.Filename, Size: item.Size(), Status: StatusQueued}
+		job.Items = append(job.Items, &jobItem)
+		job.items[item] = &jobItem
+	}
+
+	q.mu.Lock()
+	job.ID = q.nextID
+	q.nextID++
+	q.jobs = append(q.jobs, &job)
+	queued := job.snapshot()
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	q.notify()
+	return queued
+}
+
+// Jobs returns a copy of all the jobs, newest first
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for i := len(q.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, q.jobs[i].snapshot())
+	}
+	return jobs
+}
+
+// snapshot copies the job and its items, the queue lock must be held
+func (j *Job) snapshot() Job {
+	job := *j
+	job.Items = make([]*JobItem, 0, len(j.Items))
+	for _, item := range j.Items {
+		copied := *item
+		job.Items = append(job.Items, &copied)
+	}
+	return job
+}
+
+// Subscribe returns a channel signaled whenever a job changes, cancel stops the notifications
+func (q *Queue) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	q.mu.Lock()
+	q.subs[ch] = struct{}{}
+	q.mu.Unlock()
+	return ch, func() {
+		q.mu.Lock()
+		delete(q.subs, ch)
+		q.mu.Unlock()
+	}
+}
+
+func (q *Queue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ch := range q.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run downloads the queued jobs until ctx is cancelled
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		job := q.next()
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+				continue
+			}
+		}
+		q.run(ctx, job)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// next marks the oldest queued job as running
+func (q *Queue) next() *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if job.Status == StatusQueued {
+			job.Status = StatusRunning
+			return job
+		}
+	}
+	return nil
+}
+
+func (q *Queue) run(ctx context.Context, job *Job) {
+	q.notify()
+	opts := append(append([]downloader.Option{}, q.opts...), downloader.WithEventHandler(func(e downloader.Event) {
+		q.update(job, e)
+	}))
+	_, err := downloader.New(job.Dest, opts...).Download(ctx, job.plan)
+
+	q.mu.Lock()
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	switch {
+	case ctx.Err() != nil:
+		job.Status = StatusInterrupted
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	default:
+		job.Status = StatusDone
+	}
+	q.mu.Unlock()
+	q.notify()
+}
+
+// update applies a download event to the job item it belongs to
+func (q *Queue) update(job *Job, e downloader.Event) {
+	q.mu.Lock()
+	item, ok := job.items[e.Item]
+	if ok {
+		switch e.Type {
+		case downloader.EventStarted:
+			item.Status = StatusRunning
+		case downloader.EventProgress:
+			item.Written = e.Written
+		case downloader.EventDone:
+			item.Status = StatusDone
+			item.Written = item.Size
+		case downloader.EventFailed:
+			item.Status = StatusFailed
+			item.Error = e.Err.Error()
+		}
+	}
+	q.mu.Unlock()
+	if ok {
+		q.notify()
+	}
+}
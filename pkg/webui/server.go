@@ -0,0 +1,371 @@
+// Package webui serves a browser UI to browse the cached library and queue
+// downloads, progress is streamed to the browser with server sent events.
+package webui
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"diogogmt.com/hbd/pkg/downloader"
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/library"
+	"github.com/pkg/errors"
+)
+
+// Server is the web UI http.Handler
+type Server struct {
+	lib      *library.Library
+	queue    *Queue
+	user     string
+	password string
+	// interval is the minimum delay between two progress updates sent to a browser
+	interval time.Duration
+	mux      *http.ServeMux
+	// addr is the listen address requests must be addressed to, any when empty
+	addr string
+}
+
+// Option defines the signature for functional options to be applied to the server
+type Option = func(s *Server)
+
+// New creates a web UI browsing lib and downloading into queue
+func New(lib *library.Library, queue *Queue, opts ...Option) *Server {
+	s := Server{
+		lib:      lib,
+		queue:    queue,
+		interval: 250 * time.Millisecond,
+		mux:      http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/api/orders", s.handleOrders)
+	s.mux.HandleFunc("/api/orders/", s.handleOrder)
+	s.mux.HandleFunc("/api/sync", s.handleSync)
+	s.mux.HandleFunc("/api/downloads", s.handleDownloads)
+	s.mux.HandleFunc("/api/events", s.handleEvents)
+	return &s
+}
+
+// WithBasicAuth requires every request to carry the user and password
+func WithBasicAuth(user, password string) Option {
+	return func(s *Server) {
+		s.user = user
+		s.password = password
+	}
+}
+
+// WithUpdateInterval sets the minimum delay between two progress updates sent to a browser
+func WithUpdateInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.interval = d
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.user != "" || s.password != "" {
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hbd"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// orderSummary is an order in the library listing
+type orderSummary struct {
+	Key      string    `json:"key"`
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+	Products int       `json:"products"`
+	Assets   int       `json:"assets"`
+	Size     int64     `json:"size"`
+}
+
+// assetView is an asset of an order page
+type assetView struct {
+	ID         string `json:"id"`
+	Product    string `json:"product"`
+	Platform   string `json:"platform"`
+	Type       string `json:"type"`
+	Filename   string `json:"filename"`
+	Size       int64  `json:"size"`
+	Downloaded bool   `json:"downloaded"`
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, indexHTML)
+}
+
+func (s *Server) handleOrders(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	orders, err := s.lib.Orders()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	summaries := make([]orderSummary, 0, len(orders))
+	for _, order := range orders {
+		assets := order.Assets()
+		summaries = append(summaries, orderSummary{
+			Key:      order.GameKey,
+			Name:     bundleName(order),
+			Created:  order.Created.Time,
+			Products: len(order.Products),
+			Assets:   len(assets),
+			Size:     assets.TotalSize(),
+		})
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+	order, err := s.lib.Order(key)
+	if errors.Cause(err) == library.ErrNotCached {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	plan := downloader.NewPlan(order)
+	dir := filepath.Join(s.queue.dest, downloader.BundleDir(order))
+	assets := make([]assetView, 0, len(plan.Items))
+	for _, item := range plan.Items {
+		_, err := os.Stat(filepath.Join(dir, item.Filename))
+		assets = append(assets, assetView{
+			ID:         item.ID(),
+			Product:    item.Product.HumanName,
+			Platform:   item.Platform(),
+			Type:       item.Type.Name,
+			Filename:   item.Filename,
+			Size:       item.Size(),
+			Downloaded: err == nil,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    order.GameKey,
+		"name":   bundleName(order),
+		"dest":   dir,
+		"assets": assets,
+	})
+}
+
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) || !s.allowPost(w, r) {
+		return
+	}
+	orders, err := s.lib.Sync(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"synced": len(orders)})
+}
+
+// downloadRequest queues the assets of an order, all of them when IDs is empty
+type downloadRequest struct {
+	Order string   `json:"order"`
+	IDs   []string `json:"ids"`
+}
+
+func (s *Server) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.queue.Jobs())
+	case http.MethodPost:
+		if !s.allowPost(w, r) {
+			return
+		}
+		req := downloadRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "json.Decode"))
+			return
+		}
+		order, err := s.lib.Order(req.Order)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		filter := hbclient.All
+		if len(req.IDs) != 0 {
+			filter = hbclient.ByID(req.IDs...)
+		}
+		plan := downloader.NewPlan(order, filter)
+		if len(plan.Items) == 0 {
+			writeError(w, http.StatusBadRequest, errors.New("no assets selected"))
+			return
+		}
+		writeJSON(w, http.StatusAccepted, s.queue.Add(plan))
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleEvents streams the jobs to the browser every time they change
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+	changed, cancel := s.queue.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for {
+		by, err := json.Marshal(s.queue.Jobs())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: jobs\ndata: %s\n\n", by); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+		// coalesce the progress events of busy downloads
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// Serve listens on addr until ctx is cancelled, the download queue runs alongside
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	s.addr = addr
+	srv := http.Server{
+		Addr:    addr,
+		Handler: s,
+		// Shutdown doesn't cancel the requests in flight, the event streams
+		// only end with ctx
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go s.queue.Run(ctx)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return errors.Wrap(err, "http.ListenAndServe")
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return errors.Wrap(srv.Shutdown(shutdownCtx), "http.Shutdown")
+	}
+}
+
+func bundleName(order *hbclient.Order) string {
+	if order.Product != nil && order.Product.HumanName != "" {
+		return order.Product.HumanName
+	}
+	return order.GameKey
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+// allowPost rejects the requests a web page of another site can make: forms
+// can't send JSON, browsers send the Origin of cross site requests and a Host
+// other than the listen address is a DNS rebinding
+func (s *Server) allowPost(w http.ResponseWriter, r *http.Request) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("expected an application/json request"))
+		return false
+	}
+	if !s.allowHost(r.Host) {
+		writeError(w, http.StatusForbidden, errors.Errorf("unexpected host %s", r.Host))
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			writeError(w, http.StatusForbidden, errors.Errorf("cross origin request from %s", origin))
+			return false
+		}
+	}
+	return true
+}
+
+// allowHost reports whether a request Host header names the listen address,
+// localhost and the loopback addresses are interchangeable
+func (s *Server) allowHost(host string) bool {
+	if s.addr == "" {
+		return true
+	}
+	listenHost, listenPort, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return false
+	}
+	reqHost, reqPort, err := net.SplitHostPort(host)
+	if err != nil {
+		reqHost, reqPort = host, "80"
+	}
+	if reqPort != listenPort {
+		return false
+	}
+	if ip := net.ParseIP(listenHost); listenHost == "" || (ip != nil && ip.IsUnspecified()) {
+		return true
+	}
+	return strings.EqualFold(reqHost, listenHost) || (isLoopback(reqHost) && isLoopback(listenHost))
+}
+
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
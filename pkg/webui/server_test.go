@@ -0,0 +1,273 @@
+package webui
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"diogogmt.com/hbd/pkg/hbclient"
+	"diogogmt.com/hbd/pkg/library"
+	"diogogmt.com/hbd/pkg/state"
+)
+
+func TestServer(t *testing.T) {
+	content := []byte("humble book content")
+	mux := http.NewServeMux()
+	var apiURL string
+	mux.HandleFunc("/user/order", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"gamekey": "order1"}]`))
+	})
+	mux.HandleFunc("/order/order1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"gamekey": "order1", "product": {"human_name": "Book Bundle"}, "subproducts": [
+			{"human_name": "Book", "machine_name": "book", "downloads": [{"platform": "ebook", "download_struct": [
+				{"name": "PDF", "md5": "%x", "file_size": %d, "url": {"web": "%s/dl/book.pdf"}},
+				{"name": "EPUB", "file_size": 10, "url": {"web": "%s/dl/book.epub"}}
+			]}]}
+		]}`, md5.Sum(content), len(content), apiURL, apiURL)
+	})
+	mux.HandleFunc("/dl/book.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	})
+	api := httptest.NewServer(mux)
+	defer api.Close()
+	apiURL = api.URL
+
+	dir, err := ioutil.TempDir("", "hbd-webui.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	lib := library.New(state.Dir(filepath.Join(dir, "state")), hbclient.NewClient(hbclient.WithAPIURL(api.URL)))
+	queue := NewQueue(filepath.Join(dir, "dest"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	srv := httptest.NewServer(New(lib, queue, WithBasicAuth("team", "secret"), WithUpdateInterval(time.Millisecond)))
+	defer srv.Close()
+
+	call := func(method, path, body string, v interface{}) int {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("http.NewRequest: %v", err)
+		}
+		req.SetBasicAuth("team", "secret")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("%s %s: json.Decode: %v", method, path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	resp, err := http.Get(srv.URL + "/api/orders")
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected requests without credentials to be rejected but got %d", resp.StatusCode)
+	}
+
+	// other sites can't queue work with forms or cross origin requests
+	for _, d := range []struct {
+		name        string
+		contentType string
+		origin      string
+		expect      int
+	}{
+		{name: "form", contentType: "application/x-www-form-urlencoded", expect: http.StatusUnsupportedMediaType},
+		{name: "text", contentType: "text/plain", expect: http.StatusUnsupportedMediaType},
+		{name: "cross-origin", contentType: "application/json", origin: "https://example.com", expect: http.StatusForbidden},
+	} {
+		for _, path := range []string{"/api/sync", "/api/downloads"} {
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(`{"order": "order1"}`))
+			req.SetBasicAuth("team", "secret")
+			req.Header.Set("Content-Type", d.contentType)
+			if d.origin != "" {
+				req.Header.Set("Origin", d.origin)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s: POST %s: %v", d.name, path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != d.expect {
+				t.Errorf("%s: expected POST %s to be rejected with %d but got %d", d.name, path, d.expect, resp.StatusCode)
+			}
+		}
+	}
+
+	if status := call(http.MethodPost, "/api/sync", "", nil); status != http.StatusOK {
+		t.Fatalf("expected sync to succeed but got %d", status)
+	}
+	orders := []orderSummary{}
+	call(http.MethodGet, "/api/orders", "", &orders)
+	if len(orders) != 1 || orders[0].Name != "Book Bundle" || orders[0].Assets != 2 {
+		t.Fatalf("expected the synced order to be listed but got %+v", orders)
+	}
+
+	order := struct {
+		Assets []assetView `json:"assets"`
+	}{}
+	call(http.MethodGet, "/api/orders/order1", "", &order)
+	if len(order.Assets) != 2 || order.Assets[0].ID != "book/ebook/pdf" || order.Assets[0].Downloaded {
+		t.Fatalf("expected 2 assets not downloaded yet but got %+v", order.Assets)
+	}
+	if status := call(http.MethodGet, "/api/orders/missing", "", nil); status != http.StatusNotFound {
+		t.Errorf("expected missing order to be not found but got %d", status)
+	}
+	if status := call(http.MethodPost, "/api/downloads", `{"order": "order1", "ids": ["nope"]}`, nil); status != http.StatusBadRequest {
+		t.Errorf("expected an empty selection to be rejected but got %d", status)
+	}
+
+	// follow the event stream until the queued download finishes
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/events", nil)
+	req.SetBasicAuth("team", "secret")
+	streamCtx, streamCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer streamCancel()
+	events, err := http.DefaultClient.Do(req.WithContext(streamCtx))
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	defer events.Body.Close()
+
+	job := Job{}
+	if status := call(http.MethodPost, "/api/downloads", `{"order": "order1", "ids": ["book/ebook/pdf"]}`, &job); status != http.StatusAccepted {
+		t.Fatalf("expected download to be queued but got %d", status)
+	}
+	if len(job.Items) != 1 || job.Items[0].Filename != "Book.pdf" {
+		t.Fatalf("expected a job with Book.pdf but got %+v", job)
+	}
+	if job.Finished != nil {
+		t.Errorf("expected a queued job to have no finish time but got %v", job.Finished)
+	}
+
+	finished := false
+	scanner := bufio.NewScanner(events.Body)
+	for !finished && scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		jobs := []Job{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &jobs); err != nil {
+			t.Fatalf("json.Unmarshal event: %v", err)
+		}
+		for _, j := range jobs {
+			if j.ID == job.ID && j.Status == StatusDone {
+				finished = true
+				if j.Finished == nil || j.Finished.IsZero() {
+					t.Errorf("expected a done job to have a finish time")
+				}
+				if j.Items[0].Written != int64(len(content)) {
+					t.Errorf("expected %d bytes written but got %d", len(content), j.Items[0].Written)
+				}
+			}
+		}
+	}
+	if !finished {
+		t.Fatalf("expected the event stream to report the job as done: %v", scanner.Err())
+	}
+
+	by, err := ioutil.ReadFile(filepath.Join(dir, "dest", "Book Bundle", "Book.pdf"))
+	if err != nil || string(by) != string(content) {
+		t.Errorf("expected Book.pdf to be downloaded: %v", err)
+	}
+	call(http.MethodGet, "/api/orders/order1", "", &order)
+	if !order.Assets[0].Downloaded || order.Assets[1].Downloaded {
+		t.Errorf("expected only the pdf to be downloaded but got %+v", order.Assets)
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hbd-webui.")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	lib := library.New(state.Dir(filepath.Join(dir, "state")), hbclient.NewClient())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- New(lib, NewQueue(filepath.Join(dir, "dest"))).Serve(ctx, addr)
+	}()
+
+	// an open event stream doesn't hold the shutdown
+	var events *http.Response
+	for i := 0; i < 100; i++ {
+		if events, err = http.Get("http://" + addr + "/api/events"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	defer events.Body.Close()
+	if _, err := bufio.NewReader(events.Body).ReadString('\n'); err != nil {
+		t.Fatalf("reading the event stream: %v", err)
+	}
+
+	// a DNS rebinding site reaches the server under its own name
+	for _, d := range []struct {
+		host   string
+		expect int
+	}{
+		{host: "evil.example.com:" + port(addr), expect: http.StatusForbidden},
+		{host: "localhost:" + port(addr), expect: http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, "http://"+addr+"/api/downloads", strings.NewReader(`{}`))
+		req.Host = d.host
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: POST /api/downloads: %v", d.host, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != d.expect {
+			t.Errorf("%s: expected %d but got %d", d.host, d.expect, resp.StatusCode)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("expected a clean shutdown but got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected Serve to return once cancelled")
+	}
+}
+
+func port(addr string) string {
+	_, p, _ := net.SplitHostPort(addr)
+	return p
+}